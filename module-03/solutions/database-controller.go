@@ -320,6 +320,9 @@ func (r *DatabaseReconciler) updateStatus(ctx context.Context, db *databasev1.Da
 	// Set the secret name in status
 	db.Status.SecretName = r.secretName(db)
 
+	// The managed resources have been reconciled with this spec generation
+	db.Status.ObservedGeneration = db.Generation
+
 	// Check StatefulSet status
 	statefulSet := &appsv1.StatefulSet{}
 	err := r.Get(ctx, client.ObjectKey{
@@ -49,6 +49,11 @@ type StorageSpec struct {
 
 // DatabaseStatus defines the observed state of Database
 type DatabaseStatus struct {
+	// ObservedGeneration is the spec generation the controller last acted on.
+	// Ready only reflects the current spec when it equals metadata.generation.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	// Phase is the current phase
 	// +kubebuilder:validation:Enum=Pending;Creating;Ready;Failed
 	Phase string `json:"phase,omitempty"`
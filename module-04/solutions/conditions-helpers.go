@@ -1,50 +1,147 @@
-// Solution: Condition Helper Functions from Module 4
-// These helpers make it easy to manage conditions in your operator
+// Solution: Condition Helpers from Module 4
+// This is the standard condition set shared by all resources of the operator
+// Location: internal/conditions/conditions.go
+//
+// The condition types follow kstatus (sigs.k8s.io/cli-utils/pkg/kstatus), so kubectl wait,
+// Argo CD, Flux and other kstatus-based tools can compute the health of every resource:
+//
+//   - Ready is True once the resource is in its desired state.
+//   - Reconciling is True while the controller works towards it, and removed afterwards.
+//   - Stalled is True when the controller can't get there without a change to the spec
+//     or the cluster (e.g. retries are used up), and removed afterwards.
+//   - Degraded is True when the resource works, but not as well as desired.
+//
+// Reconciling and Stalled are "abnormal-true" conditions: tools treat them as absent
+// unless they are True, so they are removed instead of being set to False. The helpers
+// work on any object with a Conditions slice, the API types implement Object:
+//
+//	func (db *Database) GetConditions() []metav1.Condition  { return db.Status.Conditions }
+//	func (db *Database) SetConditions(c []metav1.Condition) { db.Status.Conditions = c }
+//
+// Example usage in Reconcile:
+//
+//	if statefulSet.Status.ReadyReplicas == *statefulSet.Spec.Replicas {
+//	    conditions.MarkReady(db, "AllReplicasReady", "All replicas are ready")
+//	} else {
+//	    conditions.MarkReconciling(db, "Scaling",
+//	        fmt.Sprintf("%d/%d replicas ready", statefulSet.Status.ReadyReplicas, *statefulSet.Spec.Replicas))
+//	}
+//	db.Status.ObservedGeneration = db.Generation
+//	return ctrl.Result{}, r.Status().Update(ctx, db)
 
-package controller
+package conditions
 
 import (
-    "k8s.io/apimachinery/pkg/api/meta"
-    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Type is the type of a condition
+type Type string
+
+// The standard condition types
+const (
+	// Ready is True when the resource is in its desired state
+	Ready Type = "Ready"
+	// Reconciling is True while the controller works towards the desired state
+	Reconciling Type = "Reconciling"
+	// Stalled is True when the controller can't make progress on its own
+	Stalled Type = "Stalled"
+	// Degraded is True when the resource works, but not as well as desired
+	Degraded Type = "Degraded"
 )
 
-// setCondition sets a condition on the Database
-func (r *DatabaseReconciler) setCondition(db *databasev1.Database, conditionType string, status metav1.ConditionStatus, reason, message string) {
-    condition := metav1.Condition{
-        Type:               conditionType,
-        Status:             status,
-        Reason:             reason,
-        Message:            message,
-        LastTransitionTime: metav1.Now(),
-        ObservedGeneration: db.Generation,
-    }
+// Reason is the CamelCase reason of a condition.
+// Controllers declare their own reasons next to these.
+type Reason string
+
+// Reasons shared by the controllers
+const (
+	// ReasonSucceeded is the reason of a Ready resource without a more specific one
+	ReasonSucceeded Reason = "Succeeded"
+	// ReasonProgressing is the reason of a resource being reconciled without a more specific one
+	ReasonProgressing Reason = "Progressing"
+	// ReasonWaiting is the reason of a resource waiting for something to be observed
+	ReasonWaiting Reason = "Waiting"
+	// ReasonFailed is the reason of a failed resource without a more specific one
+	ReasonFailed Reason = "Failed"
+	// ReasonRetriesExhausted is the reason of a resource that failed too often to retry
+	ReasonRetriesExhausted Reason = "RetriesExhausted"
+)
 
-    meta.SetStatusCondition(&db.Status.Conditions, condition)
+// Object is a resource with conditions in its status
+type Object interface {
+	metav1.Object
+	GetConditions() []metav1.Condition
+	SetConditions(conditions []metav1.Condition)
 }
 
-// getCondition gets a condition by type
-func (r *DatabaseReconciler) getCondition(db *databasev1.Database, conditionType string) *metav1.Condition {
-    return meta.FindStatusCondition(db.Status.Conditions, conditionType)
+// Set sets a condition on obj for its current generation.
+// The transition time only changes when the status does.
+func Set(obj Object, t Type, status metav1.ConditionStatus, reason Reason, message string) {
+	conditions := obj.GetConditions()
+	meta.SetStatusCondition(&conditions, metav1.Condition{
+		Type:               string(t),
+		Status:             status,
+		Reason:             string(reason),
+		Message:            message,
+		ObservedGeneration: obj.GetGeneration(),
+	})
+	obj.SetConditions(conditions)
 }
 
-// Example usage in Reconcile:
-//
-// // Check StatefulSet status
-// statefulSet := &appsv1.StatefulSet{}
-// err := r.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, statefulSet)
-//
-// if errors.IsNotFound(err) {
-//     r.setCondition(db, "Ready", metav1.ConditionFalse, "StatefulSetNotFound", "StatefulSet not found")
-//     r.setCondition(db, "Progressing", metav1.ConditionTrue, "Creating", "Creating StatefulSet")
-// } else if statefulSet.Status.ReadyReplicas == *statefulSet.Spec.Replicas {
-//     r.setCondition(db, "Ready", metav1.ConditionTrue, "AllReplicasReady", "All replicas are ready")
-//     r.setCondition(db, "Progressing", metav1.ConditionFalse, "ReconciliationComplete", "Reconciliation complete")
-// } else {
-//     r.setCondition(db, "Ready", metav1.ConditionFalse, "ReplicasNotReady",
-//         fmt.Sprintf("%d/%d replicas ready", statefulSet.Status.ReadyReplicas, *statefulSet.Spec.Replicas))
-//     r.setCondition(db, "Progressing", metav1.ConditionTrue, "Scaling", "Waiting for replicas to be ready")
-// }
-//
-// db.Status.ObservedGeneration = db.Generation
-// return ctrl.Result{}, r.Status().Update(ctx, db)
+// Get returns the condition of type t, nil if obj doesn't have one
+func Get(obj Object, t Type) *metav1.Condition {
+	return meta.FindStatusCondition(obj.GetConditions(), string(t))
+}
+
+// Remove removes the condition of type t
+func Remove(obj Object, t Type) {
+	conditions := obj.GetConditions()
+	meta.RemoveStatusCondition(&conditions, string(t))
+	obj.SetConditions(conditions)
+}
+
+// IsTrue reports whether the condition of type t is True
+func IsTrue(obj Object, t Type) bool {
+	return meta.IsStatusConditionTrue(obj.GetConditions(), string(t))
+}
+
+// IsReady reports whether obj is Ready for its current generation.
+// A Ready condition set before the last spec change doesn't count.
+func IsReady(obj Object) bool {
+	c := Get(obj, Ready)
+	return c != nil && c.Status == metav1.ConditionTrue && c.ObservedGeneration == obj.GetGeneration()
+}
+
+// MarkReady records that obj reached its desired state
+func MarkReady(obj Object, reason Reason, message string) {
+	Set(obj, Ready, metav1.ConditionTrue, reason, message)
+	Remove(obj, Reconciling)
+	Remove(obj, Stalled)
+}
+
+// MarkReconciling records that the controller works towards the desired state of obj
+func MarkReconciling(obj Object, reason Reason, message string) {
+	Set(obj, Ready, metav1.ConditionFalse, reason, message)
+	Set(obj, Reconciling, metav1.ConditionTrue, reason, message)
+	Remove(obj, Stalled)
+}
+
+// MarkStalled records that the controller can't make progress on obj without a change
+func MarkStalled(obj Object, reason Reason, message string) {
+	Set(obj, Ready, metav1.ConditionFalse, reason, message)
+	Set(obj, Stalled, metav1.ConditionTrue, reason, message)
+	Remove(obj, Reconciling)
+}
+
+// MarkDegraded records that obj works, but not as well as desired.
+// It is independent of Ready and stays until ClearDegraded.
+func MarkDegraded(obj Object, reason Reason, message string) {
+	Set(obj, Degraded, metav1.ConditionTrue, reason, message)
+}
 
+// ClearDegraded records that obj works as desired
+func ClearDegraded(obj Object) {
+	Remove(obj, Degraded)
+}
@@ -0,0 +1,78 @@
+// Solution: Condition Helper Tests from Module 4
+// This tests the standard condition set with a plain object, no cluster needed.
+// Location: internal/conditions/conditions_test.go
+
+package conditions
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConditions(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Conditions Suite")
+}
+
+// object stands in for a custom resource with conditions in its status
+type object struct {
+	metav1.ObjectMeta
+	conditions []metav1.Condition
+}
+
+func (o *object) GetConditions() []metav1.Condition  { return o.conditions }
+func (o *object) SetConditions(c []metav1.Condition) { o.conditions = c }
+
+var _ = Describe("Conditions", func() {
+	var obj *object
+
+	BeforeEach(func() {
+		obj = &object{ObjectMeta: metav1.ObjectMeta{Generation: 1}}
+	})
+
+	It("should set conditions for the current generation", func() {
+		Set(obj, Ready, metav1.ConditionFalse, ReasonWaiting, "waiting for replicas")
+		c := Get(obj, Ready)
+		Expect(c).NotTo(BeNil())
+		Expect(c.Reason).To(Equal("Waiting"))
+		Expect(c.ObservedGeneration).To(Equal(int64(1)))
+		Expect(c.LastTransitionTime.IsZero()).To(BeFalse())
+	})
+
+	It("should keep exactly one of Reconciling and Stalled while not Ready", func() {
+		MarkReconciling(obj, ReasonProgressing, "deploying")
+		Expect(IsTrue(obj, Reconciling)).To(BeTrue())
+		Expect(Get(obj, Stalled)).To(BeNil())
+		Expect(IsReady(obj)).To(BeFalse())
+
+		MarkStalled(obj, ReasonRetriesExhausted, "failed 5 times")
+		Expect(IsTrue(obj, Stalled)).To(BeTrue())
+		Expect(Get(obj, Reconciling)).To(BeNil())
+		Expect(Get(obj, Ready).Reason).To(Equal("RetriesExhausted"))
+
+		MarkReady(obj, ReasonSucceeded, "ready")
+		Expect(IsReady(obj)).To(BeTrue())
+		Expect(obj.GetConditions()).To(HaveLen(1))
+	})
+
+	It("should not count a Ready condition of an older generation", func() {
+		MarkReady(obj, ReasonSucceeded, "ready")
+		obj.Generation = 2
+		Expect(IsTrue(obj, Ready)).To(BeTrue())
+		Expect(IsReady(obj)).To(BeFalse())
+	})
+
+	It("should track Degraded independently of Ready", func() {
+		MarkReady(obj, ReasonSucceeded, "ready")
+		MarkDegraded(obj, "HookFailed", "post hook failed")
+		Expect(IsReady(obj)).To(BeTrue())
+		Expect(IsTrue(obj, Degraded)).To(BeTrue())
+
+		ClearDegraded(obj)
+		Expect(Get(obj, Degraded)).To(BeNil())
+	})
+})
@@ -14,8 +14,6 @@ import (
     "time"
 
     "k8s.io/apimachinery/pkg/api/errors"
-    "k8s.io/apimachinery/pkg/api/meta"
-    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
     "sigs.k8s.io/controller-runtime/pkg/client"
     "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
     "sigs.k8s.io/controller-runtime/pkg/log"
@@ -23,6 +21,7 @@ import (
 
     appsv1 "k8s.io/api/apps/v1"
     databasev1 "github.com/example/postgres-operator/api/v1"
+    "github.com/example/postgres-operator/internal/conditions"
 )
 
 const finalizerName = "database.example.com/finalizer"
@@ -56,16 +55,8 @@ func (r *DatabaseReconciler) handleDeletion(ctx context.Context, db *databasev1.
     // Perform cleanup operations
     if err := r.cleanupExternalResources(ctx, db); err != nil {
         logger.Error(err, "Failed to cleanup external resources")
-        // Update condition if setCondition method exists
-        condition := metav1.Condition{
-            Type:               "Ready",
-            Status:             metav1.ConditionFalse,
-            Reason:             "CleanupFailed",
-            Message:            err.Error(),
-            LastTransitionTime: metav1.Now(),
-            ObservedGeneration: db.Generation,
-        }
-        meta.SetStatusCondition(&db.Status.Conditions, condition)
+        // Cleanup is retried, so the Database is still reconciling
+        conditions.MarkReconciling(db, "CleanupFailed", err.Error())
         r.Status().Update(ctx, db)
         // Retry after delay
         return ctrl.Result{RequeueAfter: 10 * time.Second}, err
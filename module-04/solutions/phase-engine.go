@@ -0,0 +1,242 @@
+// Solution: Phase Engine from Module 4
+// This is a small declarative engine for multi-phase reconciliation
+// Location: internal/phase/engine.go
+//
+// Instead of a hard-coded switch over the phase, a controller declares its phases:
+// what each one does (Handle), what must be observed before it can be entered (Entry),
+// how long it may take (Timeout, then TimeoutPhase) and which phases may follow it (Next).
+// Step runs one reconcile of an object through its current phase:
+//
+//	machine := phase.Machine[*databasev1.Database]{
+//	    Initial: "Pending",
+//	    Phases: []phase.Phase[*databasev1.Database]{
+//	        {Name: "Pending", Handle: r.handlePending, Next: []string{"Provisioning"}},
+//	        {Name: "Deploying", Handle: r.handleDeploying, Timeout: 10 * time.Minute,
+//	            TimeoutPhase: "Failed", Next: []string{"Verifying", "Failed"}},
+//	        {Name: "Verifying", Entry: r.replicasReady, ...},
+//	        ...
+//	    },
+//	    Current: func(db *databasev1.Database) string { return db.Status.Phase },
+//	    Enter:   r.enterPhase,
+//	}
+//	result, err := machine.Step(ctx, db)
+//	if result.Transition != nil { /* persist the status */ }
+//
+// Transitions happen when the observed state allows them, the engine requeues right away
+// so the next phase runs, and never waits a fixed time between phases. Used by the
+// Database (state-machine-controller.go), Backup and Restore controllers.
+
+package phase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Reasons of the transitions the engine makes on its own
+const (
+	ReasonTimeout      = "PhaseTimeout"
+	ReasonUnknownPhase = "UnknownPhase"
+)
+
+// ErrInvalidTransition is returned for a transition that the current phase doesn't declare
+var ErrInvalidTransition = errors.New("invalid phase transition")
+
+// Handler does the work of a phase. It returns the phase to move to, if any.
+type Handler[T any] func(ctx context.Context, obj T) (Outcome, error)
+
+// EntryCriteria checks the observed state before obj may enter a phase.
+// It returns false and what it waits for while the phase can't be entered yet.
+type EntryCriteria[T any] func(ctx context.Context, obj T) (bool, string, error)
+
+// Phase declares one phase of an object's lifecycle
+type Phase[T any] struct {
+	// Name is stored in the object's status, e.g. "Provisioning"
+	Name string
+	// Handle runs on every reconcile while the object is in this phase
+	Handle Handler[T]
+	// Entry must hold before the object moves into this phase (optional)
+	Entry EntryCriteria[T]
+	// Timeout moves the object to TimeoutPhase when it stays longer in this phase (optional)
+	Timeout      time.Duration
+	TimeoutPhase string
+	// Next lists the phases this phase may move to
+	Next []string
+}
+
+// Outcome is what a Handler decided
+type Outcome struct {
+	// Next is the phase to move to, empty to stay in the current phase
+	Next string
+	// Reason and Message describe the transition, e.g. for conditions
+	Reason  string
+	Message string
+	// RequeueAfter and Requeue are returned when the object stays in its phase
+	RequeueAfter time.Duration
+	Requeue      bool
+}
+
+// Recorded is the Outcome of a handler that records its transitions itself and
+// returns a ctrl.Result, e.g. a reconcile function written before the engine
+func Recorded(result ctrl.Result, err error) (Outcome, error) {
+	return Outcome{RequeueAfter: result.RequeueAfter, Requeue: result.Requeue}, err
+}
+
+// Transition is a move from one phase to another
+type Transition struct {
+	From    string
+	To      string
+	Reason  string
+	Message string
+}
+
+// Result is the result of a Step
+type Result struct {
+	ctrl.Result
+	// Transition is set when Step moved the object to another phase.
+	// Enter has recorded it on the object, the caller persists it.
+	Transition *Transition
+	// Waiting describes the entry criteria of Outcome.Next that don't hold yet
+	Waiting string
+}
+
+// Machine drives objects of type T through their phases
+type Machine[T any] struct {
+	// Initial is the phase of objects without one
+	Initial string
+	// Phases declares all phases
+	Phases []Phase[T]
+	// Current returns the phase of obj
+	Current func(obj T) string
+	// EnteredAt returns when obj entered its current phase, the zero time if unknown.
+	// Timeouts only apply with EnteredAt.
+	EnteredAt func(obj T) time.Time
+	// Enter records a transition on obj (in memory)
+	Enter func(obj T, t Transition)
+	// Now returns the current time, for tests
+	Now func() time.Time
+}
+
+// Validate checks that every phase is declared once and every phase named
+// by Initial, Next and TimeoutPhase is declared
+func (m *Machine[T]) Validate() error {
+	names := make(map[string]bool, len(m.Phases))
+	for _, p := range m.Phases {
+		if p.Name == "" || p.Handle == nil {
+			return fmt.Errorf("phase %q needs a name and a handler", p.Name)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("phase %s is declared twice", p.Name)
+		}
+		names[p.Name] = true
+	}
+	if !names[m.Initial] {
+		return fmt.Errorf("initial phase %q is not declared", m.Initial)
+	}
+	for _, p := range m.Phases {
+		for _, next := range p.Next {
+			if !names[next] {
+				return fmt.Errorf("phase %s moves to undeclared phase %q", p.Name, next)
+			}
+		}
+		if p.Timeout > 0 && !p.allows(p.TimeoutPhase) {
+			return fmt.Errorf("phase %s times out to %q, which is not in its next phases", p.Name, p.TimeoutPhase)
+		}
+	}
+	return nil
+}
+
+// Step runs obj's current phase once. Objects without a phase are in the Initial phase.
+//
+// The phase times out first if it has run for longer than its Timeout. Otherwise its
+// handler runs, and when it asks for a Next phase whose Entry holds, Enter records the
+// transition and Step requeues. Handlers that record a transition themselves (e.g. with
+// other status fields in the same update) are checked against Next all the same.
+func (m *Machine[T]) Step(ctx context.Context, obj T) (Result, error) {
+	current := m.Current(obj)
+	name := current
+	if name == "" {
+		name = m.Initial
+	}
+	p := m.phase(name)
+	if p == nil {
+		return m.transition(obj, current, m.Initial, ReasonUnknownPhase,
+			fmt.Sprintf("Unknown phase %q, starting over in %s", current, m.Initial)), nil
+	}
+
+	if p.Timeout > 0 && m.EnteredAt != nil {
+		if entered := m.EnteredAt(obj); !entered.IsZero() && m.now().Sub(entered) > p.Timeout {
+			return m.transition(obj, current, p.TimeoutPhase, ReasonTimeout,
+				fmt.Sprintf("Phase %s did not complete within %s", name, p.Timeout)), nil
+		}
+	}
+
+	outcome, err := p.Handle(ctx, obj)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{Result: ctrl.Result{RequeueAfter: outcome.RequeueAfter, Requeue: outcome.Requeue}}
+
+	// The handler recorded a transition itself
+	if recorded := m.Current(obj); recorded != current && recorded != name {
+		if !p.allows(recorded) {
+			return result, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, name, recorded)
+		}
+		return result, nil
+	}
+
+	if outcome.Next == "" || outcome.Next == name {
+		return result, nil
+	}
+	if !p.allows(outcome.Next) {
+		return result, fmt.Errorf("%w: %s to %s", ErrInvalidTransition, name, outcome.Next)
+	}
+	if entry := m.phase(outcome.Next).Entry; entry != nil {
+		ok, waiting, err := entry(ctx, obj)
+		if err != nil {
+			return Result{}, err
+		}
+		if !ok {
+			result.Waiting = waiting
+			return result, nil
+		}
+	}
+	return m.transition(obj, current, outcome.Next, outcome.Reason, outcome.Message), nil
+}
+
+// transition records the move of obj to phase to
+func (m *Machine[T]) transition(obj T, from, to, reason, message string) Result {
+	t := Transition{From: from, To: to, Reason: reason, Message: message}
+	m.Enter(obj, t)
+	return Result{Result: ctrl.Result{Requeue: true}, Transition: &t}
+}
+
+func (m *Machine[T]) phase(name string) *Phase[T] {
+	for i := range m.Phases {
+		if m.Phases[i].Name == name {
+			return &m.Phases[i]
+		}
+	}
+	return nil
+}
+
+func (m *Machine[T]) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
+
+// allows reports whether p may move to next
+func (p *Phase[T]) allows(next string) bool {
+	for _, n := range p.Next {
+		if n == next {
+			return true
+		}
+	}
+	return false
+}
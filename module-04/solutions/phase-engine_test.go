@@ -0,0 +1,201 @@
+// Solution: Phase Engine Tests from Module 4
+// This tests the transitions of the phase engine with a plain object, no cluster needed.
+// Location: internal/phase/engine_test.go
+
+package phase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestPhase(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Phase Engine Suite")
+}
+
+// object stands in for a custom resource with a phase in its status
+type object struct {
+	phase   string
+	entered time.Time
+	ready   bool
+}
+
+var _ = Describe("Machine", func() {
+	var (
+		ctx     context.Context
+		now     time.Time
+		handled []string
+		machine *Machine[*object]
+	)
+
+	// handle records that the phase ran and returns outcome
+	handle := func(name string, outcome Outcome) Handler[*object] {
+		return func(ctx context.Context, obj *object) (Outcome, error) {
+			handled = append(handled, name)
+			return outcome, nil
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		now = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		handled = nil
+		machine = &Machine[*object]{
+			Initial: "Pending",
+			Phases: []Phase[*object]{
+				{Name: "Pending", Handle: handle("Pending", Outcome{Next: "Deploying"}), Next: []string{"Deploying"}},
+				{
+					Name:         "Deploying",
+					Handle:       handle("Deploying", Outcome{Next: "Ready", Reason: "Deployed", RequeueAfter: 5 * time.Second}),
+					Timeout:      10 * time.Minute,
+					TimeoutPhase: "Failed",
+					Next:         []string{"Ready", "Failed"},
+				},
+				{
+					Name:   "Ready",
+					Handle: handle("Ready", Outcome{}),
+					Entry: func(ctx context.Context, obj *object) (bool, string, error) {
+						return obj.ready, "waiting for replicas", nil
+					},
+					Next: []string{"Deploying"},
+				},
+				{Name: "Failed", Handle: handle("Failed", Outcome{}), Next: []string{"Pending"}},
+			},
+			Current:   func(obj *object) string { return obj.phase },
+			EnteredAt: func(obj *object) time.Time { return obj.entered },
+			Enter: func(obj *object, t Transition) {
+				obj.phase = t.To
+				obj.entered = now
+			},
+			Now: func() time.Time { return now },
+		}
+		Expect(machine.Validate()).To(Succeed())
+	})
+
+	It("should start objects without a phase in the initial phase", func() {
+		obj := &object{}
+		result, err := machine.Step(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(handled).To(Equal([]string{"Pending"}))
+		Expect(result.Transition).To(Equal(&Transition{From: "", To: "Deploying"}))
+		Expect(result.Requeue).To(BeTrue())
+		Expect(obj.phase).To(Equal("Deploying"))
+	})
+
+	It("should wait in the phase while the entry criteria of the next one don't hold", func() {
+		obj := &object{phase: "Deploying", entered: now}
+		result, err := machine.Step(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Transition).To(BeNil())
+		Expect(result.Waiting).To(Equal("waiting for replicas"))
+		Expect(result.RequeueAfter).To(Equal(5 * time.Second))
+		Expect(obj.phase).To(Equal("Deploying"))
+
+		obj.ready = true
+		result, err = machine.Step(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Transition).To(Equal(&Transition{From: "Deploying", To: "Ready", Reason: "Deployed"}))
+		Expect(obj.phase).To(Equal("Ready"))
+	})
+
+	It("should move to the timeout phase without running the handler", func() {
+		obj := &object{phase: "Deploying", entered: now.Add(-11 * time.Minute)}
+		result, err := machine.Step(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(handled).To(BeEmpty())
+		Expect(result.Transition.To).To(Equal("Failed"))
+		Expect(result.Transition.Reason).To(Equal(ReasonTimeout))
+	})
+
+	It("should not time out without a known entry time", func() {
+		obj := &object{phase: "Deploying"}
+		_, err := machine.Step(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(handled).To(Equal([]string{"Deploying"}))
+	})
+
+	It("should start unknown phases over in the initial phase", func() {
+		obj := &object{phase: "Creating"}
+		result, err := machine.Step(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(handled).To(BeEmpty())
+		Expect(result.Transition.To).To(Equal("Pending"))
+		Expect(result.Transition.Reason).To(Equal(ReasonUnknownPhase))
+	})
+
+	It("should refuse transitions the phase doesn't declare", func() {
+		machine.Phases[3].Handle = handle("Failed", Outcome{Next: "Ready"})
+		obj := &object{phase: "Failed", ready: true}
+		result, err := machine.Step(ctx, obj)
+		Expect(errors.Is(err, ErrInvalidTransition)).To(BeTrue())
+		Expect(result.Transition).To(BeNil())
+		Expect(obj.phase).To(Equal("Failed"))
+	})
+
+	It("should check transitions the handler recorded itself", func() {
+		machine.Phases[2].Handle = func(ctx context.Context, obj *object) (Outcome, error) {
+			obj.phase = "Deploying"
+			return Recorded(ctrl.Result{RequeueAfter: time.Minute}, nil)
+		}
+		obj := &object{phase: "Ready"}
+		result, err := machine.Step(ctx, obj)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Transition).To(BeNil())
+		Expect(result.RequeueAfter).To(Equal(time.Minute))
+
+		machine.Phases[2].Handle = func(ctx context.Context, obj *object) (Outcome, error) {
+			obj.phase = "Failed"
+			return Outcome{}, nil
+		}
+		obj = &object{phase: "Ready"}
+		_, err = machine.Step(ctx, obj)
+		Expect(errors.Is(err, ErrInvalidTransition)).To(BeTrue())
+	})
+
+	It("should return handler errors without a transition", func() {
+		machine.Phases[0].Handle = func(ctx context.Context, obj *object) (Outcome, error) {
+			return Outcome{Next: "Deploying"}, errors.New("boom")
+		}
+		obj := &object{}
+		result, err := machine.Step(ctx, obj)
+		Expect(err).To(MatchError("boom"))
+		Expect(result.Transition).To(BeNil())
+		Expect(obj.phase).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Validate", func() {
+	noop := func(ctx context.Context, obj *object) (Outcome, error) { return Outcome{}, nil }
+
+	It("should reject transitions to undeclared phases", func() {
+		m := &Machine[*object]{
+			Initial: "Pending",
+			Phases:  []Phase[*object]{{Name: "Pending", Handle: noop, Next: []string{"Ready"}}},
+		}
+		Expect(m.Validate()).To(MatchError(ContainSubstring(`undeclared phase "Ready"`)))
+	})
+
+	It("should reject timeouts to phases that can't follow", func() {
+		m := &Machine[*object]{
+			Initial: "Pending",
+			Phases: []Phase[*object]{
+				{Name: "Pending", Handle: noop, Timeout: time.Minute, TimeoutPhase: "Failed"},
+				{Name: "Failed", Handle: noop},
+			},
+		}
+		Expect(m.Validate()).To(MatchError(ContainSubstring("times out")))
+	})
+
+	It("should reject an undeclared initial phase", func() {
+		m := &Machine[*object]{Initial: "Pending"}
+		Expect(m.Validate()).To(MatchError(ContainSubstring("initial phase")))
+	})
+})
@@ -4,22 +4,55 @@ State Machine Controller - Complete Implementation
 This file demonstrates multi-phase reconciliation using a state machine pattern.
 It shows the complete implementation including:
 - State definitions
-- State machine dispatcher
+- Phase declarations for the phase engine (internal/phase, see phase-engine.go)
 - State handler functions
 - Resource creation during appropriate phases
-- Status updates with conditions
+- Status updates with the standard conditions (internal/conditions, see conditions-helpers.go)
 
 State Flow:
 Pending → Provisioning → Configuring → Deploying → Verifying → Ready
                                                               ↓
-                                                           Failed (on error)
+                                                           Failed (on error or timeout)
+                                                              ↓
+                                                           Pending (retry with backoff)
+
+Each phase declares its handler, the entry criteria that must be observed before the
+Database moves into it, how long it may take and which phases may follow it. A phase
+is left as soon as the observed state allows it, e.g. Verifying is entered once all
+StatefulSet replicas are ready, instead of after a fixed delay.
+
+A Failed Database starts over with exponential backoff (30s, 1m, 2m, ... up to 10m).
+After spec.maxRetries retries it stays Failed, until its spec changes: failures are
+counted per spec generation, so a fix to the spec gets a fresh set of retries. Reaching
+Ready clears them too, so unrelated failures far apart don't add up.
 
 IMPORTANT: Before using this code, you must update your API types (api/v1/database_types.go)
-to allow the new phase values. Update the Phase field enum:
+to allow the new phase values, record when the phase last changed and count failures
+(see module-08/solutions/database_types.go for the complete types):
 
+    // DatabaseSpec
+    // +kubebuilder:validation:Minimum=0
+    // +kubebuilder:default=5
+    // +optional
+    MaxRetries *int32 `json:"maxRetries,omitempty"`
+
+    // DatabaseStatus
     // +kubebuilder:validation:Enum=Pending;Provisioning;Configuring;Deploying;Verifying;Ready;Failed
     Phase string `json:"phase,omitempty"`
 
+    // PhaseTransitionTime is when the Database entered its current phase
+    // +optional
+    PhaseTransitionTime *metav1.Time `json:"phaseTransitionTime,omitempty"`
+
+    // ObservedGeneration is the spec generation the controller last acted on
+    // +optional
+    ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+    FailureCount      int32        `json:"failureCount,omitempty"`
+    FailureGeneration int64        `json:"failureGeneration,omitempty"`
+    LastFailureReason string       `json:"lastFailureReason,omitempty"`
+    NextRetryTime     *metav1.Time `json:"nextRetryTime,omitempty"`
+
 Then run: make manifests && make install
 */
 package controller
@@ -30,7 +63,6 @@ import (
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
-	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -39,6 +71,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/conditions"
+	"github.com/example/postgres-operator/internal/phase"
 )
 
 // ============================================================================
@@ -60,6 +94,21 @@ const (
 
 const finalizerName = "database.example.com/finalizer"
 
+const (
+	// setupTimeout bounds Provisioning, Configuring and Verifying
+	setupTimeout = 5 * time.Minute
+	// deployTimeout bounds waiting for the StatefulSet replicas to become ready
+	deployTimeout = 10 * time.Minute
+	// waitInterval rechecks entry criteria that no watch event reports
+	waitInterval = 5 * time.Second
+	// defaultMaxRetries is how often a Failed Database starts over without spec.maxRetries
+	defaultMaxRetries = 5
+	// retryBackoff is the wait after the first failure, it doubles with every failure
+	// up to maxRetryBackoff
+	retryBackoff    = 30 * time.Second
+	maxRetryBackoff = 10 * time.Minute
+)
+
 // ============================================================================
 // Main Reconcile Function - Entry Point
 // ============================================================================
@@ -98,118 +147,288 @@ func (r *DatabaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	return r.reconcileWithStateMachine(ctx, db)
 }
 
+// ============================================================================
+// Phase Declarations
+// ============================================================================
+
+// stateMachine declares the phases of a Database
+func (r *DatabaseReconciler) stateMachine() *phase.Machine[*databasev1.Database] {
+	return &phase.Machine[*databasev1.Database]{
+		Initial: string(StatePending),
+		Phases: []phase.Phase[*databasev1.Database]{
+			{
+				Name:   string(StatePending),
+				Handle: r.handlePending,
+				Next:   states(StateProvisioning),
+			},
+			{
+				Name:         string(StateProvisioning),
+				Handle:       r.handleProvisioning,
+				Timeout:      setupTimeout,
+				TimeoutPhase: string(StateFailed),
+				Next:         states(StateConfiguring, StateFailed),
+			},
+			{
+				Name:         string(StateConfiguring),
+				Handle:       r.handleConfiguring,
+				Entry:        r.statefulSetExists,
+				Timeout:      setupTimeout,
+				TimeoutPhase: string(StateFailed),
+				Next:         states(StateDeploying, StateFailed),
+			},
+			{
+				Name:         string(StateDeploying),
+				Handle:       r.handleDeploying,
+				Timeout:      deployTimeout,
+				TimeoutPhase: string(StateFailed),
+				Next:         states(StateVerifying, StateProvisioning, StateFailed),
+			},
+			{
+				Name:         string(StateVerifying),
+				Handle:       r.handleVerifying,
+				Entry:        r.replicasReady,
+				Timeout:      setupTimeout,
+				TimeoutPhase: string(StateFailed),
+				Next:         states(StateReady, StateFailed),
+			},
+			{
+				Name:   string(StateReady),
+				Handle: r.handleReady,
+				Next:   states(StateProvisioning, StateDeploying),
+			},
+			{
+				Name:   string(StateFailed),
+				Handle: r.handleFailed,
+				Next:   states(StatePending),
+			},
+		},
+		Current: func(db *databasev1.Database) string { return db.Status.Phase },
+		EnteredAt: func(db *databasev1.Database) time.Time {
+			if db.Status.PhaseTransitionTime == nil {
+				return time.Time{}
+			}
+			return db.Status.PhaseTransitionTime.Time
+		},
+		Enter: r.enterPhase,
+	}
+}
+
+// states converts DatabaseStates to the phase names of the engine
+func states(s ...DatabaseState) []string {
+	names := make([]string, len(s))
+	for i, state := range s {
+		names[i] = string(state)
+	}
+	return names
+}
+
 // ============================================================================
 // State Machine Dispatcher
 // ============================================================================
 
-// reconcileWithStateMachine routes reconciliation to the appropriate state handler
+// reconcileWithStateMachine runs the handler of the current phase and persists
+// the transition it leads to
 func (r *DatabaseReconciler) reconcileWithStateMachine(ctx context.Context, db *databasev1.Database) (ctrl.Result, error) {
-	currentState := DatabaseState(db.Status.Phase)
-	if currentState == "" {
-		currentState = StatePending
+	logger := log.FromContext(ctx)
+	logger.Info("Reconciling", "state", db.Status.Phase)
+
+	machine := r.stateMachine()
+	if err := machine.Validate(); err != nil {
+		return ctrl.Result{}, err
 	}
 
-	logger := log.FromContext(ctx)
-	logger.Info("Reconciling", "state", currentState)
-
-	switch currentState {
-	case StatePending:
-		return r.transitionToProvisioning(ctx, db)
-	case StateProvisioning:
-		return r.handleProvisioning(ctx, db)
-	case StateConfiguring:
-		return r.handleConfiguring(ctx, db)
-	case StateDeploying:
-		return r.handleDeploying(ctx, db)
-	case StateVerifying:
-		return r.handleVerifying(ctx, db)
+	// Failures are counted per spec generation, a changed spec starts with a clean slate.
+	// A Failed Database starts over right away (see handleFailed), which clears them.
+	if failuresOutdated(db) && db.Status.Phase != string(StateFailed) {
+		logger.Info("Spec changed, clearing failures", "failures", db.Status.FailureCount)
+		clearFailures(db)
+		if err := r.Status().Update(ctx, db); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+	}
+
+	result, err := machine.Step(ctx, db)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if t := result.Transition; t != nil {
+		logger.Info("STATE TRANSITION", "from", t.From, "to", t.To, "reason", t.Reason, "database", db.Name)
+		if err := r.Status().Update(ctx, db); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+		return result.Result, nil
+	}
+
+	// Only write the status when there is news, every write triggers another reconcile
+	update := false
+
+	// Without a transition the current phase has acted on the spec, e.g. Deploying
+	// waits for the StatefulSet to roll it out (Ready leaves for Deploying on a change)
+	if db.Status.ObservedGeneration != db.Generation {
+		db.Status.ObservedGeneration = db.Generation
+		if cond := conditions.Get(db, conditions.Ready); cond != nil {
+			conditions.Set(db, conditions.Ready, cond.Status, conditions.Reason(cond.Reason), cond.Message)
+		}
+		update = true
+	}
+
+	if result.Waiting != "" {
+		logger.Info("Waiting to leave phase", "state", db.Status.Phase, "waitingFor", result.Waiting)
+		if cond := conditions.Get(db, conditions.Reconciling); cond == nil || cond.Message != result.Waiting {
+			conditions.MarkReconciling(db, conditions.ReasonWaiting, result.Waiting)
+			update = true
+		}
+		if result.RequeueAfter == 0 {
+			result.RequeueAfter = waitInterval
+		}
+	}
+
+	if update {
+		if err := r.Status().Update(ctx, db); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+	}
+	return result.Result, nil
+}
+
+// enterPhase records a transition in the status, the dispatcher persists it.
+// Transitions are decided on the current spec, so they record its generation.
+func (r *DatabaseReconciler) enterPhase(db *databasev1.Database, t phase.Transition) {
+	now := metav1.Now()
+	db.Status.Phase = t.To
+	db.Status.PhaseTransitionTime = &now
+	db.Status.ObservedGeneration = db.Generation
+	db.Status.Ready = t.To == string(StateReady)
+	if failuresOutdated(db) {
+		clearFailures(db)
+	}
+
+	reason, message := conditions.Reason(t.Reason), t.Message
+	if reason == "" {
+		reason = conditions.Reason(t.To)
+	}
+	switch DatabaseState(t.To) {
 	case StateReady:
-		return r.handleReady(ctx, db)
+		// Recovered: later failures start over with a short backoff and all retries
+		clearFailures(db)
+		conditions.MarkReady(db, reason, message)
 	case StateFailed:
-		return r.handleFailed(ctx, db)
+		// A Database that will be retried is still reconciling, one out of retries is stalled
+		if recordFailure(db, string(reason), now.Time) {
+			conditions.MarkReconciling(db, reason,
+				fmt.Sprintf("%s, retrying at %s", message, db.Status.NextRetryTime.Format(time.RFC3339)))
+		} else {
+			conditions.MarkStalled(db, conditions.ReasonRetriesExhausted,
+				fmt.Sprintf("%s (failed %d times, waiting for a spec change)", message, db.Status.FailureCount))
+		}
 	default:
-		logger.Info("Unknown state, resetting to Pending", "state", currentState)
-		db.Status.Phase = string(StatePending)
-		return ctrl.Result{}, r.Status().Update(ctx, db)
+		conditions.MarkReconciling(db, reason, message)
 	}
 }
 
 // ============================================================================
-// State Handlers
+// Entry Criteria
 // ============================================================================
 
-// transitionToProvisioning moves from Pending to Provisioning
-func (r *DatabaseReconciler) transitionToProvisioning(ctx context.Context, db *databasev1.Database) (ctrl.Result, error) {
-	logger := log.FromContext(ctx)
-	logger.Info("STATE TRANSITION: Pending -> Provisioning", "database", db.Name)
+// statefulSetExists lets a Database enter Configuring once the StatefulSet is observed
+func (r *DatabaseReconciler) statefulSetExists(ctx context.Context, db *databasev1.Database) (bool, string, error) {
+	statefulSet := &appsv1.StatefulSet{}
+	err := r.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, statefulSet)
+	if errors.IsNotFound(err) {
+		return false, "Waiting for the StatefulSet to be created", nil
+	}
+	if err != nil {
+		return false, "", err
+	}
+	return true, "", nil
+}
 
-	db.Status.Phase = string(StateProvisioning)
-	db.Status.Ready = false
-	r.setCondition(db, "Progressing", metav1.ConditionTrue, "Provisioning", "Starting provisioning")
-	r.setCondition(db, "Ready", metav1.ConditionFalse, "Provisioning", "Database is being provisioned")
-	if err := r.Status().Update(ctx, db); err != nil {
-		return ctrl.Result{}, err
+// replicasReady lets a Database enter Verifying once all StatefulSet replicas run the
+// current pod template and are ready
+func (r *DatabaseReconciler) replicasReady(ctx context.Context, db *databasev1.Database) (bool, string, error) {
+	statefulSet := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, statefulSet); err != nil {
+		return false, "", err
+	}
+	ready, waiting := statefulSetRolledOut(statefulSet)
+	return ready, waiting, nil
+}
+
+// statefulSetRolledOut reports whether the StatefulSet controller has acted on the
+// current spec and all replicas are updated and ready, and what it waits for if not
+func statefulSetRolledOut(statefulSet *appsv1.StatefulSet) (bool, string) {
+	desiredReplicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		desiredReplicas = *statefulSet.Spec.Replicas
+	}
+	status := statefulSet.Status
+	switch {
+	case status.ObservedGeneration < statefulSet.Generation:
+		return false, "Waiting for the StatefulSet controller to observe the new spec"
+	case status.UpdatedReplicas < desiredReplicas:
+		return false, fmt.Sprintf("Waiting for replicas to be updated: %d/%d updated", status.UpdatedReplicas, desiredReplicas)
+	case status.ReadyReplicas < desiredReplicas:
+		return false, fmt.Sprintf("Waiting for replicas: %d/%d ready", status.ReadyReplicas, desiredReplicas)
 	}
+	return true, ""
+}
 
-	logger.Info("Waiting 15 seconds before next reconciliation (for visualization)", "currentPhase", db.Status.Phase)
-	// Delay to visualize state transition (remove in production)
-	return ctrl.Result{RequeueAfter: 15 * time.Second}, nil
+// ============================================================================
+// State Handlers
+// ============================================================================
+
+// handlePending starts provisioning a new Database
+func (r *DatabaseReconciler) handlePending(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
+	return phase.Outcome{
+		Next:    string(StateProvisioning),
+		Reason:  "Provisioning",
+		Message: "Database is being provisioned",
+	}, nil
 }
 
 // handleProvisioning creates the Secret and StatefulSet
-func (r *DatabaseReconciler) handleProvisioning(ctx context.Context, db *databasev1.Database) (ctrl.Result, error) {
+func (r *DatabaseReconciler) handleProvisioning(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
 	logger := log.FromContext(ctx)
 	logger.Info("Handling Provisioning phase", "database", db.Name)
 
 	// Ensure Secret exists first (StatefulSet needs it for credentials)
 	if err := r.reconcileSecret(ctx, db); err != nil {
 		logger.Error(err, "Failed to reconcile Secret")
-		return r.transitionToFailed(ctx, db, "SecretCreationFailed", err.Error())
+		return failed("SecretCreationFailed", err), nil
 	}
 
-	// Check if StatefulSet exists
-	statefulSet := &appsv1.StatefulSet{}
-	err := r.Get(ctx, client.ObjectKey{
-		Name:      db.Name,
-		Namespace: db.Namespace,
-	}, statefulSet)
-
-	if errors.IsNotFound(err) {
-		// Create StatefulSet
-		logger.Info("Creating StatefulSet", "database", db.Name)
-		if err := r.reconcileStatefulSet(ctx, db); err != nil {
-			logger.Error(err, "Failed to create StatefulSet")
-			return r.transitionToFailed(ctx, db, "StatefulSetCreationFailed", err.Error())
-		}
-		logger.Info("StatefulSet created, requeuing")
-		return ctrl.Result{Requeue: true}, nil
-	} else if err != nil {
-		return ctrl.Result{}, err
-	}
-
-	// StatefulSet exists, move to next phase
-	logger.Info("STATE TRANSITION: Provisioning -> Configuring", "database", db.Name)
-	db.Status.Phase = string(StateConfiguring)
-	r.setCondition(db, "Progressing", metav1.ConditionTrue, "Configuring", "StatefulSet created, configuring")
-	if err := r.Status().Update(ctx, db); err != nil {
-		return ctrl.Result{}, err
+	// Create the StatefulSet, the Configuring phase is entered once it is observed
+	if err := r.reconcileStatefulSet(ctx, db); err != nil {
+		logger.Error(err, "Failed to create StatefulSet")
+		return failed("StatefulSetCreationFailed", err), nil
 	}
 
-	logger.Info("Waiting 15 seconds before next reconciliation (for visualization)", "currentPhase", db.Status.Phase)
-	// Delay to visualize state transition (remove in production)
-	return ctrl.Result{RequeueAfter: 15 * time.Second}, nil
+	return phase.Outcome{
+		Next:    string(StateConfiguring),
+		Reason:  "Configuring",
+		Message: "StatefulSet created, configuring",
+	}, nil
 }
 
 // handleConfiguring creates the Service and performs configuration
-func (r *DatabaseReconciler) handleConfiguring(ctx context.Context, db *databasev1.Database) (ctrl.Result, error) {
+func (r *DatabaseReconciler) handleConfiguring(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
 	logger := log.FromContext(ctx)
 	logger.Info("Handling Configuring phase", "database", db.Name)
 
 	// Ensure Service exists
-	logger.Info("Creating Service", "database", db.Name)
 	if err := r.reconcileService(ctx, db); err != nil {
 		logger.Error(err, "Failed to reconcile Service")
-		return r.transitionToFailed(ctx, db, "ServiceCreationFailed", err.Error())
+		return failed("ServiceCreationFailed", err), nil
 	}
 
 	// Configure database (create users, databases, etc.)
@@ -219,24 +438,18 @@ func (r *DatabaseReconciler) handleConfiguring(ctx context.Context, db *database
 	// - Create database users
 	// - Set up replication
 
-	logger.Info("STATE TRANSITION: Configuring -> Deploying", "database", db.Name)
-	db.Status.Phase = string(StateDeploying)
-	r.setCondition(db, "Progressing", metav1.ConditionTrue, "Deploying", "Configuration complete, deploying")
-	if err := r.Status().Update(ctx, db); err != nil {
-		return ctrl.Result{}, err
-	}
-
-	logger.Info("Waiting 15 seconds before next reconciliation (for visualization)", "currentPhase", db.Status.Phase)
-	// Delay to visualize state transition (remove in production)
-	return ctrl.Result{RequeueAfter: 15 * time.Second}, nil
+	return phase.Outcome{
+		Next:    string(StateDeploying),
+		Reason:  "Deploying",
+		Message: "Configuration complete, deploying",
+	}, nil
 }
 
-// handleDeploying waits for the StatefulSet to be ready
-func (r *DatabaseReconciler) handleDeploying(ctx context.Context, db *databasev1.Database) (ctrl.Result, error) {
+// handleDeploying moves on to Verifying, which is entered once the replicas are ready
+func (r *DatabaseReconciler) handleDeploying(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
 	logger := log.FromContext(ctx)
 	logger.Info("Handling Deploying phase", "database", db.Name)
 
-	// Check if StatefulSet is ready
 	statefulSet := &appsv1.StatefulSet{}
 	if err := r.Get(ctx, client.ObjectKey{
 		Name:      db.Name,
@@ -244,47 +457,24 @@ func (r *DatabaseReconciler) handleDeploying(ctx context.Context, db *databasev1
 	}, statefulSet); err != nil {
 		if errors.IsNotFound(err) {
 			// StatefulSet was deleted, go back to provisioning
-			logger.Info("StatefulSet not found, transitioning back to Provisioning")
-			db.Status.Phase = string(StateProvisioning)
-			return ctrl.Result{}, r.Status().Update(ctx, db)
+			return phase.Outcome{
+				Next:    string(StateProvisioning),
+				Reason:  "StatefulSetMissing",
+				Message: "StatefulSet was deleted",
+			}, nil
 		}
-		return ctrl.Result{}, err
+		return phase.Outcome{}, err
 	}
 
-	// Check replica readiness
-	desiredReplicas := int32(1)
-	if statefulSet.Spec.Replicas != nil {
-		desiredReplicas = *statefulSet.Spec.Replicas
-	}
-
-	if statefulSet.Status.ReadyReplicas >= desiredReplicas {
-		logger.Info("STATE TRANSITION: Deploying -> Verifying", "database", db.Name)
-		db.Status.Phase = string(StateVerifying)
-		r.setCondition(db, "Progressing", metav1.ConditionTrue, "Verifying", "Deployment complete, verifying")
-		if err := r.Status().Update(ctx, db); err != nil {
-			return ctrl.Result{}, err
-		}
-
-		logger.Info("Waiting 15 seconds before next reconciliation (for visualization)", "currentPhase", db.Status.Phase)
-		// Delay to visualize state transition (remove in production)
-		return ctrl.Result{RequeueAfter: 15 * time.Second}, nil
-	}
-
-	// Not ready yet - update condition with progress
-	logger.Info("Waiting for StatefulSet replicas to be ready",
-		"database", db.Name,
-		"readyReplicas", statefulSet.Status.ReadyReplicas,
-		"desiredReplicas", desiredReplicas)
-	r.setCondition(db, "Progressing", metav1.ConditionTrue, "WaitingForReplicas",
-		fmt.Sprintf("Waiting for replicas: %d/%d ready", statefulSet.Status.ReadyReplicas, desiredReplicas))
-	if err := r.Status().Update(ctx, db); err != nil {
-		return ctrl.Result{}, err
-	}
-	return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	return phase.Outcome{
+		Next:    string(StateVerifying),
+		Reason:  "Verifying",
+		Message: "Deployment complete, verifying",
+	}, nil
 }
 
 // handleVerifying performs health checks before marking as Ready
-func (r *DatabaseReconciler) handleVerifying(ctx context.Context, db *databasev1.Database) (ctrl.Result, error) {
+func (r *DatabaseReconciler) handleVerifying(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
 	logger := log.FromContext(ctx)
 	logger.Info("Handling Verifying phase", "database", db.Name)
 
@@ -296,23 +486,19 @@ func (r *DatabaseReconciler) handleVerifying(ctx context.Context, db *databasev1
 	// - Verify backups are configured
 
 	// For this example, we assume verification passes
-	logger.Info("STATE TRANSITION: Verifying -> Ready", "database", db.Name)
-
-	db.Status.Phase = string(StateReady)
-	db.Status.Ready = true
 	db.Status.SecretName = r.secretName(db)
 	db.Status.Endpoint = fmt.Sprintf("%s.%s.svc.cluster.local:5432", db.Name, db.Namespace)
-
-	r.setCondition(db, "Ready", metav1.ConditionTrue, "AllChecksPassed", "Database is ready")
-	r.setCondition(db, "Progressing", metav1.ConditionFalse, "ReconciliationComplete", "Reconciliation complete")
-
 	logger.Info("Database is now READY!", "database", db.Name, "endpoint", db.Status.Endpoint)
 
-	return ctrl.Result{}, r.Status().Update(ctx, db)
+	return phase.Outcome{
+		Next:    string(StateReady),
+		Reason:  "AllChecksPassed",
+		Message: "Database is ready",
+	}, nil
 }
 
 // handleReady monitors the ready state and handles updates
-func (r *DatabaseReconciler) handleReady(ctx context.Context, db *databasev1.Database) (ctrl.Result, error) {
+func (r *DatabaseReconciler) handleReady(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
 	logger := log.FromContext(ctx)
 
 	// Check if StatefulSet still exists and is healthy
@@ -323,66 +509,128 @@ func (r *DatabaseReconciler) handleReady(ctx context.Context, db *databasev1.Dat
 	}, statefulSet); err != nil {
 		if errors.IsNotFound(err) {
 			// StatefulSet was deleted, need to re-provision
-			logger.Info("StatefulSet deleted, transitioning to Provisioning")
-			db.Status.Phase = string(StateProvisioning)
-			db.Status.Ready = false
-			r.setCondition(db, "Ready", metav1.ConditionFalse, "StatefulSetMissing", "StatefulSet was deleted")
-			return ctrl.Result{}, r.Status().Update(ctx, db)
+			return phase.Outcome{
+				Next:    string(StateProvisioning),
+				Reason:  "StatefulSetMissing",
+				Message: "StatefulSet was deleted",
+			}, nil
 		}
-		return ctrl.Result{}, err
+		return phase.Outcome{}, err
 	}
 
 	// Check if spec changed (e.g., replicas, image)
 	if err := r.reconcileStatefulSet(ctx, db); err != nil {
 		logger.Error(err, "Failed to reconcile StatefulSet")
-		return ctrl.Result{}, err
+		return phase.Outcome{}, err
 	}
 
-	// If replicas changed and not all ready, go back to Deploying
-	desiredReplicas := int32(1)
-	if statefulSet.Spec.Replicas != nil {
-		desiredReplicas = *statefulSet.Spec.Replicas
+	// A changed spec is only Ready once the StatefulSet rolled it out, which the
+	// StatefulSet read above can't tell yet. Verifying is entered once it has.
+	if db.Status.ObservedGeneration != db.Generation {
+		return phase.Outcome{
+			Next:    string(StateDeploying),
+			Reason:  "SpecChanged",
+			Message: "Rolling out the changed spec",
+		}, nil
 	}
 
-	if statefulSet.Status.ReadyReplicas < desiredReplicas {
-		logger.Info("Replicas not ready, transitioning to Deploying")
-		db.Status.Phase = string(StateDeploying)
-		db.Status.Ready = false
-		r.setCondition(db, "Ready", metav1.ConditionFalse, "ScalingInProgress", "Scaling operation in progress")
-		return ctrl.Result{}, r.Status().Update(ctx, db)
+	// Pods that went away, go back to Deploying until they are back
+	if rolledOut, waiting := statefulSetRolledOut(statefulSet); !rolledOut {
+		return phase.Outcome{
+			Next:    string(StateDeploying),
+			Reason:  "ScalingInProgress",
+			Message: waiting,
+		}, nil
 	}
 
 	// Everything is good
-	return ctrl.Result{}, nil
+	return phase.Outcome{}, nil
 }
 
-// handleFailed handles the failed state with retry logic
-func (r *DatabaseReconciler) handleFailed(ctx context.Context, db *databasev1.Database) (ctrl.Result, error) {
+// handleFailed starts over from Pending at the next retry time, or right away when the
+// spec changed. Without a retry time the retries are used up and the Database stays Failed.
+func (r *DatabaseReconciler) handleFailed(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
 	logger := log.FromContext(ctx)
 
-	// Check if we should retry
-	// In a real operator, you might:
-	// - Check retry count
-	// - Implement exponential backoff
-	// - Check if the error condition has been resolved
+	if failuresOutdated(db) {
+		return phase.Outcome{
+			Next:    string(StatePending),
+			Reason:  "SpecChanged",
+			Message: "Spec changed, retrying",
+		}, nil
+	}
+
+	if next := db.Status.NextRetryTime; next != nil {
+		if wait := time.Until(next.Time); wait > 0 {
+			logger.Info("In Failed state, will retry", "retryAfter", wait, "failures", db.Status.FailureCount)
+			return phase.Outcome{RequeueAfter: wait}, nil
+		}
+	} else if db.Status.FailureCount > 0 {
+		// No requeue, the spec change triggers the next reconcile
+		logger.Info("In Failed state, retries exhausted", "failures", db.Status.FailureCount)
+		return phase.Outcome{}, nil
+	}
+
+	return phase.Outcome{
+		Next:    string(StatePending),
+		Reason:  "Retrying",
+		Message: fmt.Sprintf("Retrying after failure %d: %s", db.Status.FailureCount, db.Status.LastFailureReason),
+	}, nil
+}
+
+// failed is the outcome of a handler that failed with err
+func failed(reason string, err error) phase.Outcome {
+	return phase.Outcome{
+		Next:    string(StateFailed),
+		Reason:  reason,
+		Message: err.Error(),
+	}
+}
+
+// ============================================================================
+// Failure Tracking
+// ============================================================================
 
-	logger.Info("In Failed state, will retry", "retryAfter", "1m")
+// recordFailure counts a failure and schedules the next retry.
+// It returns false once the retries are used up.
+func recordFailure(db *databasev1.Database, reason string, now time.Time) bool {
+	db.Status.FailureCount++
+	db.Status.FailureGeneration = db.Generation
+	db.Status.LastFailureReason = reason
+	db.Status.NextRetryTime = nil
+
+	maxRetries := int32(defaultMaxRetries)
+	if db.Spec.MaxRetries != nil {
+		maxRetries = *db.Spec.MaxRetries
+	}
+	// The first failure isn't a retry yet
+	if db.Status.FailureCount > maxRetries {
+		return false
+	}
 
-	// For now, transition back to Pending to retry
-	// A more sophisticated implementation would track retry counts
-	db.Status.Phase = string(StatePending)
-	r.setCondition(db, "Progressing", metav1.ConditionTrue, "Retrying", "Retrying after failure")
+	backoff := retryBackoff
+	for i := int32(1); i < db.Status.FailureCount && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	next := metav1.NewTime(now.Add(backoff))
+	db.Status.NextRetryTime = &next
+	return true
+}
 
-	return ctrl.Result{RequeueAfter: 1 * time.Minute}, r.Status().Update(ctx, db)
+// failuresOutdated reports whether the failures were counted for an older spec generation
+func failuresOutdated(db *databasev1.Database) bool {
+	return db.Status.FailureCount > 0 && db.Status.FailureGeneration != db.Generation
 }
 
-// transitionToFailed is a helper to transition to the Failed state
-func (r *DatabaseReconciler) transitionToFailed(ctx context.Context, db *databasev1.Database, reason, message string) (ctrl.Result, error) {
-	db.Status.Phase = string(StateFailed)
-	db.Status.Ready = false
-	r.setCondition(db, "Ready", metav1.ConditionFalse, reason, message)
-	r.setCondition(db, "Progressing", metav1.ConditionFalse, "Failed", "Reconciliation failed")
-	return ctrl.Result{}, r.Status().Update(ctx, db)
+// clearFailures forgets the failures of an older spec generation
+func clearFailures(db *databasev1.Database) {
+	db.Status.FailureCount = 0
+	db.Status.FailureGeneration = 0
+	db.Status.LastFailureReason = ""
+	db.Status.NextRetryTime = nil
 }
 
 // ============================================================================
@@ -394,6 +642,4 @@ func (r *DatabaseReconciler) transitionToFailed(ctx context.Context, db *databas
 // - reconcileStatefulSet(ctx, db) error
 // - reconcileService(ctx, db) error
 // - handleDeletion(ctx, db) (ctrl.Result, error)
-// - setCondition(db, type, status, reason, message)
 // - secretName(db) string
-
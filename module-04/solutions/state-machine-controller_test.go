@@ -0,0 +1,154 @@
+// Solution: State Machine Retry Tests from Module 4
+// This tests the retry backoff and the retry limit of a Failed Database, and when a
+// StatefulSet counts as rolled out, no cluster needed.
+// Location: internal/controller/state_machine_controller_test.go
+//
+// The specs run in the controller suite kubebuilder scaffolded (internal/controller/suite_test.go).
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/phase"
+)
+
+var _ = Describe("Database retries", func() {
+	var (
+		db  *databasev1.Database
+		now time.Time
+	)
+
+	BeforeEach(func() {
+		db = &databasev1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "default", Generation: 1},
+			Spec:       databasev1.DatabaseSpec{MaxRetries: ptr.To(int32(10))},
+		}
+		now = time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC)
+	})
+
+	// retryDelays records n failures and returns the wait before each retry
+	retryDelays := func(n int) []time.Duration {
+		var delays []time.Duration
+		for i := 0; i < n; i++ {
+			Expect(recordFailure(db, "ProvisioningFailed", now)).To(BeTrue())
+			delays = append(delays, db.Status.NextRetryTime.Sub(now))
+		}
+		return delays
+	}
+
+	It("should double the backoff up to the maximum", func() {
+		Expect(retryDelays(7)).To(Equal([]time.Duration{
+			30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute,
+			maxRetryBackoff, maxRetryBackoff,
+		}))
+		Expect(db.Status.FailureCount).To(Equal(int32(7)))
+		Expect(db.Status.FailureGeneration).To(Equal(int64(1)))
+		Expect(db.Status.LastFailureReason).To(Equal("ProvisioningFailed"))
+	})
+
+	It("should stop retrying after spec.maxRetries retries", func() {
+		db.Spec.MaxRetries = ptr.To(int32(2))
+		retryDelays(2)
+		Expect(recordFailure(db, "ProvisioningFailed", now)).To(BeFalse())
+		Expect(db.Status.NextRetryTime).To(BeNil())
+		Expect(db.Status.FailureCount).To(Equal(int32(3)))
+	})
+
+	It("should retry defaultMaxRetries times without spec.maxRetries", func() {
+		db.Spec.MaxRetries = nil
+		retryDelays(defaultMaxRetries)
+		Expect(recordFailure(db, "ProvisioningFailed", now)).To(BeFalse())
+	})
+
+	It("should not retry at all with maxRetries 0", func() {
+		db.Spec.MaxRetries = ptr.To(int32(0))
+		Expect(recordFailure(db, "ProvisioningFailed", now)).To(BeFalse())
+		Expect(db.Status.NextRetryTime).To(BeNil())
+	})
+
+	It("should start counting again after the Database recovered", func() {
+		r := &DatabaseReconciler{}
+		db.Spec.MaxRetries = ptr.To(int32(2))
+		retryDelays(2)
+
+		r.enterPhase(db, phase.Transition{From: string(StateVerifying), To: string(StateReady)})
+		Expect(db.Status.FailureCount).To(BeZero())
+		Expect(db.Status.NextRetryTime).To(BeNil())
+
+		Expect(retryDelays(2)).To(Equal([]time.Duration{30 * time.Second, time.Minute}))
+	})
+
+	It("should stay Failed once the retries are used up, until the spec changes", func() {
+		r := &DatabaseReconciler{}
+		db.Spec.MaxRetries = ptr.To(int32(0))
+		recordFailure(db, "ProvisioningFailed", now)
+
+		outcome, err := r.handleFailed(context.Background(), db)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome.Next).To(BeEmpty())
+		Expect(outcome.RequeueAfter).To(BeZero())
+
+		db.Generation = 2
+		Expect(failuresOutdated(db)).To(BeTrue())
+		outcome, err = r.handleFailed(context.Background(), db)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome.Next).To(Equal(string(StatePending)))
+		Expect(outcome.Reason).To(Equal("SpecChanged"))
+	})
+
+	It("should wait for the retry time before starting over", func() {
+		r := &DatabaseReconciler{}
+		Expect(recordFailure(db, "ProvisioningFailed", time.Now())).To(BeTrue())
+
+		outcome, err := r.handleFailed(context.Background(), db)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome.Next).To(BeEmpty())
+		Expect(outcome.RequeueAfter).To(BeNumerically("~", retryBackoff, time.Second))
+
+		db.Status.NextRetryTime = &metav1.Time{Time: time.Now().Add(-time.Second)}
+		outcome, err = r.handleFailed(context.Background(), db)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outcome.Next).To(Equal(string(StatePending)))
+		Expect(outcome.Reason).To(Equal("Retrying"))
+	})
+})
+
+var _ = Describe("StatefulSet rollout", func() {
+	rolledOut := func(generation, observed int64, replicas, updated, ready int32) bool {
+		ok, _ := statefulSetRolledOut(&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Generation: generation},
+			Spec:       appsv1.StatefulSetSpec{Replicas: ptr.To(replicas)},
+			Status: appsv1.StatefulSetStatus{
+				ObservedGeneration: observed,
+				UpdatedReplicas:    updated,
+				ReadyReplicas:      ready,
+			},
+		})
+		return ok
+	}
+
+	It("should wait until the StatefulSet controller observed the new spec", func() {
+		Expect(rolledOut(2, 1, 3, 3, 3)).To(BeFalse())
+	})
+
+	It("should wait until every replica runs the new template", func() {
+		Expect(rolledOut(2, 2, 3, 1, 3)).To(BeFalse())
+	})
+
+	It("should wait until every replica is ready", func() {
+		Expect(rolledOut(2, 2, 3, 3, 2)).To(BeFalse())
+	})
+
+	It("should be rolled out once all replicas are updated and ready", func() {
+		Expect(rolledOut(2, 2, 3, 3, 3)).To(BeTrue())
+	})
+})
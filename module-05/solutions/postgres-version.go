@@ -0,0 +1,82 @@
+// Solution: PostgreSQL Versions from Module 5
+// This parses the PostgreSQL version out of image references and server version strings
+// Location: internal/postgres/version.go
+//
+// Since PostgreSQL 10 a version has two numbers: the major version, which decides the
+// on-disk format of the data directory, and the minor version (bug fixes). Images of
+// the same major version can replace each other in place; a new major version needs
+// pg_upgrade (see the major upgrade solution in Module 8). The webhook uses this to
+// reject images without a version and downgrades.
+
+package postgres
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MinMajor is the oldest supported major version, the first with the two-number scheme
+const MinMajor = 10
+
+// versionPattern matches the version at the start of an image tag, e.g. 16, 16.2 or 16.2-alpine
+var versionPattern = regexp.MustCompile(`^(\d+)(?:\.(\d+))?(?:$|[-.])`)
+
+// Version is a PostgreSQL release, e.g. 16.2. Minor is -1 if unknown, e.g. for postgres:16.
+type Version struct {
+	Major int
+	Minor int
+}
+
+func (v Version) String() string {
+	if v.Minor < 0 {
+		return strconv.Itoa(v.Major)
+	}
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// ParseVersion parses a version such as "16", "16.2" or "16.2-bookworm"
+func ParseVersion(s string) (Version, error) {
+	m := versionPattern.FindStringSubmatch(s)
+	if m == nil {
+		return Version{}, fmt.Errorf("%q is not a PostgreSQL version", s)
+	}
+	v := Version{Minor: -1}
+	v.Major, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		v.Minor, _ = strconv.Atoi(m[2])
+	}
+	if v.Major < MinMajor {
+		return Version{}, fmt.Errorf("PostgreSQL %s is not supported, the oldest supported major version is %d", s, MinMajor)
+	}
+	return v, nil
+}
+
+// ParseImage returns the version in the tag of a PostgreSQL image, e.g.
+// postgres:16.2-alpine or registry.example.com:5000/postgres:14@sha256:...
+// Images without a version tag (latest or only a digest) are rejected, because
+// an upgrade can't be told from a restart with them.
+func ParseImage(image string) (Version, error) {
+	name, _, _ := strings.Cut(image, "@")
+	name = name[strings.LastIndex(name, "/")+1:]
+	_, tag, ok := strings.Cut(name, ":")
+	if !ok || tag == "" {
+		return Version{}, fmt.Errorf("image %q has no version tag", image)
+	}
+	v, err := ParseVersion(tag)
+	if err != nil {
+		return Version{}, fmt.Errorf("image %q: %w", image, err)
+	}
+	return v, nil
+}
+
+// ParseServerVersion parses the output of postgres --version,
+// e.g. "postgres (PostgreSQL) 16.2 (Debian 16.2-1.pgdg120+2)"
+func ParseServerVersion(output string) (Version, error) {
+	fields := strings.Fields(output)
+	if len(fields) < 3 {
+		return Version{}, fmt.Errorf("unexpected version output %q", output)
+	}
+	return ParseVersion(fields[2])
+}
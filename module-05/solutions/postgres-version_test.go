@@ -0,0 +1,53 @@
+// Solution: PostgreSQL Version Tests from Module 5
+// This tests the version parsing the webhook and the major upgrade rely on
+// Location: internal/postgres/version_test.go
+
+package postgres
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestPostgres(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "PostgreSQL Version Suite")
+}
+
+var _ = Describe("Version", func() {
+	DescribeTable("should parse image tags",
+		func(image string, major, minor int) {
+			v, err := ParseImage(image)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(v).To(Equal(Version{Major: major, Minor: minor}))
+		},
+		Entry("major only", "postgres:14", 14, -1),
+		Entry("minor version", "postgres:16.2", 16, 2),
+		Entry("variant", "postgres:16.2-alpine", 16, 2),
+		Entry("major with variant", "postgres:15-bookworm", 15, -1),
+		Entry("registry with port", "registry.example.com:5000/library/postgres:13.14", 13, 14),
+		Entry("tag and digest", "postgres:16@sha256:4aea012537edfad80f98d870a36e6b90b4c09b27be7f4b4759d72db863baeebb", 16, -1),
+	)
+
+	DescribeTable("should reject images without a usable version",
+		func(image string) {
+			_, err := ParseImage(image)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("no tag", "postgres"),
+		Entry("latest", "postgres:latest"),
+		Entry("registry port only", "registry.example.com:5000/postgres"),
+		Entry("digest only", "postgres@sha256:4aea012537edfad80f98d870a36e6b90b4c09b27be7f4b4759d72db863baeebb"),
+		Entry("unsupported version", "postgres:9.6"),
+	)
+
+	It("should parse the server version", func() {
+		v, err := ParseServerVersion("postgres (PostgreSQL) 16.2 (Debian 16.2-1.pgdg120+2)\n")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(v).To(Equal(Version{Major: 16, Minor: 2}))
+		Expect(v.String()).To(Equal("16.2"))
+	})
+})
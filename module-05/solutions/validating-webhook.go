@@ -17,6 +17,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/postgres"
 )
 
 var databaselog = logf.Log.WithName("database-resource")
@@ -51,9 +52,11 @@ func (v *DatabaseCustomValidator) ValidateCreate(ctx context.Context, obj runtim
 
 	var errors []string
 
-	// Validate image is PostgreSQL
+	// Validate image is PostgreSQL with a version tag (see postgres-version.go)
 	if !strings.Contains(database.Spec.Image, "postgres") {
 		errors = append(errors, fmt.Sprintf("spec.image: must be a PostgreSQL image, got '%s'. Valid examples: postgres:14, postgres:13", database.Spec.Image))
+	} else if _, err := postgres.ParseImage(database.Spec.Image); err != nil {
+		errors = append(errors, fmt.Sprintf("spec.image: %v. Valid examples: postgres:16, postgres:16.2-alpine", err))
 	}
 
 	// Validate replicas and storage relationship
@@ -88,6 +91,23 @@ func (v *DatabaseCustomValidator) ValidateUpdate(ctx context.Context, oldObj, ne
 	databaselog.Info("Validation for Database upon update", "name", database.GetName())
 
 	var errors []string
+	var warnings admission.Warnings
+
+	// A new major version needs pg_upgrade, which can't go back
+	if oldDB.Spec.Image != database.Spec.Image {
+		oldVersion, oldErr := postgres.ParseImage(oldDB.Spec.Image)
+		newVersion, err := postgres.ParseImage(database.Spec.Image)
+		switch {
+		case err != nil:
+			errors = append(errors, fmt.Sprintf("spec.image: %v", err))
+		case oldErr != nil:
+			// Databases created before versions were checked can move to any tagged image
+		case newVersion.Major < oldVersion.Major:
+			errors = append(errors, fmt.Sprintf("spec.image: cannot downgrade from PostgreSQL %d to %d, restore a backup into a new Database instead", oldVersion.Major, newVersion.Major))
+		case newVersion.Major > oldVersion.Major:
+			warnings = append(warnings, fmt.Sprintf("spec.image: upgrading from PostgreSQL %d to %d stops the database while pg_upgrade runs, see spec.majorUpgrade", oldVersion.Major, newVersion.Major))
+		}
+	}
 
 	// Prevent reducing storage size
 	oldSize := parseStorageSize(oldDB.Spec.Storage.Size)
@@ -103,10 +123,10 @@ func (v *DatabaseCustomValidator) ValidateUpdate(ctx context.Context, oldObj, ne
 	}
 
 	if len(errors) > 0 {
-		return nil, fmt.Errorf("validation failed: %s", strings.Join(errors, "; "))
+		return warnings, fmt.Errorf("validation failed: %s", strings.Join(errors, "; "))
 	}
 
-	return nil, nil
+	return warnings, nil
 }
 
 // ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type Database.
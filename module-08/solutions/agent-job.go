@@ -0,0 +1,450 @@
+// Solution: Backup Agent Jobs from Module 8
+// This builds the Kubernetes Jobs that run pg_dump/psql for Backup and Restore
+// Location: internal/agent/job.go
+//
+// The Job runs in the Database's own PostgreSQL image, so pg_dump/psql always match
+// the server version and the operator image doesn't need PostgreSQL client tools.
+// An init container copies the backup-agent binary (see backup-agent-main.go) from
+// the operator image into a shared volume; the agent then streams data between
+// pg_dump/psql and the BackupStore.
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/storage"
+)
+
+const (
+	// ImageEnvVar is set on the manager Deployment to the operator image,
+	// which also contains the backup-agent binary
+	ImageEnvVar = "BACKUP_AGENT_IMAGE"
+
+	// JobTypeLabel marks Jobs created for Backups and Restores
+	JobTypeLabel = "database.example.com/job-type"
+
+	// LocationAnnotation records the backup location a Job reads or writes
+	LocationAnnotation = "database.example.com/backup-location"
+
+	// EncryptionKeyDir is where the encryption Secret is mounted in the Job
+	EncryptionKeyDir = "/etc/backup-encryption"
+
+	// terminationMessagePath is where the agent writes its Result for the controller
+	terminationMessagePath = "/dev/termination-log"
+
+	// InstalledPath is where the agent is installed in containers that run it
+	InstalledPath = "/agent/backup-agent"
+
+	binaryPath    = "/backup-agent"
+	agentDir      = "/agent"
+	agentVolume   = "agent"
+	dataVolume    = "data"
+	storageVolume = "backup-storage"
+	keysVolume    = "encryption-keys"
+)
+
+// Image returns the image containing the backup-agent binary
+func Image() string {
+	if image := os.Getenv(ImageEnvVar); image != "" {
+		return image
+	}
+	return "controller:latest"
+}
+
+// JobOptions describes a backup or restore Job
+type JobOptions struct {
+	// Name and Type ("backup", "restore", "verify" or "hook") of the Job
+	Name string
+	Type string
+	// Args are passed to the backup-agent, e.g. ["backup", "--location", "s3://..."]
+	Args []string
+	// Command replaces the backup-agent, e.g. for hooks. Image replaces the Database's image.
+	Command []string
+	Image   string
+	// BackoffLimit is how often failed pods are retried (default 3)
+	BackoffLimit *int32
+	// Location is the backup location the Job reads or writes
+	Location string
+	// Host overrides the host of the Database endpoint, e.g. to bypass its Service
+	Host string
+	// ActiveDeadlineSeconds stops the Job after this long (optional)
+	ActiveDeadlineSeconds *int64
+	// StorageSecretRef holds storage credentials, exposed to the agent as environment variables
+	StorageSecretRef *corev1.LocalObjectReference
+	// EncryptionSecretRef holds encryption keys, mounted at EncryptionKeyDir
+	EncryptionSecretRef *corev1.LocalObjectReference
+	// DataClaim is a Database data volume mounted at DataMountPath (physical restores)
+	DataClaim     string
+	DataMountPath string
+	// Namespace runs the Job outside the Database's namespace, with CredentialsSecret
+	// as a copy of the Database's credentials there (cross-namespace Backups)
+	Namespace         string
+	CredentialsSecret string
+}
+
+// CredentialsSecretName returns the Secret holding the credentials of db
+func CredentialsSecretName(db *databasev1.Database) string {
+	if db.Status.SecretName != "" {
+		return db.Status.SecretName
+	}
+	// Fallback to default secret name pattern if SecretName not set in status
+	return fmt.Sprintf("%s-credentials", db.Name)
+}
+
+// CredentialsKeys are the keys of the credentials Secret the Jobs connect with
+var CredentialsKeys = []string{"username", "password"}
+
+// NewJob builds a Job that runs the backup-agent against db
+func NewJob(db *databasev1.Database, opts JobOptions) (*batchv1.Job, error) {
+	host, port := SplitEndpoint(db.Status.Endpoint)
+	if opts.Host != "" {
+		host = opts.Host
+	}
+	if host == "" {
+		return nil, fmt.Errorf("database endpoint not available")
+	}
+
+	secretName := CredentialsSecretName(db)
+	if opts.CredentialsSecret != "" {
+		secretName = opts.CredentialsSecret
+	}
+	namespace := db.Namespace
+	if opts.Namespace != "" {
+		namespace = opts.Namespace
+	}
+
+	image := db.Spec.Image
+	if image == "" {
+		image = "postgres:14"
+	}
+	if opts.Image != "" {
+		image = opts.Image
+	}
+	command := append([]string{InstalledPath}, opts.Args...)
+	if len(opts.Command) > 0 {
+		command = opts.Command
+	}
+	backoffLimit := ptr.To(int32(3))
+	if opts.BackoffLimit != nil {
+		backoffLimit = opts.BackoffLimit
+	}
+
+	// pg_dump and psql read the connection from standard PG* variables
+	env := []corev1.EnvVar{
+		{Name: "PGHOST", Value: host},
+		{Name: "PGPORT", Value: port},
+		{Name: "PGDATABASE", Value: db.Spec.DatabaseName},
+		secretEnv("PGUSER", secretName, "username"),
+		secretEnv("PGPASSWORD", secretName, "password"),
+	}
+
+	var envFrom []corev1.EnvFromSource
+	if opts.StorageSecretRef != nil {
+		envFrom = append(envFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: *opts.StorageSecretRef},
+		})
+	}
+
+	var volumes []corev1.Volume
+	var mounts []corev1.VolumeMount
+
+	// pvc:// locations need the claim mounted where the filesystem driver expects it
+	if claim := pvcClaimName(opts.Location); claim != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name: storageVolume,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      storageVolume,
+			MountPath: path.Join(storage.PVCMountRoot, claim),
+		})
+	}
+
+	if opts.EncryptionSecretRef != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: keysVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: opts.EncryptionSecretRef.Name},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      keysVolume,
+			MountPath: EncryptionKeyDir,
+			ReadOnly:  true,
+		})
+	}
+
+	if opts.DataClaim != "" {
+		volumes = append(volumes, corev1.Volume{
+			Name: dataVolume,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: opts.DataClaim},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: dataVolume, MountPath: opts.DataMountPath})
+	}
+
+	labels := map[string]string{
+		"app":        "database",
+		"database":   db.Name,
+		JobTypeLabel: opts.Type,
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      opts.Name,
+			Namespace: namespace,
+			Labels:    labels,
+			Annotations: map[string]string{
+				LocationAnnotation: opts.Location,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          backoffLimit,
+			ActiveDeadlineSeconds: opts.ActiveDeadlineSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:         opts.Type,
+							Image:        image,
+							Command:      command,
+							Env:          env,
+							EnvFrom:      envFrom,
+							VolumeMounts: mounts,
+						},
+					},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+	if len(opts.Command) == 0 {
+		InjectAgent(&job.Spec.Template.Spec)
+	}
+	return job, nil
+}
+
+// InjectAgent adds an init container that installs the agent at InstalledPath
+// and mounts it into every other container of spec. The Database StatefulSet uses it
+// too, so PostgreSQL can run the agent as archive_command and restore_command, and
+// the instance containers can run it (see replication.go). It is idempotent and
+// mounts the agent into containers added since the last call.
+func InjectAgent(spec *corev1.PodSpec) {
+	mount := corev1.VolumeMount{Name: agentVolume, MountPath: agentDir}
+
+	installed := false
+	for _, c := range spec.InitContainers {
+		if c.Name == "install-agent" {
+			installed = true
+		}
+	}
+	if !installed {
+		// The agent must be installed before other init containers run it
+		spec.InitContainers = append([]corev1.Container{{
+			Name:         "install-agent",
+			Image:        Image(),
+			Command:      []string{binaryPath, "install", InstalledPath},
+			VolumeMounts: []corev1.VolumeMount{mount},
+		}}, spec.InitContainers...)
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name:         agentVolume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			if !hasMount(&containers[i], agentVolume) {
+				containers[i].VolumeMounts = append(containers[i].VolumeMounts, mount)
+			}
+		}
+	}
+}
+
+func hasMount(c *corev1.Container, volume string) bool {
+	for _, m := range c.VolumeMounts {
+		if m.Name == volume {
+			return true
+		}
+	}
+	return false
+}
+
+// JobFinished reports whether the Job has finished, whether it succeeded,
+// and the failure message if it didn't
+func JobFinished(job *batchv1.Job) (finished, succeeded bool, message string) {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return true, true, ""
+		case batchv1.JobFailed:
+			return true, false, fmt.Sprintf("%s: %s", c.Reason, c.Message)
+		}
+	}
+	return false, false, ""
+}
+
+// Result is what the agent reports back to the controller about the artifact it wrote.
+// It travels as the container's termination message.
+type Result struct {
+	// Size is the size of the stored (compressed) artifact in bytes
+	Size int64 `json:"size"`
+	// Digest is the SHA-256 digest of the stored artifact ("sha256:<hex>")
+	Digest string `json:"digest"`
+	// KeyID identifies the encryption key, if the artifact is encrypted
+	KeyID string `json:"keyID,omitempty"`
+	// PostgresVersion is the version of the server that was backed up
+	PostgresVersion string `json:"postgresVersion,omitempty"`
+	// Checks are the results of verification queries (verify only)
+	Checks []databasev1.VerificationResult `json:"checks,omitempty"`
+}
+
+// WriteResult records res as the termination message of the agent container
+func WriteResult(res Result) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(terminationMessagePath, data, 0o644)
+}
+
+// JobResult returns the Result reported by the successful Pod of a finished Job
+func JobResult(ctx context.Context, c client.Client, job *batchv1.Job) (Result, error) {
+	selector, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+	if err != nil {
+		return Result{}, err
+	}
+
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return Result{}, err
+	}
+
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name != job.Labels[JobTypeLabel] || cs.State.Terminated == nil || cs.State.Terminated.ExitCode != 0 {
+				continue
+			}
+			var res Result
+			if err := json.Unmarshal([]byte(cs.State.Terminated.Message), &res); err != nil {
+				return Result{}, fmt.Errorf("invalid result from pod %s: %w", pod.Name, err)
+			}
+			return res, nil
+		}
+	}
+	return Result{}, fmt.Errorf("no successful pod found for job %s", job.Name)
+}
+
+// SplitEndpoint splits a "host:port" endpoint, defaulting the port to 5432
+func SplitEndpoint(endpoint string) (host, port string) {
+	host, port, found := strings.Cut(endpoint, ":")
+	if !found || port == "" {
+		port = "5432"
+	}
+	return host, port
+}
+
+// Install copies the running agent binary to dst, so it can run in another image.
+// The operator image is distroless and has no cp.
+func Install(dst string) error {
+	src, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// StorageConfigFromEnv builds the storage config from the variables set by
+// the storage Secret (see JobOptions.StorageSecretRef)
+func StorageConfigFromEnv() storage.Config {
+	creds := map[string][]byte{}
+	for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+		if v := os.Getenv(key); v != "" {
+			creds[key] = []byte(v)
+		}
+	}
+	return storage.Config{Credentials: creds}
+}
+
+// EncryptionKeysFromDir reads the encryption Secret mounted at EncryptionKeyDir
+func EncryptionKeysFromDir() (map[string][]byte, error) {
+	entries, err := os.ReadDir(EncryptionKeyDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption keys: %w", err)
+	}
+
+	keys := map[string][]byte{}
+	for _, e := range entries {
+		// Skip the ..data directories Kubernetes uses for atomic updates
+		if strings.HasPrefix(e.Name(), "..") {
+			continue
+		}
+		data, err := os.ReadFile(path.Join(EncryptionKeyDir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key %s: %w", e.Name(), err)
+		}
+		keys[e.Name()] = data
+	}
+	return keys, nil
+}
+
+func secretEnv(name, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  key,
+			},
+		},
+	}
+}
+
+// pvcClaimName returns the claim of a pvc:// location, or "" for other schemes
+func pvcClaimName(location string) string {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "pvc" {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,242 @@
+// Solution: Backup Agent from Module 8
+// This is the entry point of the backup-agent binary that runs inside backup and restore Jobs
+// Location: cmd/backup-agent/main.go
+//
+// The agent is built into the operator image next to the manager and copied into
+// the Job's PostgreSQL container by an init container (see agent-job.go):
+//   backup-agent install /agent/backup-agent
+//   backup-agent backup --location s3://bucket/prod/db-20250101-020000.sql.gz --compression gzip
+//   backup-agent restore --location s3://bucket/prod/db-20250101-020000.sql.gz --compression gzip \
+//     --digest sha256:... --size 1234
+//
+// Point-in-time recovery adds (see backup-wal.go and restore-pitr.go):
+//   backup-agent wal-push --location s3://bucket/prod/default/db/wal %p      (archive_command)
+//   backup-agent wal-fetch --location s3://bucket/prod/default/db/wal %f %p (restore_command)
+//   backup-agent restore-base --location s3://bucket/prod/db-20250101-020000.tar.gz \
+//     --wal-location s3://bucket/prod/default/db/wal --pgdata /var/lib/postgresql/data/pgdata \
+//     --target-time 2025-01-01T03:15:00Z
+//
+// Backup verification runs sanity queries against a test restore (see backup-verify.go):
+//   backup-agent verify --query "SELECT count(*) > 0 FROM orders" --query ...
+//
+// Streaming replication runs the agent in every Database pod (see replication.go):
+//   backup-agent init-instance   (init container: clone, rewind or configure the standby)
+//   backup-agent instance        (sidecar: replication status API on port 8008)
+//
+// Major version upgrades run pg_upgrade in a Job (see instance-upgrade.go and major-upgrade.go):
+//   backup-agent upgrade-prepare /old   (init container, old image: copy the old binaries)
+//   backup-agent upgrade /old           (new image: upgrade the data directory)
+//   backup-agent upgrade-rollback       (old image: put the old data directory back)
+//
+// With --encryption (backup) or --key-id (restore) the keys are read from the
+// encryption Secret mounted at /etc/backup-encryption.
+//
+// backup reports the digest, size and key ID of the artifact through the container's
+// termination message, which the Backup controller copies into BackupStatus.
+// verify reports the query results the same way.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/example/postgres-operator/internal/agent"
+	backupPkg "github.com/example/postgres-operator/internal/backup"
+	"github.com/example/postgres-operator/internal/instance"
+	restorePkg "github.com/example/postgres-operator/internal/restore"
+	"github.com/example/postgres-operator/internal/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var err error
+	switch os.Args[1] {
+	case "install":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		err = agent.Install(os.Args[2])
+	case "backup":
+		opts := parseFlags(os.Args[2:])
+		dump := backupPkg.DumpOptions{Location: opts.location, Method: opts.method, Compression: opts.compression, WALLocation: opts.walLocation}
+		if opts.encryption != "" {
+			dump.Encryption, err = encryption(opts.encryption, "")
+		}
+		if err == nil {
+			var res agent.Result
+			if res, err = backupPkg.Dump(ctx, dump, agent.StorageConfigFromEnv()); err == nil {
+				err = agent.WriteResult(res)
+			}
+		}
+	case "restore":
+		opts := parseFlags(os.Args[2:])
+		var load restorePkg.LoadOptions
+		if load, err = loadOptions(opts); err == nil {
+			err = restorePkg.Load(ctx, load, agent.StorageConfigFromEnv())
+		}
+	case "restore-base":
+		opts := parseFlags(os.Args[2:])
+		base := restorePkg.BaseOptions{
+			PGData:      opts.pgdata,
+			WALLocation: opts.walLocation,
+			TargetLSN:   opts.targetLSN,
+		}
+		if opts.targetTime != "" {
+			var t time.Time
+			if t, err = time.Parse(time.RFC3339, opts.targetTime); err == nil {
+				base.TargetTime = &t
+			}
+		}
+		if err == nil {
+			base.LoadOptions, err = loadOptions(opts)
+		}
+		if err == nil {
+			err = restorePkg.RestoreBase(ctx, base, agent.StorageConfigFromEnv())
+		}
+	case "wal-push":
+		opts := parseFlags(os.Args[2:])
+		if len(opts.args) != 1 {
+			usage()
+		}
+		err = backupPkg.PushWAL(ctx, opts.location, opts.args[0], agent.StorageConfigFromEnv())
+	case "wal-fetch":
+		opts := parseFlags(os.Args[2:])
+		if len(opts.args) != 2 {
+			usage()
+		}
+		err = backupPkg.FetchWAL(ctx, opts.location, opts.args[0], opts.args[1], agent.StorageConfigFromEnv())
+	case "verify":
+		queries := parseQueries(os.Args[2:])
+		err = agent.WriteResult(agent.Result{Checks: backupPkg.Verify(ctx, queries)})
+	case "init-instance":
+		err = instance.Init(ctx, instance.ConfigFromEnv())
+	case "instance":
+		err = instance.Serve(ctx, fmt.Sprintf(":%d", instance.Port), instance.ConfigFromEnv())
+	case "upgrade-prepare":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		err = instance.PrepareUpgrade(ctx, os.Args[2])
+	case "upgrade":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		err = instance.Upgrade(ctx, instance.ConfigFromEnv(), os.Args[2])
+	case "upgrade-rollback":
+		err = instance.RollbackUpgrade(ctx, instance.ConfigFromEnv())
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+type options struct {
+	location    string
+	method      string
+	compression string
+	encryption  string
+	digest      string
+	size        int64
+	keyID       string
+	pgdata      string
+	walLocation string
+	targetTime  string
+	targetLSN   string
+	// args are the positional arguments after the flags (wal-push and wal-fetch)
+	args []string
+}
+
+func parseFlags(args []string) options {
+	var opts options
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	fs.StringVar(&opts.location, "location", "", "Backup location, e.g. s3://bucket/prod/db.sql.gz")
+	fs.StringVar(&opts.method, "method", backupPkg.MethodLogical, "Backup method: logical or physical (backup only)")
+	fs.StringVar(&opts.compression, "compression", storage.DefaultCompression, "Compression: gzip, zstd or none")
+	fs.StringVar(&opts.encryption, "encryption", "", "Encryption algorithm: aes-256-gcm or age (backup only)")
+	fs.StringVar(&opts.digest, "digest", "", "Expected digest of the backup (restore only)")
+	fs.Int64Var(&opts.size, "size", 0, "Expected size of the backup in bytes (restore only)")
+	fs.StringVar(&opts.keyID, "key-id", "", "ID of the key the backup is encrypted with (restore only)")
+	fs.StringVar(&opts.pgdata, "pgdata", "", "Data directory to replace (restore-base only)")
+	fs.StringVar(&opts.walLocation, "wal-location", "", "WAL archive of the database (physical backup and restore-base)")
+	fs.StringVar(&opts.targetTime, "target-time", "", "Recover up to this RFC 3339 time (restore-base only)")
+	fs.StringVar(&opts.targetLSN, "target-lsn", "", "Recover up to this WAL position (restore-base only)")
+	_ = fs.Parse(args)
+	opts.args = fs.Args()
+	if opts.location == "" {
+		fmt.Fprintln(os.Stderr, "--location is required")
+		os.Exit(2)
+	}
+	return opts
+}
+
+// queryFlag collects repeated --query flags
+type queryFlag []string
+
+func (q *queryFlag) String() string { return strings.Join(*q, "; ") }
+
+func (q *queryFlag) Set(value string) error {
+	*q = append(*q, value)
+	return nil
+}
+
+// parseQueries parses the flags of verify, which has no backup location
+func parseQueries(args []string) []string {
+	var queries queryFlag
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	fs.Var(&queries, "query", "SQL query that must return a row that isn't false (repeatable)")
+	_ = fs.Parse(args)
+	if len(queries) == 0 {
+		fmt.Fprintln(os.Stderr, "--query is required")
+		os.Exit(2)
+	}
+	return queries
+}
+
+// loadOptions returns the options shared by restore and restore-base
+func loadOptions(opts options) (restorePkg.LoadOptions, error) {
+	load := restorePkg.LoadOptions{
+		Location:    opts.location,
+		Compression: opts.compression,
+		Digest:      opts.digest,
+		Size:        opts.size,
+	}
+	if opts.keyID != "" {
+		enc, err := encryption(storage.KeyAlgorithm(opts.keyID), opts.keyID)
+		if err != nil {
+			return load, err
+		}
+		load.Encryption = enc
+	}
+	return load, nil
+}
+
+// encryption loads the keys from the mounted encryption Secret
+func encryption(algorithm, keyID string) (*storage.Encryption, error) {
+	keys, err := agent.EncryptionKeysFromDir()
+	if err != nil {
+		return nil, err
+	}
+	return &storage.Encryption{Algorithm: algorithm, Keys: keys, KeyID: keyID}, nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: backup-agent install <path> | backup --location <url> [--compression <alg>] [--encryption <alg>] | restore --location <url> [--compression <alg>] [--digest <digest>] [--size <bytes>] [--key-id <id>] | restore-base ... --pgdata <dir> --wal-location <url> [--target-time <time> | --target-lsn <lsn>] | wal-push --location <url> <path> | wal-fetch --location <url> <name> <path> | verify --query <sql> [--query <sql> ...] | init-instance | instance | upgrade-prepare <dir> | upgrade <dir> | upgrade-rollback")
+	os.Exit(2)
+}
@@ -0,0 +1,157 @@
+// Solution: Backup Catalog from Module 8
+// This converts between Backups and BackupArtifacts, the catalog of stored backups
+// Location: internal/backup/catalog.go
+//
+// The Backup controller records every completed backup as a BackupArtifact (see
+// backupartifact_types.go). Restores from an artifact turn it back into a completed
+// Backup with BackupFromArtifact, so the restore path only deals with Backups.
+// Dump also stores the Metadata of every backup next to it, so the backups can be
+// imported into the catalog of another cluster (see discovery.go).
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/agent"
+	"github.com/example/postgres-operator/internal/storage"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MetadataSuffix is appended to the key of a backup for its Metadata
+const MetadataSuffix = ".meta.json"
+
+// Metadata is stored next to every backup as <backup>.meta.json. It holds what can't be
+// told from the file name, so the catalog can be rebuilt from storage (see Discover).
+type Metadata struct {
+	Method          string    `json:"method"`
+	Compression     string    `json:"compression"`
+	Encryption      string    `json:"encryption,omitempty"`
+	Size            int64     `json:"size"`
+	Digest          string    `json:"digest"`
+	KeyID           string    `json:"keyID,omitempty"`
+	PostgresVersion string    `json:"postgresVersion,omitempty"`
+	WALLocation     string    `json:"walLocation,omitempty"`
+	StartTime       time.Time `json:"startTime"`
+	EndTime         time.Time `json:"endTime"`
+}
+
+func writeMetadata(ctx context.Context, store storage.BackupStore, key string, meta Metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, key+MetadataSuffix, bytes.NewReader(data))
+}
+
+// readMetadata returns the Metadata of the backup at key, or nil for backups
+// taken before metadata was stored
+func readMetadata(ctx context.Context, store storage.BackupStore, key string) (*Metadata, error) {
+	r, err := store.Get(ctx, key+MetadataSuffix)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	meta := &Metadata{}
+	if err := json.NewDecoder(r).Decode(meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata for %s: %w", key, err)
+	}
+	return meta, nil
+}
+
+// DatabaseSpecHashAnnotation records on a backup Job the hash of the Database spec it backs up
+const DatabaseSpecHashAnnotation = "database.example.com/database-spec-hash"
+
+// SpecHash returns a short hash of db's spec
+func SpecHash(db *databasev1.Database) string {
+	data, err := json.Marshal(db.Spec)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewArtifact builds the catalog entry for the backup the finished job stored.
+// The artifact has the Backup's name and labels, so the runs of a scheduled Backup
+// can be listed by its database.example.com/scheduled-backup label.
+func NewArtifact(backup *databasev1.Backup, job *batchv1.Job, result agent.Result) *databasev1.BackupArtifact {
+	labels := map[string]string{}
+	for k, v := range backup.Labels {
+		labels[k] = v
+	}
+	labels[databasev1.ArtifactDatabaseLabel] = backup.Spec.DatabaseRef.Name
+	labels[databasev1.ArtifactBackupLabel] = backup.Name
+
+	end := metav1.Now()
+	if job.Status.CompletionTime != nil {
+		end = *job.Status.CompletionTime
+	}
+
+	return &databasev1.BackupArtifact{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backup.Name,
+			Namespace: backup.Namespace,
+			Labels:    labels,
+		},
+		Spec: databasev1.BackupArtifactSpec{
+			DatabaseRef:      databasev1.NamespacedObjectReference{Name: backup.Spec.DatabaseRef.Name, Namespace: DatabaseNamespace(backup)},
+			BackupRef:        &corev1.LocalObjectReference{Name: backup.Name},
+			Location:         job.Annotations[agent.LocationAnnotation],
+			Method:           Method(backup),
+			Compression:      Compression(backup),
+			Size:             result.Size,
+			Digest:           result.Digest,
+			StorageSecretRef: backup.Spec.StorageSecretRef,
+			Encryption:       backup.Spec.Encryption,
+			EncryptionKeyID:  result.KeyID,
+			WALLocation:      job.Annotations[WALLocationAnnotation],
+			PostgresVersion:  result.PostgresVersion,
+			StartTime:        backup.Status.StartTime,
+			EndTime:          end,
+			DatabaseSpecHash: job.Annotations[DatabaseSpecHashAnnotation],
+		},
+	}
+}
+
+// BackupFromArtifact returns a completed Backup equivalent to artifact.
+// It only exists in memory, as the input of restore.NewRestoreJob.
+func BackupFromArtifact(artifact *databasev1.BackupArtifact) *databasev1.Backup {
+	return &databasev1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      artifact.Name,
+			Namespace: artifact.Namespace,
+		},
+		Spec: databasev1.BackupSpec{
+			DatabaseRef:      artifact.Spec.DatabaseRef,
+			Method:           artifact.Spec.Method,
+			Compression:      artifact.Spec.Compression,
+			StorageSecretRef: artifact.Spec.StorageSecretRef,
+			Encryption:       artifact.Spec.Encryption,
+		},
+		Status: databasev1.BackupStatus{
+			Phase:           "Completed",
+			BackupTime:      &artifact.Spec.EndTime,
+			BackupLocation:  artifact.Spec.Location,
+			Size:            artifact.Spec.Size,
+			Digest:          artifact.Spec.Digest,
+			EncryptionKeyID: artifact.Spec.EncryptionKeyID,
+			WALLocation:     artifact.Spec.WALLocation,
+			Artifact:        artifact.Name,
+		},
+	}
+}
@@ -0,0 +1,150 @@
+// Solution: Backup Discovery from Module 8
+// This finds the backups of a Database in storage, to import them into the catalog
+// Location: internal/backup/discovery.go
+//
+// Backups are stored as <storageLocation>/<namespace>/<database>-<timestamp>.{sql|tar}[.gz|.zst][.aes|.age]
+// (see NewBackupLocation), next to their Metadata (<backup>.meta.json). Discover builds a
+// BackupArtifact for each of them, from the Metadata or, for backups taken before
+// Metadata was stored, from the file name.
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"time"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/storage"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Discover lists the backups of backup's Database stored in its StorageLocation.
+// It returns an artifact for each backup that can be restored, and the names of the
+// ones that can't (encrypted or physical backups without Metadata, or encrypted
+// backups when backup has no encryption Secret to restore them with).
+func Discover(ctx context.Context, backup *databasev1.Backup, storageCfg storage.Config) ([]*databasev1.BackupArtifact, []string, error) {
+	dir, err := storage.JoinLocation(backup.Spec.StorageLocation, DatabaseNamespace(backup))
+	if err != nil {
+		return nil, nil, err
+	}
+	store, prefix, err := storage.Open(ctx, dir, storageCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects, err := store.List(ctx, prefix+"/")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list %s: %v", dir, err)
+	}
+
+	database := backup.Spec.DatabaseRef.Name
+	pattern := regexp.MustCompile("^" + regexp.QuoteMeta(database) + `-(\d{8}-\d{6})\.(sql|tar)(\.gz|\.zst)?(\.aes|\.age)?$`)
+
+	var artifacts []*databasev1.BackupArtifact
+	var skipped []string
+	for _, obj := range objects {
+		// WAL is archived in subdirectories, backups are directly below the namespace
+		name := path.Base(obj.Key)
+		match := pattern.FindStringSubmatch(name)
+		if path.Dir(obj.Key) != prefix || match == nil {
+			continue
+		}
+
+		meta, err := readMetadata(ctx, store, obj.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if meta == nil {
+			if meta, err = metadataFromName(match, obj); err != nil {
+				return nil, nil, err
+			}
+			// Without Metadata there is no key ID to decrypt with, and no WAL to recover from
+			if meta.Encryption != "" || meta.Method == MethodPhysical {
+				skipped = append(skipped, name)
+				continue
+			}
+		}
+		if meta.Encryption != "" && backup.Spec.Encryption == nil {
+			skipped = append(skipped, name)
+			continue
+		}
+
+		location, err := storage.JoinLocation(backup.Spec.StorageLocation, DatabaseNamespace(backup), name)
+		if err != nil {
+			return nil, nil, err
+		}
+		artifacts = append(artifacts, newDiscoveredArtifact(backup, fmt.Sprintf("%s-%s", database, match[1]), location, meta))
+	}
+	return artifacts, skipped, nil
+}
+
+// metadataFromName derives what it can from the file name of a backup
+func metadataFromName(match []string, obj storage.ObjectInfo) (*Metadata, error) {
+	end, err := time.Parse("20060102-150405", match[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup time in %s: %w", obj.Key, err)
+	}
+
+	meta := &Metadata{
+		Method:      MethodLogical,
+		Compression: storage.CompressionNone,
+		Size:        obj.Size,
+		EndTime:     end,
+	}
+	if match[2] == "tar" {
+		meta.Method = MethodPhysical
+	}
+	switch match[3] {
+	case ".gz":
+		meta.Compression = storage.CompressionGzip
+	case ".zst":
+		meta.Compression = storage.CompressionZstd
+	}
+	switch match[4] {
+	case ".aes":
+		meta.Encryption = storage.EncryptionAESGCM
+	case ".age":
+		meta.Encryption = storage.EncryptionAge
+	}
+	return meta, nil
+}
+
+// newDiscoveredArtifact builds the catalog entry for a backup found in storage.
+// Encrypted backups are restored with the encryption Secret of backup.
+func newDiscoveredArtifact(backup *databasev1.Backup, name, location string, meta *Metadata) *databasev1.BackupArtifact {
+	artifact := &databasev1.BackupArtifact{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: backup.Namespace,
+			Labels: map[string]string{
+				databasev1.ArtifactDatabaseLabel: backup.Spec.DatabaseRef.Name,
+			},
+		},
+		Spec: databasev1.BackupArtifactSpec{
+			DatabaseRef:      databasev1.NamespacedObjectReference{Name: backup.Spec.DatabaseRef.Name, Namespace: DatabaseNamespace(backup)},
+			Location:         location,
+			Method:           meta.Method,
+			Compression:      meta.Compression,
+			Size:             meta.Size,
+			Digest:           meta.Digest,
+			StorageSecretRef: backup.Spec.StorageSecretRef,
+			EncryptionKeyID:  meta.KeyID,
+			WALLocation:      meta.WALLocation,
+			PostgresVersion:  meta.PostgresVersion,
+			EndTime:          metav1.NewTime(meta.EndTime),
+		},
+	}
+	if !meta.StartTime.IsZero() {
+		start := metav1.NewTime(meta.StartTime)
+		artifact.Spec.StartTime = &start
+	}
+	if meta.Encryption != "" {
+		artifact.Spec.Encryption = &databasev1.EncryptionSpec{
+			Algorithm: meta.Encryption,
+			SecretRef: backup.Spec.Encryption.SecretRef,
+		}
+	}
+	return artifact
+}
@@ -0,0 +1,214 @@
+// Solution: Backup Hooks from Module 8
+// This runs the pre- and post-backup hooks of a Backup attempt
+//
+// These are additions to the BackupReconciler (backup-operator.go); backup-hooks.go builds
+// the hook Jobs. An attempt with hooks goes through:
+//   InProgress  pre hooks run one after another (performBackup doesn't create the backup Job)
+//   InProgress  the backup Job runs (created by runPreHooks once the pre hooks are done)
+//   InProgress  post hooks run one after another, also when the backup Job failed
+//   Completed or Failed
+// The Job of each hook is the record of its outcome, so nothing is run twice in an attempt.
+// The PreBackupHooks and PostBackupHooks conditions show how the hooks of the latest attempt went.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/agent"
+	backupPkg "github.com/example/postgres-operator/internal/backup"
+	"github.com/example/postgres-operator/internal/conditions"
+	"github.com/example/postgres-operator/internal/storage"
+)
+
+// Conditions with the outcome of the hooks of the latest attempt
+const (
+	conditionPreBackupHooks  conditions.Type = "PreBackupHooks"
+	conditionPostBackupHooks conditions.Type = "PostBackupHooks"
+)
+
+// hookOutcome is the state of the hooks of one stage
+type hookOutcome struct {
+	// done is set once every hook has finished, or one failed with onFailure Fail
+	done bool
+	// failure describes the hook that failed with onFailure Fail
+	failure string
+	// ignored describes the hooks that failed with onFailure Continue
+	ignored []string
+}
+
+// runHooks starts the next hook of stage once the previous one has finished.
+// Hook Jobs are owned by the Backup, so a finished hook triggers a reconcile.
+func (r *BackupReconciler) runHooks(ctx context.Context, backup *databasev1.Backup, stage string) (hookOutcome, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	var outcome hookOutcome
+	for _, hook := range backupPkg.Hooks(backup, stage) {
+		job := &batchv1.Job{}
+		err := r.Get(ctx, client.ObjectKey{
+			Name:      backupPkg.HookJobName(backup, stage, hook),
+			Namespace: backup.Namespace,
+		}, job)
+		if errors.IsNotFound(err) {
+			return outcome, r.createHookJob(ctx, backup, stage, hook)
+		}
+		if err != nil {
+			return outcome, err
+		}
+
+		finished, succeeded, message := agent.JobFinished(job)
+		if !finished {
+			return outcome, nil
+		}
+		if succeeded {
+			continue
+		}
+
+		failure := fmt.Sprintf("%s-backup hook %s failed: %s", stage, hook.Name, message)
+		log.Info("Backup hook failed", "backup", backup.Name, "job", job.Name, "onFailure", hook.OnFailure, "message", message)
+		if hook.OnFailure == backupPkg.HookOnFailureContinue {
+			outcome.ignored = append(outcome.ignored, failure)
+			continue
+		}
+		outcome.done = true
+		outcome.failure = failure
+		return outcome, nil
+	}
+
+	outcome.done = true
+	return outcome, nil
+}
+
+// createHookJob creates the Job running hook in the current attempt
+func (r *BackupReconciler) createHookJob(ctx context.Context, backup *databasev1.Backup, stage string, hook databasev1.BackupHook) error {
+	db := &databasev1.Database{}
+	if err := r.Get(ctx, client.ObjectKey{Name: backup.Spec.DatabaseRef.Name, Namespace: backupPkg.DatabaseNamespace(backup)}, db); err != nil {
+		return err
+	}
+
+	// SQL hooks connect with the Database credentials too
+	if err := r.copyCredentials(ctx, db, backup); err != nil {
+		return err
+	}
+
+	job, err := backupPkg.NewHookJob(db, backup, stage, hook)
+	if err != nil {
+		return err
+	}
+	if err := ctrl.SetControllerReference(backup, job, r.Scheme); err != nil {
+		return err
+	}
+
+	ctrl.LoggerFrom(ctx).Info("Running backup hook", "backup", backup.Name, "stage", stage, "hook", hook.Name, "job", job.Name)
+	if err := r.Create(ctx, job); err != nil && !errors.IsAlreadyExists(err) {
+		// AlreadyExists means the cache hasn't seen the Job yet
+		return fmt.Errorf("failed to create hook job %s: %w", job.Name, err)
+	}
+	return nil
+}
+
+// runPreHooks runs the pre-backup hooks of the current attempt and then creates the backup Job
+func (r *BackupReconciler) runPreHooks(ctx context.Context, req ctrl.Request, backup *databasev1.Backup) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	outcome, err := r.runHooks(ctx, backup, backupPkg.HookStagePre)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !outcome.done {
+		return ctrl.Result{}, nil
+	}
+	if err := r.recordHookOutcome(ctx, req, backup, backupPkg.HookStagePre, outcome); err != nil {
+		if errors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if outcome.failure != "" {
+		return r.recordBackupFailure(ctx, req, backup, backup.Status.Attempts, outcome.failure)
+	}
+
+	db := &databasev1.Database{}
+	if err := r.Get(ctx, client.ObjectKey{Name: backup.Spec.DatabaseRef.Name, Namespace: backupPkg.DatabaseNamespace(backup)}, db); err != nil {
+		return ctrl.Result{}, err
+	}
+	job, err := r.createBackupJob(ctx, db, backup)
+	if err != nil {
+		log.Error(err, "Failed to start backup", "backup", backup.Name, "attempt", backup.Status.Attempts)
+		return r.recordBackupFailure(ctx, req, backup, backup.Status.Attempts, err.Error())
+	}
+
+	// Re-read backup to ensure we have the latest version
+	if err := r.Get(ctx, req.NamespacedName, backup); err != nil {
+		return ctrl.Result{}, err
+	}
+	conditions.MarkReconciling(backup, "BackupInProgress", fmt.Sprintf("Backup job %s is running", job.Name))
+	if err := r.Status().Update(ctx, backup); err != nil {
+		if errors.IsConflict(err) {
+			// Resource was modified, requeue to retry
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// recordHookOutcome sets the PreBackupHooks or PostBackupHooks condition
+func (r *BackupReconciler) recordHookOutcome(ctx context.Context, req ctrl.Request, backup *databasev1.Backup, stage string, outcome hookOutcome) error {
+	conditionType := conditionPreBackupHooks
+	if stage == backupPkg.HookStagePost {
+		conditionType = conditionPostBackupHooks
+	}
+	status := metav1.ConditionTrue
+	reason := conditions.Reason("HooksSucceeded")
+	message := fmt.Sprintf("All %s-backup hooks succeeded", stage)
+	switch {
+	case outcome.failure != "":
+		status = metav1.ConditionFalse
+		reason = "HookFailed"
+		message = strings.Join(append(outcome.ignored, outcome.failure), "; ")
+	case len(outcome.ignored) > 0:
+		status = metav1.ConditionFalse
+		reason = "HookFailedIgnored"
+		message = strings.Join(outcome.ignored, "; ")
+	}
+
+	// Re-read backup to ensure we have the latest version
+	if err := r.Get(ctx, req.NamespacedName, backup); err != nil {
+		return err
+	}
+	if c := conditions.Get(backup, conditionType); c != nil &&
+		c.Status == status && c.Reason == string(reason) && c.Message == message {
+		return nil
+	}
+	conditions.Set(backup, conditionType, status, reason, message)
+	return r.Status().Update(ctx, backup)
+}
+
+// deleteAttemptArtifact deletes what the backup Job of the current attempt stored,
+// for attempts that fail after the backup was written
+func (r *BackupReconciler) deleteAttemptArtifact(ctx context.Context, backup *databasev1.Backup, job *batchv1.Job) error {
+	location := job.Annotations[agent.LocationAnnotation]
+	if location == "" {
+		return nil
+	}
+
+	ctrl.LoggerFrom(ctx).Info("Deleting backup of failed attempt", "backup", backup.Name, "location", location)
+	storageCfg, err := storage.ConfigFromSecret(ctx, r.Client, backup.Namespace, backup.Spec.StorageSecretRef)
+	if err != nil {
+		return err
+	}
+	if err := backupPkg.DeleteFromStorage(ctx, location, storageCfg); err != nil {
+		return fmt.Errorf("failed to delete stored backup %s: %w", location, err)
+	}
+	return nil
+}
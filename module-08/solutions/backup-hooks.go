@@ -0,0 +1,72 @@
+// Solution: Backup Hooks from Module 8
+// This builds the Jobs that run pre- and post-backup hooks
+// Location: internal/backup/hooks.go
+//
+// Hooks run one after another, each in its own Job, before and after the backup Job
+// (see backup-hook-runner.go). SQL hooks run psql in the Database's image, container
+// hooks run their command in any image. Both get the PG* variables of the backup Job,
+// so a container hook can talk to the database too.
+
+package backup
+
+import (
+	"fmt"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/agent"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/utils/ptr"
+)
+
+// Hook stages, see BackupHooks
+const (
+	HookStagePre  = "pre"
+	HookStagePost = "post"
+)
+
+// HookOnFailureContinue records a failed hook and runs the next one, see BackupHook.OnFailure
+const HookOnFailureContinue = "Continue"
+
+// defaultHookTimeout matches the +kubebuilder:default on BackupHook.TimeoutSeconds
+const defaultHookTimeout = 60
+
+// Hooks returns the hooks of backup for stage
+func Hooks(backup *databasev1.Backup, stage string) []databasev1.BackupHook {
+	if backup.Spec.Hooks == nil {
+		return nil
+	}
+	if stage == HookStagePre {
+		return backup.Spec.Hooks.Pre
+	}
+	return backup.Spec.Hooks.Post
+}
+
+// HookJobName returns the name of the Job running hook in the current attempt of backup,
+// e.g. <name>-backup-pre-checkpoint
+func HookJobName(backup *databasev1.Backup, stage string, hook databasev1.BackupHook) string {
+	return fmt.Sprintf("%s-%s-%s", JobName(backup), stage, hook.Name)
+}
+
+// NewHookJob builds the Job that runs hook against db.
+// Hooks aren't necessarily safe to repeat, so a failed hook pod is not retried.
+func NewHookJob(db *databasev1.Database, backup *databasev1.Backup, stage string, hook databasev1.BackupHook) (*batchv1.Job, error) {
+	timeout := hook.TimeoutSeconds
+	if timeout == 0 {
+		timeout = defaultHookTimeout
+	}
+
+	opts := agent.JobOptions{
+		Name:                  HookJobName(backup, stage, hook),
+		Type:                  "hook",
+		ActiveDeadlineSeconds: ptr.To(int64(timeout)),
+		BackoffLimit:          ptr.To(int32(0)),
+	}
+	if hook.Container != nil {
+		opts.Image = hook.Container.Image
+		opts.Command = hook.Container.Command
+	} else {
+		opts.Command = []string{"psql", "--no-psqlrc", "--quiet", "-v", "ON_ERROR_STOP=1", "-c", hook.SQL}
+	}
+	runInBackupNamespace(&opts, backup)
+	return agent.NewJob(db, opts)
+}
@@ -11,54 +11,245 @@ package controller
 import (
 	"context"
 	"fmt"
+	"os"
 	"time"
 
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 
 	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/agent"
+	backupPkg "github.com/example/postgres-operator/internal/backup"
+	"github.com/example/postgres-operator/internal/conditions"
+	"github.com/example/postgres-operator/internal/phase"
+	"github.com/example/postgres-operator/internal/storage"
+)
+
+const (
+	// scheduledBackupLabel is set on child Backups created by a scheduled Backup
+	scheduledBackupLabel = "database.example.com/scheduled-backup"
+	// scheduledTimeAnnotation records the time a child Backup was scheduled for
+	scheduledTimeAnnotation = "database.example.com/scheduled-time"
+	// backupArtifactFinalizer deletes the stored backup, and the scratch Database of a
+	// running verification, when a one-time Backup or a scheduled run is deleted
+	backupArtifactFinalizer = "database.example.com/backup-artifact"
+	// defaultRetention matches the +kubebuilder:default on BackupSpec.Retention
+	defaultRetention = 5
+	// missingJobGracePeriod is how long a missing backup Job is blamed on the cache
+	// before the attempt counts as lost
+	missingJobGracePeriod = time.Minute
 )
 
 type BackupReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Identity names this operator instance in BackupStatus.Owner (defaults to the hostname,
+	// which is the pod name in a cluster)
+	Identity string
 }
 
 // +kubebuilder:rbac:groups=database.example.com,resources=backups,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=database.example.com,resources=backups/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=database.example.com,resources=backups/finalizers,verbs=update
 // +kubebuilder:rbac:groups=database.example.com,resources=databases,verbs=get;list;watch
+// +kubebuilder:rbac:groups=database.example.com,resources=backupartifacts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile handles Backup resources
 func (r *BackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	log := ctrl.LoggerFrom(ctx)
-
 	backup := &databasev1.Backup{}
 	if err := r.Get(ctx, req.NamespacedName, backup); err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// Skip if already completed
-	if backup.Status.Phase == "Completed" {
-		return ctrl.Result{}, nil
+	// Delete the stored backup before the Backup goes away
+	if !backup.DeletionTimestamp.IsZero() {
+		return r.handleDeletion(ctx, backup)
 	}
 
-	// Skip if already in progress (another reconciliation is handling it)
-	if backup.Status.Phase == "InProgress" {
-		log.Info("Backup already in progress, skipping", "backup", backup.Name)
-		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	// Backups created before storageLocation was required, or with a file:// location,
+	// wait for the spec to change
+	if err := backupPkg.ValidateStorageLocation(backup); err != nil {
+		return r.rejectStorageLocation(ctx, backup, err)
+	}
+
+	// Scheduled backups don't back up themselves, they create a child Backup per run
+	if backup.Spec.Schedule != "" {
+		return r.reconcileSchedule(ctx, req, backup)
+	}
+
+	// Sync Backups import backups from storage instead of taking one
+	if backup.Spec.Sync != nil {
+		return r.reconcileSync(ctx, req, backup)
+	}
+
+	// Whatever a one-time Backup stores goes with it. Scheduled runs are created with it.
+	if !controllerutil.ContainsFinalizer(backup, backupArtifactFinalizer) {
+		controllerutil.AddFinalizer(backup, backupArtifactFinalizer)
+		if err := r.Update(ctx, backup); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+	}
+
+	// The credentials copied from another namespace only live while a Job needs them
+	if err := r.releaseSecretCopies(ctx, backup); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// One-time Backups go through their phases
+	result, err := r.phases(req).Step(ctx, backup)
+	if err != nil || result.Requeue && result.Transition == nil {
+		// A conflicting handler left a stale Backup behind
+		return result.Result, err
+	}
+	// The handlers persist their own transitions, the engine's (out of an unknown phase) are left.
+	// Once the phase went through, it has acted on the spec generation.
+	if result.Transition != nil || backup.Status.ObservedGeneration != backup.Generation {
+		backup.Status.ObservedGeneration = backup.Generation
+		if err := r.Status().Update(ctx, backup); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+	}
+	return result.Result, nil
+}
+
+// rejectStorageLocation keeps a Backup with an unusable storageLocation Pending
+func (r *BackupReconciler) rejectStorageLocation(ctx context.Context, backup *databasev1.Backup, err error) (ctrl.Result, error) {
+	ctrl.LoggerFrom(ctx).Info("Invalid storage location", "backup", backup.Name, "error", err.Error())
+	if backup.Status.Phase == "" {
+		backup.Status.Phase = "Pending"
+	}
+	backup.Status.ObservedGeneration = backup.Generation
+	conditions.MarkStalled(backup, "InvalidStorageLocation", err.Error())
+	if updateErr := r.Status().Update(ctx, backup); updateErr != nil {
+		if errors.IsConflict(updateErr) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, updateErr
+	}
+	return ctrl.Result{}, nil
+}
+
+// phases declares the phases of a one-time Backup. The handlers record their
+// transitions themselves, together with the attempt, Job and timing fields.
+func (r *BackupReconciler) phases(req ctrl.Request) *phase.Machine[*databasev1.Backup] {
+	return &phase.Machine[*databasev1.Backup]{
+		Initial: "Pending",
+		Phases: []phase.Phase[*databasev1.Backup]{
+			{
+				Name: "Pending",
+				Handle: func(ctx context.Context, backup *databasev1.Backup) (phase.Outcome, error) {
+					return phase.Recorded(r.startBackup(ctx, req, backup))
+				},
+				// Completed when a reconcile on a stale cache finds the attempt already done
+				Next: []string{"InProgress", "Completed", "Failed"},
+			},
+			{
+				// The backup Job is running, check whether it has finished
+				Name: "InProgress",
+				Handle: func(ctx context.Context, backup *databasev1.Backup) (phase.Outcome, error) {
+					return phase.Recorded(r.checkBackupJob(ctx, req, backup))
+				},
+				Next: []string{"Completed", "Failed"},
+			},
+			{
+				// Completed backups are only verified, there is nothing to retry
+				Name: "Completed",
+				Handle: func(ctx context.Context, backup *databasev1.Backup) (phase.Outcome, error) {
+					if err := clearRetryRequest(ctx, r.Client, backup); err != nil {
+						return phase.Outcome{}, err
+					}
+					return phase.Recorded(r.reconcileVerification(ctx, req, backup))
+				},
+			},
+			{
+				Name: "Failed",
+				Handle: func(ctx context.Context, backup *databasev1.Backup) (phase.Outcome, error) {
+					return phase.Recorded(r.retryBackup(ctx, req, backup))
+				},
+				Next: []string{"Pending", "InProgress", "Completed"},
+			},
+		},
+		Current: func(backup *databasev1.Backup) string { return backup.Status.Phase },
+		Enter:   func(backup *databasev1.Backup, t phase.Transition) { backup.Status.Phase = t.To },
+	}
+}
+
+// retryBackup starts another attempt of a Failed backup when the retry policy
+// or the retry annotation says so
+func (r *BackupReconciler) retryBackup(ctx context.Context, req ctrl.Request, backup *databasev1.Backup) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	if !retryRequested(backup) {
+		due, wait := retryDue(backup.Status.NextRetryTime)
+		if !due {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+		log.Info("Retrying failed backup", "backup", backup.Name, "attempt", backup.Status.Attempts+1)
+	}
+	return r.startBackup(ctx, req, backup)
+}
+
+// backupDatabaseReference returns the reference from backup to its Database
+func backupDatabaseReference(backup *databasev1.Backup) crossNamespaceReference {
+	return crossNamespaceReference{
+		fromKind:      "Backup",
+		fromNamespace: backup.Namespace,
+		toKind:        "Database",
+		toNamespace:   backupPkg.DatabaseNamespace(backup),
+		toName:        backup.Spec.DatabaseRef.Name,
+	}
+}
+
+// startBackup starts an attempt once the Database can be backed up
+func (r *BackupReconciler) startBackup(ctx context.Context, req ctrl.Request, backup *databasev1.Backup) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	// A Database in another namespace needs a ReferenceGrant there
+	ref := backupDatabaseReference(backup)
+	allowed, err := referenceAllowed(ctx, r.Client, ref)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !allowed {
+		log.Info("Reference to database not permitted, waiting for a ReferenceGrant", "database", ref.toName, "namespace", ref.toNamespace)
+		// Re-read backup to ensure we have the latest version
+		if getErr := r.Get(ctx, req.NamespacedName, backup); getErr != nil {
+			return ctrl.Result{}, getErr
+		}
+		backup.Status.Phase = "Pending"
+		conditions.MarkStalled(backup, reasonReferenceNotPermitted, ref.String())
+		if updateErr := r.Status().Update(ctx, backup); updateErr != nil {
+			if errors.IsConflict(updateErr) {
+				// Resource was modified, requeue to retry
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, updateErr
+		}
+		return ctrl.Result{}, nil
 	}
 
 	// Get Database
 	db := &databasev1.Database{}
-	err := r.Get(ctx, client.ObjectKey{
+	err = r.Get(ctx, client.ObjectKey{
 		Name:      backup.Spec.DatabaseRef.Name,
-		Namespace: backup.Namespace,
+		Namespace: backupPkg.DatabaseNamespace(backup),
 	}, db)
 
 	if errors.IsNotFound(err) {
@@ -69,12 +260,7 @@ func (r *BackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 			return ctrl.Result{}, getErr
 		}
 		backup.Status.Phase = "Pending"
-		meta.SetStatusCondition(&backup.Status.Conditions, metav1.Condition{
-			Type:    "BackupReady",
-			Status:  metav1.ConditionFalse,
-			Reason:  "DatabaseNotFound",
-			Message: fmt.Sprintf("Waiting for database %s to be created", backup.Spec.DatabaseRef.Name),
-		})
+		conditions.MarkReconciling(backup, "DatabaseNotFound", fmt.Sprintf("Waiting for database %s to be created", backup.Spec.DatabaseRef.Name))
 		if updateErr := r.Status().Update(ctx, backup); updateErr != nil {
 			if errors.IsConflict(updateErr) {
 				// Resource was modified, requeue to retry
@@ -90,19 +276,15 @@ func (r *BackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	}
 
 	// Check if database is ready
-	if db.Status.Phase != "Ready" {
+	if !databaseReady(db) {
 		log.Info("Database not ready, waiting", "database", db.Name, "phase", db.Status.Phase)
 		// Re-read backup to ensure we have the latest version
 		if getErr := r.Get(ctx, req.NamespacedName, backup); getErr != nil {
 			return ctrl.Result{}, getErr
 		}
 		backup.Status.Phase = "Pending"
-		meta.SetStatusCondition(&backup.Status.Conditions, metav1.Condition{
-			Type:    "BackupReady",
-			Status:  metav1.ConditionFalse,
-			Reason:  "DatabaseNotReady",
-			Message: fmt.Sprintf("Waiting for database %s to be ready (current phase: %s)", db.Name, db.Status.Phase),
-		})
+		conditions.MarkReconciling(backup, "DatabaseNotReady",
+			fmt.Sprintf("Waiting for database %s to be ready (current phase: %s)", db.Name, db.Status.Phase))
 		if updateErr := r.Status().Update(ctx, backup); updateErr != nil {
 			if errors.IsConflict(updateErr) {
 				// Resource was modified, requeue to retry
@@ -117,6 +299,254 @@ func (r *BackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	return r.performBackup(ctx, req, db, backup)
 }
 
+// databaseReady reports whether db is Ready for its current spec. Right after a spec
+// change it isn't, until the Database controller has acted on the change.
+func databaseReady(db *databasev1.Database) bool {
+	return db.Status.Phase == "Ready" && db.Status.ObservedGeneration == db.Generation
+}
+
+// reconcileSchedule creates a child Backup for each tick of the cron schedule.
+// Each child is a one-time Backup that goes through the normal backup flow.
+func (r *BackupReconciler) reconcileSchedule(ctx context.Context, req ctrl.Request, backup *databasev1.Backup) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	sched, err := backupPkg.ParseSchedule(backup.Spec.Schedule, backup.Spec.TimeZone)
+	if err != nil {
+		// An invalid schedule won't fix itself, wait for the spec to change
+		log.Error(err, "Invalid backup schedule", "backup", backup.Name)
+		backup.Status.Phase = "Failed"
+		backup.Status.ObservedGeneration = backup.Generation
+		conditions.MarkStalled(backup, "InvalidSchedule", err.Error())
+		if updateErr := r.Status().Update(ctx, backup); updateErr != nil {
+			if errors.IsConflict(updateErr) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, updateErr
+		}
+		return ctrl.Result{}, nil
+	}
+
+	now := time.Now()
+
+	// Start from the last run, or from creation if we never ran
+	earliest := backup.CreationTimestamp.Time
+	if backup.Status.LastScheduledTime != nil {
+		earliest = backup.Status.LastScheduledTime.Time
+	}
+
+	// Runs older than the starting deadline are too late to start
+	if backup.Spec.StartingDeadlineSeconds != nil {
+		deadline := now.Add(-time.Duration(*backup.Spec.StartingDeadlineSeconds) * time.Second)
+		if deadline.After(earliest) {
+			earliest = deadline
+		}
+	}
+
+	scheduledTime, count := backupPkg.MostRecentScheduleTime(sched, earliest, now)
+	if !scheduledTime.IsZero() {
+		if count > 1 {
+			log.Info("Missed scheduled backups, starting only the most recent one",
+				"backup", backup.Name, "missed", count-1, "scheduledTime", scheduledTime)
+		}
+		if err := r.createScheduledRun(ctx, backup, scheduledTime); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Prune runs that fall outside the retention policy
+	latest, count, err := r.enforceRetention(ctx, backup)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Re-read backup to ensure we have the latest version
+	if err := r.Get(ctx, req.NamespacedName, backup); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	nextTime := sched.Next(now)
+	backup.Status.BackupCount = count
+	if latest != nil {
+		backup.Status.BackupTime = latest.Status.BackupTime
+		backup.Status.BackupLocation = latest.Status.BackupLocation
+		backup.Status.Size = latest.Status.Size
+		backup.Status.Digest = latest.Status.Digest
+		backup.Status.EncryptionKeyID = latest.Status.EncryptionKeyID
+		backup.Status.Artifact = latest.Status.Artifact
+	}
+	backup.Status.Phase = "Scheduled"
+	backup.Status.ObservedGeneration = backup.Generation
+	if !scheduledTime.IsZero() {
+		backup.Status.LastScheduledTime = &metav1.Time{Time: scheduledTime}
+	}
+	backup.Status.NextScheduleTime = &metav1.Time{Time: nextTime}
+	conditions.MarkReady(backup, "Scheduled", fmt.Sprintf("Next backup scheduled at %s", nextTime.Format(time.RFC3339)))
+	if err := r.Status().Update(ctx, backup); err != nil {
+		if errors.IsConflict(err) {
+			log.Info("Conflict updating backup status, requeuing", "backup", backup.Name)
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: nextTime.Sub(now)}, nil
+}
+
+// createScheduledRun creates the child Backup for a single scheduled run.
+// The child is owned by the scheduled Backup so it is cleaned up with it.
+func (r *BackupReconciler) createScheduledRun(ctx context.Context, backup *databasev1.Backup, scheduledTime time.Time) error {
+	log := ctrl.LoggerFrom(ctx)
+
+	run := &databasev1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backupPkg.ScheduledRunName(backup.Name, scheduledTime),
+			Namespace: backup.Namespace,
+			Labels: map[string]string{
+				scheduledBackupLabel: backup.Name,
+			},
+			Finalizers: []string{backupArtifactFinalizer},
+			Annotations: map[string]string{
+				scheduledTimeAnnotation: scheduledTime.UTC().Format(time.RFC3339),
+			},
+		},
+		Spec: databasev1.BackupSpec{
+			DatabaseRef:      backup.Spec.DatabaseRef,
+			Retention:        backup.Spec.Retention,
+			StorageLocation:  backup.Spec.StorageLocation,
+			Method:           backup.Spec.Method,
+			Compression:      backup.Spec.Compression,
+			StorageSecretRef: backup.Spec.StorageSecretRef,
+			Encryption:       backup.Spec.Encryption,
+			TimeoutSeconds:   backup.Spec.TimeoutSeconds,
+			RetryPolicy:      backup.Spec.RetryPolicy,
+			Hooks:            backup.Spec.Hooks,
+			Verify:           backup.Spec.Verify,
+		},
+	}
+	if err := ctrl.SetControllerReference(backup, run, r.Scheme); err != nil {
+		return err
+	}
+
+	log.Info("Creating scheduled backup", "backup", backup.Name, "run", run.Name, "scheduledTime", scheduledTime)
+	if err := r.Create(ctx, run); err != nil && !errors.IsAlreadyExists(err) {
+		// AlreadyExists means a previous reconcile created it but failed to update status
+		return fmt.Errorf("failed to create scheduled backup %s: %w", run.Name, err)
+	}
+	return nil
+}
+
+// enforceRetention deletes completed runs of a scheduled Backup that fall outside
+// its retention policy, and failed runs older than the newest completed one.
+// Deleting a run also deletes its stored backup (see handleDeletion).
+// It returns the newest remaining run and how many runs remain.
+func (r *BackupReconciler) enforceRetention(ctx context.Context, backup *databasev1.Backup) (*databasev1.Backup, int, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	runs := &databasev1.BackupList{}
+	if err := r.List(ctx, runs,
+		client.InNamespace(backup.Namespace),
+		client.MatchingLabels{scheduledBackupLabel: backup.Name}); err != nil {
+		return nil, 0, err
+	}
+
+	// Only completed runs have stored data to retain
+	completed := make(map[string]*databasev1.Backup)
+	var artifacts []backupPkg.Artifact
+	var failed []*databasev1.Backup
+	var newest *databasev1.Backup
+	for i := range runs.Items {
+		run := &runs.Items[i]
+		if !run.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if run.Status.Phase == "Failed" {
+			failed = append(failed, run)
+			continue
+		}
+		if run.Status.Phase != "Completed" || run.Status.BackupTime == nil {
+			continue
+		}
+		completed[run.Name] = run
+		artifacts = append(artifacts, backupPkg.Artifact{Name: run.Name, Time: run.Status.BackupTime.Time})
+		if newest == nil || newest.CreationTimestamp.Before(&run.CreationTimestamp) {
+			newest = run
+		}
+	}
+
+	// A failed run that a newer run has replaced is only kept for debugging.
+	// Deleting it also deletes the Jobs of all its attempts.
+	if newest != nil {
+		for _, run := range failed {
+			if !run.CreationTimestamp.Before(&newest.CreationTimestamp) {
+				continue
+			}
+			log.Info("Pruning failed backup replaced by a newer one", "backup", backup.Name, "run", run.Name)
+			if err := r.Delete(ctx, run); client.IgnoreNotFound(err) != nil {
+				return nil, 0, fmt.Errorf("failed to prune backup %s: %w", run.Name, err)
+			}
+		}
+	}
+
+	policy := backupPkg.RetentionPolicy{KeepLast: backup.Spec.Retention}
+	if policy.KeepLast < 1 {
+		policy.KeepLast = defaultRetention
+	}
+	if rp := backup.Spec.RetentionPolicy; rp != nil {
+		policy.KeepDaily = rp.KeepDaily
+		policy.KeepWeekly = rp.KeepWeekly
+		policy.KeepMonthly = rp.KeepMonthly
+	}
+
+	keep, prune := backupPkg.ApplyRetention(artifacts, policy)
+	for _, a := range prune {
+		log.Info("Pruning backup outside retention", "backup", backup.Name, "run", a.Name)
+		if err := r.Delete(ctx, completed[a.Name]); client.IgnoreNotFound(err) != nil {
+			return nil, 0, fmt.Errorf("failed to prune backup %s: %w", a.Name, err)
+		}
+	}
+
+	if len(keep) == 0 {
+		return nil, 0, nil
+	}
+	return completed[keep[0].Name], len(keep), nil
+}
+
+// handleDeletion deletes the stored backup and then removes the finalizer
+func (r *BackupReconciler) handleDeletion(ctx context.Context, backup *databasev1.Backup) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	if !controllerutil.ContainsFinalizer(backup, backupArtifactFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	// Garbage collection removes the scratch Database of a running verification, but not its volume
+	if err := r.deleteScratchDatabase(ctx, backup); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if backup.Status.BackupLocation != "" {
+		log.Info("Deleting stored backup", "backup", backup.Name, "location", backup.Status.BackupLocation)
+		storageCfg, err := storage.ConfigFromSecret(ctx, r.Client, backup.Namespace, backup.Spec.StorageSecretRef)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := backupPkg.DeleteFromStorage(ctx, backup.Status.BackupLocation, storageCfg); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to delete stored backup %s: %w", backup.Status.BackupLocation, err)
+		}
+	}
+
+	controllerutil.RemoveFinalizer(backup, backupArtifactFinalizer)
+	if err := r.Update(ctx, backup); err != nil {
+		if errors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// performBackup creates the Job that dumps the database and marks the Backup InProgress.
+// The Job reports back through its status, see checkBackupJob.
 func (r *BackupReconciler) performBackup(ctx context.Context, req ctrl.Request, db *databasev1.Database, backup *databasev1.Backup) (ctrl.Result, error) {
 	log := ctrl.LoggerFrom(ctx)
 
@@ -132,17 +562,66 @@ func (r *BackupReconciler) performBackup(ctx context.Context, req ctrl.Request,
 	}
 	if backup.Status.Phase == "InProgress" {
 		log.Info("Backup already in progress, skipping", "backup", backup.Name)
-		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		return ctrl.Result{}, nil
+	}
+
+	// Create the backup Job; it is owned by the Backup so it is cleaned up with it.
+	// Each attempt has its own Job. With pre-backup hooks, runPreHooks creates it
+	// once they are done.
+	attempt := backup.Status.Attempts + 1
+	backup.Status.Attempts = attempt
+	message := "Running pre-backup hooks"
+	if len(backupPkg.Hooks(backup, backupPkg.HookStagePre)) == 0 {
+		job, err := r.createBackupJob(ctx, db, backup)
+		if err != nil {
+			log.Error(err, "Failed to start backup", "backup", backup.Name, "attempt", attempt)
+			return r.recordBackupFailure(ctx, req, backup, attempt, err.Error())
+		}
+		message = fmt.Sprintf("Backup job %s is running", job.Name)
 	}
 
 	// Update status to in progress
+	now := metav1.Now()
 	backup.Status.Phase = "InProgress"
-	meta.SetStatusCondition(&backup.Status.Conditions, metav1.Condition{
-		Type:    "BackupReady",
-		Status:  metav1.ConditionFalse,
-		Reason:  "BackupInProgress",
-		Message: "Backup in progress",
-	})
+	backup.Status.StartTime = &now
+	backup.Status.Owner = r.identity()
+	backup.Status.NextRetryTime = nil
+	conditions.MarkReconciling(backup, "BackupInProgress", message)
+	if err := r.Status().Update(ctx, backup); err != nil {
+		if errors.IsConflict(err) {
+			// Resource was modified, requeue to retry
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, clearRetryRequest(ctx, r.Client, backup)
+}
+
+// recordBackupFailure marks the Backup Failed after its attempt failed and schedules
+// the next attempt if the retry policy allows one
+func (r *BackupReconciler) recordBackupFailure(ctx context.Context, req ctrl.Request, backup *databasev1.Backup, attempt int32, reason string) (ctrl.Result, error) {
+	// Re-read backup before updating status on error
+	if err := r.Get(ctx, req.NamespacedName, backup); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	backup.Status.Phase = "Failed"
+	backup.Status.Attempts = attempt
+	backup.Status.LastFailureReason = reason
+	backup.Status.NextRetryTime = nil
+	message := fmt.Sprintf("Attempt %d failed: %s", attempt, reason)
+	delay, retry := retryDelay(backup.Spec.RetryPolicy, attempt)
+	if retry {
+		next := metav1.NewTime(time.Now().Add(delay))
+		backup.Status.NextRetryTime = &next
+		message += fmt.Sprintf(", retrying at %s", next.Format(time.RFC3339))
+	}
+	if retry {
+		conditions.MarkReconciling(backup, "BackupFailed", message)
+	} else {
+		conditions.MarkStalled(backup, "BackupFailed", message)
+	}
 	if err := r.Status().Update(ctx, backup); err != nil {
 		if errors.IsConflict(err) {
 			// Resource was modified, requeue to retry
@@ -151,27 +630,202 @@ func (r *BackupReconciler) performBackup(ctx context.Context, req ctrl.Request,
 		return ctrl.Result{}, err
 	}
 
-	// Perform actual backup (simplified)
-	backupLocation, err := r.createBackup(ctx, db, backup)
+	// A failed manual retry doesn't retry again by itself
+	if err := clearRetryRequest(ctx, r.Client, backup); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: delay}, nil
+}
+
+// checkRunningBackupJob stops attempts that run past the timeout and adopts attempts
+// started by another operator instance
+func (r *BackupReconciler) checkRunningBackupJob(ctx context.Context, req ctrl.Request, backup *databasev1.Backup, job *batchv1.Job, started time.Time) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	// The Job's activeDeadlineSeconds normally fails it first; this catches Jobs
+	// that can't even start (e.g. unschedulable pods)
+	timeout := backupPkg.Timeout(backup)
+	remaining := timeout - time.Since(started)
+	if remaining <= 0 {
+		log.Info("Backup timed out, stopping job", "backup", backup.Name, "job", job.Name, "timeout", timeout)
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		return r.recordBackupFailure(ctx, req, backup, backup.Status.Attempts,
+			fmt.Sprintf("backup job %s did not finish within %s", job.Name, timeout))
+	}
+
+	if backup.Status.Owner != r.identity() {
+		log.Info("Adopting in-progress backup", "backup", backup.Name, "job", job.Name, "previousOwner", backup.Status.Owner)
+		backup.Status.Owner = r.identity()
+		if backup.Status.StartTime == nil {
+			backup.Status.StartTime = &metav1.Time{Time: started}
+		}
+		if err := r.Status().Update(ctx, backup); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Job status changes trigger a reconcile, the timeout doesn't
+	return ctrl.Result{RequeueAfter: remaining}, nil
+}
+
+// identity returns the name of this operator instance
+func (r *BackupReconciler) identity() string {
+	if r.Identity != "" {
+		return r.Identity
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// createBackupJob creates the Job that dumps db into a new location below StorageLocation.
+// An existing Job from an earlier reconcile is reused.
+func (r *BackupReconciler) createBackupJob(ctx context.Context, db *databasev1.Database, backup *databasev1.Backup) (*batchv1.Job, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	// A missing encryption Secret would leave the Job Pod waiting forever
+	if enc := backup.Spec.Encryption; enc != nil {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Name: enc.SecretRef.Name, Namespace: backup.Namespace}, secret); err != nil {
+			return nil, fmt.Errorf("failed to get encryption secret %s: %w", enc.SecretRef.Name, err)
+		}
+	}
+
+	// A base backup without the WAL written during it can't be restored
+	if backupPkg.Method(backup) == backupPkg.MethodPhysical && db.Spec.WALArchiving == nil {
+		return nil, fmt.Errorf("physical backups need WAL archiving on database %s", db.Name)
+	}
+
+	if err := r.copyCredentials(ctx, db, backup); err != nil {
+		return nil, err
+	}
+
+	backupLocation, err := backupPkg.NewBackupLocation(db, backup, time.Now())
 	if err != nil {
-		// Re-read backup before updating status on error
-		if getErr := r.Get(ctx, req.NamespacedName, backup); getErr != nil {
-			return ctrl.Result{}, getErr
+		return nil, err
+	}
+
+	job, err := backupPkg.NewBackupJob(db, backup, backupLocation)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctrl.SetControllerReference(backup, job, r.Scheme); err != nil {
+		return nil, err
+	}
+	job.Annotations[backupPkg.DatabaseSpecHashAnnotation] = backupPkg.SpecHash(db)
+
+	log.Info("Creating backup job", "backup", backup.Name, "job", job.Name, "location", backupLocation)
+	if err := r.Create(ctx, job); err != nil {
+		if !errors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("failed to create backup job: %w", err)
 		}
-		backup.Status.Phase = "Failed"
-		meta.SetStatusCondition(&backup.Status.Conditions, metav1.Condition{
-			Type:    "BackupReady",
-			Status:  metav1.ConditionFalse,
-			Reason:  "BackupFailed",
-			Message: err.Error(),
-		})
-		if updateErr := r.Status().Update(ctx, backup); updateErr != nil {
-			if errors.IsConflict(updateErr) {
-				// Resource was modified, requeue to retry
+		// A previous reconcile created the Job but failed to update status
+		if err := r.Get(ctx, client.ObjectKeyFromObject(job), job); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// copyCredentials copies the credentials of a Database in another namespace next to
+// backup, for its Jobs. The grant is checked again, it may have gone since the attempt started.
+func (r *BackupReconciler) copyCredentials(ctx context.Context, db *databasev1.Database, backup *databasev1.Backup) error {
+	ref := backupDatabaseReference(backup)
+	if ref.fromNamespace == ref.toNamespace {
+		return nil
+	}
+	allowed, err := referenceAllowed(ctx, r.Client, ref)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("%s", ref)
+	}
+	// Only what the Jobs connect with: the Secret also holds the replication password and
+	// the instance API token (see replication.go), which a backup has no business with
+	return copySecret(ctx, r.Client, r.Scheme, backup, db.Namespace, agent.CredentialsSecretName(db), backupPkg.CredentialsSecretName(backup),
+		agent.CredentialsKeys...)
+}
+
+// checkBackupJob moves an InProgress Backup to Completed or Failed once its Job finishes.
+// Job status changes trigger a reconcile because the Backup owns the Job.
+//
+// It also recovers attempts that would otherwise stay InProgress forever: an attempt
+// started by another operator instance (e.g. before a restart) is adopted while its
+// Job runs, and attempts whose Job is gone or has run past the timeout fail, so the
+// retry policy can start a new one.
+func (r *BackupReconciler) checkBackupJob(ctx context.Context, req ctrl.Request, backup *databasev1.Backup) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	started := backup.CreationTimestamp.Time
+	if backup.Status.StartTime != nil {
+		started = backup.Status.StartTime.Time
+	}
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      backupPkg.JobName(backup),
+		Namespace: backup.Namespace,
+	}, job)
+	if errors.IsNotFound(err) && len(backupPkg.Hooks(backup, backupPkg.HookStagePre)) > 0 {
+		// The backup Job is created once the pre-backup hooks are done
+		return r.runPreHooks(ctx, req, backup)
+	}
+	if errors.IsNotFound(err) {
+		if time.Since(started) > missingJobGracePeriod {
+			log.Info("Backup job is gone", "backup", backup.Name, "job", backupPkg.JobName(backup))
+			return r.recordBackupFailure(ctx, req, backup, backup.Status.Attempts,
+				fmt.Sprintf("backup job %s no longer exists", backupPkg.JobName(backup)))
+		}
+		// The cache may not have seen the Job yet
+		log.Info("Backup job not found yet, waiting", "backup", backup.Name)
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	finished, succeeded, message := agent.JobFinished(job)
+	if !finished {
+		return r.checkRunningBackupJob(ctx, req, backup, job, started)
+	}
+
+	// Post-backup hooks run after every finished backup Job, so they can undo the pre hooks
+	if len(backupPkg.Hooks(backup, backupPkg.HookStagePost)) > 0 {
+		outcome, err := r.runHooks(ctx, backup, backupPkg.HookStagePost)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !outcome.done {
+			return ctrl.Result{}, nil
+		}
+		if err := r.recordHookOutcome(ctx, req, backup, backupPkg.HookStagePost, outcome); err != nil {
+			if errors.IsConflict(err) {
 				return ctrl.Result{Requeue: true}, nil
 			}
-			return ctrl.Result{}, updateErr
+			return ctrl.Result{}, err
 		}
+		if outcome.failure != "" && succeeded {
+			// The backup doesn't count, don't leave it in storage without a Backup pointing to it
+			if err := r.deleteAttemptArtifact(ctx, backup, job); err != nil {
+				return ctrl.Result{}, err
+			}
+			return r.recordBackupFailure(ctx, req, backup, backup.Status.Attempts, outcome.failure)
+		}
+	}
+
+	if !succeeded {
+		log.Info("Backup job failed", "backup", backup.Name, "job", job.Name, "message", message)
+		return r.recordBackupFailure(ctx, req, backup, backup.Status.Attempts, fmt.Sprintf("backup job %s failed: %s", job.Name, message))
+	}
+
+	// The agent reports the digest and size of what it stored
+	result, err := agent.JobResult(ctx, r.Client, job)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
 
@@ -180,31 +834,31 @@ func (r *BackupReconciler) performBackup(ctx context.Context, req ctrl.Request,
 		return ctrl.Result{}, err
 	}
 
+	// Record the backup in the catalog; the artifact is owned by the Backup and goes away with it
+	artifact := backupPkg.NewArtifact(backup, job, result)
+	if err := ctrl.SetControllerReference(backup, artifact, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.Create(ctx, artifact); err != nil && !errors.IsAlreadyExists(err) {
+		// AlreadyExists means a previous reconcile created it but failed to update status
+		return ctrl.Result{}, fmt.Errorf("failed to create backup artifact %s: %w", artifact.Name, err)
+	}
+
 	// Update status to completed
 	backup.Status.Phase = "Completed"
-	now := metav1.Now()
-	backup.Status.BackupTime = &now
-	backup.Status.BackupLocation = backupLocation
-	meta.SetStatusCondition(&backup.Status.Conditions, metav1.Condition{
-		Type:    "BackupReady",
-		Status:  metav1.ConditionTrue,
-		Reason:  "BackupCompleted",
-		Message: "Backup completed successfully",
-	})
-
-	// Handle scheduled backups
-	if backup.Spec.Schedule != "" {
-		// For scheduled backups, requeue after interval
-		// In production, you'd parse cron schedule and calculate next time
-		if updateErr := r.Status().Update(ctx, backup); updateErr != nil {
-			if errors.IsConflict(updateErr) {
-				log.Info("Conflict updating backup status, requeuing", "backup", backup.Name)
-				return ctrl.Result{Requeue: true}, nil
-			}
-			return ctrl.Result{}, updateErr
-		}
-		return ctrl.Result{RequeueAfter: 24 * time.Hour}, nil
+	backupTime := metav1.Now()
+	if job.Status.CompletionTime != nil {
+		backupTime = *job.Status.CompletionTime
 	}
+	backup.Status.BackupTime = &backupTime
+	backup.Status.BackupLocation = job.Annotations[agent.LocationAnnotation]
+	backup.Status.Size = result.Size
+	backup.Status.Digest = result.Digest
+	backup.Status.EncryptionKeyID = result.KeyID
+	backup.Status.WALLocation = job.Annotations[backupPkg.WALLocationAnnotation]
+	backup.Status.Artifact = artifact.Name
+	backup.Status.BackupCount = 1
+	conditions.MarkReady(backup, "BackupCompleted", "Backup completed successfully")
 
 	if err := r.Status().Update(ctx, backup); err != nil {
 		if errors.IsConflict(err) {
@@ -217,27 +871,22 @@ func (r *BackupReconciler) performBackup(ctx context.Context, req ctrl.Request,
 	return ctrl.Result{}, nil
 }
 
-func (r *BackupReconciler) createBackup(ctx context.Context, db *databasev1.Database, backup *databasev1.Backup) (string, error) {
-	// Actual backup implementation would:
-	// 1. Connect to database
-	// 2. Create backup (pg_dump, mysqldump, etc.)
-	// 3. Store backup in storage (S3, PVC, etc.)
-	// 4. Return backup location
-
-	backupLocation := fmt.Sprintf("s3://backups/%s/%s-%s.sql",
-		db.Namespace,
-		db.Name,
-		time.Now().Format("20060102-150405"))
-
-	// Simulate backup creation
-	// In real implementation, this would actually perform the backup
-
-	return backupLocation, nil
-}
-
 func (r *BackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&databasev1.Backup{}).
+		// Scheduled Backups own their runs, so a finished run triggers retention
+		Owns(&databasev1.Backup{}).
+		// Backups own their Jobs, so a finished Job completes the Backup
+		Owns(&batchv1.Job{}).
+		// Verification owns its scratch Database and Restore
+		Owns(&databasev1.Database{}).
+		Owns(&databasev1.Restore{}).
+		// A new ReferenceGrant lets Backups waiting for it continue, a removed one
+		// takes away their Secret copies
+		Watches(
+			&databasev1.ReferenceGrant{},
+			handler.EnqueueRequestsFromMapFunc(r.findBackupsForGrant),
+		).
 		Complete(r)
 }
 
@@ -0,0 +1,146 @@
+// Solution: Backup Sync from Module 8
+// This imports backups found in storage into the catalog
+//
+// These are additions to the BackupReconciler (backup-operator.go); backup-discovery.go
+// finds the backups. A Backup with spec.sync doesn't take backups. It lists its
+// storageLocation for backups of its Database, e.g. ones taken by a cluster that was
+// rebuilt, and creates a BackupArtifact for every backup that isn't in the catalog yet.
+// Restores then use them with spec.artifactRef.
+//
+// The imported artifacts are owned by the sync Backup. Deleting it removes them from the
+// catalog but leaves the stored backups alone.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	backupPkg "github.com/example/postgres-operator/internal/backup"
+	"github.com/example/postgres-operator/internal/conditions"
+	"github.com/example/postgres-operator/internal/storage"
+)
+
+// syncRetryInterval is how long a failed sync waits before listing the storage location again
+const syncRetryInterval = time.Minute
+
+// reconcileSync imports the backups in storage once, or every spec.sync.intervalSeconds.
+// The retry annotation and spec changes list the storage location again right away.
+func (r *BackupReconciler) reconcileSync(ctx context.Context, req ctrl.Request, backup *databasev1.Backup) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	interval := time.Duration(backup.Spec.Sync.IntervalSeconds) * time.Second
+	upToDate := backup.Status.ObservedGeneration == backup.Generation
+	if last := backup.Status.LastSyncTime; last != nil && backup.Status.Phase == "Synced" && upToDate && !retryRequested(backup) {
+		if interval == 0 {
+			return ctrl.Result{}, nil
+		}
+		if wait := time.Until(last.Add(interval)); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	imported, found, skipped, err := r.importArtifacts(ctx, backup)
+	if err != nil {
+		log.Error(err, "Failed to sync backups from storage", "backup", backup.Name)
+		// Re-read backup to ensure we have the latest version
+		if getErr := r.Get(ctx, req.NamespacedName, backup); getErr != nil {
+			return ctrl.Result{}, getErr
+		}
+		backup.Status.Phase = "Failed"
+		backup.Status.ObservedGeneration = backup.Generation
+		conditions.MarkReconciling(backup, "SyncFailed", err.Error())
+		if updateErr := r.Status().Update(ctx, backup); updateErr != nil {
+			if errors.IsConflict(updateErr) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, updateErr
+		}
+		return ctrl.Result{RequeueAfter: syncRetryInterval}, nil
+	}
+
+	// Re-read backup to ensure we have the latest version
+	if err := r.Get(ctx, req.NamespacedName, backup); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := metav1.Now()
+	message := fmt.Sprintf("Imported %d of %d backups found in storage", imported, found)
+	if len(skipped) > 0 {
+		message += fmt.Sprintf(", skipped %d that can't be restored: %v", len(skipped), skipped)
+	}
+	backup.Status.Phase = "Synced"
+	backup.Status.ObservedGeneration = backup.Generation
+	backup.Status.LastSyncTime = &now
+	backup.Status.BackupCount = found
+	conditions.MarkReady(backup, "Synced", message)
+	if err := r.Status().Update(ctx, backup); err != nil {
+		if errors.IsConflict(err) {
+			log.Info("Conflict updating backup status, requeuing", "backup", backup.Name)
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if err := clearRetryRequest(ctx, r.Client, backup); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if interval == 0 {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// importArtifacts creates a BackupArtifact for every discovered backup whose location
+// isn't in the catalog yet. It returns how many it created, how many backups it found
+// and which ones it skipped.
+func (r *BackupReconciler) importArtifacts(ctx context.Context, backup *databasev1.Backup) (int, int, []string, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	storageCfg, err := storage.ConfigFromSecret(ctx, r.Client, backup.Namespace, backup.Spec.StorageSecretRef)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	discovered, skipped, err := backupPkg.Discover(ctx, backup, storageCfg)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	existing := &databasev1.BackupArtifactList{}
+	if err := r.List(ctx, existing,
+		client.InNamespace(backup.Namespace),
+		client.MatchingLabels{databasev1.ArtifactDatabaseLabel: backup.Spec.DatabaseRef.Name}); err != nil {
+		return 0, 0, nil, err
+	}
+	cataloged := make(map[string]bool, len(existing.Items))
+	for _, a := range existing.Items {
+		cataloged[a.Spec.Location] = true
+	}
+
+	imported := 0
+	for _, artifact := range discovered {
+		if cataloged[artifact.Spec.Location] {
+			continue
+		}
+		if err := ctrl.SetControllerReference(backup, artifact, r.Scheme); err != nil {
+			return 0, 0, nil, err
+		}
+		log.Info("Importing backup into the catalog", "backup", backup.Name, "artifact", artifact.Name, "location", artifact.Spec.Location)
+		if err := r.Create(ctx, artifact); err != nil {
+			if errors.IsAlreadyExists(err) {
+				// Created by an earlier sync the cache hasn't seen yet
+				continue
+			}
+			return 0, 0, nil, fmt.Errorf("failed to create backup artifact %s: %w", artifact.Name, err)
+		}
+		imported++
+	}
+	return imported, len(discovered), skipped, nil
+}
@@ -0,0 +1,297 @@
+// Solution: Backup Verification from Module 8
+// This test-restores completed Backups that set spec.verify
+//
+// These are additions to the BackupReconciler (backup-operator.go). See backup-verify.go
+// for the verify Job. A Completed Backup with spec.verify goes through:
+//   Verified=Unknown (Verifying)  scratch Database and Restore created, restore running
+//   Verified=Unknown (Verifying)  restore done, verify Job running the queries
+//   Verified=True/False           results recorded, scratch Database deleted
+//
+// The scratch Database copies the source Database's spec with a single replica.
+// Physical backups also need its WAL archiving settings to recover, so the scratch
+// Database archives its own (short) WAL history to <location>/<namespace>/<backup>-verify/wal.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/agent"
+	backupPkg "github.com/example/postgres-operator/internal/backup"
+	"github.com/example/postgres-operator/internal/conditions"
+	restorePkg "github.com/example/postgres-operator/internal/restore"
+)
+
+// +kubebuilder:rbac:groups=database.example.com,resources=databases,verbs=create;delete
+// +kubebuilder:rbac:groups=database.example.com,resources=restores,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=delete
+
+// verifiesBackupLabel marks the scratch Database and Restore of a backup verification
+const verifiesBackupLabel = "database.example.com/verifies-backup"
+
+// conditionVerified is the outcome of the latest verification
+const conditionVerified conditions.Type = "Verified"
+
+// reconcileVerification drives the test restore of a Completed Backup
+func (r *BackupReconciler) reconcileVerification(ctx context.Context, req ctrl.Request, backup *databasev1.Backup) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	if backup.Spec.Verify == nil {
+		return ctrl.Result{}, nil
+	}
+	verified := conditions.Get(backup, conditionVerified)
+	if verified != nil && verified.Status != metav1.ConditionUnknown {
+		// Verification finished, the scratch Database isn't needed anymore
+		return ctrl.Result{}, r.deleteScratchDatabase(ctx, backup)
+	}
+
+	// A test restore that can't finish (e.g. no storage for the scratch Database)
+	// must not stay Unknown forever
+	if verified != nil && time.Since(verified.LastTransitionTime.Time) > backupPkg.Timeout(backup) {
+		return r.recordVerification(ctx, req, backup, metav1.ConditionFalse, "VerificationTimedOut",
+			fmt.Sprintf("Verification did not finish within %s", backupPkg.Timeout(backup)), nil)
+	}
+
+	source := &databasev1.Database{}
+	err := r.Get(ctx, client.ObjectKey{Name: backup.Spec.DatabaseRef.Name, Namespace: backupPkg.DatabaseNamespace(backup)}, source)
+	if errors.IsNotFound(err) {
+		return r.recordVerification(ctx, req, backup, metav1.ConditionFalse, "DatabaseNotFound",
+			fmt.Sprintf("Database %s is needed as template for the scratch database", backup.Spec.DatabaseRef.Name), nil)
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	scratch, err := r.ensureScratchDatabase(ctx, backup, source)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	rst, err := r.ensureVerifyRestore(ctx, backup, scratch)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	switch rst.Status.Phase {
+	case "Completed":
+	case "Failed":
+		if rst.Status.NextRetryTime != nil {
+			return ctrl.Result{}, nil
+		}
+		message := fmt.Sprintf("Restore %s failed", rst.Name)
+		if c := conditions.Get(rst, conditions.Ready); c != nil {
+			message += ": " + c.Message
+		}
+		return r.recordVerification(ctx, req, backup, metav1.ConditionFalse, "RestoreFailed", message, nil)
+	default:
+		// The Backup owns the Restore, its status changes trigger a reconcile
+		if verified != nil {
+			return ctrl.Result{}, nil
+		}
+		log.Info("Verifying backup", "backup", backup.Name, "database", scratch.Name)
+		return r.recordVerification(ctx, req, backup, metav1.ConditionUnknown, "Verifying",
+			fmt.Sprintf("Restoring into scratch database %s", scratch.Name), nil)
+	}
+
+	// A physical restore restarts the scratch Database
+	if !databaseReady(scratch) {
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	job, err := r.ensureVerifyJob(ctx, backup, scratch)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	finished, succeeded, message := agent.JobFinished(job)
+	if !finished {
+		return ctrl.Result{}, nil
+	}
+	if !succeeded {
+		return r.recordVerification(ctx, req, backup, metav1.ConditionFalse, "VerificationFailed",
+			fmt.Sprintf("Verify job %s failed: %s", job.Name, message), nil)
+	}
+
+	result, err := agent.JobResult(ctx, r.Client, job)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	queries := backupPkg.VerifyQueries(backup)
+	if len(result.Checks) != len(queries) {
+		return r.recordVerification(ctx, req, backup, metav1.ConditionFalse, "VerificationFailed",
+			fmt.Sprintf("Verify job %s returned %d results for %d queries", job.Name, len(result.Checks), len(queries)), nil)
+	}
+
+	failed := 0
+	for i := range result.Checks {
+		result.Checks[i].Query = queries[i]
+		if !result.Checks[i].Passed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return r.recordVerification(ctx, req, backup, metav1.ConditionFalse, "VerificationFailed",
+			fmt.Sprintf("%d of %d queries failed", failed, len(queries)), result.Checks)
+	}
+	return r.recordVerification(ctx, req, backup, metav1.ConditionTrue, "VerificationSucceeded",
+		fmt.Sprintf("Restored into %s and all %d queries passed", scratch.Name, len(queries)), result.Checks)
+}
+
+// recordVerification sets the Verified condition. A backup that fails verification is
+// Degraded: it exists, but can't be relied on. Once verification has finished the scratch
+// Database is deleted.
+func (r *BackupReconciler) recordVerification(ctx context.Context, req ctrl.Request, backup *databasev1.Backup, status metav1.ConditionStatus, reason conditions.Reason, message string, results []databasev1.VerificationResult) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	// Re-read backup to ensure we have the latest version
+	if err := r.Get(ctx, req.NamespacedName, backup); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if status != metav1.ConditionUnknown {
+		log.Info("Backup verification finished", "backup", backup.Name, "verified", status, "reason", reason)
+		backup.Status.VerificationResults = results
+	}
+	conditions.Set(backup, conditionVerified, status, reason, message)
+	switch status {
+	case metav1.ConditionFalse:
+		conditions.MarkDegraded(backup, reason, message)
+	case metav1.ConditionTrue:
+		conditions.ClearDegraded(backup)
+	}
+	if err := r.Status().Update(ctx, backup); err != nil {
+		if errors.IsConflict(err) {
+			// Resource was modified, requeue to retry
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if status == metav1.ConditionUnknown {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{}, r.deleteScratchDatabase(ctx, backup)
+}
+
+// ensureScratchDatabase creates the Database the backup is test-restored into.
+// It is owned by the Backup so it never outlives it.
+func (r *BackupReconciler) ensureScratchDatabase(ctx context.Context, backup *databasev1.Backup, source *databasev1.Database) (*databasev1.Database, error) {
+	scratch := &databasev1.Database{}
+	err := r.Get(ctx, client.ObjectKey{Name: backupPkg.VerifyName(backup), Namespace: backup.Namespace}, scratch)
+	if err == nil || !errors.IsNotFound(err) {
+		return scratch, err
+	}
+
+	spec := source.Spec.DeepCopy()
+	spec.Replicas = ptr.To(int32(1))
+	spec.BackupRef = nil
+	if backupPkg.Method(backup) != backupPkg.MethodPhysical {
+		spec.WALArchiving = nil
+	}
+	scratch = &databasev1.Database{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backupPkg.VerifyName(backup),
+			Namespace: backup.Namespace,
+			Labels:    map[string]string{verifiesBackupLabel: backup.Name},
+		},
+		Spec: *spec,
+	}
+	if err := ctrl.SetControllerReference(backup, scratch, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	ctrl.LoggerFrom(ctx).Info("Creating scratch database", "backup", backup.Name, "database", scratch.Name)
+	if err := r.Create(ctx, scratch); err != nil {
+		return nil, fmt.Errorf("failed to create scratch database %s: %w", scratch.Name, err)
+	}
+	return scratch, nil
+}
+
+// ensureVerifyRestore creates the Restore of the backup into the scratch Database
+func (r *BackupReconciler) ensureVerifyRestore(ctx context.Context, backup *databasev1.Backup, scratch *databasev1.Database) (*databasev1.Restore, error) {
+	rst := &databasev1.Restore{}
+	err := r.Get(ctx, client.ObjectKey{Name: backupPkg.VerifyName(backup), Namespace: backup.Namespace}, rst)
+	if err == nil || !errors.IsNotFound(err) {
+		return rst, err
+	}
+
+	rst = &databasev1.Restore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backupPkg.VerifyName(backup),
+			Namespace: backup.Namespace,
+			Labels:    map[string]string{verifiesBackupLabel: backup.Name},
+		},
+		Spec: databasev1.RestoreSpec{
+			DatabaseRef: corev1.LocalObjectReference{Name: scratch.Name},
+			BackupRef:   &databasev1.NamespacedObjectReference{Name: backup.Name},
+		},
+	}
+	if err := ctrl.SetControllerReference(backup, rst, r.Scheme); err != nil {
+		return nil, err
+	}
+	if err := r.Create(ctx, rst); err != nil {
+		return nil, fmt.Errorf("failed to create verify restore %s: %w", rst.Name, err)
+	}
+	return rst, nil
+}
+
+// ensureVerifyJob creates the Job that runs the verification queries.
+// An existing Job from an earlier reconcile is reused.
+func (r *BackupReconciler) ensureVerifyJob(ctx context.Context, backup *databasev1.Backup, scratch *databasev1.Database) (*batchv1.Job, error) {
+	job, err := backupPkg.NewVerifyJob(scratch, backup)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctrl.SetControllerReference(backup, job, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	if err := r.Create(ctx, job); err != nil {
+		if !errors.IsAlreadyExists(err) {
+			return nil, fmt.Errorf("failed to create verify job: %w", err)
+		}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(job), job); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// deleteScratchDatabase removes the scratch Database, its Restore and its data volume.
+// The StatefulSet keeps its volumes when it is deleted, so the claim is deleted here.
+func (r *BackupReconciler) deleteScratchDatabase(ctx context.Context, backup *databasev1.Backup) error {
+	key := client.ObjectKey{Name: backupPkg.VerifyName(backup), Namespace: backup.Namespace}
+
+	scratch := &databasev1.Database{}
+	err := r.Get(ctx, key, scratch)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(scratch, backup) || !scratch.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	ctrl.LoggerFrom(ctx).Info("Deleting scratch database", "backup", backup.Name, "database", scratch.Name)
+	background := client.PropagationPolicy(metav1.DeletePropagationBackground)
+	rst := &databasev1.Restore{ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}}
+	if err := r.Delete(ctx, rst, background); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	if err := r.Delete(ctx, scratch, background); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: restorePkg.DataClaimName(scratch), Namespace: key.Namespace}}
+	return client.IgnoreNotFound(r.Delete(ctx, pvc))
+}
@@ -0,0 +1,92 @@
+// Solution: Backup Verification from Module 8
+// This proves that a Backup can actually be restored
+// Location: internal/backup/verify.go
+//
+// A Completed Backup only says that pg_dump or pg_basebackup exited cleanly. With
+// spec.verify the Backup controller test-restores every completed backup:
+// 1. It creates a scratch Database <backup>-verify, owned by the Backup
+// 2. It restores the backup into it with a Restore, the same way a user would
+// 3. A Job runs Verify (backup-agent verify) with the sanity queries against it
+// 4. The results go into the Verified condition and the scratch Database is deleted
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/agent"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/utils/ptr"
+)
+
+// DefaultVerifyQuery checks that the restore created at least one table
+const DefaultVerifyQuery = "SELECT count(*) > 0 FROM pg_catalog.pg_tables WHERE schemaname NOT IN ('pg_catalog', 'information_schema')"
+
+// verifyTimeout bounds the verify Job, the queries are meant to be quick sanity checks
+const verifyTimeout = 600
+
+// maxOutputLength keeps the results within the 4KB termination message
+const maxOutputLength = 200
+
+// VerifyName returns the name of the scratch Database, Restore and Job that verify backup
+func VerifyName(backup *databasev1.Backup) string {
+	return fmt.Sprintf("%s-verify", backup.Name)
+}
+
+// VerifyQueries returns the queries that verify backup, applying the default
+func VerifyQueries(backup *databasev1.Backup) []string {
+	if backup.Spec.Verify == nil || len(backup.Spec.Verify.Queries) == 0 {
+		return []string{DefaultVerifyQuery}
+	}
+	return backup.Spec.Verify.Queries
+}
+
+// NewVerifyJob builds the Job that runs the verification queries of backup against
+// the scratch Database it was restored into
+func NewVerifyJob(scratch *databasev1.Database, backup *databasev1.Backup) (*batchv1.Job, error) {
+	args := []string{"verify"}
+	for _, q := range VerifyQueries(backup) {
+		args = append(args, "--query", q)
+	}
+	return agent.NewJob(scratch, agent.JobOptions{
+		Name:                  VerifyName(backup),
+		Type:                  "verify",
+		Args:                  args,
+		ActiveDeadlineSeconds: ptr.To(int64(verifyTimeout)),
+	})
+}
+
+// Verify runs each query with psql and reports whether it passed. A query passes
+// if it returns a first row that isn't false. It runs inside the verify Job, where
+// the PG* environment variables (set by agent.NewJob) tell psql how to connect.
+// A failing query doesn't stop the others. The results leave Query empty to keep
+// them small, the controller knows which queries it asked for.
+func Verify(ctx context.Context, queries []string) []databasev1.VerificationResult {
+	results := make([]databasev1.VerificationResult, 0, len(queries))
+	for _, q := range queries {
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "psql", "--no-psqlrc", "--tuples-only", "--no-align", "-v", "ON_ERROR_STOP=1", "-c", q)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+
+		var res databasev1.VerificationResult
+		if err != nil {
+			res.Output = fmt.Sprintf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		} else {
+			row, _, _ := strings.Cut(string(out), "\n")
+			res.Output = row
+			// psql prints booleans as t and f
+			res.Passed = row != "" && row != "f"
+		}
+		if len(res.Output) > maxOutputLength {
+			res.Output = res.Output[:maxOutputLength]
+		}
+		results = append(results, res)
+	}
+	return results
+}
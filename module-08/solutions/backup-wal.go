@@ -0,0 +1,114 @@
+// Solution: WAL Archiving from Module 8
+// This archives and fetches PostgreSQL WAL segments for point-in-time recovery
+// Location: internal/backup/wal.go
+//
+// PostgreSQL runs the backup-agent as its archive_command and restore_command:
+//   archive_command = '/agent/backup-agent wal-push --location <wal location> %p'
+//   restore_command = '/agent/backup-agent wal-fetch --location <wal location> %f %p'
+// Segments are stored gzip-compressed as <wal location>/<segment>.gz
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/storage"
+)
+
+// WALLocation returns where the WAL of db is archived:
+// <walArchiving.storageLocation>/<namespace>/<database>/wal
+func WALLocation(db *databasev1.Database) (string, error) {
+	if db.Spec.WALArchiving == nil {
+		return "", fmt.Errorf("WAL archiving is not enabled for database %s", db.Name)
+	}
+	return storage.JoinLocation(db.Spec.WALArchiving.StorageLocation, db.Namespace, db.Name, "wal")
+}
+
+// PushWAL archives the WAL file at path (relative to PGDATA, as passed by %p).
+// PostgreSQL retries failed segments, and may re-archive a segment after a crash,
+// so overwriting an existing segment is fine.
+func PushWAL(ctx context.Context, walLocation, path string, storageCfg storage.Config) error {
+	location, err := storage.JoinLocation(walLocation, filepath.Base(path)+".gz")
+	if err != nil {
+		return err
+	}
+	store, key, err := storage.Open(ctx, location, storageCfg)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		zw, err := storage.NewCompressor(pw, storage.CompressionGzip)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		_, err = io.Copy(zw, f)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	err = store.Put(ctx, key, pr)
+	pr.CloseWithError(err)
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %v", path, err)
+	}
+	return nil
+}
+
+// FetchWAL restores the archived segment name (%f) to dest (%p).
+// It returns an error wrapping storage.ErrNotFound when the segment isn't archived,
+// which tells PostgreSQL it has reached the end of the archive.
+func FetchWAL(ctx context.Context, walLocation, name, dest string, storageCfg storage.Config) error {
+	location, err := storage.JoinLocation(walLocation, name+".gz")
+	if err != nil {
+		return err
+	}
+	store, key, err := storage.Open(ctx, location, storageCfg)
+	if err != nil {
+		return err
+	}
+
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	zr, err := storage.NewDecompressor(r, storage.CompressionGzip)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	// Write to a temporary file first, PostgreSQL must never see a partial segment
+	tmp := dest + ".fetch"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, zr); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to fetch %s: %v", name, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
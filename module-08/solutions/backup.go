@@ -1,108 +1,322 @@
 // Solution: Backup Implementation from Module 8
 // This demonstrates backup functionality for stateful applications
 //
-// IMPORTANT: This implementation uses pg_dump which requires PostgreSQL client tools
-// to be installed in your operator container image. Update your Dockerfile to include
-// postgresql-client package. See the Dockerfile example in this solutions directory.
+// Backups run as Kubernetes Jobs instead of inside the operator process:
+// - NewBackupJob builds the Job that the Backup controller creates
+// - Dump runs inside that Job (via the backup-agent) and streams pg_dump (or, for
+//   physical backups, pg_basebackup) into storage,
+//   compressing (gzip or zstd), optionally encrypting, and computing its SHA-256 digest on the way
+// The Job uses the Database's PostgreSQL image, so the operator image doesn't need
+// PostgreSQL client tools. See agent-job.go and backup-agent-main.go.
 
 package backup
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"net/url"
 	"os/exec"
+	"strings"
 	"time"
 
 	databasev1 "github.com/example/postgres-operator/api/v1"
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	"sigs.k8s.io/controller-runtime/pkg/client"
+	"github.com/example/postgres-operator/internal/agent"
+	"github.com/example/postgres-operator/internal/storage"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/utils/ptr"
 )
 
-func PerformBackup(ctx context.Context, k8sClient client.Client, db *databasev1.Database) (string, error) {
-	// Connect to database
-	endpoint := db.Status.Endpoint
-	if endpoint == "" {
-		return "", fmt.Errorf("database endpoint not available")
-	}
+// Backup methods, see BackupSpec.Method
+const (
+	// MethodLogical dumps the database with pg_dump into a SQL script
+	MethodLogical = "logical"
+	// MethodPhysical copies the data directory with pg_basebackup into a tar archive.
+	// Together with WAL archiving it is the base for point-in-time recovery.
+	MethodPhysical = "physical"
+)
 
-	// Get password from Secret
-	secretName := db.Status.SecretName
-	if secretName == "" {
-		// Fallback to default secret name pattern if SecretName not set in status
-		secretName = fmt.Sprintf("%s-credentials", db.Name)
+// WALLocationAnnotation records on a physical backup Job the WAL archive it depends on
+const WALLocationAnnotation = "database.example.com/wal-location"
+
+// NewBackupLocation returns where a backup of db taken at t is stored:
+// <storageLocation>/<namespace>/<database>-<timestamp>.{sql|tar}[.gz|.zst][.aes|.age]
+func NewBackupLocation(db *databasev1.Database, backup *databasev1.Backup, t time.Time) (string, error) {
+	format := "sql"
+	if Method(backup) == MethodPhysical {
+		format = "tar"
+	}
+	name := fmt.Sprintf("%s-%s.%s%s", db.Name, t.UTC().Format("20060102-150405"), format, storage.Extension(Compression(backup)))
+	if enc := backup.Spec.Encryption; enc != nil {
+		name += storage.EncryptionExtension(enc.Algorithm)
 	}
+	return storage.JoinLocation(backup.Spec.StorageLocation, db.Namespace, name)
+}
 
-	secret := &corev1.Secret{}
-	err := k8sClient.Get(ctx, client.ObjectKey{
-		Name:      secretName,
-		Namespace: db.Namespace,
-	}, secret)
+// ValidateStorageLocation checks that backup is stored where its Jobs, the restore Job
+// and the operator can all reach it. A file:// location is in the backup Job's pod and
+// gone with it.
+func ValidateStorageLocation(backup *databasev1.Backup) error {
+	location := backup.Spec.StorageLocation
+	if location == "" {
+		return fmt.Errorf("storageLocation is required")
+	}
+	u, err := url.Parse(location)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			return "", fmt.Errorf("secret %s not found", secretName)
+		return fmt.Errorf("invalid storageLocation %q: %v", location, err)
+	}
+	switch u.Scheme {
+	case "pvc", "s3":
+		return nil
+	case "file":
+		return fmt.Errorf("storageLocation %q is lost with the backup Job's pod, use a pvc:// or s3:// location", location)
+	}
+	return fmt.Errorf("unsupported storageLocation %q, use a pvc:// or s3:// location", location)
+}
+
+// DatabaseNamespace returns the namespace of the Database backup refers to
+func DatabaseNamespace(backup *databasev1.Backup) string {
+	if ns := backup.Spec.DatabaseRef.Namespace; ns != "" {
+		return ns
+	}
+	return backup.Namespace
+}
+
+// CredentialsSecretName returns the name of the copy of the Database credentials
+// in the namespace of a Backup of a Database in another namespace
+func CredentialsSecretName(backup *databasev1.Backup) string {
+	return fmt.Sprintf("%s-db-credentials", backup.Name)
+}
+
+// runInBackupNamespace makes the Job of opts run next to backup when its Database
+// is in another namespace. The Secrets backup refers to are only available there.
+func runInBackupNamespace(opts *agent.JobOptions, backup *databasev1.Backup) {
+	if DatabaseNamespace(backup) == backup.Namespace {
+		return
+	}
+	opts.Namespace = backup.Namespace
+	opts.CredentialsSecret = CredentialsSecretName(backup)
+}
+
+// Method returns the method of backup, applying the default
+func Method(backup *databasev1.Backup) string {
+	if backup.Spec.Method == "" {
+		return MethodLogical
+	}
+	return backup.Spec.Method
+}
+
+// Compression returns the compression of backup, applying the default
+func Compression(backup *databasev1.Backup) string {
+	if backup.Spec.Compression == "" {
+		return storage.DefaultCompression
+	}
+	return backup.Spec.Compression
+}
+
+// DefaultTimeout matches the +kubebuilder:default on BackupSpec.TimeoutSeconds
+const DefaultTimeout = 6 * time.Hour
+
+// Timeout returns how long an attempt of backup may run, applying the default
+func Timeout(backup *databasev1.Backup) time.Duration {
+	if backup.Spec.TimeoutSeconds == 0 {
+		return DefaultTimeout
+	}
+	return time.Duration(backup.Spec.TimeoutSeconds) * time.Second
+}
+
+// JobName returns the name of the Job for the current attempt of backup:
+// <name>-backup for the first attempt and <name>-backup-<attempt> for retries
+func JobName(backup *databasev1.Backup) string {
+	if backup.Status.Attempts <= 1 {
+		return fmt.Sprintf("%s-backup", backup.Name)
+	}
+	return fmt.Sprintf("%s-backup-%d", backup.Name, backup.Status.Attempts)
+}
+
+// NewBackupJob builds the Job that dumps db to backupLocation
+func NewBackupJob(db *databasev1.Database, backup *databasev1.Backup, backupLocation string) (*batchv1.Job, error) {
+	opts := agent.JobOptions{
+		Name:             JobName(backup),
+		Type:             "backup",
+		Args:             []string{"backup", "--location", backupLocation, "--method", Method(backup), "--compression", Compression(backup)},
+		Location:         backupLocation,
+		StorageSecretRef: backup.Spec.StorageSecretRef,
+		// Kubernetes stops the Job's pods at the timeout, the controller fails the attempt
+		ActiveDeadlineSeconds: ptr.To(int64(Timeout(backup).Seconds())),
+	}
+	if enc := backup.Spec.Encryption; enc != nil {
+		opts.Args = append(opts.Args, "--encryption", enc.Algorithm)
+		opts.EncryptionSecretRef = &enc.SecretRef
+	}
+
+	// Physical backups depend on the WAL archive, which is recorded with them
+	var walLocation string
+	if Method(backup) == MethodPhysical {
+		var err error
+		if walLocation, err = WALLocation(db); err != nil {
+			return nil, err
 		}
-		return "", fmt.Errorf("failed to get secret: %w", err)
+		opts.Args = append(opts.Args, "--wal-location", walLocation)
 	}
+	runInBackupNamespace(&opts, backup)
 
-	// Extract password from Secret
-	passwordBytes, exists := secret.Data["password"]
-	if !exists {
-		return "", fmt.Errorf("password key not found in secret %s", secretName)
+	job, err := agent.NewJob(db, opts)
+	if err != nil {
+		return nil, err
 	}
-	password := string(passwordBytes)
+	if walLocation != "" {
+		job.Annotations[WALLocationAnnotation] = walLocation
+	}
+	return job, nil
+}
 
-	// Create backup filename
-	backupFile := fmt.Sprintf("/backups/%s-%s.sql",
-		db.Name,
-		time.Now().Format("20060102-150405"))
+// DumpOptions configures Dump
+type DumpOptions struct {
+	// Location is where the backup is stored
+	Location string
+	// Method is logical (pg_dump) or physical (pg_basebackup)
+	Method string
+	// Compression is gzip, zstd or none
+	Compression string
+	// Encryption encrypts the backup after compression (optional)
+	Encryption *storage.Encryption
+	// WALLocation is the WAL archive a physical backup depends on, recorded in its Metadata
+	WALLocation string
+}
 
-	// Perform pg_dump with password from Secret
-	cmd := exec.CommandContext(ctx, "pg_dump",
-		"-h", endpoint,
-		"-U", db.Spec.Username,
-		"-d", db.Spec.DatabaseName,
-		"-f", backupFile)
+// Dump runs pg_dump (or pg_basebackup) and streams its compressed (and optionally
+// encrypted) output to storage. It runs inside the backup Job, where the PG*
+// environment variables (set by agent.NewJob) tell it how to connect.
+// The returned Result holds the digest and size of the stored artifact, which is also
+// stored next to it as Metadata.
+func Dump(ctx context.Context, opts DumpOptions, storageCfg storage.Config) (agent.Result, error) {
+	start := time.Now()
+	store, key, err := storage.Open(ctx, opts.Location, storageCfg)
+	if err != nil {
+		return agent.Result{}, err
+	}
+
+	// dump -> compressor -> [encryptor] -> (pipe to storage, digest)
+	pr, pw := io.Pipe()
+	digester := storage.NewDigester()
+	var out io.Writer = io.MultiWriter(pw, digester)
 
-	// Set password as environment variable for pg_dump
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PGPASSWORD=%s", password))
+	var keyID string
+	var encryptor io.WriteCloser
+	if opts.Encryption != nil {
+		encryptor, keyID, err = storage.NewEncryptor(out, opts.Encryption)
+		if err != nil {
+			return agent.Result{}, err
+		}
+		out = encryptor
+	}
 
-	output, err := cmd.CombinedOutput()
+	compressor, err := storage.NewCompressor(out, opts.Compression)
 	if err != nil {
-		return "", fmt.Errorf("backup failed: %v, output: %s", err, string(output))
+		return agent.Result{}, err
 	}
 
-	// Save to storage (S3, PVC, etc.)
-	backupLocation, err := saveToStorage(backupFile)
+	// Recorded in the catalog, restores into another major version need a logical backup
+	version, err := serverVersion(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to save backup: %v", err)
+		return agent.Result{}, err
 	}
 
-	return backupLocation, nil
-}
+	var stderr bytes.Buffer
+	args := dumpCommand(opts.Method)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = compressor
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return agent.Result{}, fmt.Errorf("failed to start %s: %w", cmd.Args[0], err)
+	}
 
-func saveToStorage(backupFile string) (string, error) {
-	// In real implementation, this would:
-	// 1. Upload to S3
-	// 2. Copy to PVC
-	// 3. Store in object storage
+	// Closing the pipe with the dump's error makes Put fail instead of
+	// storing a truncated backup
+	dumpErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		if err != nil {
+			err = fmt.Errorf("backup failed: %v, output: %s", err, stderr.String())
+		} else {
+			// Flush the end of the compressed and encrypted streams
+			err = compressor.Close()
+			if err == nil && encryptor != nil {
+				err = encryptor.Close()
+			}
+		}
+		pw.CloseWithError(err)
+		dumpErr <- err
+	}()
 
-	// For example, upload to S3:
-	// s3Location := fmt.Sprintf("s3://backups/%s", backupFile)
-	// aws s3 cp backupFile s3Location
+	putErr := store.Put(ctx, key, pr)
+	// Unblock the dump if Put stopped reading early
+	pr.CloseWithError(putErr)
 
-	// Simplified for example
-	return fmt.Sprintf("s3://backups/%s", backupFile), nil
+	if err := <-dumpErr; err != nil {
+		return agent.Result{}, err
+	}
+	if putErr != nil {
+		return agent.Result{}, fmt.Errorf("failed to save backup: %v", putErr)
+	}
+	res := agent.Result{Size: digester.Size(), Digest: digester.Digest(), KeyID: keyID, PostgresVersion: version}
+	meta := Metadata{
+		Method:          opts.Method,
+		Compression:     opts.Compression,
+		Size:            res.Size,
+		Digest:          res.Digest,
+		KeyID:           res.KeyID,
+		PostgresVersion: res.PostgresVersion,
+		WALLocation:     opts.WALLocation,
+		StartTime:       start.UTC(),
+		EndTime:         time.Now().UTC(),
+	}
+	if opts.Encryption != nil {
+		meta.Encryption = opts.Encryption.Algorithm
+	}
+	if err := writeMetadata(ctx, store, key, meta); err != nil {
+		return agent.Result{}, fmt.Errorf("failed to save backup metadata: %v", err)
+	}
+	return res, nil
 }
 
-func PerformScheduledBackup(ctx context.Context, db *databasev1.Database, schedule string) error {
-	// Parse cron schedule
-	// Calculate next backup time
-	// Schedule backup job
-
-	// For Kubernetes, you might use CronJob
-	// For operator, you can use RequeueAfter
+// dumpCommand returns the command that writes a backup of the method to stdout
+func dumpCommand(method string) []string {
+	if method == MethodPhysical {
+		// A tar of the data directory on stdout. WAL is left out (-X none):
+		// it is restored from the WAL archive, which must be enabled.
+		return []string{"pg_basebackup", "-D", "-", "-F", "tar", "-X", "none", "--checkpoint=fast"}
+	}
+	// The script drops every object before creating it, so it loads over the schema it
+	// was taken from, which is what an in-place restore does. Owners are left out: the
+	// restore runs as the user of the Database it restores into.
+	return []string{"pg_dump", "--clean", "--if-exists", "--no-owner"}
+}
 
-	return nil
+// serverVersion returns the version of the server, e.g. "16.2"
+func serverVersion(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "psql", "--no-psqlrc", "--tuples-only", "--no-align", "-c", "SHOW server_version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get server version: %v", err)
+	}
+	// Distribution builds append their name, e.g. "16.2 (Debian 16.2-1.pgdg120+2)"
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("failed to get server version: empty output")
+	}
+	return fields[0], nil
 }
 
+// DeleteFromStorage removes a stored backup and its metadata, e.g. when it is pruned by retention
+func DeleteFromStorage(ctx context.Context, backupLocation string, storageCfg storage.Config) error {
+	store, key, err := storage.Open(ctx, backupLocation, storageCfg)
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		return err
+	}
+	return store.Delete(ctx, key+MetadataSuffix)
+}
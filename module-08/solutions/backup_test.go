@@ -0,0 +1,24 @@
+// Solution: Backup Tests from Module 8
+// This tests the commands the backup Job runs
+// Location: internal/backup/backup_test.go
+
+package backup
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dump", func() {
+	It("should dump a script that loads over the existing schema", func() {
+		// restore.Load runs the script with psql --single-transaction -v ON_ERROR_STOP=1,
+		// so every CREATE needs its DROP first
+		Expect(dumpCommand(MethodLogical)).To(ContainElements("pg_dump", "--clean", "--if-exists", "--no-owner"))
+	})
+
+	It("should stream physical backups as a tar without WAL", func() {
+		Expect(dumpCommand(MethodPhysical)).To(Equal([]string{
+			"pg_basebackup", "-D", "-", "-F", "tar", "-X", "none", "--checkpoint=fast",
+		}))
+	})
+})
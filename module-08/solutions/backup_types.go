@@ -12,32 +12,265 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// RetryAnnotation triggers one more attempt of a failed Backup or Restore, even when its
+// RetryPolicy is used up, or of a failed major upgrade of a Database. The controller
+// removes it once the attempt has started.
+//
+//	kubectl annotate backup my-backup database.example.com/retry=now
+const RetryAnnotation = "database.example.com/retry"
+
 // BackupSpec defines the desired state of Backup
+// +kubebuilder:validation:XValidation:rule="!(has(self.sync) && has(self.schedule))",message="sync and schedule are mutually exclusive"
 type BackupSpec struct {
-	// DatabaseRef references the Database to backup
+	// DatabaseRef references the Database to backup. A Database in another namespace
+	// needs a ReferenceGrant there allowing Backups from this namespace.
 	// +kubebuilder:validation:Required
-	DatabaseRef corev1.LocalObjectReference `json:"databaseRef"`
+	DatabaseRef NamespacedObjectReference `json:"databaseRef"`
 
 	// Schedule is the cron schedule for automated backups (optional)
+	// Accepts standard five-field cron expressions (e.g., "0 2 * * *") and
+	// predefined macros such as @hourly, @daily, @weekly and @monthly.
 	// If not specified, backup is a one-time operation
 	// +optional
 	Schedule string `json:"schedule,omitempty"`
 
+	// TimeZone is the IANA time zone the Schedule is evaluated in (e.g., "Europe/Berlin")
+	// Defaults to the time zone of the operator process (usually UTC)
+	// +optional
+	TimeZone *string `json:"timeZone,omitempty"`
+
+	// StartingDeadlineSeconds is how late a scheduled backup may start after its
+	// scheduled time. Runs missed by more than this (e.g., while the operator was
+	// down) are skipped instead of being started late.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+
 	// Retention is the number of backups to retain
+	// Only applies to scheduled backups: older runs are deleted along with their stored data,
+	// failed runs once a newer run has completed
 	// +kubebuilder:validation:Minimum=1
 	// +kubebuilder:default=5
 	// +optional
 	Retention int `json:"retention,omitempty"`
 
-	// StorageLocation is where to store the backup (e.g., s3://bucket/path)
+	// RetentionPolicy keeps additional daily, weekly and monthly backups on top of Retention
+	// +optional
+	RetentionPolicy *RetentionPolicy `json:"retentionPolicy,omitempty"`
+
+	// StorageLocation is where to store the backup. The scheme selects the storage driver:
+	// pvc://<claim>/path or s3://bucket/path (S3 options such as
+	// ?endpoint=minio:9000&region=us-east-1&insecure=true go in the query string).
+	// file:// isn't accepted: it would be the filesystem of the backup Job's pod.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^(pvc|s3)://`
+	StorageLocation string `json:"storageLocation"`
+
+	// Method is logical (pg_dump SQL script) or physical (pg_basebackup of the data directory).
+	// Physical backups plus the Database's WAL archive allow point-in-time restores.
+	// +kubebuilder:validation:Enum=logical;physical
+	// +kubebuilder:default=logical
+	// +optional
+	Method string `json:"method,omitempty"`
+
+	// Compression is the algorithm used to compress the backup
+	// +kubebuilder:validation:Enum=gzip;zstd;none
+	// +kubebuilder:default=gzip
+	// +optional
+	Compression string `json:"compression,omitempty"`
+
+	// StorageSecretRef references a Secret with credentials for StorageLocation.
+	// For S3 the Secret must contain AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+	// +optional
+	StorageSecretRef *corev1.LocalObjectReference `json:"storageSecretRef,omitempty"`
+
+	// Encryption encrypts the backup before it leaves the backup Job (optional)
+	// +optional
+	Encryption *EncryptionSpec `json:"encryption,omitempty"`
+
+	// TimeoutSeconds is how long a backup attempt may run. A slower attempt is
+	// stopped and counts as failed, so it can be retried.
+	// +kubebuilder:validation:Minimum=60
+	// +kubebuilder:default=21600
+	// +optional
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+
+	// RetryPolicy retries failed backups. Without it a failed backup is not retried
+	// (see RetryAnnotation for retrying by hand).
+	// +optional
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+
+	// Sync turns the Backup into an import: instead of taking a backup, it lists
+	// StorageLocation and records the backups of DatabaseRef found there as
+	// BackupArtifacts, e.g. backups taken by a previous cluster
+	// +optional
+	Sync *SyncSpec `json:"sync,omitempty"`
+
+	// Hooks run before and after the backup, e.g. to CHECKPOINT or to notify an application
+	// +optional
+	Hooks *BackupHooks `json:"hooks,omitempty"`
+
+	// Verify test-restores the backup into a scratch Database after it completes and
+	// runs sanity queries against it. The result is the Verified condition.
+	// +optional
+	Verify *VerifySpec `json:"verify,omitempty"`
+}
+
+// SyncSpec configures importing backups from storage
+type SyncSpec struct {
+	// IntervalSeconds lists the storage location again after this long.
+	// Without it the location is listed once (see RetryAnnotation for listing it again).
+	// +kubebuilder:validation:Minimum=60
+	// +optional
+	IntervalSeconds int32 `json:"intervalSeconds,omitempty"`
+}
+
+// BackupHooks are run around the backup Job, one after another, each in its own Job
+type BackupHooks struct {
+	// Pre hooks run before the backup. If one fails with onFailure Fail, the
+	// attempt fails without taking a backup.
+	// +optional
+	Pre []BackupHook `json:"pre,omitempty"`
+
+	// Post hooks run after the backup Job finished, also when it failed, so they can
+	// undo what the pre hooks did. If one fails with onFailure Fail, the attempt fails
+	// and its backup is deleted.
+	// +optional
+	Post []BackupHook `json:"post,omitempty"`
+}
+
+// BackupHook is a SQL statement or a container command
+// +kubebuilder:validation:XValidation:rule="has(self.sql) != has(self.container)",message="exactly one of sql and container must be set"
+type BackupHook struct {
+	// Name identifies the hook in its Job name and in conditions
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=20
+	Name string `json:"name"`
+
+	// SQL runs with psql against the database, e.g. "CHECKPOINT"
+	// +optional
+	SQL string `json:"sql,omitempty"`
+
+	// Container runs a command. The PG* environment variables point at the database.
+	// +optional
+	Container *HookContainer `json:"container,omitempty"`
+
+	// TimeoutSeconds is how long the hook may run before it counts as failed
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=60
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// OnFailure is Fail (fail the backup attempt) or Continue (record the failure and go on)
+	// +kubebuilder:validation:Enum=Fail;Continue
+	// +kubebuilder:default=Fail
+	// +optional
+	OnFailure string `json:"onFailure,omitempty"`
+}
+
+// HookContainer is the container a hook runs in
+type HookContainer struct {
+	// Image defaults to the Database's PostgreSQL image
 	// +optional
-	StorageLocation string `json:"storageLocation,omitempty"`
+	Image string `json:"image,omitempty"`
+
+	// Command is the command to run, e.g. ["curl", "-fsS", "http://app/backup-started"]
+	// +kubebuilder:validation:MinItems=1
+	Command []string `json:"command"`
+}
+
+// VerifySpec configures backup verification
+type VerifySpec struct {
+	// Queries are run against the restored database. A query fails if it errors,
+	// returns no rows or returns false, e.g. "SELECT count(*) > 0 FROM orders".
+	// Defaults to checking that the restored database has at least one table.
+	// +optional
+	Queries []string `json:"queries,omitempty"`
+}
+
+// VerificationResult is the outcome of one verification query
+type VerificationResult struct {
+	// Query is the SQL that was run
+	Query string `json:"query"`
+
+	// Passed is true if the query returned a row that isn't false
+	Passed bool `json:"passed"`
+
+	// Output is the first row returned by the query, or the error (truncated)
+	// +optional
+	Output string `json:"output,omitempty"`
+}
+
+// EncryptionSpec defines client-side encryption of backups
+type EncryptionSpec struct {
+	// Algorithm is aes-256-gcm (symmetric key) or age (public-key encryption)
+	// +kubebuilder:validation:Enum=aes-256-gcm;age
+	// +kubebuilder:default=aes-256-gcm
+	// +optional
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// SecretRef references the Secret holding the keys.
+	// aes-256-gcm: "key" holds the 32-byte key used for new backups.
+	// age: "recipients" holds the recipients for new backups (one per line), and
+	// restores need the matching identities under any other key (e.g. "identity").
+	// Keep retired keys in the Secret under other names so older backups can still be restored.
+	// +kubebuilder:validation:Required
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+}
+
+// RetentionPolicy defines calendar-based retention for scheduled backups.
+// A backup is kept if Retention or any of these rules selects it.
+type RetentionPolicy struct {
+	// KeepDaily keeps the newest backup of each of the last N days
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepDaily int `json:"keepDaily,omitempty"`
+
+	// KeepWeekly keeps the newest backup of each of the last N weeks
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepWeekly int `json:"keepWeekly,omitempty"`
+
+	// KeepMonthly keeps the newest backup of each of the last N months
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	KeepMonthly int `json:"keepMonthly,omitempty"`
+}
+
+// RetryPolicy controls how often a failed Backup or Restore is attempted again
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, including the first one
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=3
+	// +optional
+	MaxAttempts int32 `json:"maxAttempts,omitempty"`
+
+	// BackoffSeconds is the delay before the first retry. It doubles for every
+	// further retry, up to MaxBackoffSeconds.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=30
+	// +optional
+	BackoffSeconds int32 `json:"backoffSeconds,omitempty"`
+
+	// MaxBackoffSeconds caps the delay between attempts
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=600
+	// +optional
+	MaxBackoffSeconds int32 `json:"maxBackoffSeconds,omitempty"`
 }
 
 // BackupStatus defines the observed state of Backup
 type BackupStatus struct {
+	// ObservedGeneration is the spec generation the controller last acted on.
+	// Ready only reflects the current spec when it equals metadata.generation.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	// Phase is the current backup phase
-	// +kubebuilder:validation:Enum=Pending;InProgress;Completed;Failed
+	// Scheduled is used by Backups with a Schedule, which create a child Backup per run,
+	// and Synced by Backups with Sync, which import backups from storage
+	// +kubebuilder:validation:Enum=Pending;Scheduled;Synced;InProgress;Completed;Failed
 	Phase string `json:"phase,omitempty"`
 
 	// BackupTime is when the backup was created
@@ -46,13 +279,71 @@ type BackupStatus struct {
 	// BackupLocation is where the backup is stored
 	BackupLocation string `json:"backupLocation,omitempty"`
 
+	// Size is the size of the stored (compressed) backup in bytes
+	// +optional
+	Size int64 `json:"size,omitempty"`
+
+	// Digest is the SHA-256 digest of the stored backup ("sha256:<hex>").
+	// Restores verify it before loading the backup.
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// EncryptionKeyID identifies the key the backup is encrypted with,
+	// so restores still find it after the key is rotated
+	// +optional
+	EncryptionKeyID string `json:"encryptionKeyID,omitempty"`
+
+	// WALLocation is the WAL archive a physical backup needs for recovery.
+	// It is recorded so clones can recover after the source Database is gone.
+	// +optional
+	WALLocation string `json:"walLocation,omitempty"`
+
+	// Artifact is the BackupArtifact recording this backup in the catalog
+	// +optional
+	Artifact string `json:"artifact,omitempty"`
+
 	// LastScheduledTime is when the last scheduled backup was triggered
 	LastScheduledTime *metav1.Time `json:"lastScheduledTime,omitempty"`
 
+	// NextScheduleTime is when the next scheduled backup will be triggered
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
+
+	// LastSyncTime is when the storage location was last listed (Sync only)
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
 	// BackupCount is the number of successful backups stored
 	BackupCount int `json:"backupCount,omitempty"`
 
+	// StartTime is when the current attempt started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// Owner identifies the operator instance that started the current attempt.
+	// Another instance (e.g. after a restart) adopts the attempt while its Job still runs.
+	// +optional
+	Owner string `json:"owner,omitempty"`
+
+	// Attempts is the number of times the backup was started
+	// +optional
+	Attempts int32 `json:"attempts,omitempty"`
+
+	// LastFailureReason is why the last attempt failed
+	// +optional
+	LastFailureReason string `json:"lastFailureReason,omitempty"`
+
+	// NextRetryTime is when the next attempt starts, unset if the backup won't be retried
+	// +optional
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+
+	// VerificationResults are the results of the verification queries of the last
+	// test restore (see BackupSpec.Verify)
+	// +optional
+	VerificationResults []VerificationResult `json:"verificationResults,omitempty"`
+
 	// Conditions represent the latest observations of the Backup's state
+	// (Ready, Reconciling, Stalled and Degraded, see the conditions package; PreBackupHooks
+	// and PostBackupHooks when BackupSpec.Hooks is set; Verified when BackupSpec.Verify is set)
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
@@ -61,8 +352,13 @@ type BackupStatus struct {
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
 // +kubebuilder:printcolumn:name="Database",type="string",JSONPath=".spec.databaseRef.name"
+// +kubebuilder:printcolumn:name="Method",type="string",JSONPath=".spec.method",priority=1
 // +kubebuilder:printcolumn:name="Schedule",type="string",JSONPath=".spec.schedule"
 // +kubebuilder:printcolumn:name="Last Backup",type="date",JSONPath=".status.backupTime"
+// +kubebuilder:printcolumn:name="Next Backup",type="date",JSONPath=".status.nextScheduleTime",priority=1
+// +kubebuilder:printcolumn:name="Size",type="integer",JSONPath=".status.size",priority=1
+// +kubebuilder:printcolumn:name="Attempts",type="integer",JSONPath=".status.attempts",priority=1
+// +kubebuilder:printcolumn:name="Verified",type="string",JSONPath=".status.conditions[?(@.type==\"Verified\")].status",priority=1
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Backup is the Schema for the backups API.
@@ -75,6 +371,16 @@ type Backup struct {
 	Status BackupStatus `json:"status,omitempty"`
 }
 
+// GetConditions returns the status conditions, for the conditions package
+func (backup *Backup) GetConditions() []metav1.Condition {
+	return backup.Status.Conditions
+}
+
+// SetConditions replaces the status conditions, for the conditions package
+func (backup *Backup) SetConditions(conditions []metav1.Condition) {
+	backup.Status.Conditions = conditions
+}
+
 // +kubebuilder:object:root=true
 
 // BackupList contains a list of Backup
@@ -0,0 +1,124 @@
+// Solution: BackupArtifact Types from Module 8
+// This file contains the API type definitions for the BackupArtifact resource,
+// the catalog of stored backups.
+//
+// Scaffold with (same group as Database and Backup, no controller):
+//   kubebuilder create api --group database --version v1 --kind BackupArtifact --resource --controller=false
+//
+// The Backup controller creates a BackupArtifact for every completed backup, owned by the
+// Backup that produced it. A scheduled Backup keeps only its latest run in its status;
+// its artifacts carry the database.example.com/scheduled-backup label, so
+//   kubectl get backupartifacts -l database.example.com/scheduled-backup=nightly
+// lists its history. A Restore can restore from an artifact with spec.artifactRef.
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Labels set on BackupArtifacts
+const (
+	// ArtifactDatabaseLabel is the Database that was backed up
+	ArtifactDatabaseLabel = "database.example.com/database"
+	// ArtifactBackupLabel is the Backup that produced the artifact
+	ArtifactBackupLabel = "database.example.com/backup"
+)
+
+// BackupArtifactSpec describes a stored backup. It is everything a restore needs,
+// so it stays usable after the Backup that produced it is gone.
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="BackupArtifact spec is immutable"
+type BackupArtifactSpec struct {
+	// DatabaseRef is the Database that was backed up, with its namespace
+	// +kubebuilder:validation:Required
+	DatabaseRef NamespacedObjectReference `json:"databaseRef"`
+
+	// BackupRef is the Backup that produced the artifact
+	// +optional
+	BackupRef *corev1.LocalObjectReference `json:"backupRef,omitempty"`
+
+	// Location is where the backup is stored
+	// +kubebuilder:validation:Required
+	Location string `json:"location"`
+
+	// Method is logical or physical, see BackupSpec.Method
+	// +kubebuilder:validation:Enum=logical;physical
+	Method string `json:"method"`
+
+	// Compression is gzip, zstd or none
+	// +kubebuilder:validation:Enum=gzip;zstd;none
+	Compression string `json:"compression"`
+
+	// Size is the size of the stored (compressed) backup in bytes
+	// +optional
+	Size int64 `json:"size,omitempty"`
+
+	// Digest is the SHA-256 digest of the stored backup ("sha256:<hex>")
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// StorageSecretRef references a Secret with credentials for Location
+	// +optional
+	StorageSecretRef *corev1.LocalObjectReference `json:"storageSecretRef,omitempty"`
+
+	// Encryption is how the backup is encrypted. The Secret must still hold the key.
+	// +optional
+	Encryption *EncryptionSpec `json:"encryption,omitempty"`
+
+	// EncryptionKeyID identifies the key the backup is encrypted with
+	// +optional
+	EncryptionKeyID string `json:"encryptionKeyID,omitempty"`
+
+	// WALLocation is the WAL archive a physical backup needs for recovery
+	// +optional
+	WALLocation string `json:"walLocation,omitempty"`
+
+	// PostgresVersion is the version of the server that was backed up, e.g. "16.2"
+	// +optional
+	PostgresVersion string `json:"postgresVersion,omitempty"`
+
+	// StartTime is when the backup started
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// EndTime is when the backup finished
+	// +kubebuilder:validation:Required
+	EndTime metav1.Time `json:"endTime"`
+
+	// DatabaseSpecHash is a hash of the Database spec at the time of the backup,
+	// to tell whether the Database was reconfigured since
+	// +optional
+	DatabaseSpecHash string `json:"databaseSpecHash,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Database",type="string",JSONPath=".spec.databaseRef.name"
+// +kubebuilder:printcolumn:name="Method",type="string",JSONPath=".spec.method"
+// +kubebuilder:printcolumn:name="PostgreSQL",type="string",JSONPath=".spec.postgresVersion"
+// +kubebuilder:printcolumn:name="Size",type="integer",JSONPath=".spec.size"
+// +kubebuilder:printcolumn:name="End",type="date",JSONPath=".spec.endTime"
+// +kubebuilder:printcolumn:name="Location",type="string",JSONPath=".spec.location",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// BackupArtifact is the Schema for the backupartifacts API.
+// It is a catalog entry for one stored backup.
+type BackupArtifact struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BackupArtifactSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// BackupArtifactList contains a list of BackupArtifact
+type BackupArtifactList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BackupArtifact `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BackupArtifact{}, &BackupArtifactList{})
+}
@@ -27,6 +27,7 @@ import (
 
 	appsv1 "k8s.io/api/apps/v1"
 	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/conditions"
 )
 
 // Finalizer for cleanup when ClusterDatabase is deleted
@@ -481,6 +482,9 @@ func (r *ClusterDatabaseReconciler) updateStatus(ctx context.Context, db *databa
 	db.Status.TargetNamespace = db.Spec.TargetNamespace
 	db.Status.SecretName = r.secretName(db)
 
+	// The managed resources have been reconciled with this spec generation
+	db.Status.ObservedGeneration = db.Generation
+
 	// Check StatefulSet status
 	statefulSet := &appsv1.StatefulSet{}
 	err := r.Get(ctx, client.ObjectKey{
@@ -492,16 +496,20 @@ func (r *ClusterDatabaseReconciler) updateStatus(ctx context.Context, db *databa
 	if err != nil {
 		db.Status.Phase = "Pending"
 		db.Status.Ready = false
+		conditions.MarkReconciling(db, "StatefulSetNotFound", "Waiting for the StatefulSet to be created")
 	} else {
 		if statefulSet.Status.ReadyReplicas == *statefulSet.Spec.Replicas {
 			db.Status.Phase = "Ready"
 			db.Status.Ready = true
 			db.Status.Endpoint = fmt.Sprintf("%s.%s.svc.cluster.local:5432",
 				db.Name, db.Spec.TargetNamespace)
+			conditions.MarkReady(db, "AllReplicasReady", "All replicas are ready")
 			ready = true
 		} else {
 			db.Status.Phase = "Creating"
 			db.Status.Ready = false
+			conditions.MarkReconciling(db, "ReplicasNotReady",
+				fmt.Sprintf("%d/%d replicas ready", statefulSet.Status.ReadyReplicas, *statefulSet.Spec.Replicas))
 		}
 	}
 
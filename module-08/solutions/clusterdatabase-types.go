@@ -59,6 +59,11 @@ type StorageSpec struct {
 
 // ClusterDatabaseStatus defines the observed state of ClusterDatabase
 type ClusterDatabaseStatus struct {
+	// ObservedGeneration is the spec generation the controller last acted on.
+	// Ready only reflects the current spec when it equals metadata.generation.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	// Phase is the current phase
 	// +kubebuilder:validation:Enum=Pending;Creating;Ready;Failed
 	Phase string `json:"phase,omitempty"`
@@ -99,6 +104,16 @@ type ClusterDatabase struct {
 	Status ClusterDatabaseStatus `json:"status,omitempty"`
 }
 
+// GetConditions returns the status conditions, for the conditions package
+func (db *ClusterDatabase) GetConditions() []metav1.Condition {
+	return db.Status.Conditions
+}
+
+// SetConditions replaces the status conditions, for the conditions package
+func (db *ClusterDatabase) SetConditions(conditions []metav1.Condition) {
+	db.Status.Conditions = conditions
+}
+
 // +kubebuilder:object:root=true
 
 // ClusterDatabaseList contains a list of ClusterDatabase
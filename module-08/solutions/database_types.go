@@ -0,0 +1,345 @@
+// Solution: Database Types from Module 8
+// This extends the Database API from Module 3 with the fields used by the
+// Module 8 backup, restore and coordination solutions.
+// Replace api/v1/database_types.go with this file, then run: make manifests && make install
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RestoreInProgressAnnotation is set on a Database by the Restore controller while a
+// physical (point-in-time) restore replaces its data directory. The value is the
+// Restore's name. The Database controller leaves the StatefulSet alone while it is set.
+const RestoreInProgressAnnotation = "database.example.com/restore-in-progress"
+
+// SwitchoverInProgressAnnotation is set on a Database by the Switchover controller while
+// it hands the primary role to another pod. The value is the Switchover's name. The
+// Database controller neither fails over nor promotes a standby while it is set.
+const SwitchoverInProgressAnnotation = "database.example.com/switchover-in-progress"
+
+// DatabaseSpec defines the desired state of Database
+type DatabaseSpec struct {
+	// Image is the PostgreSQL image to use
+	// +kubebuilder:validation:Required
+	// +kubebuilder:default="postgres:14"
+	Image string `json:"image"`
+
+	// Replicas is the number of database replicas
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	// +kubebuilder:default=1
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Storage is the storage configuration
+	Storage StorageSpec `json:"storage"`
+
+	// Resources are the resource requirements
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// DatabaseName is the name of the database to create
+	// +kubebuilder:validation:Required
+	DatabaseName string `json:"databaseName"`
+
+	// Username is the database user
+	// +kubebuilder:validation:Required
+	Username string `json:"username"`
+
+	// BackupRef references a Backup the Database depends on (see operator-coordination.go)
+	// +optional
+	BackupRef *corev1.LocalObjectReference `json:"backupRef,omitempty"`
+
+	// WALArchiving continuously archives WAL to backup storage.
+	// Together with physical Backups it enables point-in-time recovery.
+	// +optional
+	WALArchiving *WALArchivingSpec `json:"walArchiving,omitempty"`
+
+	// MaxRetries is how often a Failed Database starts over, with exponential backoff,
+	// before it stays Failed. Changing the spec starts over with a fresh count.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=5
+	// +optional
+	MaxRetries *int32 `json:"maxRetries,omitempty"`
+
+	// Failover configures the promotion of a standby when the primary fails
+	// +optional
+	Failover *FailoverSpec `json:"failover,omitempty"`
+
+	// MajorUpgrade configures upgrades to a new PostgreSQL major version, which start
+	// when spec.image changes to one
+	// +optional
+	MajorUpgrade *MajorUpgradeSpec `json:"majorUpgrade,omitempty"`
+}
+
+// StorageSpec defines storage configuration
+type StorageSpec struct {
+	// Size is the storage size (e.g., "10Gi")
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[0-9]+(Gi|Mi)$`
+	Size string `json:"size"`
+
+	// StorageClass is the storage class to use
+	StorageClass string `json:"storageClass,omitempty"`
+}
+
+// WALArchivingSpec defines continuous WAL archiving
+type WALArchivingSpec struct {
+	// StorageLocation is where WAL segments are archived, using the same schemes as
+	// BackupSpec.StorageLocation. Segments go to <location>/<namespace>/<database>/wal/.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^(file|pvc|s3)://`
+	StorageLocation string `json:"storageLocation"`
+
+	// StorageSecretRef references a Secret with credentials for StorageLocation
+	// +optional
+	StorageSecretRef *corev1.LocalObjectReference `json:"storageSecretRef,omitempty"`
+
+	// ArchiveTimeoutSeconds forces a WAL segment switch after this many seconds,
+	// which bounds how much data a point-in-time restore can lose (RPO)
+	// +kubebuilder:validation:Minimum=10
+	// +kubebuilder:default=60
+	// +optional
+	ArchiveTimeoutSeconds int32 `json:"archiveTimeoutSeconds,omitempty"`
+}
+
+// FailoverSpec defines automatic failover
+type FailoverSpec struct {
+	// Disabled turns automatic failover off, e.g. while the primary's node is maintained
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// DelaySeconds is how long the primary may be not ready before the most
+	// up-to-date standby is promoted
+	// +kubebuilder:validation:Minimum=5
+	// +kubebuilder:default=30
+	// +optional
+	DelaySeconds int32 `json:"delaySeconds,omitempty"`
+}
+
+// MajorUpgradeSpec defines how a Database is upgraded to a new major version
+// +kubebuilder:validation:XValidation:rule="(has(self.skipBackup) && self.skipBackup) || has(self.storageLocation)",message="storageLocation is required unless skipBackup is set"
+type MajorUpgradeSpec struct {
+	// SkipBackup upgrades without a Backup first. The old data directory is
+	// kept for a rollback either way.
+	// +optional
+	SkipBackup bool `json:"skipBackup,omitempty"`
+
+	// StorageLocation is where the Backup taken before the upgrade is stored, see
+	// BackupSpec.StorageLocation. Required unless SkipBackup is set.
+	// +kubebuilder:validation:Pattern=`^(pvc|s3)://`
+	// +optional
+	StorageLocation string `json:"storageLocation,omitempty"`
+
+	// StorageSecretRef references a Secret with credentials for StorageLocation
+	// +optional
+	StorageSecretRef *corev1.LocalObjectReference `json:"storageSecretRef,omitempty"`
+
+	// TimeoutSeconds is how long pg_upgrade may run, and how long the upgraded primary
+	// may take to become ready before the upgrade is rolled back
+	// +kubebuilder:validation:Minimum=60
+	// +kubebuilder:default=3600
+	// +optional
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// DatabaseStatus defines the observed state of Database
+type DatabaseStatus struct {
+	// ObservedGeneration is the spec generation the controller last acted on.
+	// Ready only reflects the current spec when it equals metadata.generation.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Phase is the current phase.
+	// Provisioning to Verifying are the phases of the Module 4 state machine.
+	// +kubebuilder:validation:Enum=Pending;Creating;Provisioning;Configuring;Deploying;Verifying;Ready;Failed
+	Phase string `json:"phase,omitempty"`
+
+	// PhaseTransitionTime is when the Database entered its current phase
+	// +optional
+	PhaseTransitionTime *metav1.Time `json:"phaseTransitionTime,omitempty"`
+
+	// Ready indicates if the database is ready
+	Ready bool `json:"ready,omitempty"`
+
+	// Endpoint is the database endpoint
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// SecretName is the name of the Secret containing database credentials
+	SecretName string `json:"secretName,omitempty"`
+
+	// Primary is the pod running the primary server, the other pods are hot standbys
+	// +optional
+	Primary string `json:"primary,omitempty"`
+
+	// ReadOnlyEndpoint is the endpoint of the standbys (the <name>-ro Service)
+	// +optional
+	ReadOnlyEndpoint string `json:"readOnlyEndpoint,omitempty"`
+
+	// Replicas is the replication state of each standby
+	// +optional
+	Replicas []ReplicaStatus `json:"replicas,omitempty"`
+
+	// Failovers are the most recent promotions of a standby, oldest first
+	// +optional
+	Failovers []FailoverEvent `json:"failovers,omitempty"`
+
+	// MajorUpgrade is the latest major version upgrade
+	// +optional
+	MajorUpgrade *MajorUpgradeStatus `json:"majorUpgrade,omitempty"`
+
+	// Rollout is the latest rollout of a changed pod template, e.g. a new minor version
+	// +optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+
+	// FailureCount is the number of failures of the spec generation in FailureGeneration
+	FailureCount int32 `json:"failureCount,omitempty"`
+
+	// FailureGeneration is the spec generation FailureCount counts the failures of
+	FailureGeneration int64 `json:"failureGeneration,omitempty"`
+
+	// LastFailureReason is the reason of the last failure
+	LastFailureReason string `json:"lastFailureReason,omitempty"`
+
+	// NextRetryTime is when a Failed Database starts over.
+	// Unset once MaxRetries are used up.
+	// +optional
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+
+	// Conditions represent the latest observations
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ReplicaStatus is the replication state of a standby, as seen by the primary
+type ReplicaStatus struct {
+	// Name is the standby's pod
+	Name string `json:"name"`
+
+	// State is the WAL sender state on the primary (e.g. streaming or catchup),
+	// or Disconnected if the standby doesn't replicate
+	State string `json:"state"`
+
+	// LagBytes is how much WAL the standby has yet to replay
+	LagBytes int64 `json:"lagBytes"`
+}
+
+// FailoverEvent records the promotion of a standby to primary
+type FailoverEvent struct {
+	// Time is when the standby was chosen
+	Time metav1.Time `json:"time"`
+
+	// From is the former primary, To the promoted standby
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Reason is why the primary was replaced, e.g. PrimaryNotReady or Switchover
+	Reason string `json:"reason"`
+
+	// LSN is the WAL position the promoted standby had received
+	// +optional
+	LSN string `json:"lsn,omitempty"`
+}
+
+// MajorUpgradeStatus records the progress of a major version upgrade
+type MajorUpgradeStatus struct {
+	// FromImage and ToImage are the images before and after the upgrade
+	FromImage string `json:"fromImage"`
+	ToImage   string `json:"toImage"`
+
+	// Phase is the current upgrade phase. A Failed upgrade has been rolled back to
+	// FromImage and is only retried with the database.example.com/retry annotation.
+	// +kubebuilder:validation:Enum=BackingUp;Stopping;Upgrading;Starting;RollingBack;Completed;Failed
+	Phase string `json:"phase"`
+
+	// Message describes the phase, e.g. why the upgrade failed
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// BackupName is the Backup taken before the upgrade
+	// +optional
+	BackupName string `json:"backupName,omitempty"`
+
+	// StartTime is when the upgrade started, PhaseTime when it entered its current phase
+	StartTime metav1.Time  `json:"startTime"`
+	PhaseTime *metav1.Time `json:"phaseTime,omitempty"`
+
+	// CompletionTime is when the upgraded primary became ready
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// RolloutStatus records the progress of a rollout of the StatefulSet's pod template.
+// The standbys are updated first, then a Switchover moves the primary to an updated
+// standby and the former primary is updated last.
+type RolloutStatus struct {
+	// Revision is the StatefulSet revision being rolled out
+	Revision string `json:"revision"`
+
+	// Image is the PostgreSQL image of the revision
+	Image string `json:"image"`
+
+	// Phase is the current rollout phase
+	// +kubebuilder:validation:Enum=UpdatingStandbys;SwitchingOver;UpdatingPrimary;Completed
+	Phase string `json:"phase"`
+
+	// Partition is the StatefulSet partition: only pods with this ordinal or higher
+	// are updated
+	Partition int32 `json:"partition"`
+
+	// UpdatedReplicas is the number of pods that run the revision and are ready
+	UpdatedReplicas int32 `json:"updatedReplicas"`
+
+	// Switchover is the Switchover that moves the primary to an updated pod
+	// +optional
+	Switchover string `json:"switchover,omitempty"`
+
+	// Message describes what the rollout waits for
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// StartTime is when the rollout started, CompletionTime when all pods were updated
+	StartTime      metav1.Time  `json:"startTime"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Replicas",type="integer",JSONPath=".spec.replicas"
+// +kubebuilder:printcolumn:name="Ready",type="boolean",JSONPath=".status.ready"
+// +kubebuilder:printcolumn:name="Primary",type="string",JSONPath=".status.primary",priority=1
+// +kubebuilder:printcolumn:name="Failures",type="integer",JSONPath=".status.failureCount",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Database is the Schema for the databases API
+type Database struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatabaseSpec   `json:"spec,omitempty"`
+	Status DatabaseStatus `json:"status,omitempty"`
+}
+
+// GetConditions returns the status conditions, for the conditions package
+func (db *Database) GetConditions() []metav1.Condition {
+	return db.Status.Conditions
+}
+
+// SetConditions replaces the status conditions, for the conditions package
+func (db *Database) SetConditions(conditions []metav1.Condition) {
+	db.Status.Conditions = conditions
+}
+
+// +kubebuilder:object:root=true
+
+// DatabaseList contains a list of Database
+type DatabaseList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Database `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Database{}, &DatabaseList{})
+}
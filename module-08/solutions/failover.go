@@ -0,0 +1,286 @@
+// Solution: Automated Failover from Module 8
+// This promotes a standby when the primary of a Database fails
+//
+// These are additions to the DatabaseReconciler, on top of streaming replication
+// (replication.go), which calls reconcileFailover before it reconciles the Services:
+// 1. The primary pod has been not ready for longer than spec.failover.delaySeconds (default 30)
+// 2. The instance sidecar of every ready standby reports the WAL position it received,
+//    and the most up-to-date standby is elected
+// 3. status.failovers records the failover and the <name>-replication ConfigMap names the
+//    new primary. From here on the failover completes even if the operator restarts.
+// 4. The old primary, still labelled database.example.com/role=primary, is fenced: the
+//    role=fenced label keeps it out of the read-only Service, and it is deleted, so it
+//    can't come back as a second primary. Its init-instance container rewinds it into
+//    a standby of the new primary.
+// 5. Only once the old primary is fenced, the new primary is promoted through its instance
+//    sidecar, and the selector of the read-write Service built in buildService is
+//    repointed at it (reconcileReadWriteService)
+//
+// The other standbys follow the new primary through the read-write Service. Failover is
+// skipped with spec.failover.disabled, during physical restores, which stop all pods, and
+// during switchovers, which replace the primary on purpose.
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/instance"
+)
+
+// +kubebuilder:rbac:groups="",resources=pods,verbs=delete
+
+const (
+	// roleFenced marks a former primary until it is gone
+	roleFenced = "fenced"
+
+	// defaultFailoverDelay is how long the primary may be not ready without spec.failover
+	defaultFailoverDelay = 30 * time.Second
+
+	// failoverRetryInterval is how soon a failover that can't proceed yet is retried
+	failoverRetryInterval = 10 * time.Second
+
+	// maxFailoverHistory is how many failovers status.failovers keeps
+	maxFailoverHistory = 10
+)
+
+func failoverDelay(db *databasev1.Database) time.Duration {
+	if db.Spec.Failover != nil && db.Spec.Failover.DelaySeconds > 0 {
+		return time.Duration(db.Spec.Failover.DelaySeconds) * time.Second
+	}
+	return defaultFailoverDelay
+}
+
+// podReady reports whether pod is ready and since when it is (not) ready.
+// A terminating pod isn't ready.
+func podReady(pod *corev1.Pod) (bool, time.Time) {
+	ready, since := false, pod.CreationTimestamp.Time
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			ready, since = c.Status == corev1.ConditionTrue, c.LastTransitionTime.Time
+		}
+	}
+	if pod.DeletionTimestamp != nil && ready {
+		return false, pod.DeletionTimestamp.Time
+	}
+	return ready, since
+}
+
+func findPod(pods []corev1.Pod, name string) *corev1.Pod {
+	for i := range pods {
+		if pods[i].Name == name {
+			return &pods[i]
+		}
+	}
+	return nil
+}
+
+// reconcileFailover replaces a primary that has been not ready for too long with the most
+// up-to-date standby, and promotes the primary if it is still a standby. It returns the
+// primary and, while a failover is pending, how soon to check again.
+func (r *DatabaseReconciler) reconcileFailover(ctx context.Context, db *databasev1.Database, primary string, pods []corev1.Pod) (string, time.Duration, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	// A switchover promotes the new primary itself, once the old one has shut down
+	// (see switchover-controller.go)
+	if _, ok := db.Annotations[databasev1.SwitchoverInProgressAnnotation]; ok {
+		return primary, 0, nil
+	}
+
+	token, err := instanceToken(ctx, r.Client, db)
+	if err != nil {
+		return primary, 0, err
+	}
+
+	current := findPod(pods, primary)
+	if current != nil {
+		if ready, _ := podReady(current); ready {
+			// A failover that failed to fence the old primary finishes here
+			if err := r.fenceFormerPrimaries(ctx, pods, primary); err != nil {
+				return primary, 0, err
+			}
+			return primary, 0, r.promote(ctx, current, token)
+		}
+	}
+
+	if len(pods) < 2 || (db.Spec.Failover != nil && db.Spec.Failover.Disabled) {
+		return primary, 0, nil
+	}
+	// A physical restore stops all pods on purpose
+	if _, ok := db.Annotations[databasev1.RestoreInProgressAnnotation]; ok {
+		return primary, 0, nil
+	}
+	if current == nil {
+		// The StatefulSet recreates the pod, whose Ready condition starts the clock
+		return primary, failoverRetryInterval, nil
+	}
+
+	_, since := podReady(current)
+	if wait := time.Until(since.Add(failoverDelay(db))); wait > 0 {
+		log.Info("Primary not ready, waiting before failover", "primary", primary, "since", since, "wait", wait)
+		return primary, wait, nil
+	}
+
+	candidate, lsn := r.electPrimary(ctx, db, pods, primary, token)
+	if candidate == nil {
+		log.Info("Primary not ready, but no standby can be promoted", "primary", primary, "since", since)
+		return primary, failoverRetryInterval, nil
+	}
+
+	log.Info("Primary not ready, failing over", "primary", primary, "since", since, "newPrimary", candidate.Name, "lsn", lsn)
+	if err := setPrimary(ctx, r.Client, db, primary, candidate.Name, "PrimaryNotReady", lsn); err != nil {
+		return primary, 0, err
+	}
+	// Never run two primaries: promote only once the old one is fenced
+	if err := r.fencePod(ctx, current); err != nil {
+		return candidate.Name, 0, err
+	}
+	return candidate.Name, 0, r.promote(ctx, candidate, token)
+}
+
+// electPrimary returns the ready standby that has received the most WAL, and its position.
+// Pods a scale-down removes are skipped.
+func (r *DatabaseReconciler) electPrimary(ctx context.Context, db *databasev1.Database, pods []corev1.Pod, primary, token string) (*corev1.Pod, string) {
+	log := ctrl.LoggerFrom(ctx)
+
+	var best *corev1.Pod
+	var bestLSN uint64
+	var bestPosition string
+	for i := range pods {
+		pod := &pods[i]
+		if ready, _ := podReady(pod); !ready || pod.Name == primary || pod.Status.PodIP == "" || !isDatabasePod(db, pod.Name) {
+			continue
+		}
+		status, err := instance.GetStatus(ctx, pod.Status.PodIP, token)
+		if err != nil {
+			log.Info("Skipping standby without replication status", "pod", pod.Name, "error", err.Error())
+			continue
+		}
+		if status.Primary {
+			log.Info("Skipping pod that isn't a standby", "pod", pod.Name)
+			continue
+		}
+
+		// Received WAL is replayed before the promotion completes. A standby that
+		// hasn't streamed since it started only has its replayed position.
+		position, lsn := status.LSN, uint64(0)
+		for _, p := range []string{status.LSN, status.ReceivedLSN} {
+			if p == "" {
+				continue
+			}
+			n, err := instance.ParseLSN(p)
+			if err != nil {
+				log.Info("Skipping standby with invalid WAL position", "pod", pod.Name, "error", err.Error())
+				continue
+			}
+			if n >= lsn {
+				position, lsn = p, n
+			}
+		}
+
+		if best == nil || lsn > bestLSN {
+			best, bestLSN, bestPosition = pod, lsn, position
+		}
+	}
+	return best, bestPosition
+}
+
+// setPrimary records a new primary in the status and names it in the replication
+// ConfigMap, which pods started from then on take their role from. The status goes
+// first, so a conflict leaves everything as it was and the failover is retried.
+// The Switchover controller uses it too.
+func setPrimary(ctx context.Context, c client.Client, db *databasev1.Database, from, to, reason, lsn string) error {
+	// Re-read the Database so the status update doesn't conflict with earlier writes
+	latest := &databasev1.Database{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(db), latest); err != nil {
+		return err
+	}
+	latest.Status.Primary = to
+	latest.Status.Failovers = append(latest.Status.Failovers, databasev1.FailoverEvent{
+		Time:   metav1.Now(),
+		From:   from,
+		To:     to,
+		Reason: reason,
+		LSN:    lsn,
+	})
+	if n := len(latest.Status.Failovers); n > maxFailoverHistory {
+		latest.Status.Failovers = latest.Status.Failovers[n-maxFailoverHistory:]
+	}
+	if err := c.Status().Update(ctx, latest); err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(ctx, client.ObjectKey{Name: replicationConfigMapName(db), Namespace: db.Namespace}, cm); err != nil {
+		return err
+	}
+	patch := client.MergeFrom(cm.DeepCopy())
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[primaryKey] = to
+	return c.Patch(ctx, cm, patch)
+}
+
+// fencePod takes a former primary out of service and deletes it. The StatefulSet
+// recreates it as a standby; a pod on a lost node stays fenced until it is gone.
+func (r *DatabaseReconciler) fencePod(ctx context.Context, pod *corev1.Pod) error {
+	if err := r.labelPod(ctx, pod, roleFenced); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if pod.DeletionTimestamp != nil {
+		return nil
+	}
+
+	ctrl.LoggerFrom(ctx).Info("Deleting fenced primary", "pod", pod.Name)
+	if err := r.Delete(ctx, pod); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// fenceFormerPrimaries fences every pod other than primary that is still labelled as
+// a primary or fenced
+func (r *DatabaseReconciler) fenceFormerPrimaries(ctx context.Context, pods []corev1.Pod, primary string) error {
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Name == primary {
+			continue
+		}
+		if role := pod.Labels[roleLabel]; role == rolePrimary || role == roleFenced {
+			if err := r.fencePod(ctx, pod); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// promote promotes the primary pod if it is still a standby, which finishes a failover.
+// Unreachable pods are retried on the next reconcile.
+func (r *DatabaseReconciler) promote(ctx context.Context, pod *corev1.Pod, token string) error {
+	log := ctrl.LoggerFrom(ctx)
+	if pod.Status.PodIP == "" {
+		return nil
+	}
+
+	status, err := instance.GetStatus(ctx, pod.Status.PodIP, token)
+	if err != nil {
+		log.Info("Failed to get replication status", "pod", pod.Name, "error", err.Error())
+		return nil
+	}
+	if status.Primary {
+		return nil
+	}
+
+	log.Info("Promoting standby to primary", "pod", pod.Name)
+	return instance.Promote(ctx, pod.Status.PodIP, token)
+}
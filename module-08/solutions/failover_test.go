@@ -0,0 +1,383 @@
+// Solution: Failover Tests from Module 8
+// This tests the election of the new primary and the order of a failover, against a
+// fake client and a fake instance API, no cluster needed
+// Location: internal/controller/failover_test.go
+//
+// The specs run in the controller suite kubebuilder scaffolded (internal/controller/suite_test.go).
+// The helpers here are shared with switchover_controller_test.go.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/agent"
+	"github.com/example/postgres-operator/internal/instance"
+)
+
+// testAPIToken is the instance API token in the credentials Secret of the test Databases
+const testAPIToken = "test-token"
+
+// fakeInstances serves the instance API of every pod. It records the promotions,
+// and the fake client records the deletions, so specs can check their order.
+type fakeInstances struct {
+	mu     sync.Mutex
+	status map[string]*instance.Status // by pod name, pods without one are unreachable
+	pods   map[string]string           // pod names by IP
+	events []string
+}
+
+// newFakeInstances sends the instance API requests of the spec to a fake. The instance
+// package calls the pods through http.DefaultTransport, which is swapped for one that
+// dials the fake whatever the pod IP; the Host header still names the pod.
+func newFakeInstances() *fakeInstances {
+	f := &fakeInstances{status: map[string]*instance.Status{}, pods: map[string]string{}}
+	server := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	DeferCleanup(server.Close)
+
+	transport := http.DefaultTransport
+	DeferCleanup(func() { http.DefaultTransport = transport })
+	http.DefaultTransport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		},
+	}
+	return f
+}
+
+func (f *fakeInstances) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	host, _, _ := net.SplitHostPort(r.Host)
+	status := f.status[f.pods[host]]
+	switch {
+	case r.Header.Get("Authorization") != "Bearer "+testAPIToken:
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	case status == nil:
+		http.Error(w, "unreachable", http.StatusServiceUnavailable)
+	case r.Method == http.MethodGet && r.URL.Path == "/status":
+		_ = json.NewEncoder(w).Encode(status)
+	case r.Method == http.MethodPost && r.URL.Path == "/promote":
+		f.events = append(f.events, "promote "+f.pods[host])
+		status.Primary = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// set makes pod report status, nil makes it unreachable
+func (f *fakeInstances) set(pod *corev1.Pod, status *instance.Status) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pods[pod.Status.PodIP] = pod.Name
+	f.status[pod.Name] = status
+}
+
+func (f *fakeInstances) record(event string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = append(f.events, event)
+}
+
+func (f *fakeInstances) recorded() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.events...)
+}
+
+// newTestClient returns a fake client holding objs that records pod deletions in instances
+func newTestClient(instances *fakeInstances, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(databasev1.AddToScheme(scheme)).To(Succeed())
+
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&databasev1.Database{}, &databasev1.Switchover{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+				if _, ok := obj.(*corev1.Pod); ok {
+					instances.record("delete " + obj.GetName())
+				}
+				return c.Delete(ctx, obj, opts...)
+			},
+		}).
+		Build()
+}
+
+// newTestDatabase returns a Database with replicas pods, its credentials Secret and its
+// replication ConfigMap naming primary
+func newTestDatabase(replicas int32, primary string) (*databasev1.Database, []client.Object) {
+	db := &databasev1.Database{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "default", Generation: 1},
+		Spec:       databasev1.DatabaseSpec{Replicas: ptr.To(replicas)},
+	}
+	return db, []client.Object{
+		db,
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: agent.CredentialsSecretName(db), Namespace: db.Namespace},
+			Data:       map[string][]byte{apiTokenKey: []byte(testAPIToken)},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: replicationConfigMapName(db), Namespace: db.Namespace},
+			Data:       map[string]string{primaryKey: primary},
+		},
+	}
+}
+
+// newTestPod returns the pod of db with the given ordinal and role, ready or not since since
+func newTestPod(db *databasev1.Database, ordinal int32, role string, ready bool, since time.Time) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podName(db, ordinal),
+			Namespace: db.Namespace,
+			UID:       types.UID(fmt.Sprintf("uid-%d", ordinal)),
+			Labels:    map[string]string{roleLabel: role},
+		},
+		Status: corev1.PodStatus{
+			PodIP: fmt.Sprintf("10.0.0.%d", ordinal+1),
+			Conditions: []corev1.PodCondition{{
+				Type:               corev1.PodReady,
+				Status:             status,
+				LastTransitionTime: metav1.NewTime(since),
+			}},
+		},
+	}
+}
+
+var _ = Describe("Failover", func() {
+	var (
+		ctx       context.Context
+		instances *fakeInstances
+		db        *databasev1.Database
+		objs      []client.Object
+		pods      []*corev1.Pod
+	)
+
+	// podList returns the pods as reconcileFailover gets them from databasePods
+	podList := func() []corev1.Pod {
+		var list []corev1.Pod
+		for _, pod := range pods {
+			list = append(list, *pod)
+		}
+		return list
+	}
+
+	newReconciler := func() *DatabaseReconciler {
+		all := objs
+		for _, pod := range pods {
+			all = append(all, pod)
+		}
+		c := newTestClient(instances, all...)
+		return &DatabaseReconciler{Client: c, Scheme: c.Scheme()}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		instances = newFakeInstances()
+		db, objs = newTestDatabase(3, "orders-0")
+
+		// The primary has been not ready for a minute, longer than the default delay
+		lost := time.Now().Add(-time.Minute)
+		pods = []*corev1.Pod{
+			newTestPod(db, 0, rolePrimary, false, lost),
+			newTestPod(db, 1, roleReplica, true, lost),
+			newTestPod(db, 2, roleReplica, true, lost),
+		}
+	})
+
+	Describe("electPrimary", func() {
+		DescribeTable("should elect the standby that has received the most WAL",
+			func(standby1, standby2 *instance.Status, want, wantLSN string) {
+				instances.set(pods[1], standby1)
+				instances.set(pods[2], standby2)
+
+				pod, lsn := newReconciler().electPrimary(ctx, db, podList(), "orders-0", testAPIToken)
+				if want == "" {
+					Expect(pod).To(BeNil())
+					return
+				}
+				Expect(pod).NotTo(BeNil())
+				Expect(pod.Name).To(Equal(want))
+				Expect(lsn).To(Equal(wantLSN))
+			},
+			Entry("by received WAL, not replayed WAL",
+				&instance.Status{LSN: "0/3000000", ReceivedLSN: "0/5000000"},
+				&instance.Status{LSN: "0/4000000", ReceivedLSN: "0/4000000"},
+				"orders-1", "0/5000000"),
+			Entry("comparing the whole position, not the text",
+				&instance.Status{LSN: "0/F000000"},
+				&instance.Status{LSN: "1/1000000"},
+				"orders-2", "1/1000000"),
+			Entry("by replayed WAL of a standby that hasn't streamed yet",
+				&instance.Status{LSN: "0/6000000"},
+				&instance.Status{LSN: "0/4000000", ReceivedLSN: "0/5000000"},
+				"orders-1", "0/6000000"),
+			Entry("the lowest ordinal on a tie",
+				&instance.Status{LSN: "0/5000000"},
+				&instance.Status{LSN: "0/4000000", ReceivedLSN: "0/5000000"},
+				"orders-1", "0/5000000"),
+			Entry("by the valid position of a standby with an unparseable one",
+				&instance.Status{LSN: "0/6000000", ReceivedLSN: "garbage"},
+				&instance.Status{LSN: "0/5000000"},
+				"orders-1", "0/6000000"),
+			Entry("ignoring unparseable positions",
+				&instance.Status{LSN: "0/1000000", ReceivedLSN: "F/garbage"},
+				&instance.Status{LSN: "0/2000000"},
+				"orders-2", "0/2000000"),
+			Entry("skipping pods that report they are a primary",
+				&instance.Status{Primary: true, LSN: "0/9000000"},
+				&instance.Status{LSN: "0/1000000"},
+				"orders-2", "0/1000000"),
+			Entry("skipping unreachable standbys",
+				nil,
+				&instance.Status{LSN: "0/1000000"},
+				"orders-2", "0/1000000"),
+			Entry("and none without an eligible standby",
+				&instance.Status{Primary: true, LSN: "0/9000000"},
+				nil,
+				"", ""),
+		)
+
+		It("should skip standbys that aren't ready", func() {
+			pods[1] = newTestPod(db, 1, roleReplica, false, time.Now())
+			instances.set(pods[1], &instance.Status{LSN: "0/9000000"})
+			instances.set(pods[2], &instance.Status{LSN: "0/1000000"})
+
+			pod, _ := newReconciler().electPrimary(ctx, db, podList(), "orders-0", testAPIToken)
+			Expect(pod).NotTo(BeNil())
+			Expect(pod.Name).To(Equal("orders-2"))
+		})
+
+		It("should skip pods a scale-down removes", func() {
+			// orders-2 is kept until the scale-down to 2 replicas is handed over
+			db.Spec.Replicas = ptr.To(int32(2))
+			instances.set(pods[1], &instance.Status{LSN: "0/1000000"})
+			instances.set(pods[2], &instance.Status{LSN: "0/9000000"})
+
+			pod, _ := newReconciler().electPrimary(ctx, db, podList(), "orders-0", testAPIToken)
+			Expect(pod).NotTo(BeNil())
+			Expect(pod.Name).To(Equal("orders-1"))
+		})
+	})
+
+	Describe("reconcileFailover", func() {
+		// configuredPrimary returns the primary the replication ConfigMap names
+		configuredPrimary := func(r *DatabaseReconciler) string {
+			primary, err := currentPrimary(ctx, r.Client, db)
+			Expect(err).NotTo(HaveOccurred())
+			return primary
+		}
+
+		It("should fence the old primary before it promotes the new one", func() {
+			instances.set(pods[0], nil)
+			instances.set(pods[1], &instance.Status{LSN: "0/3000000"})
+			instances.set(pods[2], &instance.Status{LSN: "0/3000000", ReceivedLSN: "0/4000000"})
+			r := newReconciler()
+
+			primary, wait, err := r.reconcileFailover(ctx, db, "orders-0", podList())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(primary).To(Equal("orders-2"))
+			Expect(wait).To(BeZero())
+			Expect(instances.recorded()).To(Equal([]string{"delete orders-0", "promote orders-2"}))
+
+			Expect(configuredPrimary(r)).To(Equal("orders-2"))
+			latest := &databasev1.Database{}
+			Expect(r.Get(ctx, client.ObjectKeyFromObject(db), latest)).To(Succeed())
+			Expect(latest.Status.Primary).To(Equal("orders-2"))
+			Expect(latest.Status.Failovers).To(HaveLen(1))
+			Expect(latest.Status.Failovers[0].From).To(Equal("orders-0"))
+			Expect(latest.Status.Failovers[0].Reason).To(Equal("PrimaryNotReady"))
+			Expect(latest.Status.Failovers[0].LSN).To(Equal("0/4000000"))
+			err = r.Get(ctx, client.ObjectKeyFromObject(pods[0]), &corev1.Pod{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("should wait for the failover delay", func() {
+			pods[0] = newTestPod(db, 0, rolePrimary, false, time.Now().Add(-10*time.Second))
+			instances.set(pods[1], &instance.Status{LSN: "0/3000000"})
+			r := newReconciler()
+
+			primary, wait, err := r.reconcileFailover(ctx, db, "orders-0", podList())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(primary).To(Equal("orders-0"))
+			Expect(wait).To(BeNumerically("~", 20*time.Second, time.Second))
+			Expect(instances.recorded()).To(BeEmpty())
+		})
+
+		It("should keep the primary without an eligible standby", func() {
+			instances.set(pods[1], &instance.Status{Primary: true, LSN: "0/3000000"})
+			instances.set(pods[2], nil)
+			r := newReconciler()
+
+			primary, wait, err := r.reconcileFailover(ctx, db, "orders-0", podList())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(primary).To(Equal("orders-0"))
+			Expect(wait).To(Equal(failoverRetryInterval))
+			Expect(instances.recorded()).To(BeEmpty())
+			Expect(configuredPrimary(r)).To(Equal("orders-0"))
+		})
+
+		It("should leave the primary to a switchover", func() {
+			db.Annotations = map[string]string{databasev1.SwitchoverInProgressAnnotation: "maintenance"}
+			instances.set(pods[1], &instance.Status{LSN: "0/3000000"})
+			r := newReconciler()
+
+			primary, _, err := r.reconcileFailover(ctx, db, "orders-0", podList())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(primary).To(Equal("orders-0"))
+			Expect(instances.recorded()).To(BeEmpty())
+		})
+
+		It("should not fail over with spec.failover.disabled", func() {
+			db.Spec.Failover = &databasev1.FailoverSpec{Disabled: true}
+			instances.set(pods[1], &instance.Status{LSN: "0/3000000"})
+			r := newReconciler()
+
+			primary, _, err := r.reconcileFailover(ctx, db, "orders-0", podList())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(primary).To(Equal("orders-0"))
+			Expect(instances.recorded()).To(BeEmpty())
+		})
+
+		It("should finish a failover that didn't get to fence the old primary", func() {
+			// orders-1 was named the primary, but orders-0 is still labelled as one
+			pods[1].Labels[roleLabel] = rolePrimary
+			instances.set(pods[1], &instance.Status{LSN: "0/3000000"})
+			r := newReconciler()
+
+			primary, _, err := r.reconcileFailover(ctx, db, "orders-1", podList())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(primary).To(Equal("orders-1"))
+			Expect(instances.recorded()).To(Equal([]string{"delete orders-0", "promote orders-1"}))
+		})
+	})
+})
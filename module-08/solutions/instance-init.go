@@ -0,0 +1,220 @@
+// Solution: PostgreSQL Instance Initialization from Module 8
+// This prepares the data directory of a Database pod for its replication role
+// Location: internal/instance/init.go
+//
+// Init runs as backup-agent init-instance in an init container of every Database pod,
+// so it runs again whenever a pod starts. The PRIMARY variable comes from the
+// <name>-replication ConfigMap (see replication.go) and decides the pod's role:
+// - The primary keeps its data directory; the image's entrypoint initializes it if it's empty
+// - A standby without data clones the primary with pg_basebackup
+// - A standby that ran as a primary before (it has no standby.signal) is rewound onto
+//   the primary's timeline with pg_rewind, or cloned again if that fails
+// - A standby of another major version than the image is cloned again, e.g. after the
+//   primary was upgraded or rolled back (see instance-upgrade.go)
+// - Every standby gets standby.signal and primary_conninfo pointing at the read-write
+//   Service, so it follows whichever pod is primary
+
+package instance
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	restorePkg "github.com/example/postgres-operator/internal/restore"
+)
+
+// standbySettings are the settings Init writes to postgresql.auto.conf, in order
+var standbySettings = []string{"primary_conninfo", "primary_slot_name", "recovery_target_timeline"}
+
+// Init prepares the data directory in cfg.PGData for the role of cfg.PodName
+func Init(ctx context.Context, cfg Config) error {
+	signal := filepath.Join(cfg.PGData, "standby.signal")
+
+	if cfg.PodName == cfg.Primary {
+		// A standby chosen as primary while it was down starts as primary
+		if err := os.Remove(signal); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	_, err := os.Stat(filepath.Join(cfg.PGData, "PG_VERSION"))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		fmt.Printf("Cloning %s from %s\n", cfg.PodName, cfg.PrimaryHost)
+		if err := clone(ctx, cfg); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !sameMajor(ctx, cfg.PGData):
+		// The primary was upgraded to another major version (see instance-upgrade.go)
+		fmt.Printf("Cloning %s from %s for the new major version\n", cfg.PodName, cfg.PrimaryHost)
+		if err := clone(ctx, cfg); err != nil {
+			return err
+		}
+	default:
+		if _, err := os.Stat(signal); errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Rewinding former primary %s onto %s\n", cfg.PodName, cfg.PrimaryHost)
+			if err := rewind(ctx, cfg); err != nil {
+				fmt.Printf("Rewind failed, cloning %s from %s instead: %v\n", cfg.PodName, cfg.PrimaryHost, err)
+				if err := clone(ctx, cfg); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return writeStandbyConfig(cfg)
+}
+
+// sameMajor reports whether the data directory is of the server's major version.
+// It assumes it is if either can't be determined.
+func sameMajor(ctx context.Context, pgdata string) bool {
+	binary, err := exec.LookPath("postgres")
+	if err != nil {
+		return true
+	}
+	server, err := serverMajor(ctx, filepath.Dir(binary))
+	if err != nil {
+		return true
+	}
+	data, err := dataMajor(pgdata)
+	return err != nil || data == server
+}
+
+// clone replaces the data directory with a copy of the primary's
+func clone(ctx context.Context, cfg Config) error {
+	if err := os.RemoveAll(cfg.PGData); err != nil {
+		return fmt.Errorf("failed to clear data directory: %v", err)
+	}
+	if err := os.Mkdir(cfg.PGData, 0o700); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+	if err := chownToPostgres(cfg.PGData); err != nil {
+		return err
+	}
+
+	return runAsPostgres(ctx, []string{"PGPASSWORD=" + cfg.ReplicationPassword}, "pg_basebackup",
+		"--pgdata", cfg.PGData, "--host", cfg.PrimaryHost, "--username", cfg.ReplicationUser, "--no-password",
+		"--wal-method", "stream", "--checkpoint", "fast")
+}
+
+// rewind undoes the changes the former primary made after the new primary was promoted.
+// pg_rewind reads the primary's files through SQL functions only superusers may call,
+// and needs wal_log_hints (see replicationArgs).
+func rewind(ctx context.Context, cfg Config) error {
+	source := fmt.Sprintf("host=%s user=%s dbname=postgres", conninfoValue(cfg.PrimaryHost), conninfoValue(cfg.Superuser))
+	return runAsPostgres(ctx, []string{"PGPASSWORD=" + cfg.SuperuserPassword}, "pg_rewind",
+		"--target-pgdata", cfg.PGData, "--source-server", source, "--progress")
+}
+
+// writeStandbyConfig makes the server start as a standby of the read-write Service.
+// recovery_target_timeline=latest lets it follow a newly promoted primary.
+func writeStandbyConfig(cfg Config) error {
+	settings := map[string]string{
+		"primary_conninfo": fmt.Sprintf("host=%s port=5432 user=%s password=%s application_name=%s",
+			conninfoValue(cfg.PrimaryHost), conninfoValue(cfg.ReplicationUser),
+			conninfoValue(cfg.ReplicationPassword), conninfoValue(cfg.PodName)),
+		"primary_slot_name":        SlotName(cfg.PodName),
+		"recovery_target_timeline": "latest",
+	}
+	autoConf := filepath.Join(cfg.PGData, "postgresql.auto.conf")
+	if err := setAutoConf(autoConf, standbySettings, settings); err != nil {
+		return fmt.Errorf("failed to configure standby: %v", err)
+	}
+
+	signal := filepath.Join(cfg.PGData, "standby.signal")
+	if err := os.WriteFile(signal, nil, 0o600); err != nil {
+		return err
+	}
+	for _, p := range []string{autoConf, signal} {
+		if err := chownToPostgres(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setAutoConf replaces the given settings in the postgresql.auto.conf at path
+// and keeps all other lines
+func setAutoConf(path string, names []string, settings map[string]string) error {
+	var lines []string
+	f, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			name, _, _ := strings.Cut(scanner.Text(), "=")
+			if _, ok := settings[strings.TrimSpace(name)]; !ok {
+				lines = append(lines, scanner.Text())
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	var conf strings.Builder
+	for _, line := range lines {
+		conf.WriteString(line + "\n")
+	}
+	for _, name := range names {
+		if value, ok := settings[name]; ok {
+			fmt.Fprintf(&conf, "%s = '%s'\n", name, strings.ReplaceAll(value, "'", "''"))
+		}
+	}
+	return os.WriteFile(path, []byte(conf.String()), 0o600)
+}
+
+// conninfoValue quotes a value of a libpq connection string
+func conninfoValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// runAsPostgres runs a PostgreSQL tool as the postgres user. The init container
+// runs as root, and PostgreSQL refuses a data directory it doesn't own.
+func runAsPostgres(ctx context.Context, env []string, name string, args ...string) error {
+	return runAsPostgresIn(ctx, "", env, name, args...)
+}
+
+// runAsPostgresIn is runAsPostgres in the working directory dir
+func runAsPostgresIn(ctx context.Context, dir string, env []string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if os.Geteuid() == 0 {
+		uid, gid, err := restorePkg.PostgresUser()
+		if err != nil {
+			return err
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}}
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v", name, err)
+	}
+	return nil
+}
+
+func chownToPostgres(path string) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	uid, gid, err := restorePkg.PostgresUser()
+	if err != nil {
+		return err
+	}
+	return os.Lchown(path, uid, gid)
+}
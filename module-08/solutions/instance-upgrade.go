@@ -0,0 +1,258 @@
+// Solution: PostgreSQL Major Version Upgrade from Module 8
+// This upgrades a data directory to a new major version with pg_upgrade
+// Location: internal/instance/upgrade.go
+//
+// The upgrade Job (see major-upgrade.go) runs on the stopped primary's data volume:
+//   backup-agent upgrade-prepare /old   (init container, old image: copies the old binaries)
+//   backup-agent upgrade /old           (new image: pg_upgrade from the old binaries)
+// and a rollback Job, in the old image again:
+//   backup-agent upgrade-rollback
+//
+// pg_upgrade needs the binaries of both versions, but every PostgreSQL image only has
+// its own. The old binaries are copied with their directory layout under /old, so they
+// still find their share and lib directories; they use the shared libraries of the new
+// image, which is why both images should be built on the same distribution.
+//
+// pg_upgrade copies the data into a fresh <pgdata>.upgrade directory and leaves the old
+// cluster untouched. Only then is the old directory renamed to <pgdata>.<old major> and
+// the new one put in its place, so a rollback just renames them back. The old directory
+// stays until the next upgrade.
+
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/example/postgres-operator/internal/postgres"
+)
+
+// binDirFile records where upgrade-prepare put the old binaries
+const binDirFile = "bindir"
+
+// keptDataDir matches the old data directories kept for a rollback, <pgdata>.<major>
+var keptDataDir = regexp.MustCompile(`\.(\d+)$`)
+
+// PrepareUpgrade copies the binaries of the image it runs in below dest
+func PrepareUpgrade(ctx context.Context, dest string) error {
+	binary, err := exec.LookPath("postgres")
+	if err != nil {
+		return err
+	}
+	if binary, err = filepath.EvalSymlinks(binary); err != nil {
+		return err
+	}
+	binDir := filepath.Dir(binary)
+	major, err := serverMajor(ctx, binDir)
+	if err != nil {
+		return err
+	}
+
+	// Debian images keep a version in /usr/lib/postgresql/<major> and its share directory
+	// in /usr/share/postgresql/<major>. Alpine images keep everything in /usr/local.
+	root := filepath.Dir(binDir)
+	dirs := []string{root}
+	share := filepath.Join(filepath.Dir(filepath.Dir(root)), "share", "postgresql", strconv.Itoa(major))
+	if _, err := os.Stat(share); err == nil && !strings.HasPrefix(share, root+"/") {
+		dirs = append(dirs, share)
+	}
+	for _, dir := range dirs {
+		target := filepath.Join(dest, dir)
+		fmt.Printf("Copying %s to %s\n", dir, target)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if out, err := exec.CommandContext(ctx, "cp", "-a", dir, filepath.Dir(target)).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to copy %s: %v: %s", dir, err, out)
+		}
+	}
+	return os.WriteFile(filepath.Join(dest, binDirFile), []byte(filepath.Join(dest, binDir)), 0o644)
+}
+
+// Upgrade upgrades the data directory in cfg.PGData to the major version of the image it
+// runs in, with the old binaries PrepareUpgrade copied below oldRoot. It can be repeated.
+func Upgrade(ctx context.Context, cfg Config, oldRoot string) error {
+	binary, err := exec.LookPath("postgres")
+	if err != nil {
+		return err
+	}
+	if binary, err = filepath.EvalSymlinks(binary); err != nil {
+		return err
+	}
+	newBinDir := filepath.Dir(binary)
+	newMajor, err := serverMajor(ctx, newBinDir)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(oldRoot, binDirFile))
+	if err != nil {
+		return fmt.Errorf("old binaries not prepared: %v", err)
+	}
+	oldBinDir := strings.TrimSpace(string(data))
+	oldMajor, err := serverMajor(ctx, oldBinDir)
+	if err != nil {
+		return err
+	}
+
+	staging := cfg.PGData + ".upgrade"
+	kept := fmt.Sprintf("%s.%d", cfg.PGData, oldMajor)
+
+	current, err := dataMajor(cfg.PGData)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// The last attempt stopped between the two renames
+		if _, statErr := os.Stat(kept); statErr != nil {
+			return fmt.Errorf("data directory %s is missing: %v", cfg.PGData, err)
+		}
+		fmt.Printf("Finishing upgrade of %s\n", cfg.PGData)
+		return os.Rename(staging, cfg.PGData)
+	case err != nil:
+		return err
+	case current == newMajor:
+		fmt.Printf("%s is already at PostgreSQL %d\n", cfg.PGData, newMajor)
+		return nil
+	case current != oldMajor:
+		return fmt.Errorf("%s is at PostgreSQL %d, the old binaries are PostgreSQL %d", cfg.PGData, current, oldMajor)
+	}
+
+	// Clear what earlier attempts and upgrades left behind
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	if err := removeKeptDataDirs(cfg.PGData); err != nil {
+		return err
+	}
+
+	// The new cluster must have the old one's superuser and checksum setting
+	initdb := []string{"--pgdata", staging, "--username", cfg.Superuser}
+	if checksums, err := dataChecksums(ctx, oldBinDir, cfg.PGData); err != nil {
+		return err
+	} else if checksums {
+		initdb = append(initdb, "--data-checksums")
+	}
+	if err := os.Mkdir(staging, 0o700); err != nil {
+		return err
+	}
+	if err := chownToPostgres(staging); err != nil {
+		return err
+	}
+	fmt.Printf("Initializing PostgreSQL %d data directory %s\n", newMajor, staging)
+	if err := runAsPostgres(ctx, nil, filepath.Join(newBinDir, "initdb"), initdb...); err != nil {
+		return err
+	}
+
+	// pg_upgrade writes its logs and sockets into the working directory
+	work, err := os.MkdirTemp("", "pg_upgrade")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	if err := chownToPostgres(work); err != nil {
+		return err
+	}
+	fmt.Printf("Upgrading %s from PostgreSQL %d to %d\n", cfg.PGData, oldMajor, newMajor)
+	if err := runAsPostgresIn(ctx, work, nil, filepath.Join(newBinDir, "pg_upgrade"),
+		"--old-datadir", cfg.PGData, "--new-datadir", staging,
+		"--old-bindir", oldBinDir, "--new-bindir", newBinDir,
+		"--username", cfg.Superuser); err != nil {
+		return err
+	}
+
+	if err := os.Rename(cfg.PGData, kept); err != nil {
+		return err
+	}
+	return os.Rename(staging, cfg.PGData)
+}
+
+// RollbackUpgrade puts back the data directory of the major version of the image it
+// runs in, which Upgrade kept. The upgraded data directory is removed.
+func RollbackUpgrade(ctx context.Context, cfg Config) error {
+	binary, err := exec.LookPath("postgres")
+	if err != nil {
+		return err
+	}
+	major, err := serverMajor(ctx, filepath.Dir(binary))
+	if err != nil {
+		return err
+	}
+	kept := fmt.Sprintf("%s.%d", cfg.PGData, major)
+
+	if current, err := dataMajor(cfg.PGData); err == nil && current == major {
+		// The upgrade never replaced the data directory
+		fmt.Printf("%s is still at PostgreSQL %d\n", cfg.PGData, major)
+		return os.RemoveAll(cfg.PGData + ".upgrade")
+	}
+	if _, err := os.Stat(kept); err != nil {
+		return fmt.Errorf("no PostgreSQL %d data directory to roll back to: %v", major, err)
+	}
+
+	fmt.Printf("Rolling %s back to PostgreSQL %d\n", cfg.PGData, major)
+	if err := os.RemoveAll(cfg.PGData); err != nil {
+		return err
+	}
+	return os.Rename(kept, cfg.PGData)
+}
+
+// serverMajor returns the major version of the postgres binary in binDir
+func serverMajor(ctx context.Context, binDir string) (int, error) {
+	out, err := exec.CommandContext(ctx, filepath.Join(binDir, "postgres"), "--version").Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get version of %s: %v", binDir, err)
+	}
+	v, err := postgres.ParseServerVersion(string(out))
+	if err != nil {
+		return 0, err
+	}
+	return v.Major, nil
+}
+
+// dataMajor returns the major version of the data directory
+func dataMajor(pgdata string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(pgdata, "PG_VERSION"))
+	if err != nil {
+		return 0, err
+	}
+	v, err := postgres.ParseVersion(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, err
+	}
+	return v.Major, nil
+}
+
+// dataChecksums reports whether the cluster in pgdata has data checksums
+func dataChecksums(ctx context.Context, binDir, pgdata string) (bool, error) {
+	out, err := exec.CommandContext(ctx, filepath.Join(binDir, "pg_controldata"), pgdata).Output()
+	if err != nil {
+		return false, fmt.Errorf("pg_controldata failed: %v", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(name) == "Data page checksum version" {
+			return strings.TrimSpace(value) != "0", nil
+		}
+	}
+	return false, nil
+}
+
+// removeKeptDataDirs removes the data directories earlier upgrades kept
+func removeKeptDataDirs(pgdata string) error {
+	matches, err := filepath.Glob(pgdata + ".*")
+	if err != nil {
+		return err
+	}
+	for _, dir := range matches {
+		if keptDataDir.MatchString(dir) {
+			fmt.Printf("Removing %s of an earlier upgrade\n", dir)
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+// Solution: PostgreSQL Instance Manager from Module 8
+// This runs next to PostgreSQL in every Database pod and reports its replication state
+// Location: internal/instance/instance.go
+//
+// The operator has no SQL driver and its image is distroless, so it can't ask PostgreSQL
+// about replication itself. Every Database pod gets an "instance" sidecar instead, which
+// runs backup-agent instance in the Database's image (see replication.go) and uses psql
+// against the local server:
+// - GET /status returns the role, the WAL position and, on the primary, the lag of each standby
+// - DELETE /slots/<name> drops the replication slot of a removed standby on the primary
+// - POST /promote promotes a standby to primary (see failover.go)
+// - every request needs the API token from the credentials Secret as a bearer token,
+//   and a NetworkPolicy only lets the operator reach the port (see replication.go)
+// - on the primary, it creates the replication user from the credentials Secret
+// - on a standby, it creates the standby's replication slot on the primary whenever the
+//   standby doesn't stream, e.g. after another pod became primary
+//
+// The init-instance init container prepares the data directory before PostgreSQL starts
+// (see instance-init.go).
+
+package instance
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables of the instance containers, set by the Database controller
+const (
+	// PodNameEnvVar is the pod the container runs in
+	PodNameEnvVar = "POD_NAME"
+	// PrimaryEnvVar is the pod that runs the primary
+	PrimaryEnvVar = "PRIMARY"
+	// PrimaryHostEnvVar is the host of the read-write Service in front of the primary
+	PrimaryHostEnvVar = "PRIMARY_HOST"
+	// ReplicationUserEnvVar and ReplicationPasswordEnvVar are the credentials standbys connect with
+	ReplicationUserEnvVar     = "REPLICATION_USER"
+	ReplicationPasswordEnvVar = "REPLICATION_PASSWORD"
+	// APITokenEnvVar is the bearer token the instance API requires
+	APITokenEnvVar = "INSTANCE_API_TOKEN"
+)
+
+// Port is where the instance sidecar serves its API
+const Port = 8008
+
+// maintainInterval is how often the sidecar checks the replication user and slot
+const maintainInterval = 10 * time.Second
+
+// httpClient bounds the controller's calls, a hanging pod mustn't block a reconcile
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+// Config is the replication setup of one pod
+type Config struct {
+	PodName     string
+	Primary     string
+	PrimaryHost string
+
+	ReplicationUser     string
+	ReplicationPassword string
+
+	// APIToken authenticates the controller's API requests (instance only)
+	APIToken string
+
+	// PGData is the data directory (init-instance and upgrade only)
+	PGData string
+	// Superuser and SuperuserPassword connect pg_rewind to the primary (init-instance only).
+	// The upgrade creates the new cluster with the Superuser.
+	Superuser         string
+	SuperuserPassword string
+}
+
+// ConfigFromEnv reads the Config from the variables set by the Database controller
+func ConfigFromEnv() Config {
+	return Config{
+		PodName:             os.Getenv(PodNameEnvVar),
+		Primary:             os.Getenv(PrimaryEnvVar),
+		PrimaryHost:         os.Getenv(PrimaryHostEnvVar),
+		ReplicationUser:     os.Getenv(ReplicationUserEnvVar),
+		ReplicationPassword: os.Getenv(ReplicationPasswordEnvVar),
+		APIToken:            os.Getenv(APITokenEnvVar),
+		PGData:              os.Getenv("PGDATA"),
+		Superuser:           os.Getenv("POSTGRES_USER"),
+		SuperuserPassword:   os.Getenv("POSTGRES_PASSWORD"),
+	}
+}
+
+// SlotName returns the replication slot of the standby in pod.
+// Slot names may only contain lower case letters, numbers and underscores.
+func SlotName(pod string) string {
+	return strings.ReplaceAll(pod, "-", "_")
+}
+
+// Status is the replication state of one PostgreSQL server
+type Status struct {
+	// Primary is false while the server is in recovery, i.e. a standby
+	Primary bool `json:"primary"`
+	// LSN is the current WAL position of a primary, the last replayed one of a standby
+	LSN string `json:"lsn,omitempty"`
+	// ReceivedLSN is the last WAL position a standby received
+	ReceivedLSN string `json:"receivedLSN,omitempty"`
+	// Standbys are the standbys replicating from a primary
+	Standbys []Standby `json:"standbys,omitempty"`
+	// Slots are the physical replication slots of a primary
+	Slots []string `json:"slots,omitempty"`
+}
+
+// Standby is a standby connected to the primary
+type Standby struct {
+	// Name is the standby's application_name, its pod
+	Name string `json:"name"`
+	// State is the WAL sender state, e.g. streaming
+	State string `json:"state"`
+	// LagBytes is the WAL the standby has yet to replay
+	LagBytes int64 `json:"lagBytes"`
+}
+
+const statusQuery = `SELECT pg_is_in_recovery(),
+  CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END,
+  pg_last_wal_receive_lsn()`
+
+const standbysQuery = `SELECT application_name, state,
+  pg_wal_lsn_diff(pg_current_wal_lsn(), COALESCE(replay_lsn, '0/0'))::bigint
+  FROM pg_stat_replication`
+
+const slotsQuery = `SELECT slot_name FROM pg_replication_slots WHERE slot_type = 'physical'`
+
+const streamingQuery = `SELECT count(*) FROM pg_stat_wal_receiver WHERE status = 'streaming'`
+
+// createUserQuery creates the replication user unless it exists. psql only
+// substitutes variables outside of quotes, hence format() and \gexec.
+const createUserQuery = `SELECT format('CREATE ROLE %I WITH REPLICATION LOGIN PASSWORD %L', :'user', :'password')
+  WHERE NOT EXISTS (SELECT FROM pg_roles WHERE rolname = :'user') \gexec`
+
+// promoteQuery doesn't wait for the promotion, GET /status shows when it's done
+const promoteQuery = `SELECT pg_promote(wait => false)`
+
+const dropSlotQuery = `SELECT pg_drop_replication_slot(slot_name) FROM pg_replication_slots
+  WHERE slot_name = :'slot' AND NOT active`
+
+// Serve runs the instance API on addr and maintains the replication user or slot
+// until ctx is done. psql connects to the local server through the PG* environment variables.
+func Serve(ctx context.Context, addr string, cfg Config) error {
+	// The API promotes standbys and drops slots as the superuser, it is never open
+	if cfg.APIToken == "" {
+		return fmt.Errorf("%s is not set", APITokenEnvVar)
+	}
+
+	mux := http.NewServeMux()
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.Handle(pattern, authorize(cfg.APIToken, h))
+	}
+	handle("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := localStatus(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status)
+	})
+	handle("DELETE /slots/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := psql(r.Context(), dropSlotQuery, map[string]string{"slot": r.PathValue("name")}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handle("POST /promote", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := psql(r.Context(), promoteQuery, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	go maintain(ctx, cfg)
+
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// authorize only passes requests with token as their bearer token on to h
+func authorize(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// maintain keeps the replication user (primary) or slot (standby) in place.
+// Failures are retried, e.g. while the server is still starting.
+func maintain(ctx context.Context, cfg Config) {
+	ticker := time.NewTicker(maintainInterval)
+	defer ticker.Stop()
+	for {
+		if err := maintainOnce(ctx, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "instance: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func maintainOnce(ctx context.Context, cfg Config) error {
+	rows, err := psql(ctx, statusQuery, nil)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 || rows[0][0] == "f" {
+		_, err := psql(ctx, createUserQuery, map[string]string{"user": cfg.ReplicationUser, "password": cfg.ReplicationPassword})
+		return err
+	}
+
+	rows, err = psql(ctx, streamingQuery, nil)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 && rows[0][0] != "0" {
+		return nil
+	}
+	return ensureSlot(ctx, cfg)
+}
+
+// ensureSlot creates the replication slot of this standby on the primary
+func ensureSlot(ctx context.Context, cfg Config) error {
+	cmd := exec.CommandContext(ctx, "pg_receivewal", "--create-slot", "--if-not-exists",
+		"--slot", SlotName(cfg.PodName), "--host", cfg.PrimaryHost, "--username", cfg.ReplicationUser, "--no-password")
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+cfg.ReplicationPassword)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create replication slot on %s: %v: %s", cfg.PrimaryHost, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// localStatus queries the replication state of the local server
+func localStatus(ctx context.Context) (*Status, error) {
+	rows, err := psql(ctx, statusQuery, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || len(rows[0]) < 3 {
+		return nil, fmt.Errorf("unexpected status %v", rows)
+	}
+	status := &Status{Primary: rows[0][0] == "f", LSN: rows[0][1], ReceivedLSN: rows[0][2]}
+	if !status.Primary {
+		return status, nil
+	}
+
+	if rows, err = psql(ctx, standbysQuery, nil); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if len(row) < 3 {
+			continue
+		}
+		lag, err := strconv.ParseInt(row[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lag of %s: %v", row[0], err)
+		}
+		status.Standbys = append(status.Standbys, Standby{Name: row[0], State: row[1], LagBytes: lag})
+	}
+
+	if rows, err = psql(ctx, slotsQuery, nil); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		status.Slots = append(status.Slots, row[0])
+	}
+	return status, nil
+}
+
+// psql runs sql against the local server and returns the rows of its output.
+// vars are available to sql as :'name'.
+func psql(ctx context.Context, sql string, vars map[string]string) ([][]string, error) {
+	args := []string{"--no-psqlrc", "--quiet", "--tuples-only", "--no-align", "--field-separator=|", "-v", "ON_ERROR_STOP=1"}
+	for name, value := range vars {
+		args = append(args, "-v", name+"="+value)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "psql", args...)
+	// Variables are only substituted in scripts, not in -c commands
+	cmd.Stdin = strings.NewReader(sql)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseRows(string(out)), nil
+}
+
+// ParseLSN parses a WAL position such as 16/B374D848 into a number,
+// so positions can be compared
+func ParseLSN(lsn string) (uint64, error) {
+	hi, lo, ok := strings.Cut(lsn, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid LSN %q", lsn)
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q: %v", lsn, err)
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %q: %v", lsn, err)
+	}
+	return h<<32 | l, nil
+}
+
+// parseRows splits unaligned psql output into rows and columns
+func parseRows(out string) [][]string {
+	var rows [][]string
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+		rows = append(rows, strings.Split(line, "|"))
+	}
+	return rows
+}
+
+// GetStatus fetches the Status of the server in the pod with the given IP.
+// token is the API token from the Database's credentials Secret.
+func GetStatus(ctx context.Context, podIP, token string) (*Status, error) {
+	req, err := newRequest(ctx, http.MethodGet, podIP, "/status", token)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+	status := &Status{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, fmt.Errorf("invalid status from %s: %w", podIP, err)
+	}
+	return status, nil
+}
+
+// Promote promotes the standby in the pod with the given IP
+func Promote(ctx context.Context, podIP, token string) error {
+	req, err := newRequest(ctx, http.MethodPost, podIP, "/promote", token)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return apiError(resp)
+	}
+	return nil
+}
+
+// DropSlot drops the replication slot on the primary with the given IP, if it is inactive
+func DropSlot(ctx context.Context, podIP, token, slot string) error {
+	req, err := newRequest(ctx, http.MethodDelete, podIP, "/slots/"+slot, token)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return apiError(resp)
+	}
+	return nil
+}
+
+// newRequest builds an API request to the pod with the given IP, authenticated with token
+func newRequest(ctx context.Context, method, podIP, path, token string) (*http.Request, error) {
+	url := "http://" + net.JoinHostPort(podIP, strconv.Itoa(Port)) + path
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+func apiError(resp *http.Response) error {
+	var body bytes.Buffer
+	_, _ = body.ReadFrom(resp.Body)
+	return fmt.Errorf("instance API returned %s: %s", resp.Status, strings.TrimSpace(body.String()))
+}
@@ -0,0 +1,128 @@
+// Solution: Instance Manager Tests from Module 8
+// This tests the parts of the instance manager that don't need a running PostgreSQL
+// Location: internal/instance/instance_test.go
+
+package instance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestInstance(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Instance Suite")
+}
+
+var _ = Describe("Instance", func() {
+	It("should derive valid slot names from pod names", func() {
+		Expect(SlotName("orders-db-2")).To(Equal("orders_db_2"))
+	})
+
+	It("should split unaligned psql output", func() {
+		rows := parseRows("orders-db-1|streaming|0\norders-db-2|catchup|8192\n")
+		Expect(rows).To(Equal([][]string{
+			{"orders-db-1", "streaming", "0"},
+			{"orders-db-2", "catchup", "8192"},
+		}))
+		Expect(parseRows("")).To(BeEmpty())
+	})
+
+	It("should order WAL positions", func() {
+		a, err := ParseLSN("0/3000060")
+		Expect(err).NotTo(HaveOccurred())
+		b, err := ParseLSN("16/B374D848")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b).To(Equal(uint64(0x16B374D848)))
+		Expect(a).To(BeNumerically("<", b))
+
+		_, err = ParseLSN("3000060")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should only serve requests with the API token", func() {
+		handler := authorize("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		for header, code := range map[string]int{
+			"":              http.StatusUnauthorized,
+			"secret":        http.StatusUnauthorized,
+			"Bearer other":  http.StatusUnauthorized,
+			"Bearer secret": http.StatusNoContent,
+		} {
+			req := httptest.NewRequest(http.MethodPost, "/promote", nil)
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(code), "Authorization %q", header)
+		}
+	})
+
+	It("should not serve without an API token", func() {
+		Expect(Serve(context.Background(), "127.0.0.1:0", Config{})).To(MatchError(ContainSubstring(APITokenEnvVar)))
+	})
+
+	It("should quote connection string values", func() {
+		Expect(conninfoValue(`it's\here`)).To(Equal(`'it\'s\\here'`))
+	})
+
+	It("should only remove the data directories kept by earlier upgrades", func() {
+		pgdata := filepath.Join(GinkgoT().TempDir(), "pgdata")
+		for _, dir := range []string{pgdata, pgdata + ".14", pgdata + ".upgrade"} {
+			Expect(os.Mkdir(dir, 0o700)).To(Succeed())
+		}
+		Expect(os.WriteFile(filepath.Join(pgdata, "PG_VERSION"), []byte("16\n"), 0o600)).To(Succeed())
+
+		Expect(removeKeptDataDirs(pgdata)).To(Succeed())
+		Expect(pgdata + ".14").NotTo(BeADirectory())
+		Expect(pgdata + ".upgrade").To(BeADirectory())
+
+		major, err := dataMajor(pgdata)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(major).To(Equal(16))
+	})
+
+	Describe("setAutoConf", func() {
+		var path string
+
+		BeforeEach(func() {
+			path = filepath.Join(GinkgoT().TempDir(), "postgresql.auto.conf")
+		})
+
+		It("should replace the given settings and keep the others", func() {
+			Expect(os.WriteFile(path, []byte("# Do not edit this file manually!\n"+
+				"restore_command = 'wal-fetch %f %p'\n"+
+				"primary_conninfo = 'host=old'\n"), 0o600)).To(Succeed())
+
+			Expect(setAutoConf(path, standbySettings, map[string]string{
+				"primary_conninfo":  "host=new password='secret'",
+				"primary_slot_name": "orders_db_1",
+			})).To(Succeed())
+
+			data, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("# Do not edit this file manually!\n" +
+				"restore_command = 'wal-fetch %f %p'\n" +
+				"primary_conninfo = 'host=new password=''secret'''\n" +
+				"primary_slot_name = 'orders_db_1'\n"))
+		})
+
+		It("should create the file if it doesn't exist", func() {
+			Expect(setAutoConf(path, standbySettings, map[string]string{"recovery_target_timeline": "latest"})).To(Succeed())
+
+			data, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("recovery_target_timeline = 'latest'\n"))
+		})
+	})
+})
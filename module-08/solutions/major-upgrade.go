@@ -0,0 +1,601 @@
+// Solution: Major Version Upgrades from Module 8
+// This upgrades a Database to a new PostgreSQL major version with pg_upgrade
+//
+// These are additions to the DatabaseReconciler from Module 3 (database-controller.go).
+// A new major version can't just replace the image: the data directory has the on-disk
+// format of the old version, which the new server refuses to start on. When spec.image
+// changes to another major version (parsed by internal/postgres from Module 5),
+// updateStatefulSet keeps the old image and reconcileMajorUpgrade takes over once the
+// Database is Ready. The upgrade goes through phases declared for the phase engine:
+// 1. BackingUp: a logical Backup <name>-upgrade-<time> of the running Database, which
+//    restores into either version (skipped with spec.majorUpgrade.skipBackup)
+// 2. Stopping: the StatefulSet is scaled to zero
+// 3. Upgrading: a Job runs pg_upgrade on the primary's data volume (see instance-upgrade.go)
+// 4. Starting: the StatefulSet runs the new image; the standbys clone the upgraded primary
+// 5. Completed once the primary runs the new image and is ready
+//
+// When the Job fails or the upgraded primary isn't ready within spec.majorUpgrade.timeoutSeconds,
+// RollingBack stops the StatefulSet again, puts the old data directory back with another
+// Job and starts the old image. The upgrade is then Failed and waits for a new image or the
+// database.example.com/retry annotation. If the rollback fails too, restore the Backup.
+//
+// The state machine must not scale the StatefulSet or fail over while the upgrade runs,
+// so call reconcileMajorUpgrade in Reconcile before it:
+//
+//	if result, upgrading, err := r.reconcileMajorUpgrade(ctx, db); err != nil || upgrading {
+//	    return result, err
+//	}
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/agent"
+	"github.com/example/postgres-operator/internal/conditions"
+	"github.com/example/postgres-operator/internal/phase"
+	"github.com/example/postgres-operator/internal/postgres"
+	restorePkg "github.com/example/postgres-operator/internal/restore"
+)
+
+// +kubebuilder:rbac:groups=database.example.com,resources=backups,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;update;patch
+
+// The phases of a major upgrade (MajorUpgradeStatus.Phase)
+const (
+	upgradeBackingUp   = "BackingUp"
+	upgradeStopping    = "Stopping"
+	upgradeUpgrading   = "Upgrading"
+	upgradeStarting    = "Starting"
+	upgradeRollingBack = "RollingBack"
+	upgradeCompleted   = "Completed"
+	upgradeFailed      = "Failed"
+)
+
+const (
+	// defaultUpgradeTimeout applies without spec.majorUpgrade.timeoutSeconds
+	defaultUpgradeTimeout = time.Hour
+
+	// upgradePollInterval is how often an upgrade checks on its Backup, Jobs and pods
+	upgradePollInterval = 10 * time.Second
+
+	// oldBinariesVolume is where the upgrade Job's init container copies the binaries
+	// of the old image to
+	oldBinariesVolume = "old-binaries"
+	oldBinariesPath   = "/old"
+)
+
+func upgradeTimeout(db *databasev1.Database) time.Duration {
+	if spec := db.Spec.MajorUpgrade; spec != nil && spec.TimeoutSeconds > 0 {
+		return time.Duration(spec.TimeoutSeconds) * time.Second
+	}
+	return defaultUpgradeTimeout
+}
+
+// upgradeRunning reports whether db is in the middle of a major upgrade
+func upgradeRunning(db *databasev1.Database) bool {
+	up := db.Status.MajorUpgrade
+	return up != nil && up.Phase != upgradeCompleted && up.Phase != upgradeFailed
+}
+
+// majorVersionChange reports whether replacing the image from with to changes the
+// major version. Images without a version tag are treated as the same version.
+func majorVersionChange(from, to string) bool {
+	fromVersion, err := postgres.ParseImage(from)
+	if err != nil {
+		return false
+	}
+	toVersion, err := postgres.ParseImage(to)
+	if err != nil {
+		return false
+	}
+	return fromVersion.Major != toVersion.Major
+}
+
+// reconcileMajorUpgrade starts a major upgrade when spec.image changed to a new major
+// version and drives a running one. It reports whether an upgrade is running, in which
+// case the caller must leave the StatefulSet alone.
+func (r *DatabaseReconciler) reconcileMajorUpgrade(ctx context.Context, db *databasev1.Database) (ctrl.Result, bool, error) {
+	if !upgradeRunning(db) {
+		started, err := r.startMajorUpgrade(ctx, db)
+		if err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, true, nil
+			}
+			return ctrl.Result{}, false, err
+		}
+		if !started {
+			return ctrl.Result{}, false, nil
+		}
+		return ctrl.Result{Requeue: true}, true, nil
+	}
+
+	machine := r.upgradePhases(db)
+	if err := machine.Validate(); err != nil {
+		return ctrl.Result{}, true, err
+	}
+	result, err := machine.Step(ctx, db)
+	if err != nil {
+		return ctrl.Result{}, true, err
+	}
+	if t := result.Transition; t != nil {
+		ctrl.LoggerFrom(ctx).Info("Major upgrade", "from", t.From, "to", t.To, "reason", t.Reason, "database", db.Name)
+		if err := r.Status().Update(ctx, db); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, true, nil
+			}
+			return ctrl.Result{}, true, err
+		}
+	}
+	return result.Result, true, nil
+}
+
+// startMajorUpgrade records a new upgrade in the status if the StatefulSet runs another
+// major version than spec.image. It waits for a Ready Database, and doesn't repeat a
+// Failed upgrade to the same image unless the retry annotation asks for it.
+func (r *DatabaseReconciler) startMajorUpgrade(ctx context.Context, db *databasev1.Database) (bool, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	ss := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, ss); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	current := ss.Spec.Template.Spec.Containers[0].Image
+	if !majorVersionChange(current, db.Spec.Image) {
+		return false, nil
+	}
+	from, _ := postgres.ParseImage(current)
+	to, _ := postgres.ParseImage(db.Spec.Image)
+
+	last := db.Status.MajorUpgrade
+	failedBefore := last != nil && last.Phase == upgradeFailed && last.FromImage == current && last.ToImage == db.Spec.Image
+	if to.Major < from.Major {
+		// The webhook rejects downgrades, pg_upgrade can't do them
+		if failedBefore {
+			return false, nil
+		}
+		message := fmt.Sprintf("Can't downgrade from PostgreSQL %d to %d, restore a Backup into a new Database instead", from.Major, to.Major)
+		now := metav1.Now()
+		db.Status.MajorUpgrade = &databasev1.MajorUpgradeStatus{
+			FromImage: current,
+			ToImage:   db.Spec.Image,
+			Phase:     upgradeFailed,
+			Message:   message,
+			StartTime: now,
+			PhaseTime: &now,
+		}
+		conditions.MarkStalled(db, "MajorDowngrade", message)
+		return false, r.Status().Update(ctx, db)
+	}
+	if failedBefore && !retryRequested(db) {
+		return false, nil
+	}
+
+	if _, ok := db.Annotations[databasev1.RestoreInProgressAnnotation]; ok {
+		return false, nil
+	}
+	if _, ok := db.Annotations[databasev1.SwitchoverInProgressAnnotation]; ok {
+		return false, nil
+	}
+	if db.Status.Phase != "Ready" {
+		log.Info("Waiting for the Database to be ready before the major upgrade", "phase", db.Status.Phase)
+		return false, nil
+	}
+
+	log.Info("Starting major upgrade", "from", current, "to", db.Spec.Image)
+	now := metav1.Now()
+	up := &databasev1.MajorUpgradeStatus{
+		FromImage: current,
+		ToImage:   db.Spec.Image,
+		Phase:     upgradeBackingUp,
+		StartTime: now,
+		PhaseTime: &now,
+	}
+	if spec := db.Spec.MajorUpgrade; spec == nil || !spec.SkipBackup {
+		up.BackupName = fmt.Sprintf("%s-upgrade-%s", db.Name, now.UTC().Format("20060102-150405"))
+	}
+	db.Status.MajorUpgrade = up
+	// The upgrade acts on the new image, the state machine is paused until it finished
+	db.Status.ObservedGeneration = db.Generation
+	conditions.MarkReconciling(db, "MajorUpgrade", fmt.Sprintf("Upgrading from PostgreSQL %d to %d", from.Major, to.Major))
+	if err := r.Status().Update(ctx, db); err != nil {
+		return false, err
+	}
+	return true, clearRetryRequest(ctx, r.Client, db)
+}
+
+// upgradePhases declares the phases of the major upgrade of db
+func (r *DatabaseReconciler) upgradePhases(db *databasev1.Database) *phase.Machine[*databasev1.Database] {
+	timeout := upgradeTimeout(db)
+	done := func(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
+		return phase.Outcome{}, nil
+	}
+	return &phase.Machine[*databasev1.Database]{
+		Initial: upgradeBackingUp,
+		Phases: []phase.Phase[*databasev1.Database]{
+			{
+				Name:         upgradeBackingUp,
+				Handle:       r.handleUpgradeBackup,
+				Timeout:      timeout,
+				TimeoutPhase: upgradeFailed,
+				Next:         []string{upgradeStopping, upgradeFailed},
+			},
+			{
+				// A StatefulSet that doesn't stop has run nothing on the data, the rollback
+				// only starts it again
+				Name:         upgradeStopping,
+				Handle:       r.handleUpgradeStop,
+				Timeout:      timeout,
+				TimeoutPhase: upgradeRollingBack,
+				Next:         []string{upgradeUpgrading, upgradeRollingBack},
+			},
+			{
+				// The Job has a deadline of the same timeout
+				Name:   upgradeUpgrading,
+				Handle: r.handleUpgradeJob,
+				Next:   []string{upgradeStarting, upgradeRollingBack},
+			},
+			{
+				Name:         upgradeStarting,
+				Handle:       r.handleUpgradeStart,
+				Timeout:      timeout,
+				TimeoutPhase: upgradeRollingBack,
+				Next:         []string{upgradeCompleted, upgradeRollingBack},
+			},
+			{
+				Name:   upgradeRollingBack,
+				Handle: r.handleUpgradeRollback,
+				Next:   []string{upgradeFailed},
+			},
+			{Name: upgradeCompleted, Handle: done},
+			{Name: upgradeFailed, Handle: done},
+		},
+		Current: func(db *databasev1.Database) string { return db.Status.MajorUpgrade.Phase },
+		EnteredAt: func(db *databasev1.Database) time.Time {
+			if db.Status.MajorUpgrade.PhaseTime == nil {
+				return time.Time{}
+			}
+			return db.Status.MajorUpgrade.PhaseTime.Time
+		},
+		Enter: enterUpgradePhase,
+	}
+}
+
+// enterUpgradePhase records a transition of the upgrade and its outcome in the conditions
+func enterUpgradePhase(db *databasev1.Database, t phase.Transition) {
+	now := metav1.Now()
+	up := db.Status.MajorUpgrade
+	up.Phase = t.To
+	up.PhaseTime = &now
+	up.Message = t.Message
+
+	reason := conditions.Reason(t.Reason)
+	if reason == "" {
+		reason = conditions.Reason("MajorUpgrade" + t.To)
+	}
+	switch t.To {
+	case upgradeCompleted:
+		up.CompletionTime = &now
+		conditions.MarkReady(db, reason, t.Message)
+		conditions.ClearDegraded(db)
+	case upgradeFailed:
+		conditions.MarkStalled(db, reason, t.Message)
+		conditions.MarkDegraded(db, "MajorUpgradeFailed", t.Message)
+	default:
+		conditions.MarkReconciling(db, reason, t.Message)
+	}
+}
+
+// handleUpgradeBackup takes a Backup before anything is touched. It is a logical Backup,
+// which can be restored into the old and the new version.
+func (r *DatabaseReconciler) handleUpgradeBackup(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
+	up := db.Status.MajorUpgrade
+	if up.BackupName == "" {
+		return phase.Outcome{Next: upgradeStopping, Reason: "BackupSkipped", Message: "Stopping the database"}, nil
+	}
+
+	backup := &databasev1.Backup{}
+	err := r.Get(ctx, client.ObjectKey{Name: up.BackupName, Namespace: db.Namespace}, backup)
+	if errors.IsNotFound(err) {
+		if spec := db.Spec.MajorUpgrade; spec == nil || spec.StorageLocation == "" {
+			return phase.Outcome{
+				Next:    upgradeFailed,
+				Reason:  "BackupLocationMissing",
+				Message: "spec.majorUpgrade.storageLocation is required unless skipBackup is set, the database was not upgraded",
+			}, nil
+		}
+		// The Backup isn't owned by the Database, so it outlives a Database the upgrade broke
+		backup = &databasev1.Backup{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      up.BackupName,
+				Namespace: db.Namespace,
+				Labels:    map[string]string{"app": "database", "database": db.Name},
+			},
+			Spec: databasev1.BackupSpec{
+				DatabaseRef: databasev1.NamespacedObjectReference{Name: db.Name},
+				Method:      "logical",
+			},
+		}
+		backup.Spec.StorageLocation = db.Spec.MajorUpgrade.StorageLocation
+		backup.Spec.StorageSecretRef = db.Spec.MajorUpgrade.StorageSecretRef
+		ctrl.LoggerFrom(ctx).Info("Backing up before the major upgrade", "backup", backup.Name)
+		if err := r.Create(ctx, backup); err != nil && !errors.IsAlreadyExists(err) {
+			return phase.Outcome{}, err
+		}
+		return phase.Outcome{RequeueAfter: upgradePollInterval}, nil
+	}
+	if err != nil {
+		return phase.Outcome{}, err
+	}
+
+	switch backup.Status.Phase {
+	case "Completed":
+		return phase.Outcome{
+			Next:    upgradeStopping,
+			Reason:  "BackupCompleted",
+			Message: fmt.Sprintf("Backup %s completed, stopping the database", backup.Name),
+		}, nil
+	case "Failed":
+		return phase.Outcome{
+			Next:    upgradeFailed,
+			Reason:  "BackupFailed",
+			Message: fmt.Sprintf("Backup %s failed, the database was not upgraded", backup.Name),
+		}, nil
+	}
+	return phase.Outcome{RequeueAfter: upgradePollInterval}, nil
+}
+
+// handleUpgradeStop scales the StatefulSet to zero, pg_upgrade needs a cleanly shut down server
+func (r *DatabaseReconciler) handleUpgradeStop(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
+	stopped, err := r.stopStatefulSet(ctx, db)
+	if err != nil || !stopped {
+		return phase.Outcome{RequeueAfter: upgradePollInterval}, err
+	}
+	return phase.Outcome{
+		Next:    upgradeUpgrading,
+		Reason:  "Stopped",
+		Message: fmt.Sprintf("Running pg_upgrade on %s", restorePkg.DataClaimName(db)),
+	}, nil
+}
+
+// handleUpgradeJob runs pg_upgrade on the primary's data volume and starts the new image
+// once it succeeded
+func (r *DatabaseReconciler) handleUpgradeJob(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
+	up := db.Status.MajorUpgrade
+	job, err := r.upgradeJob(ctx, db, upgradeJobName(db), func() (*batchv1.Job, error) {
+		return r.newUpgradeJob(db)
+	})
+	if err != nil || job == nil {
+		return phase.Outcome{RequeueAfter: upgradePollInterval}, err
+	}
+
+	finished, succeeded, message := agent.JobFinished(job)
+	if !finished {
+		return phase.Outcome{RequeueAfter: upgradePollInterval}, nil
+	}
+	if !succeeded {
+		return phase.Outcome{
+			Next:    upgradeRollingBack,
+			Reason:  "UpgradeJobFailed",
+			Message: fmt.Sprintf("pg_upgrade failed (see the logs of Job %s): %s", job.Name, message),
+		}, nil
+	}
+
+	if err := r.startStatefulSet(ctx, db, up.ToImage); err != nil {
+		return phase.Outcome{}, err
+	}
+	return phase.Outcome{
+		Next:    upgradeStarting,
+		Reason:  "Upgraded",
+		Message: fmt.Sprintf("Starting %s", up.ToImage),
+	}, nil
+}
+
+// handleUpgradeStart waits for the primary to run the new image. The standbys clone it
+// in the meantime (see instance-init.go), the state machine waits for them afterwards.
+func (r *DatabaseReconciler) handleUpgradeStart(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
+	up := db.Status.MajorUpgrade
+	pod := &corev1.Pod{}
+	err := r.Get(ctx, client.ObjectKey{Name: restorePkg.PrimaryPod(db), Namespace: db.Namespace}, pod)
+	if err != nil && !errors.IsNotFound(err) {
+		return phase.Outcome{}, err
+	}
+	if err == nil && pod.Spec.Containers[0].Image == up.ToImage {
+		if ready, _ := podReady(pod); ready {
+			from, _ := postgres.ParseImage(up.FromImage)
+			to, _ := postgres.ParseImage(up.ToImage)
+			return phase.Outcome{
+				Next:    upgradeCompleted,
+				Reason:  "MajorUpgradeCompleted",
+				Message: fmt.Sprintf("Upgraded from PostgreSQL %d to %d", from.Major, to.Major),
+			}, nil
+		}
+	}
+	return phase.Outcome{RequeueAfter: upgradePollInterval}, nil
+}
+
+// handleUpgradeRollback stops the StatefulSet, puts the old data directory back and starts
+// the old image. The data directory of an upgrade that never replaced it is left alone.
+func (r *DatabaseReconciler) handleUpgradeRollback(ctx context.Context, db *databasev1.Database) (phase.Outcome, error) {
+	up := db.Status.MajorUpgrade
+	stopped, err := r.stopStatefulSet(ctx, db)
+	if err != nil || !stopped {
+		return phase.Outcome{RequeueAfter: upgradePollInterval}, err
+	}
+
+	job, err := r.upgradeJob(ctx, db, rollbackJobName(db), func() (*batchv1.Job, error) {
+		return r.newRollbackJob(db)
+	})
+	if err != nil || job == nil {
+		return phase.Outcome{RequeueAfter: upgradePollInterval}, err
+	}
+	finished, succeeded, message := agent.JobFinished(job)
+	if !finished {
+		return phase.Outcome{RequeueAfter: upgradePollInterval}, nil
+	}
+	if !succeeded {
+		// Leave the StatefulSet stopped, starting either image could make things worse
+		restore := "restore a Backup"
+		if up.BackupName != "" {
+			restore = fmt.Sprintf("restore Backup %s", up.BackupName)
+		}
+		return phase.Outcome{
+			Next:    upgradeFailed,
+			Reason:  "RollbackFailed",
+			Message: fmt.Sprintf("%s; the rollback failed too (see the logs of Job %s): %s. The database is stopped, %s", up.Message, job.Name, message, restore),
+		}, nil
+	}
+
+	if err := r.startStatefulSet(ctx, db, up.FromImage); err != nil {
+		return phase.Outcome{}, err
+	}
+	return phase.Outcome{
+		Next:    upgradeFailed,
+		Reason:  "RolledBack",
+		Message: fmt.Sprintf("%s; rolled back to %s", up.Message, up.FromImage),
+	}, nil
+}
+
+// stopStatefulSet scales the StatefulSet to zero and reports whether all pods are gone
+func (r *DatabaseReconciler) stopStatefulSet(ctx context.Context, db *databasev1.Database) (bool, error) {
+	ss := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, ss); err != nil {
+		return false, err
+	}
+	if ss.Spec.Replicas == nil || *ss.Spec.Replicas != 0 {
+		ctrl.LoggerFrom(ctx).Info("Stopping database for the major upgrade", "database", db.Name)
+		patch := client.MergeFrom(ss.DeepCopy())
+		ss.Spec.Replicas = ptr.To(int32(0))
+		return false, r.Patch(ctx, ss, patch)
+	}
+	return ss.Status.Replicas == 0, nil
+}
+
+// startStatefulSet scales the StatefulSet back up with image, which the instance
+// containers follow (see configurePostgres)
+func (r *DatabaseReconciler) startStatefulSet(ctx context.Context, db *databasev1.Database, image string) error {
+	ss := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, ss); err != nil {
+		return err
+	}
+	ss.Spec.Template.Spec.Containers[0].Image = image
+	if _, err := configurePostgres(db, ss); err != nil {
+		return err
+	}
+	ss.Spec.Replicas = ptr.To(databaseReplicas(db))
+	// All pods start from the new template, there is no primary to protect
+	setPartition(ss, 0)
+	return r.Update(ctx, ss)
+}
+
+func upgradeJobName(db *databasev1.Database) string {
+	return fmt.Sprintf("%s-upgrade", db.Name)
+}
+
+func rollbackJobName(db *databasev1.Database) string {
+	return fmt.Sprintf("%s-upgrade-rollback", db.Name)
+}
+
+// upgradeJob returns the Job called name of the current upgrade, creating it with build
+// on the first call. A Job left behind by an earlier upgrade is deleted first. It returns nil
+// until the Job exists.
+func (r *DatabaseReconciler) upgradeJob(ctx context.Context, db *databasev1.Database, name string, build func() (*batchv1.Job, error)) (*batchv1.Job, error) {
+	job := &batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: db.Namespace}, job)
+	if err == nil {
+		if job.CreationTimestamp.Before(&db.Status.MajorUpgrade.StartTime) {
+			if job.DeletionTimestamp == nil {
+				ctrl.LoggerFrom(ctx).Info("Deleting Job of an earlier upgrade", "job", job.Name)
+				if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+					return nil, err
+				}
+			}
+			return nil, nil
+		}
+		return job, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	if job, err = build(); err != nil {
+		return nil, err
+	}
+	if err := ctrl.SetControllerReference(db, job, r.Scheme); err != nil {
+		return nil, err
+	}
+	ctrl.LoggerFrom(ctx).Info("Creating major upgrade Job", "job", job.Name)
+	if err := r.Create(ctx, job); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// newUpgradeJob builds the Job that runs pg_upgrade in the new image, with the old
+// image's binaries copied by an init container running the old image
+func (r *DatabaseReconciler) newUpgradeJob(db *databasev1.Database) (*batchv1.Job, error) {
+	up := db.Status.MajorUpgrade
+	job, err := newDataJob(db, upgradeJobName(db), up.ToImage, []string{"upgrade", oldBinariesPath},
+		ptr.To(int64(upgradeTimeout(db).Seconds())))
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &job.Spec.Template.Spec
+	mount := corev1.VolumeMount{Name: oldBinariesVolume, MountPath: oldBinariesPath}
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name:         oldBinariesVolume,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	spec.InitContainers = append(spec.InitContainers, corev1.Container{
+		Name:         oldBinariesVolume,
+		Image:        up.FromImage,
+		Command:      []string{agent.InstalledPath, "upgrade-prepare", oldBinariesPath},
+		VolumeMounts: []corev1.VolumeMount{mount},
+	})
+	spec.Containers[0].VolumeMounts = append(spec.Containers[0].VolumeMounts, mount)
+	// Mount the agent into the new init container
+	agent.InjectAgent(spec)
+	return job, nil
+}
+
+// newRollbackJob builds the Job that puts the old data directory back, in the old image
+func (r *DatabaseReconciler) newRollbackJob(db *databasev1.Database) (*batchv1.Job, error) {
+	return newDataJob(db, rollbackJobName(db), db.Status.MajorUpgrade.FromImage, []string{"upgrade-rollback"}, nil)
+}
+
+// newDataJob builds a Job that runs the backup-agent in image on the primary's data volume.
+// The agent finds the data directory and the superuser where the postgres container does.
+func newDataJob(db *databasev1.Database, name, image string, args []string, deadline *int64) (*batchv1.Job, error) {
+	job, err := agent.NewJob(db, agent.JobOptions{
+		Name:                  name,
+		Type:                  "upgrade",
+		Args:                  args,
+		Image:                 image,
+		ActiveDeadlineSeconds: deadline,
+		DataClaim:             restorePkg.DataClaimName(db),
+		DataMountPath:         restorePkg.DataMountPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	container := &job.Spec.Template.Spec.Containers[0]
+	container.Env = append(container.Env,
+		corev1.EnvVar{Name: "PGDATA", Value: restorePkg.PGDataDir},
+		secretKeyEnv("POSTGRES_USER", agent.CredentialsSecretName(db), "username"),
+	)
+	return job, nil
+}
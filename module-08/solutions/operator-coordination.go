@@ -9,12 +9,11 @@ import (
 	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/meta"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/conditions"
 )
 
 // Example 1: Database operator checks backup status
@@ -38,9 +37,8 @@ func (r *DatabaseReconciler) checkBackupStatus(ctx context.Context, db *database
 	}
 
 	// Check backup condition
-	condition := meta.FindStatusCondition(backup.Status.Conditions, "BackupReady")
-	if condition == nil || condition.Status != metav1.ConditionTrue {
-		return fmt.Errorf("backup not ready: %s", condition.Message)
+	if !conditions.IsReady(backup) {
+		return fmt.Errorf("backup %s not ready", backup.Name)
 	}
 
 	return nil
@@ -72,13 +70,8 @@ func (r *DatabaseReconciler) emitCoordinationEvent(db *databasev1.Database, even
 
 // Example 4: Update status for other operators to read
 func (r *DatabaseReconciler) updateStatusForCoordination(ctx context.Context, db *databasev1.Database) error {
-	// Set condition that other operators can check
-	meta.SetStatusCondition(&db.Status.Conditions, metav1.Condition{
-		Type:    "ReadyForBackup",
-		Status:  metav1.ConditionTrue,
-		Reason:  "DatabaseReady",
-		Message: "Database is ready for backup",
-	})
+	// Set the standard Ready condition, other operators check it with conditions.IsReady
+	conditions.MarkReady(db, "DatabaseReady", "Database is ready for backup")
 
 	return r.Status().Update(ctx, db)
 }
@@ -0,0 +1,226 @@
+// Solution: Reference Grants from Module 8
+// This checks references into other namespaces against ReferenceGrants
+//
+// Shared by the BackupReconciler (databaseRef) and the RestoreReconciler (backupRef and
+// artifactRef), see referencegrant_types.go. A reference into another namespace is only
+// followed when a ReferenceGrant there lists the referring kind and namespace in from,
+// and the referred kind (and name) in to. Without one, the Backup or Restore stays
+// Pending with reason ReferenceNotPermitted until the grant is created.
+//
+// Jobs run next to the Backup or Restore, so the Secrets they need from the other
+// namespace are copied there, owned by the Backup or Restore. The copies are deleted
+// once no Job of the Backup or Restore runs any more, or when the grant goes away.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	backupPkg "github.com/example/postgres-operator/internal/backup"
+)
+
+// +kubebuilder:rbac:groups=database.example.com,resources=referencegrants,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;delete
+
+// reasonReferenceNotPermitted is the condition reason while a ReferenceGrant is missing
+const reasonReferenceNotPermitted = "ReferenceNotPermitted"
+
+// crossNamespaceReference is a reference from a Backup or Restore to another namespace
+type crossNamespaceReference struct {
+	fromKind      string
+	fromNamespace string
+	toKind        string
+	toNamespace   string
+	toName        string
+}
+
+// String describes the missing grant, for conditions
+func (ref crossNamespaceReference) String() string {
+	return fmt.Sprintf("%s %s/%s can't be referred to from namespace %s: no ReferenceGrant in namespace %s allows %s from %s",
+		ref.toKind, ref.toNamespace, ref.toName, ref.fromNamespace, ref.toNamespace, ref.fromKind, ref.fromNamespace)
+}
+
+// referenceAllowed reports whether a ReferenceGrant allows ref.
+// References within a namespace don't need one.
+func referenceAllowed(ctx context.Context, c client.Client, ref crossNamespaceReference) (bool, error) {
+	if ref.fromNamespace == ref.toNamespace {
+		return true, nil
+	}
+
+	grants := &databasev1.ReferenceGrantList{}
+	if err := c.List(ctx, grants, client.InNamespace(ref.toNamespace)); err != nil {
+		return false, err
+	}
+	for _, grant := range grants.Items {
+		if grantAllows(&grant, ref) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// grantAllows reports whether grant lists both ends of ref
+func grantAllows(grant *databasev1.ReferenceGrant, ref crossNamespaceReference) bool {
+	from := false
+	for _, f := range grant.Spec.From {
+		if f.Kind == ref.fromKind && f.Namespace == ref.fromNamespace {
+			from = true
+			break
+		}
+	}
+	if !from {
+		return false
+	}
+	for _, to := range grant.Spec.To {
+		if to.Kind == ref.toKind && (to.Name == "" || to.Name == ref.toName) {
+			return true
+		}
+	}
+	return false
+}
+
+// copySecret copies the Secret name in namespace to copyName in the namespace of owner.
+// With keys, only those keys are copied. The copy is owned by owner, and follows changes
+// of the original on every call.
+func copySecret(ctx context.Context, c client.Client, scheme *runtime.Scheme, owner client.Object, namespace, name, copyName string, keys ...string) error {
+	original := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: name, Namespace: namespace}, original); err != nil {
+		return fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      copyName,
+			Namespace: owner.GetNamespace(),
+		},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, c, secret, func() error {
+		if secret.CreationTimestamp.IsZero() {
+			secret.Type = original.Type
+		}
+		secret.Data = original.Data
+		if len(keys) > 0 {
+			secret.Data = map[string][]byte{}
+			for _, key := range keys {
+				if value, ok := original.Data[key]; ok {
+					secret.Data[key] = value
+				}
+			}
+		}
+		return controllerutil.SetControllerReference(owner, secret, scheme)
+	})
+	return err
+}
+
+// deleteSecretCopies deletes the Secrets copySecret copied for owner
+func deleteSecretCopies(ctx context.Context, c client.Client, owner client.Object) error {
+	secrets := &corev1.SecretList{}
+	if err := c.List(ctx, secrets, client.InNamespace(owner.GetNamespace())); err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		if !metav1.IsControlledBy(secret, owner) {
+			continue
+		}
+		ctrl.LoggerFrom(ctx).Info("Deleting secret copy", "secret", secret.Name, "owner", owner.GetName())
+		if err := c.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete secret copy %s: %w", secret.Name, err)
+		}
+	}
+	return nil
+}
+
+// releaseSecretCopies deletes the Secret copies of a Backup of a Database in another
+// namespace once no Job of it runs, or when no ReferenceGrant allows the reference
+func (r *BackupReconciler) releaseSecretCopies(ctx context.Context, backup *databasev1.Backup) error {
+	ref := backupDatabaseReference(backup)
+	if ref.fromNamespace == ref.toNamespace {
+		return nil
+	}
+	if backup.Status.Phase != "Completed" && backup.Status.Phase != "Failed" {
+		allowed, err := referenceAllowed(ctx, r.Client, ref)
+		if err != nil || allowed {
+			return err
+		}
+	}
+	return deleteSecretCopies(ctx, r.Client, backup)
+}
+
+// releaseSecretCopies deletes the Secret copies of a Restore from a backup in another
+// namespace once its Job is done, or when no ReferenceGrant allows the reference
+func (r *RestoreReconciler) releaseSecretCopies(ctx context.Context, rst *databasev1.Restore) error {
+	ref := restoreSourceReference(rst)
+	if ref.fromNamespace == ref.toNamespace {
+		return nil
+	}
+	if rst.Status.Phase != "Completed" && rst.Status.Phase != "Failed" {
+		allowed, err := referenceAllowed(ctx, r.Client, ref)
+		if err != nil || allowed {
+			return err
+		}
+	}
+	return deleteSecretCopies(ctx, r.Client, rst)
+}
+
+// findBackupsForGrant returns the Backups in the namespaces grant allows Backups from
+// that refer to a Database in the grant's namespace. Pending ones can continue when
+// the grant is created, the others lose their Secret copies when it is removed.
+func (r *BackupReconciler) findBackupsForGrant(ctx context.Context, obj client.Object) []reconcile.Request {
+	grant := obj.(*databasev1.ReferenceGrant)
+
+	var requests []reconcile.Request
+	for _, from := range grant.Spec.From {
+		if from.Kind != "Backup" {
+			continue
+		}
+		backups := &databasev1.BackupList{}
+		if err := r.List(ctx, backups, client.InNamespace(from.Namespace)); err != nil {
+			continue
+		}
+		for _, backup := range backups.Items {
+			if backup.Spec.Schedule == "" && backupPkg.DatabaseNamespace(&backup) == grant.Namespace {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: backup.Name, Namespace: backup.Namespace},
+				})
+			}
+		}
+	}
+	return requests
+}
+
+// findRestoresForGrant returns the Restores in the namespaces grant allows Restores from
+// that restore from the grant's namespace
+func (r *RestoreReconciler) findRestoresForGrant(ctx context.Context, obj client.Object) []reconcile.Request {
+	grant := obj.(*databasev1.ReferenceGrant)
+
+	var requests []reconcile.Request
+	for _, from := range grant.Spec.From {
+		if from.Kind != "Restore" {
+			continue
+		}
+		restores := &databasev1.RestoreList{}
+		if err := r.List(ctx, restores, client.InNamespace(from.Namespace)); err != nil {
+			continue
+		}
+		for _, rst := range restores.Items {
+			if restoreSourceReference(&rst).toNamespace == grant.Namespace {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Name: rst.Name, Namespace: rst.Namespace},
+				})
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,103 @@
+// Solution: ReferenceGrant Types from Module 8
+// This file contains the API type definitions for the ReferenceGrant resource,
+// modeled after the Gateway API ReferenceGrant.
+//
+// Scaffold with (same group as Database and Backup, no controller):
+//   kubebuilder create api --group database --version v1 --kind ReferenceGrant --resource --controller=false
+//
+// Backups may back up a Database in another namespace, and Restores may restore from a
+// Backup or BackupArtifact in another namespace, e.g. to keep all backups in a central
+// namespace run by a platform team. The namespace being referred to has to allow it
+// with a ReferenceGrant:
+//
+//   apiVersion: database.example.com/v1
+//   kind: ReferenceGrant
+//   metadata:
+//     name: allow-central-backups
+//     namespace: shop            # the namespace of the Database
+//   spec:
+//     from:
+//     - kind: Backup
+//       namespace: backups
+//     to:
+//     - kind: Database
+//       name: orders             # optional, all Databases if empty
+//
+// Jobs always run in the namespace of the Backup or Restore. The Secrets they need from
+// the other namespace (Database credentials, or storage and encryption Secrets) are copied
+// next to the Job, owned by the Backup or Restore.
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NamespacedObjectReference refers to an object that may be in another namespace.
+// Referring to another namespace needs a ReferenceGrant in that namespace.
+type NamespacedObjectReference struct {
+	// Name of the referent
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+
+	// Namespace of the referent, defaults to the namespace of the referring object
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ReferenceGrantSpec defines which references into the namespace are allowed
+type ReferenceGrantSpec struct {
+	// From lists the resources in other namespaces that may refer to this namespace
+	// +kubebuilder:validation:MinItems=1
+	From []ReferenceGrantFrom `json:"from"`
+
+	// To lists the resources in this namespace they may refer to
+	// +kubebuilder:validation:MinItems=1
+	To []ReferenceGrantTo `json:"to"`
+}
+
+// ReferenceGrantFrom describes the referring resources
+type ReferenceGrantFrom struct {
+	// Kind of the referring resource
+	// +kubebuilder:validation:Enum=Backup;Restore
+	Kind string `json:"kind"`
+
+	// Namespace of the referring resource
+	// +kubebuilder:validation:Required
+	Namespace string `json:"namespace"`
+}
+
+// ReferenceGrantTo describes the resources that may be referred to
+type ReferenceGrantTo struct {
+	// Kind of the referent
+	// +kubebuilder:validation:Enum=Database;Backup;BackupArtifact
+	Kind string `json:"kind"`
+
+	// Name limits the grant to one resource, all resources of Kind if empty
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ReferenceGrant is the Schema for the referencegrants API.
+// It allows Backups and Restores in other namespaces to refer to resources in its namespace.
+type ReferenceGrant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ReferenceGrantSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ReferenceGrantList contains a list of ReferenceGrant
+type ReferenceGrantList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ReferenceGrant `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ReferenceGrant{}, &ReferenceGrantList{})
+}
@@ -0,0 +1,570 @@
+// Solution: Streaming Replication from Module 8
+// This runs the pods of a Database as one primary and hot standbys
+//
+// These are additions to the DatabaseReconciler from Module 3 (database-controller.go).
+// Without them, spec.replicas only scales the StatefulSet and every pod is an independent
+// primary with its own data. With them:
+// - The <name>-replication ConfigMap names the primary pod, <name>-0 at first
+// - The credentials Secret gets replication-username and replication-password; the
+//   primary creates that user, standbys stream WAL with it. It also gets instance-api-token,
+//   which the controller authenticates to the instance sidecars with. Backups in other
+//   namespaces only get username and password copied (see reference-grants.go).
+// - configureReplication adds two containers running the backup-agent to the pod template:
+//   init-instance prepares the data directory before PostgreSQL starts (clone, rewind or
+//   configure a standby, see instance-init.go), and the instance sidecar reports the
+//   replication state on port 8008 (see instance.go)
+// - The <name>-instance NetworkPolicy only lets the operator's pods reach port 8008
+// - Each standby streams from the read-write Service through its own replication slot
+// - The <name> Service built by buildService is the read-write Service: its selector also
+//   matches the primary's pod name. The <name>-ro Service selects the standbys through
+//   the database.example.com/role label the controller puts on every pod
+// - A standby replaces a failed primary (see failover.go), or takes over from a running
+//   primary on request or during a rollout (see switchover-controller.go and rolling-update.go)
+// - status.primary and status.replicas (WAL sender state and replay lag of every standby)
+//   are refreshed every replicationStatusInterval
+//
+// createStatefulSet and updateStatefulSet (rolling-update.go) call reconcileReplicationConfig
+// before they touch the StatefulSet. Call reconcileReplication after them, e.g. in handleReady:
+//
+//	if err := r.updateStatefulSet(ctx, db); err != nil {
+//	    return ctrl.Result{}, err
+//	}
+//	return r.reconcileReplication(ctx, db)
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/agent"
+	"github.com/example/postgres-operator/internal/instance"
+)
+
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch
+
+const (
+	// roleLabel marks the pods of a Database as primary or replica
+	roleLabel   = "database.example.com/role"
+	rolePrimary = "primary"
+	roleReplica = "replica"
+
+	// replicationUser is the user standbys stream WAL with
+	replicationUser        = "replicator"
+	replicationUsernameKey = "replication-username"
+	replicationPasswordKey = "replication-password"
+	// apiTokenKey holds the bearer token of the instance API
+	apiTokenKey = "instance-api-token"
+
+	// primaryKey holds the primary pod in the replication ConfigMap
+	primaryKey = "primary"
+
+	// replicationStatusInterval is how often the replication status is refreshed
+	replicationStatusInterval = 30 * time.Second
+)
+
+// replicationArgs are the server settings for streaming replication. Standbys need
+// the same max_* settings as their primary; wal_log_hints allows pg_rewind.
+func replicationArgs() []string {
+	return []string{
+		"-c", "wal_level=replica",
+		"-c", "hot_standby=on",
+		"-c", "max_wal_senders=10",
+		"-c", "max_replication_slots=10",
+		"-c", "wal_log_hints=on",
+	}
+}
+
+func replicationConfigMapName(db *databasev1.Database) string {
+	return fmt.Sprintf("%s-replication", db.Name)
+}
+
+func readOnlyServiceName(db *databasev1.Database) string {
+	return fmt.Sprintf("%s-ro", db.Name)
+}
+
+// primaryHost is the read-write Service standbys stream from
+func primaryHost(db *databasev1.Database) string {
+	return fmt.Sprintf("%s.%s.svc", db.Name, db.Namespace)
+}
+
+// podName returns the name of the StatefulSet pod with the given ordinal
+func podName(db *databasev1.Database, ordinal int32) string {
+	return fmt.Sprintf("%s-%d", db.Name, ordinal)
+}
+
+// configureReplication adds the init-instance and instance containers to the pod template.
+// It runs before agent.InjectAgent, which mounts the agent into them.
+func configureReplication(db *databasev1.Database, spec *corev1.PodSpec) {
+	postgres := &spec.Containers[0]
+	secretName := agent.CredentialsSecretName(db)
+
+	env := []corev1.EnvVar{
+		{
+			Name: instance.PodNameEnvVar,
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.name"},
+			},
+		},
+		{
+			Name: instance.PrimaryEnvVar,
+			ValueFrom: &corev1.EnvVarSource{
+				ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: replicationConfigMapName(db)},
+					Key:                  primaryKey,
+				},
+			},
+		},
+		{Name: instance.PrimaryHostEnvVar, Value: primaryHost(db)},
+		secretKeyEnv(instance.ReplicationUserEnvVar, secretName, replicationUsernameKey),
+		secretKeyEnv(instance.ReplicationPasswordEnvVar, secretName, replicationPasswordKey),
+	}
+
+	// init-instance needs PGDATA and the superuser (for pg_rewind) of the postgres container
+	initEnv := append(append([]corev1.EnvVar{}, postgres.Env...), env...)
+	var dataMounts []corev1.VolumeMount
+	for _, m := range postgres.VolumeMounts {
+		if m.Name == "data" {
+			dataMounts = append(dataMounts, m)
+		}
+	}
+	setContainer(&spec.InitContainers, corev1.Container{
+		Name:         "init-instance",
+		Image:        postgres.Image,
+		Command:      []string{agent.InstalledPath, "init-instance"},
+		Env:          initEnv,
+		VolumeMounts: dataMounts,
+	})
+
+	// psql in the sidecar connects to the local server as the superuser
+	sidecarEnv := append(env,
+		corev1.EnvVar{Name: "PGHOST", Value: "localhost"},
+		corev1.EnvVar{Name: "PGDATABASE", Value: "postgres"},
+		secretKeyEnv("PGUSER", secretName, "username"),
+		secretKeyEnv("PGPASSWORD", secretName, "password"),
+		secretKeyEnv(instance.APITokenEnvVar, secretName, apiTokenKey),
+	)
+	setContainer(&spec.Containers, corev1.Container{
+		Name:    "instance",
+		Image:   postgres.Image,
+		Command: []string{agent.InstalledPath, "instance"},
+		Env:     sidecarEnv,
+		Ports: []corev1.ContainerPort{{
+			Name:          "instance",
+			ContainerPort: instance.Port,
+			Protocol:      corev1.ProtocolTCP,
+		}},
+	})
+}
+
+// setContainer adds c to containers, or sets the fields the controller manages on the
+// container of the same name. Fields defaulted by the API server are kept, so an
+// unchanged Database doesn't update the StatefulSet.
+func setContainer(containers *[]corev1.Container, c corev1.Container) {
+	for i := range *containers {
+		existing := &(*containers)[i]
+		if existing.Name != c.Name {
+			continue
+		}
+		existing.Image = c.Image
+		existing.Command = c.Command
+		existing.Env = c.Env
+		existing.Ports = c.Ports
+		existing.VolumeMounts = c.VolumeMounts
+		return
+	}
+	*containers = append(*containers, c)
+}
+
+func secretKeyEnv(name, secretName, key string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+				Key:                  key,
+			},
+		},
+	}
+}
+
+// reconcileReplicationConfig creates what the pod template refers to: the replication
+// credentials, the instance API token and the ConfigMap naming the primary.
+// The NetworkPolicy in front of the instance API goes in place before the pods.
+func (r *DatabaseReconciler) reconcileReplicationConfig(ctx context.Context, db *databasev1.Database) error {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Name: agent.CredentialsSecretName(db), Namespace: db.Namespace}, secret); err != nil {
+		return err
+	}
+	patch := client.MergeFrom(secret.DeepCopy())
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	changed := false
+	for _, key := range []string{replicationPasswordKey, apiTokenKey} {
+		if _, ok := secret.Data[key]; ok {
+			continue
+		}
+		value, err := generatePassword(32)
+		if err != nil {
+			return fmt.Errorf("failed to generate %s: %w", key, err)
+		}
+		secret.Data[key] = []byte(value)
+		changed = true
+	}
+	if changed {
+		secret.Data[replicationUsernameKey] = []byte(replicationUser)
+		if err := r.Patch(ctx, secret, patch); err != nil {
+			return err
+		}
+	}
+
+	if err := r.reconcileInstancePolicy(ctx, db); err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      replicationConfigMapName(db),
+			Namespace: db.Namespace,
+		},
+		Data: map[string]string{primaryKey: podName(db, 0)},
+	}
+	if err := ctrl.SetControllerReference(db, cm, r.Scheme); err != nil {
+		return err
+	}
+	err := r.Get(ctx, client.ObjectKeyFromObject(cm), &corev1.ConfigMap{})
+	if errors.IsNotFound(err) {
+		return r.Create(ctx, cm)
+	}
+	return err
+}
+
+// instanceToken returns the token the instance API of db's pods requires
+func instanceToken(ctx context.Context, c client.Client, db *databasev1.Database) (string, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: agent.CredentialsSecretName(db), Namespace: db.Namespace}, secret); err != nil {
+		return "", err
+	}
+	token := string(secret.Data[apiTokenKey])
+	if token == "" {
+		return "", fmt.Errorf("secret %s has no %s", secret.Name, apiTokenKey)
+	}
+	return token, nil
+}
+
+// operatorNamespace returns the namespace the operator runs in, from OPERATOR_NAMESPACE
+// or the service account. It is empty when the operator runs outside the cluster.
+func operatorNamespace() string {
+	if ns := os.Getenv("OPERATOR_NAMESPACE"); ns != "" {
+		return ns
+	}
+	data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// reconcileInstancePolicy creates the <name>-instance NetworkPolicy. It admits PostgreSQL
+// connections from anywhere, but the instance API only from the operator's pods, which
+// kubebuilder labels control-plane=controller-manager. An operator running outside the
+// cluster can't be told apart, so there is no policy then.
+func (r *DatabaseReconciler) reconcileInstancePolicy(ctx context.Context, db *databasev1.Database) error {
+	namespace := operatorNamespace()
+	if namespace == "" {
+		ctrl.LoggerFrom(ctx).Info("Operator namespace unknown, not restricting the instance API", "database", db.Name)
+		return nil
+	}
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-instance", db.Name),
+			Namespace: db.Namespace,
+		},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, policy, func() error {
+		policy.Spec = networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: r.buildService(db).Spec.Selector},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					Ports: []networkingv1.NetworkPolicyPort{{
+						Protocol: ptr.To(corev1.ProtocolTCP),
+						Port:     ptr.To(intstr.FromInt32(5432)),
+					}},
+				},
+				{
+					Ports: []networkingv1.NetworkPolicyPort{{
+						Protocol: ptr.To(corev1.ProtocolTCP),
+						Port:     ptr.To(intstr.FromInt32(instance.Port)),
+					}},
+					From: []networkingv1.NetworkPolicyPeer{{
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{corev1.LabelMetadataName: namespace},
+						},
+						PodSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"control-plane": "controller-manager"},
+						},
+					}},
+				},
+			},
+		}
+		return ctrl.SetControllerReference(db, policy, r.Scheme)
+	})
+	return err
+}
+
+// currentPrimary returns the primary pod named in the replication ConfigMap
+func currentPrimary(ctx context.Context, c client.Client, db *databasev1.Database) (string, error) {
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(ctx, client.ObjectKey{Name: replicationConfigMapName(db), Namespace: db.Namespace}, cm); err != nil {
+		return "", err
+	}
+	if cm.Data[primaryKey] == "" {
+		return "", fmt.Errorf("configmap %s names no primary", cm.Name)
+	}
+	return cm.Data[primaryKey], nil
+}
+
+// reconcileReplication fails over from a failed primary, points the Services at the
+// primary and the standbys, labels the pods with their role and records the replication
+// state in the status. It requeues to keep the lag current.
+func (r *DatabaseReconciler) reconcileReplication(ctx context.Context, db *databasev1.Database) (ctrl.Result, error) {
+	primary, err := currentPrimary(ctx, r.Client, db)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	pods, err := r.databasePods(ctx, db)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Replace a failed primary first, the Services follow it (see failover.go)
+	primary, requeueAfter, err := r.reconcileFailover(ctx, db, primary, pods)
+	if err != nil {
+		if errors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if requeueAfter == 0 || requeueAfter > replicationStatusInterval {
+		requeueAfter = replicationStatusInterval
+	}
+	// A rollout waits for pods and Switchovers (see rolling-update.go)
+	if rollout := db.Status.Rollout; rollout != nil && rollout.Phase != rolloutCompleted && requeueAfter > rolloutPollInterval {
+		requeueAfter = rolloutPollInterval
+	}
+
+	if err := reconcileReadWriteService(ctx, r.Client, db, primary); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileReadOnlyService(ctx, db); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		switch {
+		case pod.Name == primary:
+			err = r.labelPod(ctx, pod, rolePrimary)
+		case pod.Labels[roleLabel] == rolePrimary || pod.Labels[roleLabel] == roleFenced:
+			// A former primary
+			err = r.fencePod(ctx, pod)
+		case pod.DeletionTimestamp == nil:
+			err = r.labelPod(ctx, pod, roleReplica)
+		}
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	replicas := r.replicationStatus(ctx, db, primary, pods)
+
+	// Re-read the Database so the status update doesn't conflict with earlier writes
+	latest := &databasev1.Database{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(db), latest); err != nil {
+		return ctrl.Result{}, err
+	}
+	readOnlyEndpoint := fmt.Sprintf("%s.%s.svc.cluster.local:5432", readOnlyServiceName(db), db.Namespace)
+	if latest.Status.Primary != primary ||
+		latest.Status.ReadOnlyEndpoint != readOnlyEndpoint ||
+		!equality.Semantic.DeepEqual(latest.Status.Replicas, replicas) {
+		latest.Status.Primary = primary
+		latest.Status.ReadOnlyEndpoint = readOnlyEndpoint
+		latest.Status.Replicas = replicas
+		if err := r.Status().Update(ctx, latest); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// reconcileReadWriteService makes the Service built by buildService select only the primary.
+// It only touches the pod name, so a restore can still take the Service out of service.
+func reconcileReadWriteService(ctx context.Context, c client.Client, db *databasev1.Database, primary string) error {
+	svc := &corev1.Service{}
+	err := c.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, svc)
+	if errors.IsNotFound(err) {
+		// reconcileService creates it
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if svc.Spec.Selector[appsv1.StatefulSetPodNameLabel] == primary {
+		return nil
+	}
+
+	ctrl.LoggerFrom(ctx).Info("Pointing read-write Service at primary", "service", svc.Name, "primary", primary)
+	patch := client.MergeFrom(svc.DeepCopy())
+	if svc.Spec.Selector == nil {
+		svc.Spec.Selector = map[string]string{}
+	}
+	svc.Spec.Selector[appsv1.StatefulSetPodNameLabel] = primary
+	return c.Patch(ctx, svc, patch)
+}
+
+// reconcileReadOnlyService creates the <name>-ro Service in front of the standbys
+func (r *DatabaseReconciler) reconcileReadOnlyService(ctx context.Context, db *databasev1.Database) error {
+	svc := r.buildService(db)
+	svc.Name = readOnlyServiceName(db)
+	svc.Spec.Selector[roleLabel] = roleReplica
+	if err := ctrl.SetControllerReference(db, svc, r.Scheme); err != nil {
+		return err
+	}
+
+	err := r.Get(ctx, client.ObjectKeyFromObject(svc), &corev1.Service{})
+	if errors.IsNotFound(err) {
+		return r.Create(ctx, svc)
+	}
+	return err
+}
+
+// databaseReplicas returns the number of pods of the Database
+func databaseReplicas(db *databasev1.Database) int32 {
+	if db.Spec.Replicas != nil {
+		return *db.Spec.Replicas
+	}
+	return 1
+}
+
+// databasePods returns the existing pods of the Database's StatefulSet, including those
+// above spec.replicas that are kept until the primary moved (see scaleDownHandover)
+func (r *DatabaseReconciler) databasePods(ctx context.Context, db *databasev1.Database) ([]corev1.Pod, error) {
+	replicas := databaseReplicas(db)
+	ss := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, ss); err == nil {
+		if ss.Spec.Replicas != nil && *ss.Spec.Replicas > replicas {
+			replicas = *ss.Spec.Replicas
+		}
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	// Backup and restore Jobs share the Database's labels, so the pods are looked up by name
+	var pods []corev1.Pod
+	for i := int32(0); i < replicas; i++ {
+		pod := corev1.Pod{}
+		err := r.Get(ctx, client.ObjectKey{Name: podName(db, i), Namespace: db.Namespace}, &pod)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// labelPod sets the role label the read-only Service selects on
+func (r *DatabaseReconciler) labelPod(ctx context.Context, pod *corev1.Pod, role string) error {
+	if pod.Labels[roleLabel] == role {
+		return nil
+	}
+	patch := client.MergeFrom(pod.DeepCopy())
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[roleLabel] = role
+	return r.Patch(ctx, pod, patch)
+}
+
+// replicationStatus asks the primary's instance sidecar for the state of every standby
+// and drops the replication slots of standbys that were scaled away. A standby the
+// primary doesn't know is Disconnected; all are Unknown if the primary can't be reached.
+func (r *DatabaseReconciler) replicationStatus(ctx context.Context, db *databasev1.Database, primary string, pods []corev1.Pod) []databasev1.ReplicaStatus {
+	log := ctrl.LoggerFrom(ctx)
+
+	token, err := instanceToken(ctx, r.Client, db)
+	if err != nil {
+		log.Info("Failed to get instance API token", "error", err.Error())
+	}
+
+	var status *instance.Status
+	for _, pod := range pods {
+		if pod.Name != primary || pod.Status.PodIP == "" || token == "" {
+			continue
+		}
+		var err error
+		if status, err = instance.GetStatus(ctx, pod.Status.PodIP, token); err != nil {
+			log.Info("Failed to get replication status", "pod", pod.Name, "error", err.Error())
+			break
+		}
+
+		slots := map[string]bool{}
+		for i := int32(0); i < databaseReplicas(db); i++ {
+			slots[instance.SlotName(podName(db, i))] = true
+		}
+		for _, slot := range status.Slots {
+			if slots[slot] {
+				continue
+			}
+			log.Info("Dropping replication slot of removed standby", "pod", pod.Name, "slot", slot)
+			if err := instance.DropSlot(ctx, pod.Status.PodIP, token, slot); err != nil {
+				log.Info("Failed to drop replication slot", "slot", slot, "error", err.Error())
+			}
+		}
+	}
+
+	var replicas []databasev1.ReplicaStatus
+	for _, pod := range pods {
+		if pod.Name == primary {
+			continue
+		}
+		replica := databasev1.ReplicaStatus{Name: pod.Name, State: "Unknown"}
+		if status != nil {
+			replica.State = "Disconnected"
+			for _, standby := range status.Standbys {
+				if standby.Name == pod.Name {
+					replica.State = standby.State
+					replica.LagBytes = standby.LagBytes
+				}
+			}
+		}
+		replicas = append(replicas, replica)
+	}
+	return replicas
+}
@@ -11,19 +11,33 @@ package controller
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 
 	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/agent"
+	backupPkg "github.com/example/postgres-operator/internal/backup"
+	"github.com/example/postgres-operator/internal/conditions"
+	"github.com/example/postgres-operator/internal/phase"
 	restorePkg "github.com/example/postgres-operator/internal/restore"
 )
 
+// restoreFinalizer puts the Database back into service when a Restore is deleted
+// while it has the Database out of service
+const restoreFinalizer = "database.example.com/restore"
+
 // RestoreReconciler reconciles a Restore object
 type RestoreReconciler struct {
 	client.Client
@@ -33,29 +47,111 @@ type RestoreReconciler struct {
 // +kubebuilder:rbac:groups=database.example.com,resources=restores,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=database.example.com,resources=restores/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=database.example.com,resources=restores/finalizers,verbs=update
-// +kubebuilder:rbac:groups=database.example.com,resources=databases,verbs=get;list;watch
+// +kubebuilder:rbac:groups=database.example.com,resources=databases,verbs=get;list;watch;create;patch
 // +kubebuilder:rbac:groups=database.example.com,resources=backups,verbs=get;list;watch
+// +kubebuilder:rbac:groups=database.example.com,resources=backupartifacts,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=list;delete
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *RestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	log := ctrl.LoggerFrom(ctx)
-
 	rst := &databasev1.Restore{}
 	if err := r.Get(ctx, req.NamespacedName, rst); err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// Skip if already completed
-	if rst.Status.Phase == "Completed" {
-		return ctrl.Result{}, nil
+	// Undo the quiesce and stop of a restore that is deleted before it finished
+	if !rst.DeletionTimestamp.IsZero() {
+		return r.handleDeletion(ctx, rst)
+	}
+	if !controllerutil.ContainsFinalizer(rst, restoreFinalizer) {
+		controllerutil.AddFinalizer(rst, restoreFinalizer)
+		if err := r.Update(ctx, rst); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
 	}
 
-	// Skip if failed (don't retry failed restores automatically)
-	// Users can delete and recreate the Restore resource if they want to retry
-	if rst.Status.Phase == "Failed" {
-		log.Info("Restore failed, skipping reconciliation", "restore", rst.Name)
-		return ctrl.Result{}, nil
+	// The Secrets copied from another namespace only live while the Job needs them
+	if err := r.releaseSecretCopies(ctx, rst); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result, err := r.phases(req).Step(ctx, rst)
+	if err != nil || result.Requeue && result.Transition == nil {
+		// A conflicting handler left a stale Restore behind
+		return result.Result, err
+	}
+	// The handlers persist their own transitions, the engine's (out of an unknown phase) are left.
+	// Once the phase went through, it has acted on the spec generation.
+	if result.Transition != nil || rst.Status.ObservedGeneration != rst.Generation {
+		rst.Status.ObservedGeneration = rst.Generation
+		if err := r.Status().Update(ctx, rst); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+	}
+	return result.Result, nil
+}
+
+// phases declares the phases of a Restore. The handlers record their transitions
+// themselves, together with the attempt, Job and downtime fields.
+func (r *RestoreReconciler) phases(req ctrl.Request) *phase.Machine[*databasev1.Restore] {
+	restore := func(ctx context.Context, rst *databasev1.Restore) (phase.Outcome, error) {
+		return phase.Recorded(r.reconcileRestore(ctx, req, rst))
+	}
+	return &phase.Machine[*databasev1.Restore]{
+		Initial: "Pending",
+		Phases: []phase.Phase[*databasev1.Restore]{
+			// Completed when a reconcile on a stale cache finds the restore already done
+			{Name: "Pending", Handle: restore, Next: []string{"InProgress", "Completed", "Failed"}},
+			// An InProgress restore checks on its Job
+			{Name: "InProgress", Handle: restore, Next: []string{"Completed", "Failed", "Pending"}},
+			{
+				// Skip if already completed, there is nothing to retry
+				Name: "Completed",
+				Handle: func(ctx context.Context, rst *databasev1.Restore) (phase.Outcome, error) {
+					return phase.Outcome{}, clearRetryRequest(ctx, r.Client, rst)
+				},
+			},
+			{
+				Name: "Failed",
+				Handle: func(ctx context.Context, rst *databasev1.Restore) (phase.Outcome, error) {
+					return phase.Recorded(r.retryRestore(ctx, req, rst))
+				},
+				Next: []string{"Pending", "InProgress", "Completed"},
+			},
+		},
+		Current: func(rst *databasev1.Restore) string { return rst.Status.Phase },
+		Enter:   func(rst *databasev1.Restore, t phase.Transition) { rst.Status.Phase = t.To },
+	}
+}
+
+// retryRestore starts another attempt of a Failed restore when the retry policy
+// or the retry annotation says so
+func (r *RestoreReconciler) retryRestore(ctx context.Context, req ctrl.Request, rst *databasev1.Restore) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	if !retryRequested(rst) {
+		due, wait := retryDue(rst.Status.NextRetryTime)
+		if !due {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+		log.Info("Retrying failed restore", "restore", rst.Name, "attempt", rst.Status.Attempts+1)
 	}
+	return r.reconcileRestore(ctx, req, rst)
+}
+
+// reconcileRestore restores once the Database and the backup are ready
+func (r *RestoreReconciler) reconcileRestore(ctx context.Context, req ctrl.Request, rst *databasev1.Restore) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
 
 	// Get Database
 	db := &databasev1.Database{}
@@ -63,6 +159,9 @@ func (r *RestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		Name:      rst.Spec.DatabaseRef.Name,
 		Namespace: rst.Namespace,
 	}, db)
+	if errors.IsNotFound(err) && rst.Spec.DatabaseTemplate != nil {
+		return r.createDatabase(ctx, req, rst)
+	}
 	if errors.IsNotFound(err) {
 		log.Info("Database not found, waiting", "database", rst.Spec.DatabaseRef.Name)
 		// Re-read restore to ensure we have the latest version
@@ -70,12 +169,7 @@ func (r *RestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			return ctrl.Result{}, getErr
 		}
 		rst.Status.Phase = "Pending"
-		meta.SetStatusCondition(&rst.Status.Conditions, metav1.Condition{
-			Type:    "RestoreReady",
-			Status:  metav1.ConditionFalse,
-			Reason:  "DatabaseNotFound",
-			Message: fmt.Sprintf("Waiting for database %s to be created", rst.Spec.DatabaseRef.Name),
-		})
+		conditions.MarkReconciling(rst, "DatabaseNotFound", fmt.Sprintf("Waiting for database %s to be created", rst.Spec.DatabaseRef.Name))
 		if updateErr := r.Status().Update(ctx, rst); updateErr != nil {
 			if errors.IsConflict(updateErr) {
 				return ctrl.Result{Requeue: true}, nil
@@ -88,20 +182,35 @@ func (r *RestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, err
 	}
 
-	// Check if database is ready
-	if db.Status.Phase != "Ready" {
+	// A clone must never overwrite a Database that existed before the Restore
+	if rst.Spec.DatabaseTemplate != nil && db.Annotations[databasev1.CreatedByRestoreAnnotation] != rst.Name {
+		log.Info("Database already exists, refusing to overwrite it", "database", db.Name)
+		// Re-read restore to ensure we have the latest version
+		if getErr := r.Get(ctx, req.NamespacedName, rst); getErr != nil {
+			return ctrl.Result{}, getErr
+		}
+		rst.Status.Phase = "Failed"
+		conditions.MarkStalled(rst, "DatabaseExists", fmt.Sprintf("Database %s already exists and was not created by this restore", db.Name))
+		if updateErr := r.Status().Update(ctx, rst); updateErr != nil {
+			if errors.IsConflict(updateErr) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, updateErr
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Check if database is ready.
+	// A physical restore in progress has stopped the database itself.
+	if !databaseReady(db) && rst.Status.Phase != "InProgress" {
 		log.Info("Database not ready, waiting", "database", db.Name, "phase", db.Status.Phase)
 		// Re-read restore to ensure we have the latest version
 		if getErr := r.Get(ctx, req.NamespacedName, rst); getErr != nil {
 			return ctrl.Result{}, getErr
 		}
 		rst.Status.Phase = "Pending"
-		meta.SetStatusCondition(&rst.Status.Conditions, metav1.Condition{
-			Type:    "RestoreReady",
-			Status:  metav1.ConditionFalse,
-			Reason:  "DatabaseNotReady",
-			Message: fmt.Sprintf("Waiting for database %s to be ready (current phase: %s)", db.Name, db.Status.Phase),
-		})
+		conditions.MarkReconciling(rst, "DatabaseNotReady",
+			fmt.Sprintf("Waiting for database %s to be ready (current phase: %s)", db.Name, db.Status.Phase))
 		if updateErr := r.Status().Update(ctx, rst); updateErr != nil {
 			if errors.IsConflict(updateErr) {
 				return ctrl.Result{Requeue: true}, nil
@@ -111,25 +220,39 @@ func (r *RestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
 	}
 
-	// Get Backup
-	backup := &databasev1.Backup{}
-	err = r.Get(ctx, client.ObjectKey{
-		Name:      rst.Spec.BackupRef.Name,
-		Namespace: rst.Namespace,
-	}, backup)
+	// A Backup or BackupArtifact in another namespace needs a ReferenceGrant there
+	ref := restoreSourceReference(rst)
+	allowed, err := referenceAllowed(ctx, r.Client, ref)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !allowed {
+		log.Info("Reference to backup not permitted, waiting for a ReferenceGrant", "backup", ref.toName, "namespace", ref.toNamespace)
+		// Re-read restore to ensure we have the latest version
+		if getErr := r.Get(ctx, req.NamespacedName, rst); getErr != nil {
+			return ctrl.Result{}, getErr
+		}
+		rst.Status.Phase = "Pending"
+		conditions.MarkStalled(rst, reasonReferenceNotPermitted, ref.String())
+		if updateErr := r.Status().Update(ctx, rst); updateErr != nil {
+			if errors.IsConflict(updateErr) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, updateErr
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Get Backup (or the BackupArtifact standing in for it)
+	backup, err := r.restoreSource(ctx, rst)
 	if errors.IsNotFound(err) {
-		log.Info("Backup not found, waiting", "backup", rst.Spec.BackupRef.Name)
+		log.Info("Backup not found, waiting", "backup", restoreSourceName(rst))
 		// Re-read restore to ensure we have the latest version
 		if getErr := r.Get(ctx, req.NamespacedName, rst); getErr != nil {
 			return ctrl.Result{}, getErr
 		}
 		rst.Status.Phase = "Pending"
-		meta.SetStatusCondition(&rst.Status.Conditions, metav1.Condition{
-			Type:    "RestoreReady",
-			Status:  metav1.ConditionFalse,
-			Reason:  "BackupNotFound",
-			Message: fmt.Sprintf("Waiting for backup %s to be created", rst.Spec.BackupRef.Name),
-		})
+		conditions.MarkReconciling(rst, "BackupNotFound", fmt.Sprintf("Waiting for %s to be created", restoreSourceName(rst)))
 		if updateErr := r.Status().Update(ctx, rst); updateErr != nil {
 			if errors.IsConflict(updateErr) {
 				return ctrl.Result{Requeue: true}, nil
@@ -150,12 +273,8 @@ func (r *RestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			return ctrl.Result{}, getErr
 		}
 		rst.Status.Phase = "Pending"
-		meta.SetStatusCondition(&rst.Status.Conditions, metav1.Condition{
-			Type:    "RestoreReady",
-			Status:  metav1.ConditionFalse,
-			Reason:  "BackupNotCompleted",
-			Message: fmt.Sprintf("Waiting for backup %s to complete (current phase: %s)", backup.Name, backup.Status.Phase),
-		})
+		conditions.MarkReconciling(rst, "BackupNotCompleted",
+			fmt.Sprintf("Waiting for backup %s to complete (current phase: %s)", backup.Name, backup.Status.Phase))
 		if updateErr := r.Status().Update(ctx, rst); updateErr != nil {
 			if errors.IsConflict(updateErr) {
 				return ctrl.Result{Requeue: true}, nil
@@ -165,10 +284,102 @@ func (r *RestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
 	}
 
+	// The restore Job runs next to the Restore, with copies of the Secrets of a
+	// backup in another namespace
+	backup, err = r.useSecretCopies(ctx, rst, backup)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// Perform restore
 	return r.performRestore(ctx, req, db, backup, rst)
 }
 
+// restoreSource returns the Backup to restore from. A BackupArtifact is turned into
+// the equivalent completed Backup, so the restore works the same for both.
+func (r *RestoreReconciler) restoreSource(ctx context.Context, rst *databasev1.Restore) (*databasev1.Backup, error) {
+	ref := restoreSourceReference(rst)
+	if rst.Spec.ArtifactRef != nil {
+		artifact := &databasev1.BackupArtifact{}
+		if err := r.Get(ctx, client.ObjectKey{Name: ref.toName, Namespace: ref.toNamespace}, artifact); err != nil {
+			return nil, err
+		}
+		return backupPkg.BackupFromArtifact(artifact), nil
+	}
+
+	if rst.Spec.BackupRef == nil {
+		return nil, fmt.Errorf("restore %s has neither backupRef nor artifactRef", rst.Name)
+	}
+	backup := &databasev1.Backup{}
+	if err := r.Get(ctx, client.ObjectKey{Name: ref.toName, Namespace: ref.toNamespace}, backup); err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+// restoreSourceReference returns the reference from rst to what it restores from
+func restoreSourceReference(rst *databasev1.Restore) crossNamespaceReference {
+	ref := crossNamespaceReference{
+		fromKind:      "Restore",
+		fromNamespace: rst.Namespace,
+		toKind:        "Backup",
+	}
+	source := rst.Spec.BackupRef
+	if rst.Spec.ArtifactRef != nil {
+		ref.toKind = "BackupArtifact"
+		source = rst.Spec.ArtifactRef
+	}
+	if source != nil {
+		ref.toName = source.Name
+		ref.toNamespace = source.Namespace
+	}
+	if ref.toNamespace == "" {
+		ref.toNamespace = rst.Namespace
+	}
+	return ref
+}
+
+// restoreSourceName describes what rst restores from, for messages
+func restoreSourceName(rst *databasev1.Restore) string {
+	ref := restoreSourceReference(rst)
+	name := ref.toName
+	if ref.toNamespace != rst.Namespace {
+		name = ref.toNamespace + "/" + name
+	}
+	if rst.Spec.ArtifactRef != nil {
+		return fmt.Sprintf("backup artifact %s", name)
+	}
+	if rst.Spec.BackupRef != nil {
+		return fmt.Sprintf("backup %s", name)
+	}
+	return "backup"
+}
+
+// useSecretCopies copies the storage and encryption Secrets of a backup in another
+// namespace next to rst, and returns a copy of backup that refers to them
+func (r *RestoreReconciler) useSecretCopies(ctx context.Context, rst *databasev1.Restore, backup *databasev1.Backup) (*databasev1.Backup, error) {
+	if backup.Namespace == rst.Namespace {
+		return backup, nil
+	}
+
+	backup = backup.DeepCopy()
+	if ref := backup.Spec.StorageSecretRef; ref != nil {
+		name := fmt.Sprintf("%s-storage", rst.Name)
+		if err := copySecret(ctx, r.Client, r.Scheme, rst, backup.Namespace, ref.Name, name); err != nil {
+			return nil, err
+		}
+		backup.Spec.StorageSecretRef = &corev1.LocalObjectReference{Name: name}
+	}
+	if enc := backup.Spec.Encryption; enc != nil {
+		name := fmt.Sprintf("%s-encryption", rst.Name)
+		if err := copySecret(ctx, r.Client, r.Scheme, rst, backup.Namespace, enc.SecretRef.Name, name); err != nil {
+			return nil, err
+		}
+		enc.SecretRef.Name = name
+	}
+	return backup, nil
+}
+
 func (r *RestoreReconciler) performRestore(ctx context.Context, req ctrl.Request, db *databasev1.Database, backup *databasev1.Backup, rst *databasev1.Restore) (ctrl.Result, error) {
 	log := ctrl.LoggerFrom(ctx)
 
@@ -182,24 +393,25 @@ func (r *RestoreReconciler) performRestore(ctx context.Context, req ctrl.Request
 		log.Info("Restore already completed, skipping", "restore", rst.Name)
 		return ctrl.Result{}, nil
 	}
-	// Note: We don't skip InProgress here because the restore operation might have completed
-	// but failed to update status due to a conflict. We'll proceed to check/retry.
+	// Note: We don't skip InProgress here, an InProgress restore checks on its Job below
 
-	// Update status to in progress (only if not already InProgress)
+	// Update status to in progress (only if not already InProgress).
+	// Each attempt has its own Job and downtime window.
 	if rst.Status.Phase != "InProgress" {
 		rst.Status.Phase = "InProgress"
-		meta.SetStatusCondition(&rst.Status.Conditions, metav1.Condition{
-			Type:    "RestoreReady",
-			Status:  metav1.ConditionFalse,
-			Reason:  "RestoreInProgress",
-			Message: "Restore in progress",
-		})
+		rst.Status.Attempts++
+		rst.Status.NextRetryTime = nil
+		rst.Status.Downtime = nil
+		conditions.MarkReconciling(rst, "RestoreInProgress", "Restore in progress")
 		if err := r.Status().Update(ctx, rst); err != nil {
 			if errors.IsConflict(err) {
 				return ctrl.Result{Requeue: true}, nil
 			}
 			return ctrl.Result{}, err
 		}
+		if err := clearRetryRequest(ctx, r.Client, rst); err != nil {
+			return ctrl.Result{}, err
+		}
 		// Re-read after updating to get latest version
 		if err := r.Get(ctx, req.NamespacedName, rst); err != nil {
 			return ctrl.Result{}, err
@@ -214,12 +426,7 @@ func (r *RestoreReconciler) performRestore(ctx context.Context, req ctrl.Request
 			return ctrl.Result{}, getErr
 		}
 		rst.Status.Phase = "Failed"
-		meta.SetStatusCondition(&rst.Status.Conditions, metav1.Condition{
-			Type:    "RestoreReady",
-			Status:  metav1.ConditionFalse,
-			Reason:  "BackupLocationMissing",
-			Message: err.Error(),
-		})
+		conditions.MarkStalled(rst, "BackupLocationMissing", err.Error())
 		if updateErr := r.Status().Update(ctx, rst); updateErr != nil {
 			if errors.IsConflict(updateErr) {
 				return ctrl.Result{Requeue: true}, nil
@@ -229,30 +436,101 @@ func (r *RestoreReconciler) performRestore(ctx context.Context, req ctrl.Request
 		return ctrl.Result{}, err
 	}
 
-	// Perform actual restore using restore package
-	// Note: PerformRestore requires k8sClient to retrieve password from Secret
-	err := restorePkg.PerformRestore(ctx, r.Client, db, backup.Status.BackupLocation)
-	if err != nil {
-		log.Error(err, "Restore failed", "database", db.Name, "backup", backup.Name)
+	if err := restorePkg.Validate(db, rst, backup); err != nil {
 		// Re-read restore before updating status on error
 		if getErr := r.Get(ctx, req.NamespacedName, rst); getErr != nil {
 			return ctrl.Result{}, getErr
 		}
 		rst.Status.Phase = "Failed"
-		meta.SetStatusCondition(&rst.Status.Conditions, metav1.Condition{
-			Type:    "RestoreReady",
-			Status:  metav1.ConditionFalse,
-			Reason:  "RestoreFailed",
-			Message: err.Error(),
-		})
+		conditions.MarkStalled(rst, "InvalidRestore", err.Error())
 		if updateErr := r.Status().Update(ctx, rst); updateErr != nil {
 			if errors.IsConflict(updateErr) {
 				return ctrl.Result{Requeue: true}, nil
 			}
 			return ctrl.Result{}, updateErr
 		}
+		return ctrl.Result{}, nil
+	}
+
+	// Record when the database goes out of service, ensureRestoreJob takes it out
+	if rst.Status.Downtime == nil {
+		rst.Status.Downtime = &databasev1.DowntimeWindow{Start: metav1.Now()}
+		if err := r.Status().Update(ctx, rst); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+		// Re-read after updating to get latest version
+		if err := r.Get(ctx, req.NamespacedName, rst); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Start the restore Job, or check on the one started by an earlier reconcile
+	job, err := r.ensureRestoreJob(ctx, db, backup, rst)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
+	if job == nil {
+		log.Info("Waiting for database to stop", "restore", rst.Name, "database", db.Name)
+		return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+	}
+
+	// Job status changes trigger a reconcile because the Restore owns the Job
+	finished, succeeded, message := agent.JobFinished(job)
+	if !finished {
+		log.Info("Restore job running", "restore", rst.Name, "job", job.Name)
+		return ctrl.Result{}, nil
+	}
+
+	// Bring the database back into service, whether the restore succeeded or not.
+	// A failed restore is rolled back (logical) or leaves the old data directory in place (physical).
+	if restorePkg.IsPhysical(backup) {
+		if succeeded {
+			if err := r.dropStandbyData(ctx, db, rst); err != nil {
+				return ctrl.Result{}, fmt.Errorf("failed to drop standby data: %w", err)
+			}
+		}
+		if err := r.startDatabase(ctx, db, rst); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to start database: %w", err)
+		}
+	}
+	if err := r.resumeService(ctx, db, rst); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to resume database service: %w", err)
+	}
+	downtimeEnd := metav1.Now()
+
+	if !succeeded {
+		err := fmt.Errorf("restore job %s failed: %s", job.Name, message)
+		log.Error(err, "Restore failed", "database", db.Name, "backup", backup.Name, "attempt", rst.Status.Attempts)
+		// Re-read restore before updating status on error
+		if getErr := r.Get(ctx, req.NamespacedName, rst); getErr != nil {
+			return ctrl.Result{}, getErr
+		}
+		rst.Status.Phase = "Failed"
+		rst.Status.LastFailureReason = err.Error()
+		setDowntimeEnd(rst, downtimeEnd)
+		message := fmt.Sprintf("Attempt %d failed: %s", rst.Status.Attempts, err)
+		delay, retry := retryDelay(rst.Spec.RetryPolicy, rst.Status.Attempts)
+		if retry {
+			next := metav1.NewTime(time.Now().Add(delay))
+			rst.Status.NextRetryTime = &next
+			message += fmt.Sprintf(", retrying at %s", next.Format(time.RFC3339))
+		}
+		if retry {
+			conditions.MarkReconciling(rst, "RestoreFailed", message)
+		} else {
+			conditions.MarkStalled(rst, "RestoreFailed", message)
+		}
+		if updateErr := r.Status().Update(ctx, rst); updateErr != nil {
+			if errors.IsConflict(updateErr) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, updateErr
+		}
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
 
 	// Re-read restore before final status update
 	if err := r.Get(ctx, req.NamespacedName, rst); err != nil {
@@ -261,14 +539,13 @@ func (r *RestoreReconciler) performRestore(ctx context.Context, req ctrl.Request
 
 	// Update status to completed
 	rst.Status.Phase = "Completed"
-	now := metav1.Now()
-	rst.Status.RestoreTime = &now
-	meta.SetStatusCondition(&rst.Status.Conditions, metav1.Condition{
-		Type:    "RestoreReady",
-		Status:  metav1.ConditionTrue,
-		Reason:  "RestoreCompleted",
-		Message: "Restore completed successfully",
-	})
+	restoreTime := metav1.Now()
+	if job.Status.CompletionTime != nil {
+		restoreTime = *job.Status.CompletionTime
+	}
+	rst.Status.RestoreTime = &restoreTime
+	setDowntimeEnd(rst, downtimeEnd)
+	conditions.MarkReady(rst, "RestoreCompleted", "Restore completed successfully")
 
 	log.Info("Restore completed", "database", db.Name, "backup", backup.Name)
 	if err := r.Status().Update(ctx, rst); err != nil {
@@ -281,10 +558,301 @@ func (r *RestoreReconciler) performRestore(ctx context.Context, req ctrl.Request
 	return ctrl.Result{}, nil
 }
 
+// ensureRestoreJob takes the database out of service and returns the restore Job,
+// creating it on the first call. The Job is owned by the Restore so it is cleaned up with it.
+// For physical restores it returns nil until the database has stopped.
+func (r *RestoreReconciler) ensureRestoreJob(ctx context.Context, db *databasev1.Database, backup *databasev1.Backup, rst *databasev1.Restore) (*batchv1.Job, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{
+		Name:      restorePkg.JobName(rst),
+		Namespace: rst.Namespace,
+	}, job)
+	if err == nil || !errors.IsNotFound(err) {
+		return job, err
+	}
+
+	if err := r.quiesceService(ctx, db, rst); err != nil {
+		return nil, fmt.Errorf("failed to quiesce database service: %w", err)
+	}
+
+	var host string
+	if restorePkg.IsPhysical(backup) {
+		stopped, err := r.stopDatabase(ctx, db, rst)
+		if err != nil || !stopped {
+			return nil, err
+		}
+	} else if host, err = r.databaseHost(ctx, db); err != nil {
+		return nil, err
+	}
+
+	job, err = restorePkg.NewRestoreJob(db, rst, backup, host)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctrl.SetControllerReference(rst, job, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	log.Info("Creating restore job", "restore", rst.Name, "job", job.Name, "location", backup.Status.BackupLocation)
+	if err := r.Create(ctx, job); err != nil && !errors.IsAlreadyExists(err) {
+		return nil, fmt.Errorf("failed to create restore job: %w", err)
+	}
+	return job, nil
+}
+
+// quiesceService points the Database's Service at no pods, so clients can't reach the
+// database while it is restored. Established sessions are terminated by the restore Job.
+// The Database controller only creates the Service and leaves the selector alone.
+func (r *RestoreReconciler) quiesceService(ctx context.Context, db *databasev1.Database, rst *databasev1.Restore) error {
+	svc := &corev1.Service{}
+	if err := r.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, svc); err != nil {
+		return err
+	}
+	if _, ok := svc.Spec.Selector[restorePkg.QuiescedLabel]; ok {
+		return nil
+	}
+
+	ctrl.LoggerFrom(ctx).Info("Taking database out of service for restore", "database", db.Name, "service", svc.Name)
+	patch := client.MergeFrom(svc.DeepCopy())
+	if svc.Spec.Selector == nil {
+		svc.Spec.Selector = map[string]string{}
+	}
+	svc.Spec.Selector[restorePkg.QuiescedLabel] = rst.Name
+	return r.Patch(ctx, svc, patch)
+}
+
+// resumeService restores the Service selector changed by quiesceService for rst
+func (r *RestoreReconciler) resumeService(ctx context.Context, db *databasev1.Database, rst *databasev1.Restore) error {
+	svc := &corev1.Service{}
+	if err := r.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, svc); err != nil {
+		return err
+	}
+	if owner, ok := svc.Spec.Selector[restorePkg.QuiescedLabel]; !ok || owner != rst.Name {
+		return nil
+	}
+
+	ctrl.LoggerFrom(ctx).Info("Putting database back into service", "database", db.Name, "service", svc.Name)
+	patch := client.MergeFrom(svc.DeepCopy())
+	delete(svc.Spec.Selector, restorePkg.QuiescedLabel)
+	return r.Patch(ctx, svc, patch)
+}
+
+// databaseHost returns the IP of the Database's primary pod. The restore Job connects
+// to it directly because the Service has no endpoints while the database is quiesced.
+func (r *RestoreReconciler) databaseHost(ctx context.Context, db *databasev1.Database) (string, error) {
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, client.ObjectKey{Name: restorePkg.PrimaryPod(db), Namespace: db.Namespace}, pod); err != nil {
+		return "", fmt.Errorf("failed to get database pod: %w", err)
+	}
+	if pod.Status.PodIP == "" {
+		return "", fmt.Errorf("database pod %s has no IP yet", pod.Name)
+	}
+	return pod.Status.PodIP, nil
+}
+
+// setDowntimeEnd closes the Restore's downtime window
+func setDowntimeEnd(rst *databasev1.Restore, end metav1.Time) {
+	if rst.Status.Downtime == nil {
+		rst.Status.Downtime = &databasev1.DowntimeWindow{Start: end}
+	}
+	if rst.Status.Downtime.End == nil {
+		rst.Status.Downtime.End = &end
+	}
+}
+
+// createDatabase creates the Database described by the Restore's DatabaseTemplate.
+// The Restore waits for it to become Ready like for any other Database.
+func (r *RestoreReconciler) createDatabase(ctx context.Context, req ctrl.Request, rst *databasev1.Restore) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	db := &databasev1.Database{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      rst.Spec.DatabaseRef.Name,
+			Namespace: rst.Namespace,
+			Labels:    rst.Spec.DatabaseTemplate.Labels,
+			Annotations: map[string]string{
+				databasev1.CreatedByRestoreAnnotation: rst.Name,
+			},
+		},
+		Spec: *rst.Spec.DatabaseTemplate.Spec.DeepCopy(),
+	}
+
+	log.Info("Creating database for restore", "restore", rst.Name, "database", db.Name)
+	if err := r.Create(ctx, db); err != nil && !errors.IsAlreadyExists(err) {
+		return ctrl.Result{}, fmt.Errorf("failed to create database: %w", err)
+	}
+
+	// Re-read restore to ensure we have the latest version
+	if err := r.Get(ctx, req.NamespacedName, rst); err != nil {
+		return ctrl.Result{}, err
+	}
+	rst.Status.Phase = "Pending"
+	conditions.MarkReconciling(rst, "CreatingDatabase", fmt.Sprintf("Creating database %s from the template", db.Name))
+	if err := r.Status().Update(ctx, rst); err != nil {
+		if errors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+}
+
+// stopDatabase scales the Database's StatefulSet to zero so a physical restore can
+// replace its data directory. The RestoreInProgressAnnotation keeps the Database
+// controller from scaling it back up. It reports whether all pods are gone.
+func (r *RestoreReconciler) stopDatabase(ctx context.Context, db *databasev1.Database, rst *databasev1.Restore) (bool, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	if owner, ok := db.Annotations[databasev1.RestoreInProgressAnnotation]; ok && owner != rst.Name {
+		log.Info("Another restore is in progress", "database", db.Name, "restore", owner)
+		return false, nil
+	}
+	if _, ok := db.Annotations[databasev1.RestoreInProgressAnnotation]; !ok {
+		patch := client.MergeFrom(db.DeepCopy())
+		if db.Annotations == nil {
+			db.Annotations = map[string]string{}
+		}
+		db.Annotations[databasev1.RestoreInProgressAnnotation] = rst.Name
+		if err := r.Patch(ctx, db, patch); err != nil {
+			return false, err
+		}
+	}
+
+	ss := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, ss); err != nil {
+		return false, err
+	}
+	if ss.Spec.Replicas == nil || *ss.Spec.Replicas != 0 {
+		log.Info("Stopping database for physical restore", "database", db.Name)
+		patch := client.MergeFrom(ss.DeepCopy())
+		ss.Spec.Replicas = ptr.To(int32(0))
+		return false, r.Patch(ctx, ss, patch)
+	}
+	return ss.Status.Replicas == 0, nil
+}
+
+// dropStandbyData deletes the data volumes of every pod but the primary, which a physical
+// restore replaced. The standbys' data is from after the restore target and from the old
+// timeline, so they couldn't follow the restored primary; the StatefulSet creates new
+// volumes and the standbys clone the primary into them (see instance-init.go).
+// It only acts while rst keeps the database stopped.
+func (r *RestoreReconciler) dropStandbyData(ctx context.Context, db *databasev1.Database, rst *databasev1.Restore) error {
+	if owner, ok := db.Annotations[databasev1.RestoreInProgressAnnotation]; !ok || owner != rst.Name {
+		return nil
+	}
+
+	var pvcs corev1.PersistentVolumeClaimList
+	if err := r.List(ctx, &pvcs, client.InNamespace(db.Namespace)); err != nil {
+		return err
+	}
+	primary := restorePkg.DataClaimName(db)
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		pod, ok := strings.CutPrefix(pvc.Name, "data-")
+		if !ok || pvc.Name == primary || pvc.DeletionTimestamp != nil {
+			continue
+		}
+		if _, ok := podOrdinal(db, pod); !ok {
+			continue
+		}
+		ctrl.LoggerFrom(ctx).Info("Deleting standby data volume", "database", db.Name, "pvc", pvc.Name)
+		if err := r.Delete(ctx, pvc); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startDatabase scales the StatefulSet stopped by rst back up and hands it back to
+// the Database controller
+func (r *RestoreReconciler) startDatabase(ctx context.Context, db *databasev1.Database, rst *databasev1.Restore) error {
+	if owner, ok := db.Annotations[databasev1.RestoreInProgressAnnotation]; !ok || owner != rst.Name {
+		return nil
+	}
+
+	ss := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, ss); err != nil {
+		return err
+	}
+	replicas := ptr.To(int32(1))
+	if db.Spec.Replicas != nil {
+		replicas = db.Spec.Replicas
+	}
+	patch := client.MergeFrom(ss.DeepCopy())
+	ss.Spec.Replicas = replicas
+	if err := r.Patch(ctx, ss, patch); err != nil {
+		return err
+	}
+
+	patch = client.MergeFrom(db.DeepCopy())
+	delete(db.Annotations, databasev1.RestoreInProgressAnnotation)
+	return r.Patch(ctx, db, patch)
+}
+
+// handleDeletion brings the Database back into service if the deleted Restore took it
+// out, and then removes the finalizer. A running restore Job is deleted and waited
+// for first, so it can't write to the data volume once PostgreSQL starts again.
+func (r *RestoreReconciler) handleDeletion(ctx context.Context, rst *databasev1.Restore) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	if !controllerutil.ContainsFinalizer(rst, restoreFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	job := &batchv1.Job{}
+	err := r.Get(ctx, client.ObjectKey{Name: restorePkg.JobName(rst), Namespace: rst.Namespace}, job)
+	if client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, err
+	}
+	if err == nil {
+		if finished, _, _ := agent.JobFinished(job); !finished {
+			log.Info("Deleting running restore job", "restore", rst.Name, "job", job.Name)
+			// Foreground deletion keeps the Job until its pods are gone
+			if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationForeground)); client.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
+	}
+
+	db := &databasev1.Database{}
+	err = r.Get(ctx, client.ObjectKey{Name: rst.Spec.DatabaseRef.Name, Namespace: rst.Namespace}, db)
+	if client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, err
+	}
+	if err == nil && db.DeletionTimestamp.IsZero() {
+		if err := r.startDatabase(ctx, db, rst); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to start database: %w", err)
+		}
+		if err := r.resumeService(ctx, db, rst); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to resume database service: %w", err)
+		}
+	}
+
+	controllerutil.RemoveFinalizer(rst, restoreFinalizer)
+	if err := r.Update(ctx, rst); err != nil {
+		if errors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *RestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&databasev1.Restore{}).
+		// Restores own their Jobs, so a finished Job completes the Restore
+		Owns(&batchv1.Job{}).
+		// A new ReferenceGrant lets Restores waiting for it continue, a removed one
+		// takes away their Secret copies
+		Watches(
+			&databasev1.ReferenceGrant{},
+			handler.EnqueueRequestsFromMapFunc(r.findRestoresForGrant),
+		).
 		Complete(r)
 }
 
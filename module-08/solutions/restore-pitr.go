@@ -0,0 +1,266 @@
+// Solution: Point-in-Time Restore from Module 8
+// This restores a physical backup into a Database's data volume and replays archived WAL
+// Location: internal/restore/pitr.go
+//
+// A point-in-time restore replaces the data directory, so it can't run against a live server:
+// 1. The Restore controller scales the Database's StatefulSet to zero
+// 2. A Job mounts the data volume and runs RestoreBase (backup-agent restore-base), which
+//    extracts the pg_basebackup tar and writes the recovery settings
+// 3. Once the Job succeeded, the data volumes of the standbys are deleted: they hold data
+//    from after the target that the restored primary's new timeline doesn't have
+// 4. The StatefulSet is scaled back up; PostgreSQL fetches WAL from the archive with
+//    restore_command until it reaches the target, then promotes and accepts writes.
+//    The standbys start on new volumes and clone the primary.
+
+package restore
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/agent"
+	"github.com/example/postgres-operator/internal/storage"
+)
+
+const (
+	// DataMountPath is where the Database's data volume is mounted (see buildStatefulSet)
+	DataMountPath = "/var/lib/postgresql/data"
+	// PGDataDir is PGDATA inside the data volume
+	PGDataDir = DataMountPath + "/pgdata"
+)
+
+// PrimaryPod returns the pod running db's primary server, which moves to
+// another pod on failover (see replication.go)
+func PrimaryPod(db *databasev1.Database) string {
+	if db.Status.Primary != "" {
+		return db.Status.Primary
+	}
+	return fmt.Sprintf("%s-0", db.Name)
+}
+
+// DataClaimName returns the PVC holding the data directory of db's primary,
+// created by the StatefulSet's "data" volumeClaimTemplate
+func DataClaimName(db *databasev1.Database) string {
+	return fmt.Sprintf("data-%s", PrimaryPod(db))
+}
+
+// HasTarget reports whether rst restores to a point in time instead of the end of the backup
+func HasTarget(rst *databasev1.Restore) bool {
+	return rst.Spec.TargetTime != nil || rst.Spec.TargetLSN != ""
+}
+
+// BaseOptions configures RestoreBase
+type BaseOptions struct {
+	LoadOptions
+	// PGData is the data directory to replace
+	PGData string
+	// WALLocation is where the Database's WAL is archived
+	WALLocation string
+	// TargetTime and TargetLSN stop recovery at a point in time or WAL position.
+	// Without either, recovery stops as soon as the backup is consistent.
+	TargetTime *time.Time
+	TargetLSN  string
+}
+
+// RestoreBase replaces PGData with the physical backup and configures recovery.
+// pg_basebackup's tar already contains backup_label, which tells recovery where to start.
+// It runs inside the restore Job with the Database's data volume mounted and the
+// server stopped. The next server start replays WAL up to the target and promotes.
+func RestoreBase(ctx context.Context, opts BaseOptions, storageCfg storage.Config) error {
+	store, key, err := storage.Open(ctx, opts.Location, storageCfg)
+	if err != nil {
+		return err
+	}
+
+	// Verify first: the old data directory is only removed for a good backup
+	if opts.Digest != "" {
+		if err := storage.Verify(ctx, store, key, opts.Digest, opts.Size); err != nil {
+			return fmt.Errorf("backup verification failed: %v", err)
+		}
+	}
+
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to load backup: %v", err)
+	}
+	defer r.Close()
+
+	var in io.Reader = r
+	if opts.Encryption != nil {
+		if in, err = storage.NewDecryptor(in, opts.Encryption); err != nil {
+			return fmt.Errorf("failed to decrypt backup: %v", err)
+		}
+	}
+	archive, err := storage.NewDecompressor(in, opts.Compression)
+	if err != nil {
+		return fmt.Errorf("failed to decompress backup: %v", err)
+	}
+	defer archive.Close()
+
+	// The Job runs as root; PostgreSQL refuses a data directory it doesn't own
+	uid, gid, err := PostgresUser()
+	if err != nil {
+		return err
+	}
+
+	// Extract next to the data directory and swap it in at the end, so a failed
+	// restore leaves the old data directory in place
+	staging := opts.PGData + ".restore"
+	if err := os.RemoveAll(staging); err != nil {
+		return fmt.Errorf("failed to clear staging directory: %v", err)
+	}
+	if err := os.Mkdir(staging, 0o700); err != nil {
+		return fmt.Errorf("failed to create staging directory: %v", err)
+	}
+	if err := extractTar(archive, staging, uid, gid); err != nil {
+		return fmt.Errorf("failed to extract backup: %v", err)
+	}
+	if err := writeRecoveryConfig(staging, opts, uid, gid); err != nil {
+		return fmt.Errorf("failed to configure recovery: %v", err)
+	}
+
+	old := opts.PGData + ".old"
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(opts.PGData, old); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to replace data directory: %v", err)
+	}
+	if err := os.Rename(staging, opts.PGData); err != nil {
+		return fmt.Errorf("failed to replace data directory: %v", err)
+	}
+	return os.RemoveAll(old)
+}
+
+// writeRecoveryConfig puts the server in dir into targeted recovery on its next start.
+// PostgreSQL removes recovery.signal when it promotes; the settings stay in
+// postgresql.auto.conf but only apply while recovery.signal exists.
+func writeRecoveryConfig(dir string, opts BaseOptions, uid, gid int) error {
+	settings := map[string]string{
+		"restore_command":          fmt.Sprintf("%s wal-fetch --location '%s' %%f %%p", agent.InstalledPath, opts.WALLocation),
+		"recovery_target_action":   "promote",
+		"recovery_target_timeline": "latest",
+	}
+	switch {
+	case opts.TargetTime != nil:
+		settings["recovery_target_time"] = opts.TargetTime.UTC().Format("2006-01-02 15:04:05.999999Z07:00")
+	case opts.TargetLSN != "":
+		settings["recovery_target_lsn"] = opts.TargetLSN
+	default:
+		settings["recovery_target"] = "immediate"
+	}
+
+	var conf strings.Builder
+	conf.WriteString("\n# Added by the Restore controller for point-in-time recovery\n")
+	for _, name := range []string{"restore_command", "recovery_target", "recovery_target_time", "recovery_target_lsn", "recovery_target_timeline", "recovery_target_action"} {
+		if value, ok := settings[name]; ok {
+			fmt.Fprintf(&conf, "%s = '%s'\n", name, strings.ReplaceAll(value, "'", "''"))
+		}
+	}
+
+	autoConf := filepath.Join(dir, "postgresql.auto.conf")
+	f, err := os.OpenFile(autoConf, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(conf.String()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	signal := filepath.Join(dir, "recovery.signal")
+	if err := os.WriteFile(signal, nil, 0o600); err != nil {
+		return err
+	}
+	for _, p := range []string{autoConf, signal} {
+		if err := os.Lchown(p, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTar unpacks a pg_basebackup tar into dir, owned by uid:gid.
+// Entries that would land outside dir are rejected.
+func extractTar(r io.Reader, dir string, uid, gid int) error {
+	if err := os.Lchown(dir, uid, gid); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if target != dir && !strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			return fmt.Errorf("tar entry %q is outside of the data directory", hdr.Name)
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		default:
+			// pg_basebackup only streams a single tar without extra tablespaces,
+			// so there are no tablespace links to restore
+			continue
+		}
+
+		if err := os.Lchown(target, uid, gid); err != nil {
+			return err
+		}
+	}
+}
+
+// PostgresUser returns the uid and gid of the postgres user of the PostgreSQL image
+func PostgresUser() (int, int, error) {
+	u, err := user.Lookup("postgres")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to look up postgres user: %v", err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
@@ -1,131 +1,185 @@
 // Solution: Restore Implementation from Module 8
 // This demonstrates restore functionality for stateful applications
 //
-// IMPORTANT: This implementation uses psql which requires PostgreSQL client tools
-// to be installed in your operator container image. Update your Dockerfile to include
-// postgresql-client package. See the Dockerfile example in this solutions directory.
+// Restores run as Kubernetes Jobs instead of inside the operator process:
+// - NewRestoreJob builds the Job that the Restore controller creates
+// - Load runs inside that Job (via the backup-agent), verifies the backup's SHA-256 digest
+//   and then streams the decrypted, decompressed backup into psql
+// - RestoreBase does the same for physical backups, see pitr.go
+// The Job uses the Database's PostgreSQL image, so the operator image doesn't need
+// PostgreSQL client tools. See agent-job.go and backup-agent-main.go.
 
 package restore
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os/exec"
-	"strings"
+	"strconv"
+	"time"
 
 	databasev1 "github.com/example/postgres-operator/api/v1"
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	"sigs.k8s.io/controller-runtime/pkg/client"
+	"github.com/example/postgres-operator/internal/agent"
+	backupPkg "github.com/example/postgres-operator/internal/backup"
+	"github.com/example/postgres-operator/internal/storage"
+	batchv1 "k8s.io/api/batch/v1"
 )
 
-func PerformRestore(ctx context.Context, k8sClient client.Client, db *databasev1.Database, backupLocation string) error {
-	// Load backup from storage
-	backupData, err := loadFromStorage(backupLocation)
-	if err != nil {
-		return fmt.Errorf("failed to load backup: %v", err)
+// QuiescedLabel is added to the Database Service's selector during a restore.
+// No pod has it, so the Service has no endpoints and clients can't connect.
+const QuiescedLabel = "database.example.com/quiesced-by"
+
+// JobName returns the name of the Job for the current attempt of rst:
+// <name>-restore for the first attempt and <name>-restore-<attempt> for retries
+func JobName(rst *databasev1.Restore) string {
+	if rst.Status.Attempts <= 1 {
+		return fmt.Sprintf("%s-restore", rst.Name)
 	}
+	return fmt.Sprintf("%s-restore-%d", rst.Name, rst.Status.Attempts)
+}
 
-	// Get database endpoint
-	endpoint := db.Status.Endpoint
-	if endpoint == "" {
-		return fmt.Errorf("database endpoint not available")
+// NewRestoreJob builds the Job that restores the backup into db.
+// Logical backups are loaded with psql into the running server. Physical backups
+// replace the data directory of the stopped server (see pitr.go).
+// host is the address of the database pod, the Database's Service is quiesced during the restore.
+func NewRestoreJob(db *databasev1.Database, rst *databasev1.Restore, backup *databasev1.Backup, host string) (*batchv1.Job, error) {
+	backupLocation := backup.Status.BackupLocation
+	opts := agent.JobOptions{
+		Name:             JobName(rst),
+		Type:             "restore",
+		Args:             []string{"restore", "--location", backupLocation, "--compression", backupPkg.Compression(backup)},
+		Location:         backupLocation,
+		Host:             host,
+		StorageSecretRef: backup.Spec.StorageSecretRef,
 	}
 
-	// Parse endpoint (format: hostname:port or hostname)
-	host := endpoint
-	port := "5432" // Default PostgreSQL port
-	if strings.Contains(endpoint, ":") {
-		parts := strings.Split(endpoint, ":")
-		if len(parts) == 2 {
-			host = parts[0]
-			port = parts[1]
+	if err := Validate(db, rst, backup); err != nil {
+		return nil, err
+	}
+	if IsPhysical(backup) {
+		// The WAL comes from the archive of the Database the backup was taken from,
+		// which is a different Database when restoring into a clone
+		opts.Args[0] = "restore-base"
+		opts.Args = append(opts.Args, "--wal-location", backup.Status.WALLocation, "--pgdata", PGDataDir)
+		if rst.Spec.TargetTime != nil {
+			opts.Args = append(opts.Args, "--target-time", rst.Spec.TargetTime.UTC().Format(time.RFC3339))
 		}
+		if rst.Spec.TargetLSN != "" {
+			opts.Args = append(opts.Args, "--target-lsn", rst.Spec.TargetLSN)
+		}
+		opts.DataClaim = DataClaimName(db)
+		opts.DataMountPath = DataMountPath
 	}
-
-	// Get password from Secret
-	secretName := db.Status.SecretName
-	if secretName == "" {
-		// Fallback to default secret name pattern if SecretName not set in status
-		secretName = fmt.Sprintf("%s-credentials", db.Name)
+	if backup.Status.Digest != "" {
+		opts.Args = append(opts.Args, "--digest", backup.Status.Digest, "--size", strconv.FormatInt(backup.Status.Size, 10))
 	}
 
-	secret := &corev1.Secret{}
-	err = k8sClient.Get(ctx, client.ObjectKey{
-		Name:      secretName,
-		Namespace: db.Namespace,
-	}, secret)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			return fmt.Errorf("secret %s not found", secretName)
+	// Encrypted backups are decrypted with the key they were written with,
+	// which must still be in the Backup's encryption Secret
+	if keyID := backup.Status.EncryptionKeyID; keyID != "" {
+		if backup.Spec.Encryption == nil {
+			return nil, fmt.Errorf("backup %s is encrypted with key %s but has no encryption secret", backup.Name, keyID)
 		}
-		return fmt.Errorf("failed to get secret: %w", err)
+		opts.Args = append(opts.Args, "--key-id", keyID)
+		opts.EncryptionSecretRef = &backup.Spec.Encryption.SecretRef
 	}
+	return agent.NewJob(db, opts)
+}
 
-	// Extract password from Secret
-	passwordBytes, exists := secret.Data["password"]
-	if !exists {
-		return fmt.Errorf("password key not found in secret %s", secretName)
-	}
-	password := string(passwordBytes)
+// IsPhysical reports whether backup is restored by replacing the data directory
+func IsPhysical(backup *databasev1.Backup) bool {
+	return backupPkg.Method(backup) == backupPkg.MethodPhysical
+}
 
-	// Stop database if needed (application-specific)
-	if err := stopDatabase(ctx, db); err != nil {
-		return fmt.Errorf("failed to stop database: %v", err)
+// Validate checks that rst can restore backup into db
+func Validate(db *databasev1.Database, rst *databasev1.Restore, backup *databasev1.Backup) error {
+	if HasTarget(rst) && !IsPhysical(backup) {
+		return fmt.Errorf("point-in-time restore needs a physical backup, %s is %s", backup.Name, backupPkg.Method(backup))
 	}
+	if !IsPhysical(backup) {
+		return nil
+	}
+	if backup.Status.WALLocation == "" {
+		return fmt.Errorf("physical backup %s has no WAL location", backup.Name)
+	}
+	// PostgreSQL runs the backup-agent as restore_command, which is only installed
+	// (with storage credentials) when WAL archiving is on
+	if db.Spec.WALArchiving == nil {
+		return fmt.Errorf("restoring physical backup %s needs WAL archiving on database %s", backup.Name, db.Name)
+	}
+	return nil
+}
 
-	// Perform restore with password from Secret
-	cmd := exec.CommandContext(ctx, "psql",
-		"-h", host,
-		"-p", port,
-		"-U", db.Spec.Username,
-		"-d", db.Spec.DatabaseName)
-
-	// Set password as environment variable for psql
-	cmd.Env = append(cmd.Env, fmt.Sprintf("PGPASSWORD=%s", password))
-	cmd.Stdin = bytes.NewReader(backupData)
+// LoadOptions configures Load
+type LoadOptions struct {
+	// Location is where the backup is stored
+	Location string
+	// Compression is gzip, zstd or none
+	Compression string
+	// Digest and Size are checked before the restore starts (optional)
+	Digest string
+	Size   int64
+	// Encryption decrypts the backup before decompression (optional)
+	Encryption *storage.Encryption
+}
 
-	output, err := cmd.CombinedOutput()
+// Load streams the backup into psql.
+// It runs inside the restore Job, where the PG* environment variables
+// (set by agent.NewJob) tell psql how to connect.
+// If a digest is set, the stored backup is verified before psql sees any of it.
+// Other sessions on the database are terminated first so nothing writes during the restore.
+func Load(ctx context.Context, opts LoadOptions, storageCfg storage.Config) error {
+	store, key, err := storage.Open(ctx, opts.Location, storageCfg)
 	if err != nil {
-		return fmt.Errorf("restore failed: %v, output: %s", err, string(output))
+		return err
 	}
 
-	// Start database
-	if err := startDatabase(ctx, db); err != nil {
-		return fmt.Errorf("failed to start database: %v", err)
+	// Verify first: a corrupted backup must not be partially restored.
+	// This reads the backup twice but never holds it in memory.
+	if opts.Digest != "" {
+		if err := storage.Verify(ctx, store, key, opts.Digest, opts.Size); err != nil {
+			return fmt.Errorf("backup verification failed: %v", err)
+		}
 	}
 
-	return nil
-}
-
-func loadFromStorage(backupLocation string) ([]byte, error) {
-	// In real implementation, this would:
-	// 1. Download from S3
-	// 2. Read from PVC
-	// 3. Load from object storage
-
-	// For example, download from S3:
-	// aws s3 cp s3://backups/backup.sql /tmp/backup.sql
-	// data, err := os.ReadFile("/tmp/backup.sql")
+	// Sessions opened before the Service was quiesced would keep writing
+	terminate := exec.CommandContext(ctx, "psql", "--quiet", "-v", "ON_ERROR_STOP=1", "-c",
+		"SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = current_database() AND pid <> pg_backend_pid()")
+	if output, err := terminate.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to terminate connections: %v, output: %s", err, string(output))
+	}
 
-	// Simplified for example
-	return []byte("-- Backup data"), nil
-}
+	// Load backup from storage
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to load backup: %v", err)
+	}
+	defer r.Close()
 
-func stopDatabase(ctx context.Context, db *databasev1.Database) error {
-	// Application-specific: stop database gracefully
-	// For PostgreSQL, might use pg_ctl stop
-	// For other databases, use appropriate commands
+	// storage -> [decryptor] -> decompressor -> psql
+	var in io.Reader = r
+	if opts.Encryption != nil {
+		if in, err = storage.NewDecryptor(in, opts.Encryption); err != nil {
+			return fmt.Errorf("failed to decrypt backup: %v", err)
+		}
+	}
 
-	return nil
-}
+	sql, err := storage.NewDecompressor(in, opts.Compression)
+	if err != nil {
+		return fmt.Errorf("failed to decompress backup: %v", err)
+	}
+	defer sql.Close()
 
-func startDatabase(ctx context.Context, db *databasev1.Database) error {
-	// Application-specific: start database
-	// For PostgreSQL, might use pg_ctl start
-	// For other databases, use appropriate commands
+	// Stop at the first error so a broken restore fails the Job, and roll back
+	// everything it did so the database is never left half restored. The dump drops
+	// the objects it recreates (see backup.dumpCommand), so it loads over existing ones.
+	cmd := exec.CommandContext(ctx, "psql", "--quiet", "--single-transaction", "-v", "ON_ERROR_STOP=1")
+	cmd.Stdin = sql
 
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("restore failed: %v, output: %s", err, string(output))
+	}
 	return nil
 }
-
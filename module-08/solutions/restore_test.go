@@ -0,0 +1,96 @@
+// Solution: Restore Tests from Module 8
+// This restores a logical backup over the schema it was taken from, the in-place restore
+// Location: internal/restore/restore_test.go
+//
+// The specs start a throwaway PostgreSQL server with the initdb and pg_ctl found on the
+// PATH, and are skipped without them (or as root, which initdb refuses).
+
+package restore
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	backupPkg "github.com/example/postgres-operator/internal/backup"
+	"github.com/example/postgres-operator/internal/storage"
+)
+
+func TestRestore(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Restore Suite")
+}
+
+// psql runs sql against the test server and returns its unaligned output
+func psql(sql string) string {
+	out, err := exec.Command("psql", "--no-psqlrc", "--tuples-only", "--no-align", "-v", "ON_ERROR_STOP=1", "-c", sql).CombinedOutput()
+	Expect(err).NotTo(HaveOccurred(), string(out))
+	return strings.TrimSpace(string(out))
+}
+
+var _ = Describe("Load", func() {
+	var dir string
+
+	BeforeEach(func() {
+		for _, tool := range []string{"initdb", "pg_ctl", "pg_dump", "psql"} {
+			if _, err := exec.LookPath(tool); err != nil {
+				Skip(tool + " not found")
+			}
+		}
+		if os.Geteuid() == 0 {
+			Skip("initdb can't run as root")
+		}
+
+		dir = GinkgoT().TempDir()
+		data := filepath.Join(dir, "data")
+		out, err := exec.Command("initdb", "--pgdata", data, "--username", "postgres", "--auth", "trust").CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(out))
+		// Only a Unix socket in dir, nothing listens on TCP
+		out, err = exec.Command("pg_ctl", "--pgdata", data, "--wait", "--log", filepath.Join(dir, "server.log"),
+			"-o", "-c listen_addresses='' -k "+dir, "start").CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(out))
+		DeferCleanup(func() {
+			_ = exec.Command("pg_ctl", "--pgdata", data, "--mode", "immediate", "stop").Run()
+		})
+
+		// What agent.NewJob sets in the Jobs
+		GinkgoT().Setenv("PGHOST", dir)
+		GinkgoT().Setenv("PGUSER", "postgres")
+		GinkgoT().Setenv("PGDATABASE", "postgres")
+	})
+
+	It("should restore a dump into the database it was taken from", func() {
+		ctx := context.Background()
+		psql("CREATE TABLE orders (id int PRIMARY KEY, item text NOT NULL); INSERT INTO orders VALUES (1, 'book')")
+
+		location := "file://" + filepath.Join(dir, "backups", "orders.sql")
+		result, err := backupPkg.Dump(ctx, backupPkg.DumpOptions{
+			Location:    location,
+			Method:      backupPkg.MethodLogical,
+			Compression: "none",
+		}, storage.Config{})
+		Expect(err).NotTo(HaveOccurred())
+
+		// Changes after the backup, including to the schema
+		psql("INSERT INTO orders VALUES (2, 'pen'); ALTER TABLE orders ADD COLUMN note text; CREATE TABLE invoices (id int)")
+
+		Expect(Load(ctx, LoadOptions{
+			Location:    location,
+			Compression: "none",
+			Digest:      result.Digest,
+			Size:        result.Size,
+		}, storage.Config{})).To(Succeed())
+
+		Expect(psql("SELECT string_agg(id || ':' || item, ',' ORDER BY id) FROM orders")).To(Equal("1:book"))
+		Expect(psql("SELECT count(*) FROM information_schema.columns WHERE table_name = 'orders' AND column_name = 'note'")).To(Equal("0"))
+		// Objects the backup doesn't know are left alone
+		Expect(psql("SELECT to_regclass('invoices') IS NOT NULL")).To(Equal("t"))
+	})
+})
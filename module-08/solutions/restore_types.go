@@ -12,19 +12,72 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// CreatedByRestoreAnnotation is set on a Database created from RestoreSpec.DatabaseTemplate.
+// The value is the Restore's name. A Restore with a template only restores into a
+// Database it created, so a clone can never overwrite an existing Database.
+const CreatedByRestoreAnnotation = "database.example.com/created-by-restore"
+
 // RestoreSpec defines the desired state of Restore
+// +kubebuilder:validation:XValidation:rule="!(has(self.targetTime) && has(self.targetLSN))",message="targetTime and targetLSN are mutually exclusive"
+// +kubebuilder:validation:XValidation:rule="has(self.backupRef) != has(self.artifactRef)",message="exactly one of backupRef and artifactRef must be set"
 type RestoreSpec struct {
-	// DatabaseRef references the Database to restore to
+	// DatabaseRef references the Database to restore to.
+	// With DatabaseTemplate it names the Database to create.
 	// +kubebuilder:validation:Required
 	DatabaseRef corev1.LocalObjectReference `json:"databaseRef"`
 
-	// BackupRef references the Backup to restore from
+	// DatabaseTemplate creates a new Database from this template and restores into it,
+	// instead of overwriting an existing Database. Use it to clone a Database for
+	// debugging or staging. The Database is not deleted with the Restore.
+	// +optional
+	DatabaseTemplate *DatabaseTemplateSpec `json:"databaseTemplate,omitempty"`
+
+	// BackupRef references the Backup to restore from.
+	// For a scheduled Backup this is its latest run.
+	// A Backup in another namespace needs a ReferenceGrant there allowing Restores
+	// from this namespace.
+	// +optional
+	BackupRef *NamespacedObjectReference `json:"backupRef,omitempty"`
+
+	// ArtifactRef references the BackupArtifact to restore from, e.g. an older
+	// run of a scheduled Backup. Other namespaces need a ReferenceGrant like BackupRef.
+	// +optional
+	ArtifactRef *NamespacedObjectReference `json:"artifactRef,omitempty"`
+
+	// TargetTime restores the database as it was at this time (point-in-time recovery).
+	// Requires a physical Backup taken before TargetTime and WAL archiving on the Database.
+	// +optional
+	TargetTime *metav1.Time `json:"targetTime,omitempty"`
+
+	// TargetLSN restores the database up to this WAL position, e.g. "0/3000060"
+	// +kubebuilder:validation:Pattern=`^[0-9A-Fa-f]+/[0-9A-Fa-f]+$`
+	// +optional
+	TargetLSN string `json:"targetLSN,omitempty"`
+
+	// RetryPolicy retries failed restore Jobs. Without it a failed restore is not retried
+	// (see RetryAnnotation for retrying by hand).
+	// +optional
+	RetryPolicy *RetryPolicy `json:"retryPolicy,omitempty"`
+}
+
+// DatabaseTemplateSpec describes the Database a Restore creates
+type DatabaseTemplateSpec struct {
+	// Labels are added to the new Database
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Spec is the spec of the new Database
 	// +kubebuilder:validation:Required
-	BackupRef corev1.LocalObjectReference `json:"backupRef"`
+	Spec DatabaseSpec `json:"spec"`
 }
 
 // RestoreStatus defines the observed state of Restore
 type RestoreStatus struct {
+	// ObservedGeneration is the spec generation the controller last acted on.
+	// Ready only reflects the current spec when it equals metadata.generation.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	// Phase is the current restore phase
 	// +kubebuilder:validation:Enum=Pending;InProgress;Completed;Failed
 	Phase string `json:"phase,omitempty"`
@@ -32,16 +85,44 @@ type RestoreStatus struct {
 	// RestoreTime is when the restore completed
 	RestoreTime *metav1.Time `json:"restoreTime,omitempty"`
 
+	// Downtime is when the Database was out of service for the latest attempt
+	// +optional
+	Downtime *DowntimeWindow `json:"downtime,omitempty"`
+
+	// Attempts is the number of times the restore Job was started
+	// +optional
+	Attempts int32 `json:"attempts,omitempty"`
+
+	// LastFailureReason is why the last attempt failed
+	// +optional
+	LastFailureReason string `json:"lastFailureReason,omitempty"`
+
+	// NextRetryTime is when the next attempt starts, unset if the restore won't be retried
+	// +optional
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+
 	// Conditions represent the latest observations of the Restore's state
+	// (Ready, Reconciling and Stalled, see the conditions package)
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// DowntimeWindow is a period in which a Database didn't accept client connections
+type DowntimeWindow struct {
+	// Start is when clients were cut off
+	Start metav1.Time `json:"start"`
+
+	// End is when the Database was back in service, unset while it is still down
+	// +optional
+	End *metav1.Time `json:"end,omitempty"`
+}
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
 // +kubebuilder:printcolumn:name="Database",type="string",JSONPath=".spec.databaseRef.name"
 // +kubebuilder:printcolumn:name="Backup",type="string",JSONPath=".spec.backupRef.name"
+// +kubebuilder:printcolumn:name="Attempts",type="integer",JSONPath=".status.attempts",priority=1
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Restore is the Schema for the restores API.
@@ -54,6 +135,16 @@ type Restore struct {
 	Status RestoreStatus `json:"status,omitempty"`
 }
 
+// GetConditions returns the status conditions, for the conditions package
+func (rst *Restore) GetConditions() []metav1.Condition {
+	return rst.Status.Conditions
+}
+
+// SetConditions replaces the status conditions, for the conditions package
+func (rst *Restore) SetConditions(conditions []metav1.Condition) {
+	rst.Status.Conditions = conditions
+}
+
 // +kubebuilder:object:root=true
 
 // RestoreList contains a list of Restore
@@ -0,0 +1,83 @@
+// Solution: Backup Retention from Module 8
+// This decides which backup artifacts to keep based on BackupSpec.Retention
+// Location: internal/backup/retention.go
+
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Artifact is a single stored backup, as seen by the retention policy
+type Artifact struct {
+	// Name identifies the artifact (the name of the child Backup)
+	Name string
+	// Time is when the backup was taken
+	Time time.Time
+}
+
+// RetentionPolicy describes which artifacts to keep.
+// An artifact is kept if any of the rules selects it.
+type RetentionPolicy struct {
+	// KeepLast keeps the newest N artifacts
+	KeepLast int
+	// KeepDaily keeps the newest artifact of each of the last N days that have one
+	KeepDaily int
+	// KeepWeekly keeps the newest artifact of each of the last N ISO weeks that have one
+	KeepWeekly int
+	// KeepMonthly keeps the newest artifact of each of the last N months that have one
+	KeepMonthly int
+}
+
+// ApplyRetention splits artifacts into the ones to keep and the ones to prune.
+// Both results are sorted newest first. Days, weeks and months are evaluated in UTC.
+func ApplyRetention(artifacts []Artifact, policy RetentionPolicy) (keep, prune []Artifact) {
+	sorted := make([]Artifact, len(artifacts))
+	copy(sorted, artifacts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	kept := make(map[string]bool)
+	for i := 0; i < len(sorted) && i < policy.KeepLast; i++ {
+		kept[sorted[i].Name] = true
+	}
+	keepNewestPerBucket(sorted, policy.KeepDaily, kept, func(t time.Time) string {
+		return t.UTC().Format("2006-01-02")
+	})
+	keepNewestPerBucket(sorted, policy.KeepWeekly, kept, func(t time.Time) string {
+		year, week := t.UTC().ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepNewestPerBucket(sorted, policy.KeepMonthly, kept, func(t time.Time) string {
+		return t.UTC().Format("2006-01")
+	})
+
+	for _, a := range sorted {
+		if kept[a.Name] {
+			keep = append(keep, a)
+		} else {
+			prune = append(prune, a)
+		}
+	}
+	return keep, prune
+}
+
+// keepNewestPerBucket marks the newest artifact of each of the first n buckets.
+// sorted must be ordered newest first.
+func keepNewestPerBucket(sorted []Artifact, n int, kept map[string]bool, bucket func(time.Time) string) {
+	seen := make(map[string]bool)
+	for _, a := range sorted {
+		if len(seen) >= n {
+			return
+		}
+		b := bucket(a.Time)
+		if seen[b] {
+			continue
+		}
+		seen[b] = true
+		kept[a.Name] = true
+	}
+}
@@ -0,0 +1,94 @@
+// Solution: Backup Retention Tests from Module 8
+// This tests which scheduled backups the retention policy keeps
+// Location: internal/backup/retention_test.go
+
+package backup
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// daily returns one artifact per day at 02:00 UTC, newest first, ending on end
+func daily(end time.Time, days int) []Artifact {
+	var artifacts []Artifact
+	for i := 0; i < days; i++ {
+		t := end.AddDate(0, 0, -i)
+		artifacts = append(artifacts, Artifact{Name: ScheduledRunName("nightly", t), Time: t})
+	}
+	return artifacts
+}
+
+func names(artifacts []Artifact) []string {
+	var n []string
+	for _, a := range artifacts {
+		n = append(n, a.Name)
+	}
+	return n
+}
+
+var _ = Describe("Retention", func() {
+	// Wednesday, so the ISO week started on Monday 2025-03-10
+	end := time.Date(2025, 3, 12, 2, 0, 0, 0, time.UTC)
+
+	It("should keep only the newest artifacts with only keepLast set", func() {
+		keep, prune := ApplyRetention(daily(end, 5), RetentionPolicy{KeepLast: 2})
+		Expect(names(keep)).To(Equal([]string{"nightly-20250312-0200", "nightly-20250311-0200"}))
+		Expect(prune).To(HaveLen(3))
+	})
+
+	It("should sort its results newest first", func() {
+		artifacts := daily(end, 3)
+		artifacts[0], artifacts[2] = artifacts[2], artifacts[0]
+		keep, prune := ApplyRetention(artifacts, RetentionPolicy{KeepLast: 1})
+		Expect(names(keep)).To(Equal([]string{"nightly-20250312-0200"}))
+		Expect(names(prune)).To(Equal([]string{"nightly-20250311-0200", "nightly-20250310-0200"}))
+	})
+
+	It("should keep the newest artifact of each day", func() {
+		artifacts := append(daily(end, 3), Artifact{Name: "midday", Time: end.Add(-12 * time.Hour)})
+		keep, _ := ApplyRetention(artifacts, RetentionPolicy{KeepDaily: 2})
+		// midday on the 11th is newer than 02:00 on the 11th
+		Expect(names(keep)).To(Equal([]string{"nightly-20250312-0200", "midday"}))
+	})
+
+	It("should keep weekly and monthly artifacts without keepLast", func() {
+		keep, _ := ApplyRetention(daily(end, 60), RetentionPolicy{KeepWeekly: 2, KeepMonthly: 3})
+		Expect(names(keep)).To(Equal([]string{
+			// week 11 and month 2025-03
+			"nightly-20250312-0200",
+			// week 10
+			"nightly-20250309-0200",
+			// month 2025-02
+			"nightly-20250228-0200",
+			// month 2025-01
+			"nightly-20250131-0200",
+		}))
+	})
+
+	It("should count an artifact selected by several rules once", func() {
+		keep, prune := ApplyRetention(daily(end, 10), RetentionPolicy{KeepLast: 2, KeepDaily: 3, KeepWeekly: 1, KeepMonthly: 1})
+		Expect(names(keep)).To(Equal([]string{
+			"nightly-20250312-0200", "nightly-20250311-0200", "nightly-20250310-0200",
+		}))
+		Expect(prune).To(HaveLen(7))
+	})
+
+	It("should skip buckets that have no artifact", func() {
+		artifacts := []Artifact{
+			{Name: "march", Time: end},
+			{Name: "january", Time: time.Date(2025, 1, 15, 2, 0, 0, 0, time.UTC)},
+			{Name: "december", Time: time.Date(2024, 12, 15, 2, 0, 0, 0, time.UTC)},
+		}
+		keep, _ := ApplyRetention(artifacts, RetentionPolicy{KeepMonthly: 2})
+		Expect(names(keep)).To(Equal([]string{"march", "january"}))
+	})
+
+	It("should prune everything with an empty policy", func() {
+		keep, prune := ApplyRetention(daily(end, 3), RetentionPolicy{})
+		Expect(keep).To(BeEmpty())
+		Expect(prune).To(HaveLen(3))
+	})
+})
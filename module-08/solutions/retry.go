@@ -0,0 +1,93 @@
+// Solution: Retry Policy from Module 8
+// This decides when failed Backups and Restores are attempted again
+//
+// Each attempt runs in its own Job (<name>-backup, <name>-backup-2, ...), so failed
+// Jobs stay around for debugging until the Backup or Restore is deleted.
+// A failed attempt moves the resource to Failed and sets status.nextRetryTime while
+// spec.retryPolicy allows more attempts. Reconcile starts the next attempt once
+// that time has passed. The database.example.com/retry annotation starts one more
+// attempt right away, even when the policy is used up.
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+)
+
+// Defaults matching the +kubebuilder:default markers on RetryPolicy
+const (
+	defaultMaxAttempts       = 3
+	defaultBackoffSeconds    = 30
+	defaultMaxBackoffSeconds = 600
+)
+
+// retryDelay returns how long to wait before the next attempt after attempts
+// have failed. It returns false when the policy allows no more attempts.
+func retryDelay(policy *databasev1.RetryPolicy, attempts int32) (time.Duration, bool) {
+	if policy == nil {
+		return 0, false
+	}
+
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	if attempts >= maxAttempts {
+		return 0, false
+	}
+
+	backoff := time.Duration(policy.BackoffSeconds) * time.Second
+	if backoff == 0 {
+		backoff = defaultBackoffSeconds * time.Second
+	}
+	maxBackoff := time.Duration(policy.MaxBackoffSeconds) * time.Second
+	if maxBackoff == 0 {
+		maxBackoff = defaultMaxBackoffSeconds * time.Second
+	}
+
+	// backoff, 2*backoff, 4*backoff, ... capped at maxBackoff
+	delay := backoff
+	for i := int32(1); i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff), true
+}
+
+// retryDue reports whether a failed resource should start its next attempt now.
+// If a retry is scheduled for later, it returns how long to wait.
+func retryDue(nextRetryTime *metav1.Time) (bool, time.Duration) {
+	if nextRetryTime == nil {
+		return false, 0
+	}
+	if wait := time.Until(nextRetryTime.Time); wait > 0 {
+		return false, wait
+	}
+	return true, 0
+}
+
+// retryRequested reports whether the manual retry annotation is set on obj
+func retryRequested(obj client.Object) bool {
+	_, ok := obj.GetAnnotations()[databasev1.RetryAnnotation]
+	return ok
+}
+
+// clearRetryRequest removes the manual retry annotation from obj
+func clearRetryRequest(ctx context.Context, c client.Client, obj client.Object) error {
+	if !retryRequested(obj) {
+		return nil
+	}
+	ctrl.LoggerFrom(ctx).Info("Manual retry started", "name", obj.GetName())
+
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	annotations := obj.GetAnnotations()
+	delete(annotations, databasev1.RetryAnnotation)
+	obj.SetAnnotations(annotations)
+	return c.Patch(ctx, obj, patch)
+}
@@ -0,0 +1,104 @@
+// Solution: Retry Policy Tests from Module 8
+// This tests the backoff of failed Backups and Restores and the manual retry annotation
+// Location: internal/controller/retry_test.go
+//
+// The specs run in the controller suite kubebuilder scaffolded, with the fake client of
+// failover_test.go.
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+)
+
+var _ = Describe("Retry policy", func() {
+	DescribeTable("retryDelay",
+		func(policy *databasev1.RetryPolicy, attempts int32, want time.Duration, wantRetry bool) {
+			delay, retry := retryDelay(policy, attempts)
+			Expect(retry).To(Equal(wantRetry))
+			Expect(delay).To(Equal(want))
+		},
+		Entry("doesn't retry without a policy", nil, int32(1), time.Duration(0), false),
+		Entry("waits the default backoff after the first attempt", &databasev1.RetryPolicy{}, int32(1), 30*time.Second, true),
+		Entry("doubles the backoff", &databasev1.RetryPolicy{}, int32(2), time.Minute, true),
+		Entry("stops after the default number of attempts", &databasev1.RetryPolicy{}, int32(3), time.Duration(0), false),
+		Entry("waits the configured backoff",
+			&databasev1.RetryPolicy{MaxAttempts: 10, BackoffSeconds: 5}, int32(3), 20*time.Second, true),
+		Entry("caps the backoff at maxBackoffSeconds",
+			&databasev1.RetryPolicy{MaxAttempts: 10, BackoffSeconds: 5, MaxBackoffSeconds: 30}, int32(5), 30*time.Second, true),
+		Entry("caps the backoff at the default maximum",
+			&databasev1.RetryPolicy{MaxAttempts: 100}, int32(50), 10*time.Minute, true),
+		Entry("caps a backoff above the maximum",
+			&databasev1.RetryPolicy{BackoffSeconds: 120, MaxBackoffSeconds: 60}, int32(1), time.Minute, true),
+		Entry("stops after maxAttempts", &databasev1.RetryPolicy{MaxAttempts: 1}, int32(1), time.Duration(0), false),
+	)
+
+	Describe("retryDue", func() {
+		It("should not retry without a scheduled retry", func() {
+			due, wait := retryDue(nil)
+			Expect(due).To(BeFalse())
+			Expect(wait).To(BeZero())
+		})
+
+		It("should wait for a retry scheduled later", func() {
+			due, wait := retryDue(&metav1.Time{Time: time.Now().Add(time.Minute)})
+			Expect(due).To(BeFalse())
+			Expect(wait).To(BeNumerically("~", time.Minute, time.Second))
+		})
+
+		It("should retry once the time has passed", func() {
+			due, wait := retryDue(&metav1.Time{Time: time.Now().Add(-time.Second)})
+			Expect(due).To(BeTrue())
+			Expect(wait).To(BeZero())
+		})
+	})
+
+	Describe("manual retry", func() {
+		var (
+			ctx    context.Context
+			backup *databasev1.Backup
+		)
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			backup = &databasev1.Backup{ObjectMeta: metav1.ObjectMeta{
+				Name:      "nightly",
+				Namespace: "default",
+				Annotations: map[string]string{
+					databasev1.RetryAnnotation: "",
+					"example.com/owner":        "team-a",
+				},
+			}}
+		})
+
+		It("should clear the annotation once the retry started", func() {
+			c := newTestClient(newFakeInstances(), backup)
+			Expect(retryRequested(backup)).To(BeTrue())
+
+			Expect(clearRetryRequest(ctx, c, backup)).To(Succeed())
+
+			latest := &databasev1.Backup{}
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(backup), latest)).To(Succeed())
+			Expect(retryRequested(latest)).To(BeFalse())
+			Expect(latest.Annotations).To(HaveKeyWithValue("example.com/owner", "team-a"))
+			Expect(retryRequested(backup)).To(BeFalse())
+		})
+
+		It("should leave a resource without the annotation alone", func() {
+			delete(backup.Annotations, databasev1.RetryAnnotation)
+			// A patch would fail, the client doesn't hold the Backup
+			c := newTestClient(newFakeInstances())
+
+			Expect(retryRequested(backup)).To(BeFalse())
+			Expect(clearRetryRequest(ctx, c, backup)).To(Succeed())
+		})
+	})
+})
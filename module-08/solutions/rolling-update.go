@@ -1,24 +1,64 @@
 // Solution: Rolling Update Handling from Module 8
 // This demonstrates how to handle rolling updates for stateful applications
+//
+// Server settings (e.g. WAL archiving) are applied to the pod template by configurePostgres,
+// see wal-archiving.go. The replication containers it adds run the Database's image too
+// (see replication.go). A new major version is left to major-upgrade.go.
+//
+// A changed pod template (a new minor version, new settings) is rolled out primary-last
+// with the partition of the StatefulSet's RollingUpdate strategy: Kubernetes only updates
+// the pods with an ordinal of at least the partition. reconcileRollout moves it on every
+// reconcile, nothing waits inside the reconcile:
+// 1. UpdatingStandbys: the partition is one above the primary's ordinal, so the standbys
+//    above it are restarted with the new template while the primary keeps serving
+// 2. SwitchingOver: once they are ready, a Switchover (switchover-controller.go) makes the
+//    highest one the primary. If the primary has the highest ordinal, the highest standby
+//    takes over first, still on the old template, and the rollout starts over from there.
+// 3. UpdatingPrimary: the partition drops to zero, which updates the former primary and
+//    the standbys below it
+// 4. Completed once every pod runs the new template and is ready
+// A Database with a single pod only has UpdatingPrimary and is down while it restarts.
+// status.rollout records the progress.
+//
+// Lowering spec.replicas below the primary's ordinal would delete the primary, so the
+// StatefulSet keeps its pods until a Switchover made the highest remaining pod the primary.
 
 package controller
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/conditions"
 )
 
+// +kubebuilder:rbac:groups=database.example.com,resources=switchovers,verbs=get;list;watch;create;delete
+
+// The phases of a rollout (RolloutStatus.Phase)
+const (
+	rolloutUpdatingStandbys = "UpdatingStandbys"
+	rolloutSwitchingOver    = "SwitchingOver"
+	rolloutUpdatingPrimary  = "UpdatingPrimary"
+	rolloutCompleted        = "Completed"
+)
+
+// rolloutPollInterval is how often a running rollout is checked on, besides the
+// StatefulSet events
+const rolloutPollInterval = 10 * time.Second
+
 func (r *DatabaseReconciler) updateStatefulSet(ctx context.Context, db *databasev1.Database) error {
 	statefulSet := &appsv1.StatefulSet{}
 	err := r.Get(ctx, client.ObjectKey{
@@ -35,25 +75,54 @@ func (r *DatabaseReconciler) updateStatefulSet(ctx context.Context, db *database
 		return err
 	}
 
+	// A physical restore has scaled the StatefulSet down and owns it until it finishes
+	if _, ok := db.Annotations[databasev1.RestoreInProgressAnnotation]; ok {
+		return nil
+	}
+
+	if err := r.reconcileHBAConfigMap(ctx, db); err != nil {
+		return err
+	}
+	if err := r.reconcileReplicationConfig(ctx, db); err != nil {
+		return err
+	}
+
 	// Check if update needed
 	desiredImage := db.Spec.Image
 	currentImage := statefulSet.Spec.Template.Spec.Containers[0].Image
 
-	if desiredImage != currentImage {
-		// Update image
-		statefulSet.Spec.Template.Spec.Containers[0].Image = desiredImage
+	// A new major version can't start on the old data directory, reconcileMajorUpgrade
+	// upgrades it and then replaces the image (see major-upgrade.go)
+	if majorVersionChange(currentImage, desiredImage) {
+		desiredImage = currentImage
+	}
 
-		// Update StatefulSet (will trigger rolling update)
-		if err := r.Update(ctx, statefulSet); err != nil {
+	// Update image, the instance containers follow it
+	statefulSet.Spec.Template.Spec.Containers[0].Image = desiredImage
+	changed, err := configurePostgres(db, statefulSet)
+	if err != nil {
+		return err
+	}
+	if changed {
+		// Only the standbys above the primary are updated at first, reconcileRollout
+		// takes it from there
+		primary, err := currentPrimary(ctx, r.Client, db)
+		if err != nil {
 			return err
 		}
+		setPartition(statefulSet, rolloutPartition(db, statefulSet, primary, false))
+		return r.Update(ctx, statefulSet)
+	}
 
-		// Wait for update to complete
-		return r.waitForRollingUpdate(ctx, statefulSet)
+	if err := r.reconcileRollout(ctx, db, statefulSet); err != nil {
+		return err
 	}
 
 	// Check if replicas need updating
 	if db.Spec.Replicas != nil && *statefulSet.Spec.Replicas != *db.Spec.Replicas {
+		if ok, err := r.scaleDownHandover(ctx, db, *db.Spec.Replicas); err != nil || !ok {
+			return err
+		}
 		statefulSet.Spec.Replicas = db.Spec.Replicas
 		if err := r.Update(ctx, statefulSet); err != nil {
 			return err
@@ -63,52 +132,313 @@ func (r *DatabaseReconciler) updateStatefulSet(ctx context.Context, db *database
 	return nil
 }
 
-func (r *DatabaseReconciler) waitForRollingUpdate(ctx context.Context, ss *appsv1.StatefulSet) error {
-	return wait.PollImmediate(5*time.Second, 5*time.Minute, func() (bool, error) {
-		err := r.Get(ctx, client.ObjectKeyFromObject(ss), ss)
+// reconcileRollout moves the rollout of the StatefulSet's update revision one step on
+// and records it in status.rollout. It returns right away; the StatefulSet's status
+// changes and the requeue of reconcileReplication bring it back.
+func (r *DatabaseReconciler) reconcileRollout(ctx context.Context, db *databasev1.Database, ss *appsv1.StatefulSet) error {
+	log := ctrl.LoggerFrom(ctx)
+
+	// The revisions in the status are only current once the StatefulSet controller
+	// has seen the template
+	if ss.Status.ObservedGeneration < ss.Generation || ss.Spec.Replicas == nil || *ss.Spec.Replicas == 0 {
+		return nil
+	}
+	// A Switchover owns the primary until it finished, the rollout's own included
+	if _, ok := db.Annotations[databasev1.SwitchoverInProgressAnnotation]; ok {
+		return nil
+	}
+
+	primary, err := currentPrimary(ctx, r.Client, db)
+	if err != nil {
+		return err
+	}
+	pods, err := r.databasePods(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	revision := ss.Status.UpdateRevision
+	replicas := *ss.Spec.Replicas
+	updated := int32(0)
+	for i := int32(0); i < replicas; i++ {
+		if pod := findPod(pods, podName(db, i)); pod != nil && podUpdated(pod, revision) {
+			if ready, _ := podReady(pod); ready {
+				updated++
+			}
+		}
+	}
+
+	rollout := db.Status.Rollout
+	if updated == replicas {
+		if rollout == nil {
+			// Nothing was ever rolled out
+			return nil
+		}
+		// Pods created below a partition would get the old revision
+		if err := r.patchPartition(ctx, ss, 0); err != nil {
+			return err
+		}
+		if rollout.Switchover != "" {
+			sw := &databasev1.Switchover{ObjectMeta: metav1.ObjectMeta{Name: rollout.Switchover, Namespace: db.Namespace}}
+			if err := r.Delete(ctx, sw); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+		next := *rollout
+		next.Phase = rolloutCompleted
+		next.Partition = 0
+		next.UpdatedReplicas = updated
+		next.Switchover = ""
+		next.Message = fmt.Sprintf("All %d pods run revision %s", replicas, revision)
+		if rollout.Phase != rolloutCompleted || rollout.Revision != revision {
+			now := metav1.Now()
+			if rollout.Revision != revision {
+				// Rolled out without a rollout, e.g. by the major upgrade
+				next.StartTime = now
+			}
+			next.Revision = revision
+			next.Image = ss.Spec.Template.Spec.Containers[0].Image
+			next.CompletionTime = &now
+			log.Info("Rollout completed", "revision", revision)
+		}
+		return r.recordRollout(ctx, db, &next)
+	}
+
+	next := databasev1.RolloutStatus{
+		Revision:        revision,
+		Image:           ss.Spec.Template.Spec.Containers[0].Image,
+		UpdatedReplicas: updated,
+		StartTime:       metav1.Now(),
+	}
+	if rollout != nil && rollout.Revision == revision {
+		next.StartTime = rollout.StartTime
+		next.Switchover = rollout.Switchover
+	}
+
+	primaryPod := findPod(pods, primary)
+	primaryUpdated := primaryPod != nil && podUpdated(primaryPod, revision)
+	next.Partition = rolloutPartition(db, ss, primary, primaryUpdated)
+	ordinal, _ := podOrdinal(db, primary)
+
+	switch {
+	case next.Partition == 0:
+		next.Phase = rolloutUpdatingPrimary
+		next.Message = fmt.Sprintf("Updating the former primary and the standbys below it, %d/%d pods updated", updated, replicas)
+		if replicas == 1 {
+			next.Message = fmt.Sprintf("Updating %s, the only pod", primary)
+		}
+	case next.Partition < replicas && !updatedAndReady(db, pods, revision, next.Partition, replicas):
+		next.Phase = rolloutUpdatingStandbys
+		next.Message = fmt.Sprintf("Updating the standbys above primary %s, %d/%d pods updated", primary, updated, replicas)
+	default:
+		// The standbys above the primary are updated, or there are none
+		target := podName(db, replicas-1)
+		if ordinal == replicas-1 {
+			target = podName(db, replicas-2)
+		}
+		name, message, err := r.ownedSwitchover(ctx, db, revision, primary, target)
 		if err != nil {
-			return false, err
+			return err
+		}
+		// The Switchover to the highest standby of a primary with the highest ordinal is done
+		if next.Switchover != "" && next.Switchover != name {
+			previous := &databasev1.Switchover{ObjectMeta: metav1.ObjectMeta{Name: next.Switchover, Namespace: db.Namespace}}
+			if err := r.Delete(ctx, previous); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
 		}
+		next.Phase = rolloutSwitchingOver
+		next.Switchover, next.Message = name, message
+	}
 
-		// Check if update complete
-		// All replicas should be updated and ready
-		if ss.Status.UpdatedReplicas == *ss.Spec.Replicas &&
-			ss.Status.ReadyReplicas == *ss.Spec.Replicas {
-			return true, nil
+	if err := r.patchPartition(ctx, ss, next.Partition); err != nil {
+		return err
+	}
+	return r.recordRollout(ctx, db, &next)
+}
+
+// scaleDownHandover reports whether the primary survives scaling the StatefulSet to
+// replicas pods. A primary that wouldn't first switches over to the highest remaining pod.
+func (r *DatabaseReconciler) scaleDownHandover(ctx context.Context, db *databasev1.Database, replicas int32) (bool, error) {
+	if replicas < 1 {
+		// Stopping every pod needs no primary
+		return true, nil
+	}
+	primary, err := currentPrimary(ctx, r.Client, db)
+	if err != nil {
+		return false, err
+	}
+	prefix := db.Name + "-scale-down"
+	if ordinal, ok := podOrdinal(db, primary); !ok || ordinal < replicas {
+		// The handover, if there was one, is done
+		sw := &databasev1.Switchover{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-%d", prefix, replicas-1), Namespace: db.Namespace}}
+		if err := r.Delete(ctx, sw); err != nil && !errors.IsNotFound(err) {
+			return false, err
 		}
+		return true, nil
+	}
 
-		return false, nil
-	})
+	_, message, err := r.ownedSwitchover(ctx, db, prefix, primary, podName(db, replicas-1))
+	if err != nil {
+		return false, err
+	}
+	ctrl.LoggerFrom(ctx).Info("Holding scale-down until the primary moved", "replicas", replicas, "primary", primary, "message", message)
+	return false, nil
 }
 
-func (r *DatabaseReconciler) createStatefulSet(ctx context.Context, db *databasev1.Database) error {
-	// Create StatefulSet for database
-	// This is a simplified example
-	statefulSet := &appsv1.StatefulSet{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      db.Name,
-			Namespace: db.Namespace,
-		},
-		Spec: appsv1.StatefulSetSpec{
-			Replicas: db.Spec.Replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app": db.Name,
-				},
+// rolloutPartition returns the partition that protects the primary: the standbys above it
+// are updated first, and the former primary once it handed over to an updated pod
+func rolloutPartition(db *databasev1.Database, ss *appsv1.StatefulSet, primary string, primaryUpdated bool) int32 {
+	replicas := int32(1)
+	if ss.Spec.Replicas != nil {
+		replicas = *ss.Spec.Replicas
+	}
+	ordinal, ok := podOrdinal(db, primary)
+	if replicas <= 1 || primaryUpdated || !ok {
+		return 0
+	}
+	return ordinal + 1
+}
+
+// ownedSwitchover makes target the primary with a Switchover owned by the Database, named
+// after prefix and the target's ordinal. A failed Switchover is deleted, so the next
+// reconcile tries again. Rollouts and scale-downs use it.
+func (r *DatabaseReconciler) ownedSwitchover(ctx context.Context, db *databasev1.Database, prefix, primary, target string) (string, string, error) {
+	ordinal, _ := podOrdinal(db, target)
+	name := fmt.Sprintf("%s-%d", prefix, ordinal)
+
+	sw := &databasev1.Switchover{}
+	err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: db.Namespace}, sw)
+	if errors.IsNotFound(err) {
+		sw = &databasev1.Switchover{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: db.Namespace,
+				Labels:    map[string]string{"app": "database", "database": db.Name},
 			},
-			Template: corev1.PodTemplateSpec{
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:  "database",
-							Image: db.Spec.Image,
-						},
-					},
-				},
+			Spec: databasev1.SwitchoverSpec{
+				DatabaseRef:   corev1.LocalObjectReference{Name: db.Name},
+				TargetReplica: target,
 			},
+		}
+		if err := ctrl.SetControllerReference(db, sw, r.Scheme); err != nil {
+			return "", "", err
+		}
+		ctrl.LoggerFrom(ctx).Info("Switching over", "switchover", name, "from", primary, "to", target)
+		if err := r.Create(ctx, sw); err != nil && !errors.IsAlreadyExists(err) {
+			return "", "", err
+		}
+		return name, fmt.Sprintf("Switching over from %s to %s", primary, target), nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case sw.DeletionTimestamp != nil:
+		return name, fmt.Sprintf("Waiting for Switchover %s to be deleted", name), nil
+	case sw.Status.Phase == "Failed" || sw.Status.Phase == "Completed" && primary != target:
+		// Completed, but the primary has moved again since, e.g. by a failover
+		message := fmt.Sprintf("Switchover %s to %s ended %s, trying again", name, target, sw.Status.Phase)
+		if cond := conditions.Get(sw, conditions.Ready); cond != nil && cond.Message != "" {
+			message += ": " + cond.Message
+		}
+		if err := r.Delete(ctx, sw); err != nil && !errors.IsNotFound(err) {
+			return "", "", err
+		}
+		return name, message, nil
+	}
+	return name, fmt.Sprintf("Switching over from %s to %s", primary, target), nil
+}
+
+// updatedAndReady reports whether the pods from ordinal from up to (excluding) to run
+// revision and are ready
+func updatedAndReady(db *databasev1.Database, pods []corev1.Pod, revision string, from, to int32) bool {
+	for i := from; i < to; i++ {
+		pod := findPod(pods, podName(db, i))
+		if pod == nil || !podUpdated(pod, revision) {
+			return false
+		}
+		if ready, _ := podReady(pod); !ready {
+			return false
+		}
+	}
+	return true
+}
+
+// podUpdated reports whether pod was created from the StatefulSet revision
+func podUpdated(pod *corev1.Pod, revision string) bool {
+	return pod.Labels[appsv1.StatefulSetRevisionLabel] == revision
+}
+
+// podOrdinal returns the ordinal of the StatefulSet pod name
+func podOrdinal(db *databasev1.Database, name string) (int32, bool) {
+	suffix, ok := strings.CutPrefix(name, db.Name+"-")
+	if !ok {
+		return 0, false
+	}
+	ordinal, err := strconv.ParseInt(suffix, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int32(ordinal), true
+}
+
+// setPartition makes the StatefulSet only update pods with an ordinal of at least partition
+func setPartition(ss *appsv1.StatefulSet, partition int32) {
+	ss.Spec.UpdateStrategy = appsv1.StatefulSetUpdateStrategy{
+		Type: appsv1.RollingUpdateStatefulSetStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{
+			Partition: ptr.To(partition),
 		},
 	}
+}
 
-	return r.Create(ctx, statefulSet)
+func (r *DatabaseReconciler) patchPartition(ctx context.Context, ss *appsv1.StatefulSet, partition int32) error {
+	if u := ss.Spec.UpdateStrategy.RollingUpdate; u != nil && ptr.Deref(u.Partition, 0) == partition ||
+		u == nil && partition == 0 {
+		return nil
+	}
+	patch := client.MergeFrom(ss.DeepCopy())
+	setPartition(ss, partition)
+	return r.Patch(ctx, ss, patch)
 }
 
+// recordRollout writes status.rollout if it changed. The Database is re-read so the
+// update doesn't conflict with earlier writes.
+func (r *DatabaseReconciler) recordRollout(ctx context.Context, db *databasev1.Database, rollout *databasev1.RolloutStatus) error {
+	latest := &databasev1.Database{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(db), latest); err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(latest.Status.Rollout, rollout) {
+		return nil
+	}
+	latest.Status.Rollout = rollout
+	if err := r.Status().Update(ctx, latest); err != nil && !errors.IsConflict(err) {
+		return err
+	}
+	db.Status.Rollout = rollout
+	return nil
+}
+
+func (r *DatabaseReconciler) createStatefulSet(ctx context.Context, db *databasev1.Database) error {
+	// Start from the StatefulSet of Module 3, which has the PostgreSQL container,
+	// credentials and data volume the replication containers build on
+	statefulSet := r.buildStatefulSet(db)
+	if err := ctrl.SetControllerReference(db, statefulSet, r.Scheme); err != nil {
+		return err
+	}
+
+	if err := r.reconcileHBAConfigMap(ctx, db); err != nil {
+		return err
+	}
+	if err := r.reconcileReplicationConfig(ctx, db); err != nil {
+		return err
+	}
+	if _, err := configurePostgres(db, statefulSet); err != nil {
+		return err
+	}
+
+	return r.Create(ctx, statefulSet)
+}
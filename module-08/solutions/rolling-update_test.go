@@ -0,0 +1,197 @@
+// Solution: Rolling Update Tests from Module 8
+// This tests the partition that keeps the primary on the old template until it handed
+// over, and the phases of a rollout, no cluster needed
+// Location: internal/controller/rolling_update_test.go
+//
+// The specs run in the controller suite kubebuilder scaffolded, with the fake client of
+// failover_test.go.
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+)
+
+var _ = Describe("Rolling update", func() {
+	DescribeTable("rolloutPartition",
+		func(replicas *int32, primary string, primaryUpdated bool, want int32) {
+			db := &databasev1.Database{ObjectMeta: metav1.ObjectMeta{Name: "orders"}}
+			ss := &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: replicas}}
+			Expect(rolloutPartition(db, ss, primary, primaryUpdated)).To(Equal(want))
+		},
+		Entry("updates the standbys above a primary at ordinal 0", ptr.To(int32(3)), "orders-0", false, int32(1)),
+		Entry("updates the standbys above a primary in the middle", ptr.To(int32(3)), "orders-1", false, int32(2)),
+		Entry("updates nothing below a primary at the highest ordinal", ptr.To(int32(3)), "orders-2", false, int32(3)),
+		Entry("updates all pods once the primary is updated", ptr.To(int32(3)), "orders-2", true, int32(0)),
+		Entry("updates a single pod right away", ptr.To(int32(1)), "orders-0", false, int32(0)),
+		Entry("updates a StatefulSet without replicas right away", nil, "orders-0", false, int32(0)),
+		Entry("doesn't protect a primary it doesn't know", ptr.To(int32(3)), "other-1", false, int32(0)),
+		Entry("follows a scale-up mid-rollout", ptr.To(int32(5)), "orders-2", false, int32(3)),
+		Entry("protects a primary a scale-down mid-rollout still keeps", ptr.To(int32(2)), "orders-2", false, int32(3)),
+	)
+
+	Describe("reconcileRollout", func() {
+		const revision = "orders-7d9f"
+
+		var (
+			ctx context.Context
+			db  *databasev1.Database
+			ss  *appsv1.StatefulSet
+			r   *DatabaseReconciler
+		)
+
+		// newStatefulSet returns the StatefulSet of db with replicas pods, rolling out revision
+		newStatefulSet := func(replicas int32) *appsv1.StatefulSet {
+			return &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: db.Name, Namespace: db.Namespace, Generation: 2},
+				Spec: appsv1.StatefulSetSpec{
+					Replicas: ptr.To(replicas),
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "postgres", Image: "postgres:16.4"}}},
+					},
+				},
+				Status: appsv1.StatefulSetStatus{ObservedGeneration: 2, UpdateRevision: revision},
+			}
+		}
+
+		// pod returns the ready pod with the given ordinal, updated to revision or not
+		pod := func(ordinal int32, updated bool) client.Object {
+			p := newTestPod(db, ordinal, roleReplica, true, time.Now())
+			p.Labels[appsv1.StatefulSetRevisionLabel] = "orders-5c4b"
+			if updated {
+				p.Labels[appsv1.StatefulSetRevisionLabel] = revision
+			}
+			return p
+		}
+
+		// rollout runs reconcileRollout with the StatefulSet and the given pods and
+		// returns the recorded rollout and the partition
+		rollout := func(primary string, pods ...client.Object) (*databasev1.RolloutStatus, int32) {
+			// The Database of the spec replaces the one newTestDatabase returns
+			_, objs := newTestDatabase(ptr.Deref(db.Spec.Replicas, 1), primary)
+			objs[0] = db
+			c := newTestClient(newFakeInstances(), append(append(objs, ss), pods...)...)
+			r = &DatabaseReconciler{Client: c, Scheme: c.Scheme()}
+
+			Expect(r.reconcileRollout(ctx, db, ss)).To(Succeed())
+
+			latest := &appsv1.StatefulSet{}
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(ss), latest)).To(Succeed())
+			partition := int32(0)
+			if u := latest.Spec.UpdateStrategy.RollingUpdate; u != nil {
+				partition = ptr.Deref(u.Partition, 0)
+			}
+			return db.Status.Rollout, partition
+		}
+
+		// switchoverTarget returns the target of the Switchover the rollout created
+		switchoverTarget := func(name string) string {
+			sw := &databasev1.Switchover{}
+			Expect(r.Get(ctx, client.ObjectKey{Name: name, Namespace: db.Namespace}, sw)).To(Succeed())
+			return sw.Spec.TargetReplica
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			db, _ = newTestDatabase(3, "orders-0")
+			ss = newStatefulSet(3)
+		})
+
+		It("should update the standbys above a primary at ordinal 0 first", func() {
+			status, partition := rollout("orders-0", pod(0, false), pod(1, false), pod(2, false))
+			Expect(partition).To(Equal(int32(1)))
+			Expect(status.Phase).To(Equal(rolloutUpdatingStandbys))
+			Expect(status.Revision).To(Equal(revision))
+		})
+
+		It("should switch over to the highest standby once the standbys are updated", func() {
+			status, partition := rollout("orders-0", pod(0, false), pod(1, true), pod(2, true))
+			Expect(partition).To(Equal(int32(1)))
+			Expect(status.Phase).To(Equal(rolloutSwitchingOver))
+			Expect(switchoverTarget(status.Switchover)).To(Equal("orders-2"))
+		})
+
+		It("should hand over from a primary at the highest ordinal before it updates anything", func() {
+			status, partition := rollout("orders-2", pod(0, false), pod(1, false), pod(2, false))
+			Expect(partition).To(Equal(int32(3)))
+			Expect(status.Phase).To(Equal(rolloutSwitchingOver))
+			// The highest standby takes over on the old template
+			Expect(switchoverTarget(status.Switchover)).To(Equal("orders-1"))
+		})
+
+		It("should update the former primary once the primary is updated", func() {
+			status, partition := rollout("orders-2", pod(0, false), pod(1, true), pod(2, true))
+			Expect(partition).To(BeZero())
+			Expect(status.Phase).To(Equal(rolloutUpdatingPrimary))
+		})
+
+		It("should update a single pod right away", func() {
+			db.Spec.Replicas = ptr.To(int32(1))
+			ss = newStatefulSet(1)
+			status, partition := rollout("orders-0", pod(0, false))
+			Expect(partition).To(BeZero())
+			Expect(status.Phase).To(Equal(rolloutUpdatingPrimary))
+			Expect(status.Message).To(Equal("Updating orders-0, the only pod"))
+		})
+
+		It("should complete and drop the partition once every pod is updated", func() {
+			db.Status.Rollout = &databasev1.RolloutStatus{Revision: revision, Phase: rolloutUpdatingPrimary, Partition: 0}
+			ss.Spec.UpdateStrategy = appsv1.StatefulSetUpdateStrategy{
+				Type:          appsv1.RollingUpdateStatefulSetStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: ptr.To(int32(3))},
+			}
+			status, partition := rollout("orders-2", pod(0, true), pod(1, true), pod(2, true))
+			Expect(partition).To(BeZero())
+			Expect(status.Phase).To(Equal(rolloutCompleted))
+			Expect(status.UpdatedReplicas).To(Equal(int32(3)))
+			Expect(status.CompletionTime).NotTo(BeNil())
+		})
+
+		Describe("with replicas changing mid-rollout", func() {
+			It("should wait for the standbys a scale-up adds", func() {
+				db.Spec.Replicas = ptr.To(int32(4))
+				ss = newStatefulSet(4)
+				status, partition := rollout("orders-0", pod(0, false), pod(1, true), pod(2, true))
+				Expect(partition).To(Equal(int32(1)))
+				Expect(status.Phase).To(Equal(rolloutUpdatingStandbys))
+			})
+
+			It("should switch over to the new highest standby and drop the old Switchover", func() {
+				db.Status.Rollout = &databasev1.RolloutStatus{
+					Revision: revision, Phase: rolloutSwitchingOver, Partition: 1, Switchover: revision + "-2",
+				}
+				db.Spec.Replicas = ptr.To(int32(4))
+				ss = newStatefulSet(4)
+				previous := &databasev1.Switchover{ObjectMeta: metav1.ObjectMeta{Name: revision + "-2", Namespace: db.Namespace}}
+				status, partition := rollout("orders-0", pod(0, false), pod(1, true), pod(2, true), pod(3, true), previous)
+				Expect(partition).To(Equal(int32(1)))
+				Expect(status.Phase).To(Equal(rolloutSwitchingOver))
+				Expect(switchoverTarget(status.Switchover)).To(Equal("orders-3"))
+
+				err := r.Get(ctx, client.ObjectKeyFromObject(previous), &databasev1.Switchover{})
+				Expect(errors.IsNotFound(err)).To(BeTrue())
+			})
+
+			It("should switch over to the highest pod a scale-down keeps", func() {
+				db.Spec.Replicas = ptr.To(int32(2))
+				ss = newStatefulSet(2)
+				status, partition := rollout("orders-0", pod(0, false), pod(1, true), pod(2, false))
+				Expect(partition).To(Equal(int32(1)))
+				Expect(status.Phase).To(Equal(rolloutSwitchingOver))
+				Expect(switchoverTarget(status.Switchover)).To(Equal("orders-1"))
+			})
+		})
+	})
+})
@@ -0,0 +1,95 @@
+// Solution: Backup Scheduling from Module 8
+// This computes when scheduled backups should run based on BackupSpec.Schedule
+// Location: internal/backup/schedule.go
+//
+// Cron parsing uses github.com/robfig/cron/v3 (the same library used by the
+// Kubebuilder CronJob tutorial):
+//   go get github.com/robfig/cron/v3
+
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	// Embed the IANA time zone database so TimeZone works in minimal images
+	_ "time/tzdata"
+
+	"github.com/robfig/cron/v3"
+)
+
+// ParseSchedule parses a standard five-field cron expression or a predefined
+// macro (@hourly, @daily, @weekly, @monthly, @yearly) evaluated in timeZone.
+// A nil or empty timeZone uses the operator's local time zone.
+func ParseSchedule(schedule string, timeZone *string) (cron.Schedule, error) {
+	if strings.Contains(schedule, "TZ=") {
+		return nil, fmt.Errorf("schedule %q must not contain TZ or CRON_TZ, use timeZone instead", schedule)
+	}
+
+	if timeZone != nil && *timeZone != "" {
+		if _, err := time.LoadLocation(*timeZone); err != nil {
+			return nil, fmt.Errorf("invalid time zone %q: %w", *timeZone, err)
+		}
+		schedule = fmt.Sprintf("CRON_TZ=%s %s", *timeZone, schedule)
+	}
+
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", schedule, err)
+	}
+	// Next returns the zero time for expressions that never match, e.g. "0 0 30 2 *"
+	if sched.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("schedule %q never fires", schedule)
+	}
+	return sched, nil
+}
+
+// maxMissedRuns is how many missed ticks are walked one by one, as in the CronJob
+// controller. After a longer outage only the last ticks before now are looked at.
+const maxMissedRuns = 100
+
+// MostRecentScheduleTime returns the latest time in (earliest, now] at which the
+// schedule should have fired, along with how many times it fired in that window.
+// A zero time means nothing is due yet.
+//
+// A count greater than one means runs were missed (e.g., the operator was down).
+// Only the most recent one should be started; the others are skipped. The count
+// stops at maxMissedRuns+1, so it means "at least" after a long outage.
+func MostRecentScheduleTime(sched cron.Schedule, earliest, now time.Time) (time.Time, int) {
+	var mostRecent time.Time
+	count := 0
+	for t := sched.Next(earliest); !t.IsZero() && !t.After(now); t = sched.Next(t) {
+		if count == maxMissedRuns {
+			return lastScheduleTime(sched, mostRecent, now), count + 1
+		}
+		mostRecent = t
+		count++
+	}
+	return mostRecent, count
+}
+
+// lastScheduleTime returns the latest tick in (after, now], where after is a tick
+// and at least one more is due. It looks back from now in doubling windows, so
+// only the ticks of the last window are walked.
+func lastScheduleTime(sched cron.Schedule, after, now time.Time) time.Time {
+	for window := time.Minute; ; window *= 2 {
+		start := now.Add(-window)
+		if !start.After(after) {
+			start = after
+		}
+		last := after
+		for t := sched.Next(start); !t.IsZero() && !t.After(now); t = sched.Next(t) {
+			last = t
+		}
+		if !last.Equal(after) || start.Equal(after) {
+			return last
+		}
+	}
+}
+
+// ScheduledRunName returns the name of the child Backup created for the run
+// scheduled at scheduledTime, e.g. "nightly-20250102-0200".
+// Names are deterministic so a retried reconcile doesn't create a second run.
+func ScheduledRunName(parentName string, scheduledTime time.Time) string {
+	return fmt.Sprintf("%s-%s", parentName, scheduledTime.UTC().Format("20060102-1504"))
+}
@@ -0,0 +1,97 @@
+// Solution: Backup Schedule Tests from Module 8
+// This tests cron parsing, missed runs and run names for scheduled backups
+// Location: internal/backup/schedule_test.go
+
+package backup
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/robfig/cron/v3"
+	"k8s.io/utils/ptr"
+)
+
+func TestBackup(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Backup Suite")
+}
+
+var _ = Describe("Schedule", func() {
+	DescribeTable("should compute the next run",
+		func(schedule string, timeZone *string, from, next time.Time) {
+			sched, err := ParseSchedule(schedule, timeZone)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sched.Next(from).Equal(next)).To(BeTrue(), "next run %s", sched.Next(from))
+		},
+		Entry("five fields", "30 2 * * *", ptr.To("UTC"),
+			time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC), time.Date(2025, 1, 3, 2, 30, 0, 0, time.UTC)),
+		Entry("@hourly", "@hourly", ptr.To("UTC"),
+			time.Date(2025, 1, 2, 3, 10, 0, 0, time.UTC), time.Date(2025, 1, 2, 4, 0, 0, 0, time.UTC)),
+		Entry("@daily", "@daily", ptr.To("UTC"),
+			time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC), time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)),
+		Entry("@weekly", "@weekly", ptr.To("UTC"),
+			time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC), time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)),
+		Entry("@monthly", "@monthly", ptr.To("UTC"),
+			time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC), time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)),
+		Entry("time zone", "0 2 * * *", ptr.To("America/New_York"),
+			time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC), time.Date(2025, 1, 2, 7, 0, 0, 0, time.UTC)),
+		Entry("time zone in summer time", "0 2 * * *", ptr.To("America/New_York"),
+			time.Date(2025, 7, 2, 3, 0, 0, 0, time.UTC), time.Date(2025, 7, 2, 6, 0, 0, 0, time.UTC)),
+	)
+
+	DescribeTable("should reject invalid schedules",
+		func(schedule string, timeZone *string) {
+			_, err := ParseSchedule(schedule, timeZone)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("syntax error", "every night", nil),
+		Entry("unknown time zone", "@daily", ptr.To("Mars/Olympus_Mons")),
+		Entry("CRON_TZ in the schedule", "CRON_TZ=UTC 0 2 * * *", nil),
+		Entry("never fires", "0 0 30 2 *", ptr.To("UTC")),
+	)
+
+	DescribeTable("should find the most recent run",
+		func(schedule string, earliest, now, mostRecent time.Time, count int) {
+			sched, err := ParseSchedule(schedule, ptr.To("UTC"))
+			Expect(err).NotTo(HaveOccurred())
+			t, n := MostRecentScheduleTime(sched, earliest, now)
+			Expect(t.Equal(mostRecent)).To(BeTrue(), "most recent run %s", t)
+			Expect(n).To(Equal(count))
+		},
+		Entry("nothing due", "0 2 * * *",
+			time.Date(2025, 1, 2, 2, 0, 0, 0, time.UTC), time.Date(2025, 1, 3, 1, 59, 0, 0, time.UTC),
+			time.Time{}, 0),
+		Entry("one run due", "0 2 * * *",
+			time.Date(2025, 1, 2, 2, 0, 0, 0, time.UTC), time.Date(2025, 1, 3, 2, 0, 0, 0, time.UTC),
+			time.Date(2025, 1, 3, 2, 0, 0, 0, time.UTC), 1),
+		Entry("missed runs", "0 2 * * *",
+			time.Date(2025, 1, 2, 2, 0, 0, 0, time.UTC), time.Date(2025, 1, 5, 3, 0, 0, 0, time.UTC),
+			time.Date(2025, 1, 5, 2, 0, 0, 0, time.UTC), 3),
+		Entry("long outage", "* * * * *",
+			time.Date(2024, 1, 2, 2, 0, 0, 0, time.UTC), time.Date(2025, 1, 2, 2, 0, 30, 0, time.UTC),
+			time.Date(2025, 1, 2, 2, 0, 0, 0, time.UTC), maxMissedRuns+1),
+		Entry("long outage with irregular ticks", "0 9 * * 1-5",
+			time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), time.Date(2025, 1, 5, 12, 0, 0, 0, time.UTC),
+			time.Date(2025, 1, 3, 9, 0, 0, 0, time.UTC), maxMissedRuns+1),
+	)
+
+	It("should stop for a schedule that never fires", func() {
+		// ParseSchedule rejects these, Next returns the zero time for them
+		sched, err := cron.ParseStandard("0 0 30 2 *")
+		Expect(err).NotTo(HaveOccurred())
+		t, n := MostRecentScheduleTime(sched, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Now())
+		Expect(t.IsZero()).To(BeTrue())
+		Expect(n).To(BeZero())
+	})
+
+	It("should name runs after their scheduled time in UTC", func() {
+		newYork, err := time.LoadLocation("America/New_York")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ScheduledRunName("nightly", time.Date(2025, 1, 1, 21, 0, 0, 0, newYork))).
+			To(Equal("nightly-20250102-0200"))
+	})
+})
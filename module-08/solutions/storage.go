@@ -0,0 +1,125 @@
+// Solution: Backup Storage from Module 8
+// This defines the BackupStore interface used by backup and restore to move data
+// Location: internal/storage/storage.go
+//
+// The driver is selected by the scheme of BackupSpec.StorageLocation:
+//   file:///var/backups/prod          - local directory
+//   pvc://backup-claim/prod           - PVC mounted at /backups/backup-claim
+//   s3://bucket/prod?endpoint=minio.minio.svc:9000&insecure=true
+//                                     - S3 or S3-compatible object storage
+// Backups only accept pvc:// and s3://: a local directory is the filesystem of
+// whichever pod opens it, so only the process that wrote it could read it back.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PVCMountRoot is where PVCs referenced by pvc:// locations are mounted
+const PVCMountRoot = "/backups"
+
+// ErrNotFound is returned by Get when the object does not exist
+var ErrNotFound = errors.New("backup object not found")
+
+// BackupStore stores backup artifacts. Keys are slash-separated paths.
+type BackupStore interface {
+	// Put streams r into the object at key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader) error
+
+	// Get opens the object at key for reading. Callers must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// List returns all objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+
+	// Delete removes the object at key. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Config holds settings that don't belong in the location URL
+type Config struct {
+	// Credentials is the data of the storage Secret (may be nil).
+	// S3 uses the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY keys.
+	Credentials map[string][]byte
+}
+
+// Open returns the BackupStore for location and the object key within it.
+// The key is the path part of location, e.g. "prod/db.sql" for s3://bucket/prod/db.sql.
+func Open(ctx context.Context, location string, cfg Config) (BackupStore, string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid storage location %q: %w", location, err)
+	}
+
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" {
+			return nil, "", fmt.Errorf("invalid storage location %q: file locations must be absolute (file:///path)", location)
+		}
+		return NewFilesystemStore("/"), strings.TrimPrefix(u.Path, "/"), nil
+	case "pvc":
+		if u.Host == "" {
+			return nil, "", fmt.Errorf("invalid storage location %q: missing PVC name", location)
+		}
+		return NewFilesystemStore(path.Join(PVCMountRoot, u.Host)), strings.TrimPrefix(u.Path, "/"), nil
+	case "s3":
+		store, err := newS3StoreFromURL(u, cfg)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid storage location %q: %w", location, err)
+		}
+		return store, strings.TrimPrefix(u.Path, "/"), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported storage location %q: scheme must be file, pvc or s3", location)
+	}
+}
+
+// JoinLocation appends path elements to a location, keeping any query parameters
+func JoinLocation(location string, elem ...string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid storage location %q: %w", location, err)
+	}
+	u.Path = path.Join(append([]string{"/", u.Path}, elem...)...)
+	return u.String(), nil
+}
+
+// ConfigFromSecret builds a Config from the Secret referenced by ref.
+// A nil ref returns an empty Config (e.g., filesystem storage or IAM-based S3 access).
+func ConfigFromSecret(ctx context.Context, k8sClient client.Client, namespace string, ref *corev1.LocalObjectReference) (Config, error) {
+	if ref == nil || ref.Name == "" {
+		return Config{}, nil
+	}
+
+	secret := &corev1.Secret{}
+	err := k8sClient.Get(ctx, client.ObjectKey{
+		Name:      ref.Name,
+		Namespace: namespace,
+	}, secret)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return Config{}, fmt.Errorf("storage secret %s not found", ref.Name)
+		}
+		return Config{}, fmt.Errorf("failed to get storage secret: %w", err)
+	}
+
+	return Config{Credentials: secret.Data}, nil
+}
@@ -0,0 +1,130 @@
+// Solution: Backup Compression and Checksums from Module 8
+// This compresses backup streams and computes their SHA-256 digest
+// Location: internal/storage/compression.go
+//
+// zstd uses the klauspost/compress package:
+//   go get github.com/klauspost/compress
+
+package storage
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported values of BackupSpec.Compression
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+// DefaultCompression matches the +kubebuilder:default on BackupSpec.Compression
+const DefaultCompression = CompressionGzip
+
+// Extension returns the file name suffix for compression, e.g. ".gz"
+func Extension(compression string) string {
+	switch compression {
+	case CompressionNone:
+		return ""
+	case CompressionZstd:
+		return ".zst"
+	default:
+		return ".gz"
+	}
+}
+
+// NewCompressor returns a writer that compresses into w.
+// Close flushes the compressed stream but does not close w.
+func NewCompressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "", CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// NewDecompressor returns a reader that decompresses r
+func NewDecompressor(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case "", CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case CompressionNone:
+		return io.NopCloser(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// Digester computes the SHA-256 digest and size of everything written to it
+type Digester struct {
+	hash hash.Hash
+	size int64
+}
+
+// NewDigester returns an empty Digester
+func NewDigester() *Digester {
+	return &Digester{hash: sha256.New()}
+}
+
+func (d *Digester) Write(p []byte) (int, error) {
+	n, _ := d.hash.Write(p)
+	d.size += int64(n)
+	return n, nil
+}
+
+// Digest returns the digest in "sha256:<hex>" form
+func (d *Digester) Digest() string {
+	return "sha256:" + hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// Size returns the number of bytes written
+func (d *Digester) Size() int64 {
+	return d.size
+}
+
+// Verify reads the object at key and checks it against digest and size.
+// It streams the object, so it can be used on backups larger than memory.
+func Verify(ctx context.Context, store BackupStore, key, digest string, size int64) error {
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	d := NewDigester()
+	if _, err := io.Copy(d, r); err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	if got := d.Digest(); got != digest {
+		return fmt.Errorf("digest mismatch for %s: expected %s, got %s", key, digest, got)
+	}
+	if size > 0 && d.Size() != size {
+		return fmt.Errorf("size mismatch for %s: expected %d bytes, got %d", key, size, d.Size())
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+// Solution: Backup Encryption from Module 8
+// This encrypts backup streams on the client side before they reach storage
+// Location: internal/storage/encryption.go
+//
+// Two algorithms are supported, configured by BackupSpec.Encryption:
+//   aes-256-gcm - symmetric 32-byte key from the Secret's "key" entry
+//   age         - public-key encryption to the Secret's "recipients" (https://age-encryption.org);
+//                 restores need the matching identities ("AGE-SECRET-KEY-1...")
+//
+// Every backup records the ID of the key it was encrypted with. Retired keys stay in
+// the Secret under other names, so rotating "key" doesn't orphan older backups.
+//
+// age uses the filippo.io/age package:
+//   go get filippo.io/age
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// Supported values of EncryptionSpec.Algorithm
+const (
+	EncryptionAESGCM = "aes-256-gcm"
+	EncryptionAge    = "age"
+)
+
+// Secret keys used for new backups
+const (
+	AESKeySecretKey        = "key"
+	AgeRecipientsSecretKey = "recipients"
+)
+
+// Encryption configures encryption of a backup stream
+type Encryption struct {
+	// Algorithm is EncryptionAESGCM or EncryptionAge
+	Algorithm string
+	// Keys is the data of the encryption Secret
+	Keys map[string][]byte
+	// KeyID selects the key used to decrypt (restore only)
+	KeyID string
+}
+
+// KeyAlgorithm returns the algorithm a key ID belongs to, e.g. "age" for "age:1f2e..."
+func KeyAlgorithm(keyID string) string {
+	algorithm, _, _ := strings.Cut(keyID, ":")
+	return algorithm
+}
+
+// EncryptionExtension returns the file name suffix for algorithm, e.g. ".age"
+func EncryptionExtension(algorithm string) string {
+	if algorithm == EncryptionAge {
+		return ".age"
+	}
+	return ".aes"
+}
+
+// NewEncryptor returns a writer that encrypts into w with the current key,
+// and the ID of that key. Close writes the end of the stream but does not close w.
+func NewEncryptor(w io.Writer, enc *Encryption) (io.WriteCloser, string, error) {
+	switch enc.Algorithm {
+	case EncryptionAESGCM:
+		key := enc.Keys[AESKeySecretKey]
+		aead, err := newAESGCM(key)
+		if err != nil {
+			return nil, "", err
+		}
+		ew, err := newAESGCMWriter(w, aead)
+		if err != nil {
+			return nil, "", err
+		}
+		return ew, aesKeyID(key), nil
+	case EncryptionAge:
+		data := enc.Keys[AgeRecipientsSecretKey]
+		recipients, err := age.ParseRecipients(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid age recipients: %w", err)
+		}
+		ew, err := age.Encrypt(w, recipients...)
+		if err != nil {
+			return nil, "", err
+		}
+		return ew, ageKeyID(data), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported encryption algorithm %q", enc.Algorithm)
+	}
+}
+
+// NewDecryptor returns a reader that decrypts r.
+// For aes-256-gcm it uses the Secret entry whose ID matches enc.KeyID;
+// for age, every age identity in the Secret is tried.
+func NewDecryptor(r io.Reader, enc *Encryption) (io.Reader, error) {
+	switch enc.Algorithm {
+	case EncryptionAESGCM:
+		for _, key := range enc.Keys {
+			if aesKeyID(key) != enc.KeyID {
+				continue
+			}
+			aead, err := newAESGCM(key)
+			if err != nil {
+				return nil, err
+			}
+			return newAESGCMReader(r, aead)
+		}
+		return nil, fmt.Errorf("encryption key %s not found in secret", enc.KeyID)
+	case EncryptionAge:
+		var identities []age.Identity
+		for _, data := range enc.Keys {
+			ids, err := age.ParseIdentities(bytes.NewReader(data))
+			if err != nil {
+				// Not an identity file, e.g. the recipients entry
+				continue
+			}
+			identities = append(identities, ids...)
+		}
+		if len(identities) == 0 {
+			return nil, fmt.Errorf("no age identities found in secret")
+		}
+		return age.Decrypt(r, identities...)
+	default:
+		return nil, fmt.Errorf("unsupported encryption algorithm %q", enc.Algorithm)
+	}
+}
+
+// aesKeyID identifies a key without revealing it
+func aesKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return EncryptionAESGCM + ":" + hex.EncodeToString(sum[:8])
+}
+
+// ageKeyID identifies a set of recipients, independent of their order
+func ageKeyID(data []byte) string {
+	var recipients []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			recipients = append(recipients, line)
+		}
+	}
+	sort.Strings(recipients)
+	sum := sha256.Sum256([]byte(strings.Join(recipients, "\n")))
+	return EncryptionAge + ":" + hex.EncodeToString(sum[:8])
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("aes-256-gcm key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// AES-GCM can't encrypt a stream in one go, so the stream is split into chunks
+// that are sealed separately:
+//
+//	header: magic | 7-byte random nonce prefix
+//	chunk:  AES-GCM(plaintext[64 KiB]), nonce = prefix | chunk counter | last-chunk flag
+//
+// The counter stops chunks from being reordered and the flag detects truncation.
+const (
+	aesMagic       = "PGBKAES1"
+	aesChunkSize   = 64 * 1024
+	aesNoncePrefix = 7
+)
+
+type aesGCMWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+}
+
+func newAESGCMWriter(w io.Writer, aead cipher.AEAD) (*aesGCMWriter, error) {
+	prefix := make([]byte, aesNoncePrefix)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, aesMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &aesGCMWriter{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, aesChunkSize)}, nil
+}
+
+func (e *aesGCMWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// Only seal a full chunk once more data arrives, so Close can mark the last one
+		if len(e.buf) == aesChunkSize {
+			if err := e.seal(false); err != nil {
+				return 0, err
+			}
+		}
+		m := copy(e.buf[len(e.buf):aesChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+m]
+		p = p[m:]
+	}
+	return n, nil
+}
+
+func (e *aesGCMWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *aesGCMWriter) seal(last bool) error {
+	nonce := aesNonce(e.prefix, e.counter, last)
+	if _, err := e.w.Write(e.aead.Seal(nil, nonce, e.buf, nil)); err != nil {
+		return err
+	}
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+type aesGCMReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	plain   []byte
+	done    bool
+}
+
+func newAESGCMReader(r io.Reader, aead cipher.AEAD) (*aesGCMReader, error) {
+	header := make([]byte, len(aesMagic)+aesNoncePrefix)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	if string(header[:len(aesMagic)]) != aesMagic {
+		return nil, fmt.Errorf("backup is not aes-256-gcm encrypted")
+	}
+	return &aesGCMReader{
+		r:      bufio.NewReader(r),
+		aead:   aead,
+		prefix: header[len(aesMagic):],
+		chunk:  make([]byte, aesChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *aesGCMReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *aesGCMReader) open() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	last := false
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		// A short chunk is the last one
+		last = true
+	case err != nil:
+		return err
+	default:
+		// A full chunk is the last one if nothing follows it
+		if _, err := d.r.Peek(1); errors.Is(err, io.EOF) {
+			last = true
+		}
+	}
+
+	plain, err := d.aead.Open(d.chunk[:0], aesNonce(d.prefix, d.counter, last), d.chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt backup (wrong key, corrupted or truncated): %w", err)
+	}
+	d.counter++
+	d.plain = plain
+	d.done = last
+	return nil
+}
+
+func aesNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
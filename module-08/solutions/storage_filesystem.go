@@ -0,0 +1,126 @@
+// Solution: Filesystem Backup Storage from Module 8
+// This stores backups in a local directory or a mounted PVC
+// Location: internal/storage/filesystem.go
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FilesystemStore stores backups as files below a root directory
+type FilesystemStore struct {
+	root string
+}
+
+var _ BackupStore = &FilesystemStore{}
+
+// NewFilesystemStore returns a store rooted at dir
+func NewFilesystemStore(dir string) *FilesystemStore {
+	return &FilesystemStore{root: filepath.Clean(dir)}
+}
+
+// path maps a key to a file below root, rejecting keys that escape it
+func (s *FilesystemStore) path(key string) (string, error) {
+	p := filepath.Join(s.root, filepath.FromSlash(key))
+	if p != s.root && !strings.HasPrefix(p, strings.TrimSuffix(s.root, string(filepath.Separator))+string(filepath.Separator)) {
+		return "", fmt.Errorf("key %q is outside of %s", key, s.root)
+	}
+	return p, nil
+}
+
+// Put writes to a temporary file first so readers never see a partial backup
+func (s *FilesystemStore) Put(ctx context.Context, key string, r io.Reader) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *FilesystemStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return f, err
+}
+
+func (s *FilesystemStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		key, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key = filepath.ToSlash(key)
+
+		if d.IsDir() {
+			// Skip directories that can't contain matching keys
+			if key != "." && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	return objects, err
+}
+
+func (s *FilesystemStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
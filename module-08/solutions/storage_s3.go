@@ -0,0 +1,152 @@
+// Solution: S3 Backup Storage from Module 8
+// This stores backups in S3 or any S3-compatible object storage (MinIO, Ceph RGW, ...)
+// Location: internal/storage/s3.go
+//
+// Uses the MinIO Go client, which works with AWS S3 and S3-compatible servers:
+//   go get github.com/minio/minio-go/v7
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	defaultS3Endpoint = "s3.amazonaws.com"
+	defaultS3Region   = "us-east-1"
+)
+
+// S3Store stores backups as objects in a single bucket
+type S3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+var _ BackupStore = &S3Store{}
+
+// S3Options configures an S3Store
+type S3Options struct {
+	// Endpoint is host[:port] of the S3 API (defaults to AWS)
+	Endpoint string
+	// Region is the bucket region (defaults to us-east-1)
+	Region string
+	// Insecure uses plain HTTP, e.g. for an in-cluster MinIO
+	Insecure bool
+	// AccessKeyID and SecretAccessKey are static credentials.
+	// If empty, credentials are taken from the environment or IAM role.
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// NewS3Store returns a store for bucket
+func NewS3Store(bucket string, opts S3Options) (*S3Store, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("missing bucket name")
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = defaultS3Endpoint
+	}
+	if opts.Region == "" {
+		opts.Region = defaultS3Region
+	}
+
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.IAM{},
+	})
+	if opts.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(opts.AccessKeyID, opts.SecretAccessKey, "")
+	}
+
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !opts.Insecure,
+		// Setting the region avoids an extra GetBucketLocation call
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return &S3Store{client: client, bucket: bucket}, nil
+}
+
+// newS3StoreFromURL builds a store from s3://bucket/...?endpoint=&region=&insecure=
+func newS3StoreFromURL(u *url.URL, cfg Config) (*S3Store, error) {
+	q := u.Query()
+	opts := S3Options{
+		Endpoint:        q.Get("endpoint"),
+		Region:          q.Get("region"),
+		AccessKeyID:     string(cfg.Credentials["AWS_ACCESS_KEY_ID"]),
+		SecretAccessKey: string(cfg.Credentials["AWS_SECRET_ACCESS_KEY"]),
+	}
+	if v := q.Get("insecure"); v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid insecure value %q: %w", v, err)
+		}
+		opts.Insecure = insecure
+	}
+	return NewS3Store(u.Host, opts)
+}
+
+// Put streams r to S3. The size is unknown, so large backups are uploaded in parts.
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, -1, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download s3://%s/%s: %w", s.bucket, key, err)
+	}
+
+	// GetObject is lazy, Stat surfaces a missing object before the caller starts reading
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("s3://%s/%s: %w", s.bucket, key, ErrNotFound)
+		}
+		return nil, fmt.Errorf("failed to download s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return obj, nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list s3://%s/%s: %w", s.bucket, prefix, obj.Err)
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          obj.Key,
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+// Delete removes the object. S3 doesn't report missing keys on delete.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete s3://%s/%s: %w", s.bucket, key, err)
+	}
+	return nil
+}
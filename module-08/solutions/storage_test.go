@@ -0,0 +1,478 @@
+// Solution: Backup Storage Tests from Module 8
+// This tests every BackupStore driver against the same behaviour.
+// The S3 driver runs against an in-process fake S3 server, so no cloud account is needed.
+// Location: internal/storage/storage_test.go
+
+package storage
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestStorage(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Storage Suite")
+}
+
+// storeContract runs the same specs against any BackupStore
+func storeContract(newStore func() BackupStore) {
+	var (
+		ctx   context.Context
+		store BackupStore
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		store = newStore()
+	})
+
+	It("should read back what was written", func() {
+		Expect(store.Put(ctx, "prod/db-1.sql", strings.NewReader("-- dump 1"))).To(Succeed())
+
+		r, err := store.Get(ctx, "prod/db-1.sql")
+		Expect(err).NotTo(HaveOccurred())
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal("-- dump 1"))
+	})
+
+	It("should replace an existing object", func() {
+		Expect(store.Put(ctx, "prod/db-1.sql", strings.NewReader("old"))).To(Succeed())
+		Expect(store.Put(ctx, "prod/db-1.sql", strings.NewReader("new"))).To(Succeed())
+
+		r, err := store.Get(ctx, "prod/db-1.sql")
+		Expect(err).NotTo(HaveOccurred())
+		defer r.Close()
+		Expect(io.ReadAll(r)).To(BeEquivalentTo("new"))
+	})
+
+	It("should return ErrNotFound for a missing object", func() {
+		_, err := store.Get(ctx, "prod/missing.sql")
+		Expect(err).To(MatchError(ErrNotFound))
+	})
+
+	It("should list objects by prefix", func() {
+		Expect(store.Put(ctx, "prod/db-1.sql", strings.NewReader("1"))).To(Succeed())
+		Expect(store.Put(ctx, "prod/db-2.sql", strings.NewReader("22"))).To(Succeed())
+		Expect(store.Put(ctx, "staging/db-1.sql", strings.NewReader("333"))).To(Succeed())
+
+		objects, err := store.List(ctx, "prod/")
+		Expect(err).NotTo(HaveOccurred())
+		sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+		Expect(objects).To(HaveLen(2))
+		Expect(objects[0].Key).To(Equal("prod/db-1.sql"))
+		Expect(objects[0].Size).To(Equal(int64(1)))
+		Expect(objects[1].Key).To(Equal("prod/db-2.sql"))
+		Expect(objects[1].Size).To(Equal(int64(2)))
+		Expect(objects[1].LastModified).NotTo(BeZero())
+	})
+
+	It("should delete objects and ignore missing ones", func() {
+		Expect(store.Put(ctx, "prod/db-1.sql", strings.NewReader("1"))).To(Succeed())
+		Expect(store.Delete(ctx, "prod/db-1.sql")).To(Succeed())
+		Expect(store.Delete(ctx, "prod/db-1.sql")).To(Succeed())
+
+		_, err := store.Get(ctx, "prod/db-1.sql")
+		Expect(err).To(MatchError(ErrNotFound))
+	})
+}
+
+var _ = Describe("FilesystemStore", func() {
+	storeContract(func() BackupStore {
+		return NewFilesystemStore(GinkgoT().TempDir())
+	})
+
+	It("should reject keys outside of the root", func() {
+		store := NewFilesystemStore(GinkgoT().TempDir())
+		err := store.Put(context.Background(), "../escape.sql", strings.NewReader("x"))
+		Expect(err).To(MatchError(ContainSubstring("outside of")))
+	})
+})
+
+var _ = Describe("S3Store", func() {
+	storeContract(func() BackupStore {
+		server := httptest.NewServer(newFakeS3("backups"))
+		DeferCleanup(server.Close)
+
+		location := fmt.Sprintf("s3://backups/?endpoint=%s&insecure=true", strings.TrimPrefix(server.URL, "http://"))
+		store, _, err := Open(context.Background(), location, Config{
+			Credentials: map[string][]byte{
+				"AWS_ACCESS_KEY_ID":     []byte("test"),
+				"AWS_SECRET_ACCESS_KEY": []byte("test-secret"),
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		return store
+	})
+})
+
+var _ = Describe("Open", func() {
+	It("should select the driver and key from the location", func() {
+		store, key, err := Open(context.Background(), "file:///var/backups/prod/db.sql", Config{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store).To(BeAssignableToTypeOf(&FilesystemStore{}))
+		Expect(key).To(Equal("var/backups/prod/db.sql"))
+
+		store, key, err = Open(context.Background(), "pvc://backup-claim/prod/db.sql", Config{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.(*FilesystemStore).root).To(Equal("/backups/backup-claim"))
+		Expect(key).To(Equal("prod/db.sql"))
+
+		store, key, err = Open(context.Background(), "s3://bucket/prod/db.sql?region=eu-west-1", Config{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.(*S3Store).bucket).To(Equal("bucket"))
+		Expect(key).To(Equal("prod/db.sql"))
+	})
+
+	It("should reject unknown schemes", func() {
+		_, _, err := Open(context.Background(), "gs://bucket/prod", Config{})
+		Expect(err).To(MatchError(ContainSubstring("unsupported storage location")))
+	})
+
+	It("should join paths and keep query parameters", func() {
+		location, err := JoinLocation("s3://bucket/prod?endpoint=minio:9000", "default", "db.sql")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(location).To(Equal("s3://bucket/prod/default/db.sql?endpoint=minio:9000"))
+	})
+})
+
+var _ = Describe("Compression", func() {
+	for _, compression := range []string{CompressionGzip, CompressionZstd, CompressionNone} {
+		It("should round-trip "+compression, func() {
+			var buf strings.Builder
+			w, err := NewCompressor(&buf, compression)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.WriteString(w, strings.Repeat("INSERT INTO t VALUES (1);\n", 100))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(w.Close()).To(Succeed())
+
+			r, err := NewDecompressor(strings.NewReader(buf.String()), compression)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := io.ReadAll(r)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.Close()).To(Succeed())
+			Expect(string(data)).To(Equal(strings.Repeat("INSERT INTO t VALUES (1);\n", 100)))
+		})
+	}
+
+	It("should reject unknown algorithms", func() {
+		_, err := NewCompressor(io.Discard, "lz4")
+		Expect(err).To(MatchError(ContainSubstring("unsupported compression")))
+	})
+})
+
+var _ = Describe("Verify", func() {
+	var (
+		ctx   context.Context
+		store BackupStore
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		store = NewFilesystemStore(GinkgoT().TempDir())
+		Expect(store.Put(ctx, "db.sql.gz", strings.NewReader("backup data"))).To(Succeed())
+	})
+
+	It("should accept a matching digest and size", func() {
+		d := NewDigester()
+		_, _ = io.WriteString(d, "backup data")
+		Expect(d.Digest()).To(HavePrefix("sha256:"))
+		Expect(Verify(ctx, store, "db.sql.gz", d.Digest(), d.Size())).To(Succeed())
+	})
+
+	It("should reject a corrupted object", func() {
+		d := NewDigester()
+		_, _ = io.WriteString(d, "other data")
+		Expect(Verify(ctx, store, "db.sql.gz", d.Digest(), 0)).To(MatchError(ContainSubstring("digest mismatch")))
+	})
+})
+
+var _ = Describe("Encryption", func() {
+	encrypt := func(enc *Encryption, plain string) (string, string) {
+		var buf strings.Builder
+		w, keyID, err := NewEncryptor(&buf, enc)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = io.WriteString(w, plain)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+		return buf.String(), keyID
+	}
+
+	decrypt := func(enc *Encryption, ciphertext string) (string, error) {
+		r, err := NewDecryptor(strings.NewReader(ciphertext), enc)
+		if err != nil {
+			return "", err
+		}
+		data, err := io.ReadAll(r)
+		return string(data), err
+	}
+
+	key := func(b byte) []byte {
+		return []byte(strings.Repeat(string(b), 32))
+	}
+
+	for _, size := range []int{0, 1, aesChunkSize, aesChunkSize + 1, 3*aesChunkSize + 17} {
+		It(fmt.Sprintf("should round-trip %d bytes with aes-256-gcm", size), func() {
+			plain := strings.Repeat("x", size)
+			keys := map[string][]byte{AESKeySecretKey: key('a')}
+			ciphertext, keyID := encrypt(&Encryption{Algorithm: EncryptionAESGCM, Keys: keys}, plain)
+			Expect(keyID).To(HavePrefix("aes-256-gcm:"))
+			Expect(ciphertext).NotTo(ContainSubstring("xxxx"))
+
+			got, err := decrypt(&Encryption{Algorithm: EncryptionAESGCM, Keys: keys, KeyID: keyID}, ciphertext)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(plain))
+		})
+	}
+
+	It("should find a rotated aes-256-gcm key by its ID", func() {
+		ciphertext, keyID := encrypt(&Encryption{
+			Algorithm: EncryptionAESGCM,
+			Keys:      map[string][]byte{AESKeySecretKey: key('a')},
+		}, "old backup")
+
+		rotated := map[string][]byte{AESKeySecretKey: key('b'), "key-2024": key('a')}
+		got, err := decrypt(&Encryption{Algorithm: EncryptionAESGCM, Keys: rotated, KeyID: keyID}, ciphertext)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("old backup"))
+
+		_, err = decrypt(&Encryption{
+			Algorithm: EncryptionAESGCM,
+			Keys:      map[string][]byte{AESKeySecretKey: key('b')},
+			KeyID:     keyID,
+		}, ciphertext)
+		Expect(err).To(MatchError(ContainSubstring("not found")))
+	})
+
+	It("should detect truncated and modified aes-256-gcm backups", func() {
+		keys := map[string][]byte{AESKeySecretKey: key('a')}
+		ciphertext, keyID := encrypt(&Encryption{Algorithm: EncryptionAESGCM, Keys: keys}, strings.Repeat("x", 2*aesChunkSize+5))
+		enc := &Encryption{Algorithm: EncryptionAESGCM, Keys: keys, KeyID: keyID}
+
+		// Drop the last chunk: the previous one isn't marked as last
+		_, err := decrypt(enc, ciphertext[:len(aesMagic)+aesNoncePrefix+2*(aesChunkSize+16)])
+		Expect(err).To(MatchError(ContainSubstring("failed to decrypt")))
+
+		modified := []byte(ciphertext)
+		modified[len(modified)-1] ^= 1
+		_, err = decrypt(enc, string(modified))
+		Expect(err).To(MatchError(ContainSubstring("failed to decrypt")))
+	})
+
+	It("should round-trip with age", func() {
+		identity, err := age.GenerateX25519Identity()
+		Expect(err).NotTo(HaveOccurred())
+		keys := map[string][]byte{
+			AgeRecipientsSecretKey: []byte(identity.Recipient().String() + "\n"),
+			"identity":             []byte(identity.String() + "\n"),
+		}
+
+		ciphertext, keyID := encrypt(&Encryption{Algorithm: EncryptionAge, Keys: keys}, "secret data")
+		Expect(keyID).To(HavePrefix("age:"))
+		Expect(KeyAlgorithm(keyID)).To(Equal(EncryptionAge))
+
+		got, err := decrypt(&Encryption{Algorithm: EncryptionAge, Keys: keys, KeyID: keyID}, ciphertext)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got).To(Equal("secret data"))
+	})
+})
+
+// fakeS3 is a minimal in-memory, path-style S3 API: enough for PutObject
+// (including multipart uploads), GetObject, StatObject, RemoveObject and
+// ListObjectsV2. It does not check signatures.
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string]fakeObject
+	uploads map[string]map[int][]byte
+}
+
+type fakeObject struct {
+	data     []byte
+	modified time.Time
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{bucket: bucket, objects: map[string]fakeObject{}, uploads: map[string]map[int][]byte{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	q := r.URL.Query()
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, q)
+	case q.Has("uploads") || q.Has("uploadId"):
+		f.multipart(w, r, key, q)
+	case r.Method == http.MethodPut:
+		data, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = fakeObject{data: data, modified: time.Now().UTC()}
+		w.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(obj.data))
+		w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) multipart(w http.ResponseWriter, r *http.Request, key string, q url.Values) {
+	uploadID := q.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		uploadID = strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[uploadID] = map[int][]byte{}
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: f.bucket, Key: key, UploadId: uploadID})
+	case r.Method == http.MethodPut:
+		partNumber, _ := strconv.Atoi(q.Get("partNumber"))
+		data, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.uploads[uploadID][partNumber] = data
+		w.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodPost:
+		parts := f.uploads[uploadID]
+		var data []byte
+		for i := 1; i <= len(parts); i++ {
+			data = append(data, parts[i]...)
+		}
+		delete(f.uploads, uploadID)
+		f.objects[key] = fakeObject{data: data, modified: time.Now().UTC()}
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: f.bucket, Key: key, ETag: etag(data)})
+	case r.Method == http.MethodDelete:
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, q url.Values) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	result := struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Name     string
+		Prefix   string
+		KeyCount int
+		Contents []content
+	}{Name: f.bucket, Prefix: q.Get("prefix")}
+
+	for key, obj := range f.objects {
+		if strings.HasPrefix(key, result.Prefix) {
+			result.Contents = append(result.Contents, content{
+				Key:          key,
+				LastModified: obj.modified.Format(time.RFC3339),
+				ETag:         etag(obj.data),
+				Size:         len(obj.data),
+			})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// readS3Body decodes the aws-chunked encoding the client uses for uploads over plain HTTP
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+
+	var data []byte
+	br := bufio.NewReader(r.Body)
+	for {
+		header, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(header), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data, nil
+		}
+		chunk := make([]byte, size+2) // chunk data followed by \r\n
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
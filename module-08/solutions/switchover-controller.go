@@ -0,0 +1,480 @@
+// Solution: Switchover Controller from Module 8
+// This hands the primary role of a Database to a standby on request, e.g. before
+// maintenance on the primary's node. Unlike a failover (failover.go) the old primary
+// is still running, so no committed transaction may be lost.
+//
+// Use kubebuilder to scaffold the API and controller first (same group as Database):
+//   kubebuilder create api --group database --version v1 --kind Switchover --resource --controller
+//
+// Then replace the generated controller with this implementation. A Switchover goes:
+// 1. Pending: wait until spec.targetReplica streams from the primary and has at most
+//    spec.maxLagBytes of WAL left to replay. It fails if that doesn't happen within
+//    spec.timeoutSeconds, so a lagging standby is never promoted.
+// 2. InProgress: the SwitchoverInProgressAnnotation stops the Database controller from
+//    failing over or promoting anything. The replication ConfigMap and status.failovers
+//    name the target, and the old primary pod is deleted. Its fast shutdown waits until
+//    the standbys have received all of its WAL. The StatefulSet recreates the pod, and
+//    init-instance rewinds it into a standby of the target.
+// 3. Once the old primary is gone, the target is promoted and the read-write Service
+//    is pointed at it (reconcileReadWriteService, replication.go)
+// 4. Completed: the annotation is removed and the Database controller takes over again
+//
+// Clients of the read-write Service get errors from the time the old primary shuts down
+// until the target is promoted, usually a few seconds.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/conditions"
+	"github.com/example/postgres-operator/internal/instance"
+	"github.com/example/postgres-operator/internal/phase"
+)
+
+const (
+	// switchoverFinalizer removes the SwitchoverInProgressAnnotation from the Database
+	// when a Switchover is deleted before it finished, so failover works again
+	switchoverFinalizer = "database.example.com/switchover"
+
+	// defaultSwitchoverMaxLag and defaultSwitchoverTimeout apply without spec.maxLagBytes
+	// and spec.timeoutSeconds
+	defaultSwitchoverMaxLag  = 16 * 1024 * 1024
+	defaultSwitchoverTimeout = 5 * time.Minute
+
+	// switchoverPollInterval is how often a Switchover checks on the pods
+	switchoverPollInterval = 5 * time.Second
+)
+
+// SwitchoverReconciler reconciles a Switchover object
+type SwitchoverReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=database.example.com,resources=switchovers,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=database.example.com,resources=switchovers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=database.example.com,resources=switchovers/finalizers,verbs=update
+// +kubebuilder:rbac:groups=database.example.com,resources=databases,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=database.example.com,resources=databases/status,verbs=get;update
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;delete
+
+func (r *SwitchoverReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	sw := &databasev1.Switchover{}
+	if err := r.Get(ctx, req.NamespacedName, sw); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !sw.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.release(ctx, sw)
+	}
+
+	result, err := r.phases(req).Step(ctx, sw)
+	if err != nil || result.Requeue && result.Transition == nil {
+		// A conflicting handler left a stale Switchover behind
+		return result.Result, err
+	}
+	// The handlers persist their own transitions, the engine's (out of an unknown phase) are left.
+	// Once the phase went through, it has acted on the spec generation.
+	if result.Transition != nil || sw.Status.ObservedGeneration != sw.Generation {
+		sw.Status.ObservedGeneration = sw.Generation
+		if err := r.Status().Update(ctx, sw); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+	}
+	return result.Result, nil
+}
+
+// phases declares the phases of a Switchover. The handlers record their transitions
+// themselves. A Failed Switchover isn't retried, create a new one instead.
+func (r *SwitchoverReconciler) phases(req ctrl.Request) *phase.Machine[*databasev1.Switchover] {
+	done := func(ctx context.Context, sw *databasev1.Switchover) (phase.Outcome, error) {
+		return phase.Outcome{}, nil
+	}
+	return &phase.Machine[*databasev1.Switchover]{
+		Initial: "Pending",
+		Phases: []phase.Phase[*databasev1.Switchover]{
+			{
+				Name: "Pending",
+				Handle: func(ctx context.Context, sw *databasev1.Switchover) (phase.Outcome, error) {
+					return phase.Recorded(r.startSwitchover(ctx, req, sw))
+				},
+				Next: []string{"InProgress", "Completed", "Failed"},
+			},
+			{
+				Name: "InProgress",
+				Handle: func(ctx context.Context, sw *databasev1.Switchover) (phase.Outcome, error) {
+					return phase.Recorded(r.reconcileSwitchover(ctx, req, sw))
+				},
+				Next: []string{"Completed", "Failed"},
+			},
+			{Name: "Completed", Handle: done},
+			{Name: "Failed", Handle: done},
+		},
+		Current: func(sw *databasev1.Switchover) string { return sw.Status.Phase },
+		Enter:   func(sw *databasev1.Switchover, t phase.Transition) { sw.Status.Phase = t.To },
+	}
+}
+
+func switchoverMaxLag(sw *databasev1.Switchover) int64 {
+	if sw.Spec.MaxLagBytes != nil {
+		return *sw.Spec.MaxLagBytes
+	}
+	return defaultSwitchoverMaxLag
+}
+
+func switchoverTimeout(sw *databasev1.Switchover) time.Duration {
+	if sw.Spec.TimeoutSeconds > 0 {
+		return time.Duration(sw.Spec.TimeoutSeconds) * time.Second
+	}
+	return defaultSwitchoverTimeout
+}
+
+// startSwitchover starts demoting the primary once the target has caught up
+func (r *SwitchoverReconciler) startSwitchover(ctx context.Context, req ctrl.Request, sw *databasev1.Switchover) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+	target := sw.Spec.TargetReplica
+	wait := func(reason, message string) (ctrl.Result, error) {
+		return r.waitFor(ctx, req, sw, sw.CreationTimestamp.Time, reason, message)
+	}
+
+	db := &databasev1.Database{}
+	err := r.Get(ctx, client.ObjectKey{Name: sw.Spec.DatabaseRef.Name, Namespace: sw.Namespace}, db)
+	if errors.IsNotFound(err) {
+		return wait("DatabaseNotFound", fmt.Sprintf("Waiting for database %s to be created", sw.Spec.DatabaseRef.Name))
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	primary, err := currentPrimary(ctx, r.Client, db)
+	if errors.IsNotFound(err) {
+		return wait("ReplicationNotConfigured", fmt.Sprintf("Waiting for database %s to configure replication", db.Name))
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if primary == target {
+		log.Info("Target is already the primary", "database", db.Name, "target", target)
+		return r.finish(ctx, req, sw, "Completed", func(sw *databasev1.Switchover) {
+			conditions.MarkReady(sw, "AlreadyPrimary", fmt.Sprintf("%s is already the primary of database %s", target, db.Name))
+		})
+	}
+	if !isDatabasePod(db, target) {
+		return r.finish(ctx, req, sw, "Failed", func(sw *databasev1.Switchover) {
+			conditions.MarkStalled(sw, "InvalidTarget",
+				fmt.Sprintf("%s is not a pod of database %s with %d replicas", target, db.Name, databaseReplicas(db)))
+		})
+	}
+
+	if owner, ok := db.Annotations[databasev1.RestoreInProgressAnnotation]; ok {
+		return wait("RestoreInProgress", fmt.Sprintf("Waiting for restore %s of database %s", owner, db.Name))
+	}
+	if owner, ok := db.Annotations[databasev1.SwitchoverInProgressAnnotation]; ok && owner != sw.Name {
+		return wait("SwitchoverInProgress", fmt.Sprintf("Waiting for switchover %s of database %s", owner, db.Name))
+	}
+
+	primaryPod := &corev1.Pod{}
+	if err := r.Get(ctx, client.ObjectKey{Name: primary, Namespace: db.Namespace}, primaryPod); err != nil {
+		if errors.IsNotFound(err) {
+			return wait("PrimaryNotReady", fmt.Sprintf("Waiting for primary %s to be created", primary))
+		}
+		return ctrl.Result{}, err
+	}
+	if ready, _ := podReady(primaryPod); !ready || primaryPod.Status.PodIP == "" {
+		// A failed primary is replaced by a failover, not a switchover
+		return wait("PrimaryNotReady", fmt.Sprintf("Waiting for primary %s to be ready", primary))
+	}
+
+	// The primary knows how far behind each standby is
+	token, err := instanceToken(ctx, r.Client, db)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	status, err := instance.GetStatus(ctx, primaryPod.Status.PodIP, token)
+	if err != nil {
+		return wait("PrimaryUnreachable", fmt.Sprintf("Failed to get replication status of primary %s: %v", primary, err))
+	}
+	var standby *instance.Standby
+	for i := range status.Standbys {
+		if status.Standbys[i].Name == target {
+			standby = &status.Standbys[i]
+		}
+	}
+	if standby == nil || standby.State != "streaming" {
+		return wait("TargetNotStreaming", fmt.Sprintf("Waiting for %s to stream WAL from primary %s", target, primary))
+	}
+	if maxLag := switchoverMaxLag(sw); standby.LagBytes > maxLag {
+		log.Info("Target lags behind, waiting", "target", target, "lagBytes", standby.LagBytes, "maxLagBytes", maxLag)
+		return wait("LagTooHigh", fmt.Sprintf("%s has %d bytes of WAL to replay, more than %d", target, standby.LagBytes, maxLag))
+	}
+
+	// Keep the Database controller out of the way until the switchover is done
+	if !controllerutil.ContainsFinalizer(sw, switchoverFinalizer) {
+		patch := client.MergeFrom(sw.DeepCopy())
+		controllerutil.AddFinalizer(sw, switchoverFinalizer)
+		if err := r.Patch(ctx, sw, patch); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if _, ok := db.Annotations[databasev1.SwitchoverInProgressAnnotation]; !ok {
+		patch := client.MergeFrom(db.DeepCopy())
+		if db.Annotations == nil {
+			db.Annotations = map[string]string{}
+		}
+		db.Annotations[databasev1.SwitchoverInProgressAnnotation] = sw.Name
+		if err := r.Patch(ctx, db, patch); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	log.Info("Starting switchover", "database", db.Name, "from", primary, "to", target, "lagBytes", standby.LagBytes)
+	// Re-read switchover to ensure we have the latest version
+	if err := r.Get(ctx, req.NamespacedName, sw); err != nil {
+		return ctrl.Result{}, err
+	}
+	now := metav1.Now()
+	sw.Status.Phase = "InProgress"
+	sw.Status.FromPrimary = primary
+	sw.Status.FromPrimaryUID = string(primaryPod.UID)
+	sw.Status.TargetLagBytes = standby.LagBytes
+	sw.Status.StartTime = &now
+	conditions.MarkReconciling(sw, "DemotingPrimary", fmt.Sprintf("Demoting %s in favour of %s", primary, target))
+	if err := r.Status().Update(ctx, sw); err != nil {
+		if errors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// reconcileSwitchover moves the primary role to the target one step at a time. Every
+// step checks the state it leads to first, so it can be repeated after a restart.
+func (r *SwitchoverReconciler) reconcileSwitchover(ctx context.Context, req ctrl.Request, sw *databasev1.Switchover) (ctrl.Result, error) {
+	log := ctrl.LoggerFrom(ctx)
+	target := sw.Spec.TargetReplica
+	start := sw.CreationTimestamp.Time
+	if sw.Status.StartTime != nil {
+		start = sw.Status.StartTime.Time
+	}
+
+	db := &databasev1.Database{}
+	err := r.Get(ctx, client.ObjectKey{Name: sw.Spec.DatabaseRef.Name, Namespace: sw.Namespace}, db)
+	if errors.IsNotFound(err) {
+		return r.finish(ctx, req, sw, "Failed", func(sw *databasev1.Switchover) {
+			conditions.MarkStalled(sw, "DatabaseNotFound", fmt.Sprintf("Database %s was deleted", sw.Spec.DatabaseRef.Name))
+		})
+	}
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	primary, err := currentPrimary(ctx, r.Client, db)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	token, err := instanceToken(ctx, r.Client, db)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Name the target as the primary. Pods started from now on, including the old
+	// primary once it is recreated, follow it.
+	if primary != target {
+		if primary != sw.Status.FromPrimary {
+			return r.finish(ctx, req, sw, "Failed", func(sw *databasev1.Switchover) {
+				conditions.MarkStalled(sw, "PrimaryChanged", fmt.Sprintf("The primary changed to %s during the switchover", primary))
+			})
+		}
+		if time.Since(start) > switchoverTimeout(sw) {
+			return r.finish(ctx, req, sw, "Failed", func(sw *databasev1.Switchover) {
+				conditions.MarkStalled(sw, "Timeout", fmt.Sprintf("Switchover did not start within %s, %s is still the primary", switchoverTimeout(sw), primary))
+			})
+		}
+
+		lsn := ""
+		pod := &corev1.Pod{}
+		if err := r.Get(ctx, client.ObjectKey{Name: primary, Namespace: db.Namespace}, pod); err == nil && pod.Status.PodIP != "" {
+			if status, err := instance.GetStatus(ctx, pod.Status.PodIP, token); err == nil {
+				lsn = status.LSN
+			}
+		}
+		log.Info("Naming new primary", "database", db.Name, "from", primary, "to", target, "lsn", lsn)
+		if err := setPrimary(ctx, r.Client, db, primary, target, "Switchover", lsn); err != nil {
+			if errors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// From here on the switchover can only go forward. The Database controller
+	// finishes it if it times out.
+	wait := func(reason, message string) (ctrl.Result, error) {
+		if time.Since(start) > switchoverTimeout(sw) {
+			return r.finish(ctx, req, sw, "Failed", func(sw *databasev1.Switchover) {
+				conditions.MarkStalled(sw, "Timeout",
+					fmt.Sprintf("Switchover did not complete within %s (%s), the database controller promotes %s", switchoverTimeout(sw), message, target))
+			})
+		}
+		return r.setReconciling(ctx, req, sw, reason, message)
+	}
+
+	// Shut the old primary down. The pod is recreated under the same name, so its UID
+	// tells whether the old primary is still running.
+	old := &corev1.Pod{}
+	err = r.Get(ctx, client.ObjectKey{Name: sw.Status.FromPrimary, Namespace: db.Namespace}, old)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	if err == nil && string(old.UID) == sw.Status.FromPrimaryUID {
+		if old.DeletionTimestamp == nil {
+			log.Info("Shutting down old primary", "pod", old.Name)
+			if err := r.Delete(ctx, old); err != nil && !errors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+		}
+		return wait("DemotingPrimary", fmt.Sprintf("Waiting for %s to shut down", old.Name))
+	}
+
+	// Promote the target, which replays the WAL it has received first
+	pod := &corev1.Pod{}
+	if err := r.Get(ctx, client.ObjectKey{Name: target, Namespace: db.Namespace}, pod); err != nil {
+		if errors.IsNotFound(err) {
+			return wait("TargetNotReady", fmt.Sprintf("Waiting for %s to be created", target))
+		}
+		return ctrl.Result{}, err
+	}
+	if pod.Status.PodIP == "" {
+		return wait("TargetNotReady", fmt.Sprintf("Waiting for %s to get an IP", target))
+	}
+	status, err := instance.GetStatus(ctx, pod.Status.PodIP, token)
+	if err != nil {
+		return wait("TargetUnreachable", fmt.Sprintf("Failed to get replication status of %s: %v", target, err))
+	}
+	if !status.Primary {
+		log.Info("Promoting standby to primary", "pod", target)
+		if err := instance.Promote(ctx, pod.Status.PodIP, token); err != nil {
+			return wait("PromotingTarget", fmt.Sprintf("Failed to promote %s: %v", target, err))
+		}
+		return wait("PromotingTarget", fmt.Sprintf("Waiting for %s to finish its promotion", target))
+	}
+
+	// Flip the read-write Service and hand the Database back to its controller
+	if err := reconcileReadWriteService(ctx, r.Client, db, target); err != nil {
+		return ctrl.Result{}, err
+	}
+	log.Info("Switchover completed", "database", db.Name, "primary", target)
+	return r.finish(ctx, req, sw, "Completed", func(sw *databasev1.Switchover) {
+		now := metav1.Now()
+		sw.Status.CompletionTime = &now
+		conditions.MarkReady(sw, "SwitchoverCompleted", fmt.Sprintf("%s is the primary of database %s", target, db.Name))
+	})
+}
+
+// isDatabasePod reports whether name is one of the Database's StatefulSet pods
+func isDatabasePod(db *databasev1.Database, name string) bool {
+	for i := int32(0); i < databaseReplicas(db); i++ {
+		if podName(db, i) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// waitFor keeps a Switchover Pending while it waits for reason, and fails it once
+// it has waited since start for longer than its timeout
+func (r *SwitchoverReconciler) waitFor(ctx context.Context, req ctrl.Request, sw *databasev1.Switchover, start time.Time, reason, message string) (ctrl.Result, error) {
+	if time.Since(start) > switchoverTimeout(sw) {
+		ctrl.LoggerFrom(ctx).Info("Refusing switchover", "switchover", sw.Name, "reason", reason, "message", message)
+		return r.finish(ctx, req, sw, "Failed", func(sw *databasev1.Switchover) {
+			conditions.MarkStalled(sw, conditions.Reason(reason), fmt.Sprintf("%s after %s, refusing to switch over", message, switchoverTimeout(sw)))
+		})
+	}
+	return r.setReconciling(ctx, req, sw, reason, message)
+}
+
+// setReconciling records what a Switchover waits for and checks again later
+func (r *SwitchoverReconciler) setReconciling(ctx context.Context, req ctrl.Request, sw *databasev1.Switchover, reason, message string) (ctrl.Result, error) {
+	// Re-read switchover to ensure we have the latest version
+	if err := r.Get(ctx, req.NamespacedName, sw); err != nil {
+		return ctrl.Result{}, err
+	}
+	if sw.Status.Phase == "" {
+		sw.Status.Phase = "Pending"
+	}
+	conditions.MarkReconciling(sw, conditions.Reason(reason), message)
+	if err := r.Status().Update(ctx, sw); err != nil {
+		if errors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: switchoverPollInterval}, nil
+}
+
+// finish moves a Switchover to Completed or Failed and hands the Database back
+func (r *SwitchoverReconciler) finish(ctx context.Context, req ctrl.Request, sw *databasev1.Switchover, to string, mark func(sw *databasev1.Switchover)) (ctrl.Result, error) {
+	// Re-read switchover to ensure we have the latest version
+	if err := r.Get(ctx, req.NamespacedName, sw); err != nil {
+		return ctrl.Result{}, err
+	}
+	sw.Status.Phase = to
+	mark(sw)
+	if err := r.Status().Update(ctx, sw); err != nil {
+		if errors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, r.release(ctx, sw)
+}
+
+// release removes the SwitchoverInProgressAnnotation of sw from the Database, then the
+// finalizer from sw
+func (r *SwitchoverReconciler) release(ctx context.Context, sw *databasev1.Switchover) error {
+	db := &databasev1.Database{}
+	err := r.Get(ctx, client.ObjectKey{Name: sw.Spec.DatabaseRef.Name, Namespace: sw.Namespace}, db)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && db.Annotations[databasev1.SwitchoverInProgressAnnotation] == sw.Name {
+		patch := client.MergeFrom(db.DeepCopy())
+		delete(db.Annotations, databasev1.SwitchoverInProgressAnnotation)
+		if err := r.Patch(ctx, db, patch); err != nil {
+			return err
+		}
+	}
+
+	if !controllerutil.ContainsFinalizer(sw, switchoverFinalizer) {
+		return nil
+	}
+	patch := client.MergeFrom(sw.DeepCopy())
+	controllerutil.RemoveFinalizer(sw, switchoverFinalizer)
+	return r.Patch(ctx, sw, patch)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SwitchoverReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&databasev1.Switchover{}).
+		Complete(r)
+}
@@ -0,0 +1,254 @@
+// Solution: Switchover Controller Tests from Module 8
+// This tests the validation of the target, the handoff between switchover and failover,
+// and that a Switchover always hands the Database back, no cluster needed
+// Location: internal/controller/switchover_controller_test.go
+//
+// The specs run in the controller suite kubebuilder scaffolded, with the fake client and
+// the fake instance API of failover_test.go.
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/conditions"
+	"github.com/example/postgres-operator/internal/instance"
+)
+
+var _ = Describe("Switchover", func() {
+	var (
+		ctx       context.Context
+		instances *fakeInstances
+		db        *databasev1.Database
+		dbObjs    []client.Object
+		pods      []*corev1.Pod
+		sw        *databasev1.Switchover
+		c         client.Client
+		r         *SwitchoverReconciler
+	)
+
+	key := types.NamespacedName{Name: "maintenance", Namespace: "default"}
+
+	// setup creates the objects, with sw switching over to target
+	setup := func(target string) {
+		sw.Spec.TargetReplica = target
+		objs := append(dbObjs, sw, &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: db.Name, Namespace: db.Namespace},
+			Spec:       corev1.ServiceSpec{Selector: map[string]string{appsv1.StatefulSetPodNameLabel: "orders-0"}},
+		})
+		for _, pod := range pods {
+			objs = append(objs, pod)
+		}
+		c = newTestClient(instances, objs...)
+		r = &SwitchoverReconciler{Client: c, Scheme: c.Scheme()}
+	}
+
+	reconcile := func() ctrl.Result {
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	getSwitchover := func() *databasev1.Switchover {
+		latest := &databasev1.Switchover{}
+		Expect(c.Get(ctx, key, latest)).To(Succeed())
+		return latest
+	}
+
+	getDatabase := func() *databasev1.Database {
+		latest := &databasev1.Database{}
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(db), latest)).To(Succeed())
+		return latest
+	}
+
+	// expectReleased checks that the Database is handed back to its controller
+	expectReleased := func() {
+		Expect(getDatabase().Annotations).NotTo(HaveKey(databasev1.SwitchoverInProgressAnnotation))
+		latest := &databasev1.Switchover{}
+		if err := c.Get(ctx, key, latest); !errors.IsNotFound(err) {
+			Expect(err).NotTo(HaveOccurred())
+			Expect(latest.Finalizers).NotTo(ContainElement(switchoverFinalizer))
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		instances = newFakeInstances()
+		db, dbObjs = newTestDatabase(3, "orders-0")
+		since := time.Now().Add(-time.Hour)
+		pods = []*corev1.Pod{
+			newTestPod(db, 0, rolePrimary, true, since),
+			newTestPod(db, 1, roleReplica, true, since),
+			newTestPod(db, 2, roleReplica, true, since),
+		}
+		instances.set(pods[0], &instance.Status{
+			Primary: true,
+			LSN:     "0/5000000",
+			Standbys: []instance.Standby{
+				{Name: "orders-1", State: "streaming"},
+				{Name: "orders-2", State: "catchup", LagBytes: 64 * 1024 * 1024},
+			},
+		})
+		instances.set(pods[1], &instance.Status{LSN: "0/5000000", ReceivedLSN: "0/5000000"})
+		instances.set(pods[2], &instance.Status{LSN: "0/1000000"})
+
+		sw = &databasev1.Switchover{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              key.Name,
+				Namespace:         key.Namespace,
+				Generation:        1,
+				CreationTimestamp: metav1.Now(),
+			},
+			Spec: databasev1.SwitchoverSpec{DatabaseRef: corev1.LocalObjectReference{Name: db.Name}},
+		}
+	})
+
+	Describe("target validation", func() {
+		It("should fail for a pod that isn't one of the Database's", func() {
+			setup("orders-3")
+			reconcile()
+
+			latest := getSwitchover()
+			Expect(latest.Status.Phase).To(Equal("Failed"))
+			Expect(conditions.Get(latest, conditions.Stalled).Reason).To(Equal("InvalidTarget"))
+			Expect(instances.recorded()).To(BeEmpty())
+			expectReleased()
+		})
+
+		It("should complete at once when the target is the primary", func() {
+			setup("orders-0")
+			reconcile()
+
+			latest := getSwitchover()
+			Expect(latest.Status.Phase).To(Equal("Completed"))
+			Expect(conditions.Get(latest, conditions.Ready).Reason).To(Equal("AlreadyPrimary"))
+			Expect(instances.recorded()).To(BeEmpty())
+		})
+
+		It("should wait for a target that lags behind", func() {
+			setup("orders-2")
+			Expect(reconcile().RequeueAfter).To(Equal(switchoverPollInterval))
+
+			latest := getSwitchover()
+			Expect(latest.Status.Phase).To(Equal("Pending"))
+			Expect(conditions.Get(latest, conditions.Reconciling).Reason).To(Equal("TargetNotStreaming"))
+			Expect(getDatabase().Annotations).NotTo(HaveKey(databasev1.SwitchoverInProgressAnnotation))
+		})
+
+		It("should wait for another switchover of the Database", func() {
+			db.Annotations = map[string]string{databasev1.SwitchoverInProgressAnnotation: "other"}
+			setup("orders-1")
+			reconcile()
+
+			latest := getSwitchover()
+			Expect(latest.Status.Phase).To(Equal("Pending"))
+			Expect(conditions.Get(latest, conditions.Reconciling).Reason).To(Equal("SwitchoverInProgress"))
+			Expect(getDatabase().Annotations).To(HaveKeyWithValue(databasev1.SwitchoverInProgressAnnotation, "other"))
+		})
+	})
+
+	Describe("handoff", func() {
+		// failover runs the failover of the Database controller with the primary lost
+		failover := func() string {
+			dr := &DatabaseReconciler{Client: c, Scheme: c.Scheme()}
+			lost := newTestPod(db, 0, rolePrimary, false, time.Now().Add(-time.Hour))
+			primary, _, err := dr.reconcileFailover(ctx, getDatabase(), "orders-0", []corev1.Pod{*lost, *pods[1], *pods[2]})
+			Expect(err).NotTo(HaveOccurred())
+			return primary
+		}
+
+		It("should keep failover out of the way while it runs", func() {
+			setup("orders-1")
+			reconcile()
+
+			latest := getSwitchover()
+			Expect(latest.Status.Phase).To(Equal("InProgress"))
+			Expect(latest.Status.FromPrimary).To(Equal("orders-0"))
+			Expect(latest.Finalizers).To(ContainElement(switchoverFinalizer))
+			Expect(getDatabase().Annotations).To(HaveKeyWithValue(databasev1.SwitchoverInProgressAnnotation, sw.Name))
+
+			Expect(failover()).To(Equal("orders-0"))
+			Expect(instances.recorded()).To(BeEmpty())
+		})
+
+		It("should give the Database back to failover once it completed", func() {
+			setup("orders-1")
+			for i := 0; i < 10 && getSwitchover().Status.Phase != "Completed"; i++ {
+				reconcile()
+			}
+
+			latest := getSwitchover()
+			Expect(latest.Status.Phase).To(Equal("Completed"))
+			Expect(latest.Status.CompletionTime).NotTo(BeNil())
+			// The old primary shuts down before the target is promoted
+			Expect(instances.recorded()).To(Equal([]string{"delete orders-0", "promote orders-1"}))
+			expectReleased()
+
+			primary, err := currentPrimary(ctx, c, db)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(primary).To(Equal("orders-1"))
+			svc := &corev1.Service{}
+			Expect(c.Get(ctx, client.ObjectKey{Name: db.Name, Namespace: db.Namespace}, svc)).To(Succeed())
+			Expect(svc.Spec.Selector).To(HaveKeyWithValue(appsv1.StatefulSetPodNameLabel, "orders-1"))
+
+			// Failover works again
+			Expect(failover()).NotTo(Equal("orders-0"))
+		})
+	})
+
+	Describe("annotation cleanup", func() {
+		BeforeEach(func() {
+			setup("orders-1")
+			reconcile()
+			Expect(getSwitchover().Status.Phase).To(Equal("InProgress"))
+		})
+
+		It("should remove the annotation when the switchover fails", func() {
+			// A failover replaced the primary before the switchover named the target
+			cm := &corev1.ConfigMap{}
+			Expect(c.Get(ctx, client.ObjectKey{Name: replicationConfigMapName(db), Namespace: db.Namespace}, cm)).To(Succeed())
+			cm.Data[primaryKey] = "orders-2"
+			Expect(c.Update(ctx, cm)).To(Succeed())
+
+			reconcile()
+
+			latest := getSwitchover()
+			Expect(latest.Status.Phase).To(Equal("Failed"))
+			Expect(conditions.Get(latest, conditions.Stalled).Reason).To(Equal("PrimaryChanged"))
+			expectReleased()
+		})
+
+		It("should remove the annotation when the switchover is deleted", func() {
+			Expect(c.Delete(ctx, getSwitchover())).To(Succeed())
+
+			reconcile()
+
+			expectReleased()
+			Expect(errors.IsNotFound(c.Get(ctx, key, &databasev1.Switchover{}))).To(BeTrue())
+		})
+
+		It("should keep the annotation of another switchover when it is deleted", func() {
+			latest := getDatabase()
+			latest.Annotations[databasev1.SwitchoverInProgressAnnotation] = "other"
+			Expect(c.Update(ctx, latest)).To(Succeed())
+			Expect(c.Delete(ctx, getSwitchover())).To(Succeed())
+
+			reconcile()
+
+			Expect(getDatabase().Annotations).To(HaveKeyWithValue(databasev1.SwitchoverInProgressAnnotation, "other"))
+			Expect(errors.IsNotFound(c.Get(ctx, key, &databasev1.Switchover{}))).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,117 @@
+// Solution: Switchover Types from Module 8
+// This file contains the complete API type definitions for the Switchover resource.
+// Use kubebuilder to scaffold the API first, then replace the generated types with these.
+//
+// Scaffold with (same group as Database):
+//   kubebuilder create api --group database --version v1 --kind Switchover --resource --controller
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SwitchoverSpec defines the desired state of Switchover
+type SwitchoverSpec struct {
+	// DatabaseRef references the Database whose primary is replaced
+	// +kubebuilder:validation:Required
+	DatabaseRef corev1.LocalObjectReference `json:"databaseRef"`
+
+	// TargetReplica is the standby pod to promote, e.g. orders-db-1
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	TargetReplica string `json:"targetReplica"`
+
+	// MaxLagBytes is how much WAL the target may have yet to replay for the switchover
+	// to start. The switchover waits for the target to catch up until it times out.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=16777216
+	// +optional
+	MaxLagBytes *int64 `json:"maxLagBytes,omitempty"`
+
+	// TimeoutSeconds is how long the switchover may take, including the wait for the target
+	// +kubebuilder:validation:Minimum=30
+	// +kubebuilder:default=300
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// SwitchoverStatus defines the observed state of Switchover
+type SwitchoverStatus struct {
+	// ObservedGeneration is the spec generation the controller last acted on.
+	// Ready only reflects the current spec when it equals metadata.generation.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Phase is the current switchover phase
+	// +kubebuilder:validation:Enum=Pending;InProgress;Completed;Failed
+	Phase string `json:"phase,omitempty"`
+
+	// FromPrimary is the primary pod the switchover replaces
+	// +optional
+	FromPrimary string `json:"fromPrimary,omitempty"`
+
+	// FromPrimaryUID is the UID of that pod, to tell when it has been shut down
+	// +optional
+	FromPrimaryUID string `json:"fromPrimaryUID,omitempty"`
+
+	// TargetLagBytes is the target's replay lag when it was last checked
+	// +optional
+	TargetLagBytes int64 `json:"targetLagBytes,omitempty"`
+
+	// StartTime is when the primary started to be demoted
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the target became the primary
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Conditions represent the latest observations of the Switchover's state
+	// (Ready, Reconciling and Stalled, see the conditions package)
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+// +kubebuilder:printcolumn:name="Database",type="string",JSONPath=".spec.databaseRef.name"
+// +kubebuilder:printcolumn:name="From",type="string",JSONPath=".status.fromPrimary"
+// +kubebuilder:printcolumn:name="Target",type="string",JSONPath=".spec.targetReplica"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Switchover is the Schema for the switchovers API.
+// It hands the primary role of a Database to one of its standbys, e.g. before
+// maintenance on the primary's node.
+type Switchover struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SwitchoverSpec   `json:"spec,omitempty"`
+	Status SwitchoverStatus `json:"status,omitempty"`
+}
+
+// GetConditions returns the status conditions, for the conditions package
+func (sw *Switchover) GetConditions() []metav1.Condition {
+	return sw.Status.Conditions
+}
+
+// SetConditions replaces the status conditions, for the conditions package
+func (sw *Switchover) SetConditions(conditions []metav1.Condition) {
+	sw.Status.Conditions = conditions
+}
+
+// +kubebuilder:object:root=true
+
+// SwitchoverList contains a list of Switchover
+type SwitchoverList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Switchover `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Switchover{}, &SwitchoverList{})
+}
@@ -0,0 +1,167 @@
+// Solution: WAL Archiving from Module 8
+// This configures the Database's PostgreSQL server to archive WAL for point-in-time recovery
+//
+// These are additions to the DatabaseReconciler from Module 3 (database-controller.go).
+// createStatefulSet and updateStatefulSet (rolling-update.go) apply configurePostgres to the
+// pod template, so enabling spec.walArchiving rolls the StatefulSet once.
+//
+// Every PostgreSQL container:
+// - gets the backup-agent from an init container (agent.InjectAgent)
+// - runs with the replication settings of replication.go (wal_level=replica etc.)
+// - uses the <name>-pg-hba ConfigMap, which allows replication connections for
+//   standbys and pg_basebackup
+//
+// With WAL archiving it also:
+// - runs with archive_mode=on, the agent as archive_command and
+//   archive_timeout=spec.walArchiving.archiveTimeoutSeconds
+// - reads storage credentials from spec.walArchiving.storageSecretRef
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	databasev1 "github.com/example/postgres-operator/api/v1"
+	"github.com/example/postgres-operator/internal/agent"
+	backupPkg "github.com/example/postgres-operator/internal/backup"
+)
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+
+const (
+	hbaVolume    = "pg-hba"
+	hbaMountPath = "/etc/postgresql/hba"
+)
+
+// pgHBA trusts local connections (as the image's default does) and requires
+// passwords for everything else, including replication connections
+const pgHBA = `local all all trust
+host all all all scram-sha-256
+host replication all all scram-sha-256
+`
+
+func hbaConfigMapName(db *databasev1.Database) string {
+	return fmt.Sprintf("%s-pg-hba", db.Name)
+}
+
+// postgresArgs returns the server settings derived from the Database spec
+func postgresArgs(db *databasev1.Database) ([]string, error) {
+	args := append([]string{"postgres"}, replicationArgs()...)
+	args = append(args, "-c", "hba_file="+hbaMountPath+"/pg_hba.conf")
+
+	wal := db.Spec.WALArchiving
+	if wal == nil {
+		return args, nil
+	}
+
+	walLocation, err := backupPkg.WALLocation(db)
+	if err != nil {
+		return nil, err
+	}
+	timeout := wal.ArchiveTimeoutSeconds
+	if timeout == 0 {
+		timeout = 60
+	}
+
+	return append(args,
+		"-c", "archive_mode=on",
+		"-c", "archive_timeout="+strconv.Itoa(int(timeout)),
+		"-c", fmt.Sprintf("archive_command=%s wal-push --location '%s' %%p", agent.InstalledPath, walLocation),
+	), nil
+}
+
+// configurePostgres applies the server settings to the StatefulSet's pod template.
+// It is idempotent and reports whether the template changed.
+func configurePostgres(db *databasev1.Database, ss *appsv1.StatefulSet) (bool, error) {
+	template := ss.Spec.Template.DeepCopy()
+	spec := &template.Spec
+
+	args, err := postgresArgs(db)
+	if err != nil {
+		return false, err
+	}
+	spec.Containers[0].Args = args
+
+	configureReplication(db, spec)
+
+	spec.Containers[0].EnvFrom = nil
+	if wal := db.Spec.WALArchiving; wal != nil && wal.StorageSecretRef != nil {
+		spec.Containers[0].EnvFrom = []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: *wal.StorageSecretRef},
+		}}
+	}
+
+	if !hasVolume(spec, hbaVolume) {
+		spec.Volumes = append(spec.Volumes, corev1.Volume{
+			Name: hbaVolume,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: hbaConfigMapName(db)},
+				},
+			},
+		})
+		spec.Containers[0].VolumeMounts = append(spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      hbaVolume,
+			MountPath: hbaMountPath,
+			ReadOnly:  true,
+		})
+	}
+
+	// Last, so the agent is mounted into the instance containers too
+	agent.InjectAgent(spec)
+
+	if equality.Semantic.DeepEqual(template, &ss.Spec.Template) {
+		return false, nil
+	}
+	ss.Spec.Template = *template
+	return true, nil
+}
+
+// reconcileHBAConfigMap creates the pg_hba.conf ConfigMap, which standbys and
+// pg_basebackup need for replication connections
+func (r *DatabaseReconciler) reconcileHBAConfigMap(ctx context.Context, db *databasev1.Database) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      hbaConfigMapName(db),
+			Namespace: db.Namespace,
+		},
+		Data: map[string]string{"pg_hba.conf": pgHBA},
+	}
+	if err := ctrl.SetControllerReference(db, cm, r.Scheme); err != nil {
+		return err
+	}
+
+	existing := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(cm), existing)
+	if errors.IsNotFound(err) {
+		return r.Create(ctx, cm)
+	}
+	if err != nil {
+		return err
+	}
+
+	if existing.Data["pg_hba.conf"] != pgHBA {
+		existing.Data = cm.Data
+		return r.Update(ctx, existing)
+	}
+	return nil
+}
+
+func hasVolume(spec *corev1.PodSpec, name string) bool {
+	for _, v := range spec.Volumes {
+		if v.Name == name {
+			return true
+		}
+	}
+	return false
+}